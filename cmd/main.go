@@ -1,19 +1,204 @@
 package main
 
 import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/SystemBuilders/LocKey/internal/lockservice"
 	"github.com/SystemBuilders/LocKey/internal/lockservice/node"
+	"github.com/SystemBuilders/LocKey/internal/lockservice/partition"
+	"github.com/SystemBuilders/LocKey/internal/lockservice/replicated"
+	"github.com/SystemBuilders/LocKey/internal/lockservice/routing"
+	"github.com/SystemBuilders/LocKey/internal/raft"
 	"github.com/rs/zerolog"
 )
 
 func main() {
+	walPath := flag.String("wal", "", "path of the write-ahead log, locks aren't persisted if empty")
+	syncPolicy := flag.String("sync", "always", "when to sync the write-ahead log: always, never or an interval such as 100ms")
+	snapshotDir := flag.String("snapshots", "", "directory in which snapshots of the locks are kept, no snapshots are taken if empty")
+	seed := flag.String("seed", "", "URL of the snapshot endpoint of a node to seed the locks from")
+	id := flag.String("id", "", "ID of the node in a replicated cluster or a partitioned deployment")
+	peers := flag.String("peers", "", "nodes of a replicated cluster as id=url pairs separated by commas, the node is standalone if empty")
+	raftDir := flag.String("raft", "", "directory in which the Raft state of a replicated node is kept")
+	ring := flag.String("ring", "", "nodes of a partitioned deployment as id=url pairs separated by commas, the node serves all IDs if empty")
+	grpcAddr := flag.String("grpc", "", "address to serve the gRPC API on, such as 127.0.0.1:1235, it isn't served if empty")
+	respAddr := flag.String("resp", "", "address to serve the locks to Redis clients on, such as 127.0.0.1:6379, they aren't served if empty")
+	keyfile := flag.String("keyfile", "", "path of a keyfile of principals and their bearer tokens, calls aren't authenticated if neither it nor -hmac-key is given")
+	hmacKey := flag.String("hmac-key", "", "path of a file holding the secret key that bearer tokens are signed with")
+	issue := flag.String("issue", "", "principal to print a bearer token of, signed with the key of -hmac-key, instead of serving")
+	issueTTL := flag.Duration("issue-ttl", 0, "time for which the token printed by -issue is valid, it never expires if 0")
+	admin := flag.String("admin", "", "principal that is served the admin routes of a node with authentication, no principal is if empty")
+	tokenFile := flag.String("token-file", "", "path of a file holding the bearer token of the admin that the node calls other nodes with, such as the node of -seed and the nodes of -ring")
+	flag.Parse()
+
 	zerolog.New(os.Stdout).With()
 
 	log := zerolog.New(os.Stdout).With().Logger().Level(zerolog.GlobalLevel())
-	ls := lockservice.NewSimpleLockService(log)
+
+	var nodeOpts []node.Option
+	if *grpcAddr != "" {
+		nodeOpts = append(nodeOpts, node.WithGRPC(*grpcAddr))
+	}
+	if *respAddr != "" {
+		nodeOpts = append(nodeOpts, node.WithRESP(*respAddr))
+	}
+	if *keyfile != "" && *hmacKey != "" {
+		log.Fatal().Msg("only one of -keyfile and -hmac-key can be given")
+	}
+	if *peers != "" && (*walPath != "" || *snapshotDir != "" || *seed != "" || *ring != "") {
+		log.Fatal().Msg("-wal, -snapshots, -seed and -ring can't be given with -peers")
+	}
+	if *issue != "" && *hmacKey == "" {
+		log.Fatal().Msg("-issue needs the key of -hmac-key")
+	}
+	if *hmacKey != "" {
+		key, err := ioutil.ReadFile(*hmacKey)
+		if err != nil {
+			log.Fatal().Err(err).Msg("can't read the HMAC key")
+		}
+		auth := routing.NewHMACAuthenticator(bytes.TrimSpace(key))
+		if *issue != "" {
+			var expires time.Time
+			if *issueTTL > 0 {
+				expires = time.Now().Add(*issueTTL)
+			}
+			fmt.Println(auth.Issue(*issue, expires))
+			return
+		}
+		nodeOpts = append(nodeOpts, node.WithAuth(auth))
+	}
+	if *keyfile != "" {
+		auth, err := routing.LoadKeyfile(*keyfile)
+		if err != nil {
+			log.Fatal().Err(err).Msg("can't load the keyfile")
+		}
+		nodeOpts = append(nodeOpts, node.WithAuth(auth))
+	}
+	if *admin != "" {
+		nodeOpts = append(nodeOpts, node.WithAdmin(*admin))
+	}
+	var token string
+	if *tokenFile != "" {
+		b, err := ioutil.ReadFile(*tokenFile)
+		if err != nil {
+			log.Fatal().Err(err).Msg("can't read the bearer token")
+		}
+		token = string(bytes.TrimSpace(b))
+	}
+
+	if *peers != "" {
+		cfg := replicated.Config{
+			ID:    *id,
+			Peers: parseNodes(log, *peers),
+		}
+		if *raftDir != "" {
+			storage, err := raft.OpenFileStorage(*raftDir)
+			if err != nil {
+				log.Fatal().Err(err).Msg("can't open the Raft storage")
+			}
+			cfg.Storage = storage
+		}
+		rls, err := replicated.NewLockService(log, cfg)
+		if err != nil {
+			log.Fatal().Err(err).Msg("can't create the replicated node")
+		}
+		if err := node.StartReplicated(rls, cfg.Peers[*id], nodeOpts...); err != nil {
+			log.Fatal().Err(err).Msg("can't serve the replicated node")
+		}
+		return
+	}
+
+	var opts []lockservice.Option
+	if *walPath != "" {
+		policy, err := lockservice.ParseSyncPolicy(*syncPolicy)
+		if err != nil {
+			log.Fatal().Err(err).Msg("invalid sync policy")
+		}
+		wal, err := lockservice.OpenWAL(*walPath, policy)
+		if err != nil {
+			log.Fatal().Err(err).Msg("can't open the write-ahead log")
+		}
+		opts = append(opts, lockservice.WithLog(wal))
+	}
+	if *snapshotDir != "" {
+		store, err := lockservice.OpenSnapshotStore(*snapshotDir)
+		if err != nil {
+			log.Fatal().Err(err).Msg("can't open the snapshot store")
+		}
+		opts = append(opts, lockservice.WithSnapshots(store))
+	}
+	ls := lockservice.NewSimpleLockService(log, opts...)
+	if err := ls.Recover(); err != nil {
+		log.Fatal().Err(err).Msg("can't recover the locks")
+	}
+	if *seed != "" {
+		if err := seedFrom(ls, *seed, token); err != nil {
+			log.Fatal().Err(err).Msg("can't seed the locks")
+		}
+	}
+
+	if *ring != "" {
+		nodes := parseNodes(log, *ring)
+		u, err := url.Parse(nodes[*id])
+		if err != nil || u.Host == "" {
+			log.Fatal().Str("id", *id).Msg("the ring has no valid URL for the node")
+		}
+		p := partition.NewPartitioner(log, *id, partition.NewRing(1, nodes, partition.DefaultVNodes), ls, partition.WithToken(token))
+		scfg := lockservice.NewSimpleConfig(u.Hostname(), u.Port())
+		if err := node.StartPartitioned(ls, p, *scfg, nodeOpts...); err != nil {
+			log.Fatal().Err(err).Msg("can't serve the node")
+		}
+		return
+	}
 
 	scfg := lockservice.NewSimpleConfig("127.0.0.1", "1234")
-	node.Start(ls, *scfg)
+	if err := node.Start(ls, *scfg, nodeOpts...); err != nil {
+		log.Fatal().Err(err).Msg("can't serve the node")
+	}
+}
+
+// parseNodes parses a list of id=url pairs separated by commas.
+func parseNodes(log zerolog.Logger, list string) map[string]string {
+	nodes := make(map[string]string)
+	for _, pair := range strings.Split(list, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			log.Fatal().Str("node", pair).Msg("nodes must be id=url pairs")
+		}
+		nodes[kv[0]] = kv[1]
+	}
+	return nodes
+}
+
+// seedFrom restores the snapshot streamed by the node at the given URL,
+// which is called with the bearer token if one is given.
+func seedFrom(ls *lockservice.SimpleLockService, url, token string) error {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("seed: %s", resp.Status)
+	}
+	snap, err := lockservice.DecodeSnapshot(resp.Body)
+	if err != nil {
+		return err
+	}
+	return ls.Restore(snap)
 }
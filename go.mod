@@ -8,6 +8,8 @@ require (
 	github.com/kr/pretty v0.1.0 // indirect
 	github.com/oklog/ulid v1.3.1
 	github.com/rs/zerolog v1.19.0
-	github.com/stretchr/testify v1.6.1
+	github.com/stretchr/testify v1.7.0
+	google.golang.org/grpc v1.46.2
+	google.golang.org/protobuf v1.28.0
 	gopkg.in/check.v1 v1.0.0-20190902080502-41f04d3bba15 // indirect
 )
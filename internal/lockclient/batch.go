@@ -0,0 +1,101 @@
+package lockclient
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/SystemBuilders/LocKey/internal/lockclient/session"
+	"github.com/SystemBuilders/LocKey/internal/lockservice"
+)
+
+// AcquireBatch allows the user process to acquire locks on a set of
+// objects at once. Either all the locks are acquired or none of them
+// is. The fencing tokens are returned in the order of the objects.
+//
+// Like Acquire, all the locks are revoked once the session expires.
+func (sc *SimpleClient) AcquireBatch(ds []lockservice.Object, s session.Session) ([]lockservice.FencingToken, error) {
+	return sc.AcquireBatchContext(context.Background(), ds, s)
+}
+
+// AcquireBatchContext is AcquireBatch with a context.
+func (sc *SimpleClient) AcquireBatchContext(ctx context.Context, ds []lockservice.Object, s session.Session) ([]lockservice.FencingToken, error) {
+	ctx, cancel, err := sc.sessionContext(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	remote := sc.remoteSession(s.ProcessID())
+	lds := make([]*lockservice.LockDescriptor, len(ds))
+	data := lockservice.BatchRequest{Requests: make([]lockservice.LockRequest, len(ds))}
+	for i := range ds {
+		lds[i] = lockservice.NewLockDescriptor(ds[i].ID(), s.ProcessID().String())
+		data.Requests[i] = lockservice.LockRequest{FileID: lds[i].ID(), UserID: lds[i].Owner(), Session: remote}
+	}
+
+	body, err := sc.post(ctx, batchID(ds), "/acquireBatch", data)
+	if err != nil {
+		return nil, sc.sessionErr(s, err)
+	}
+	var res lockservice.AcquireBatchRes
+	err = json.Unmarshal(body, &res)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range lds {
+		lds[i].Fence = res.Tokens[i]
+	}
+	if !sc.record(s.ProcessID(), lds...) {
+		for i := range lds {
+			sc.release(context.Background(), lds[i])
+		}
+		return nil, ErrSessionExpired
+	}
+	return res.Tokens, nil
+}
+
+// ReleaseBatch allows the user process to release the locks on a set
+// of objects at once. Either all the locks are released or none of them
+// is.
+func (sc *SimpleClient) ReleaseBatch(ds []lockservice.Object, s session.Session) error {
+	return sc.ReleaseBatchContext(context.Background(), ds, s)
+}
+
+// ReleaseBatchContext is ReleaseBatch with a context.
+func (sc *SimpleClient) ReleaseBatchContext(ctx context.Context, ds []lockservice.Object, s session.Session) error {
+	ctx, cancel, err := sc.sessionContext(ctx, s)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	lds := make([]lockservice.Descriptors, len(ds))
+	data := lockservice.BatchRequest{Requests: make([]lockservice.LockRequest, len(ds))}
+	for i := range ds {
+		lds[i] = sc.acquisition(s.ProcessID(), ds[i])
+		data.Requests[i] = lockservice.LockRequest{FileID: lds[i].ID(), UserID: lds[i].Owner()}
+		if td, ok := lds[i].(lockservice.TokenDescriptors); ok {
+			data.Requests[i].Token = td.Token()
+		}
+	}
+
+	_, err = sc.post(ctx, batchID(ds), "/releaseBatch", data)
+	if err != nil {
+		return sc.sessionErr(s, err)
+	}
+	for i := range lds {
+		sc.removeFromSlice(s.ProcessID(), lds[i])
+	}
+	return nil
+}
+
+// batchID returns the descriptor ID by which a batch is routed. All the
+// objects of a batch must be served by the same node of a partitioned
+// lockservice.
+func batchID(ds []lockservice.Object) string {
+	if len(ds) == 0 {
+		return ""
+	}
+	return ds[0].ID()
+}
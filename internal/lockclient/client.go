@@ -1,6 +1,8 @@
 package lockclient
 
 import (
+	"context"
+
 	"github.com/SystemBuilders/LocKey/internal/lockclient/session"
 	"github.com/SystemBuilders/LocKey/internal/lockservice"
 )
@@ -24,14 +26,28 @@ type Client interface {
 	// with the client. This returns an ID of the session that
 	// results from the connection.
 	Connect() session.Session
+	// Disconnect ends the session right away and releases all
+	// the locks acquired in it.
+	Disconnect(session.Session) error
 	// Acquire can be used to acquire a lock on Lockey. This
 	// implementation interacts with the underlying server and
-	// provides the service.
-	Acquire(lockservice.Object, session.Session) error
+	// provides the service. The fencing token of the acquisition
+	// is returned on success.
+	Acquire(lockservice.Object, session.Session) (lockservice.FencingToken, error)
+	// AcquireContext is Acquire, abandoned once the context is
+	// done.
+	AcquireContext(context.Context, lockservice.Object, session.Session) (lockservice.FencingToken, error)
 	// Release can be used to release a lock on Lockey. This
 	// implementation interacts with the underlying server and
 	// provides the service.
 	Release(lockservice.Object, session.Session) error
+	// ReleaseContext is Release, abandoned once the context is
+	// done.
+	ReleaseContext(context.Context, lockservice.Object, session.Session) error
+	// Close ends all the sessions of the client and waits for the
+	// calls that are still running. The client can't be used once
+	// it's closed.
+	Close() error
 }
 
 // Config describes the configuration for the lockservice to run on.
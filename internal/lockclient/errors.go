@@ -10,4 +10,5 @@ func (e Error) Error() string { return string(e) }
 const (
 	ErrSessionNonExistent = Error("the session related to this process doesn't exist")
 	ErrSessionExpired    = Error("session expired")
+	ErrClientClosed       = Error("the client is closed")
 )
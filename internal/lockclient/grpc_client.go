@@ -0,0 +1,585 @@
+package lockclient
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/SystemBuilders/LocKey/internal/lockclient/id"
+	"github.com/SystemBuilders/LocKey/internal/lockclient/session"
+	"github.com/SystemBuilders/LocKey/internal/lockservice"
+	"github.com/SystemBuilders/LocKey/internal/lockservice/node"
+	"github.com/SystemBuilders/LocKey/internal/lockservice/rpc"
+	"github.com/rs/zerolog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+var _ Client = (*GRPCClient)(nil)
+
+// connectTimeout is the time for which a GRPCClient waits for its
+// connection to a lockservice it started.
+const connectTimeout = 10 * time.Second
+
+// GRPCClient implements Client over the gRPC API of the lockservice. All
+// the calls of the client are multiplexed on a single connection to the
+// node, which unlike the SimpleClient isn't partition aware.
+//
+// The locks acquired in a session belong to the session in the lockservice,
+// which releases them once the session ends, or once the client stops
+// renewing it.
+type GRPCClient struct {
+	settings
+	conn *grpc.ClientConn
+	rpc  rpc.LockServiceClient
+	id   id.ID
+	log  zerolog.Logger
+
+	mu sync.Mutex
+	// sessionStates holds the state of each live session, and
+	// tokens the fencing tokens of the locks acquired in it.
+	sessionStates map[id.ID]*sessionState
+	tokens        map[id.ID]map[string]lockservice.FencingToken
+	// running counts the calls to the lockservice and the session
+	// timers that are running, which Close waits for. No more are
+	// started once closed is set.
+	running sync.WaitGroup
+	closed  bool
+	// services holds the nodes of the lockservices started by
+	// the client.
+	services []node.Node
+}
+
+// NewGRPCClient returns a new GRPCClient of the lockservice whose gRPC API
+// is served at the address of the config. The connection is made in the
+// background, so the lockservice may be started once the client is
+// created.
+func NewGRPCClient(config *lockservice.SimpleConfig, log zerolog.Logger, opts ...Option) (*GRPCClient, error) {
+	settings := defaultSettings()
+	for _, opt := range opts {
+		opt(&settings)
+	}
+	dialOpts := []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+	if settings.token != "" {
+		dialOpts = append(dialOpts, grpc.WithPerRPCCredentials(bearerToken(settings.token)))
+	}
+	conn, err := grpc.Dial(grpcAddr(config), dialOpts...)
+	if err != nil {
+		return nil, err
+	}
+	gc := &GRPCClient{
+		settings:      settings,
+		conn:          conn,
+		rpc:           rpc.NewLockServiceClient(conn),
+		id:            id.Create(),
+		log:           log,
+		sessionStates: make(map[id.ID]*sessionState),
+		tokens:        make(map[id.ID]map[string]lockservice.FencingToken),
+	}
+	return gc, nil
+}
+
+// bearerToken is the bearer token that the calls of a GRPCClient carry as
+// their "authorization" metadata.
+type bearerToken string
+
+func (t bearerToken) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + string(t)}, nil
+}
+
+// RequireTransportSecurity reports that the token may be sent over the
+// insecure connections of the client, like the SimpleClient sends it over
+// plain HTTP.
+func (t bearerToken) RequireTransportSecurity() bool {
+	return false
+}
+
+// StartService starts the lockservice LocKey, serving its gRPC API on the
+// address of the config and its HTTP API on a port picked by the system.
+// It returns once the node is listening, and the node is stopped once the
+// client is closed.
+func (gc *GRPCClient) StartService(cfg Config) error {
+	if !gc.begin() {
+		return ErrClientClosed
+	}
+	defer gc.running.Done()
+
+	ls := lockservice.NewSimpleLockService(gc.log)
+	n := node.NewSimpleNode(ls, *lockservice.NewSimpleConfig(cfg.IP(), "0"), node.WithGRPC(grpcAddr(cfg)))
+	if err := n.Start(); err != nil {
+		return err
+	}
+	gc.mu.Lock()
+	gc.services = append(gc.services, n)
+	gc.mu.Unlock()
+	if err := gc.connect(); err != nil {
+		return err
+	}
+	gc.log.
+		Debug().
+		Str("address", n.GRPCAddr()).
+		Msg("lockservice started")
+	return nil
+}
+
+// Connect lets the user process establish a connection with the client,
+// in a session that lives for the TTL of the client and, unless the
+// keepalive is turned off, is renewed in the background until it's closed.
+func (gc *GRPCClient) Connect() session.Session {
+	return gc.ConnectWithTTL(gc.sessionTTL)
+}
+
+// ConnectWithTTL lets the user process establish a connection with the
+// client, like Connect, in a session that lives for the given TTL without
+// being renewed.
+//
+// A session created once the client is closed has already ended.
+func (gc *GRPCClient) ConnectWithTTL(ttl time.Duration) session.Session {
+	if ttl <= 0 {
+		ttl = gc.sessionTTL
+	}
+	processID := id.Create()
+	newSession := func(sessionID id.ID) session.Session {
+		return session.NewSession(sessionID, gc.id, processID,
+			func() error { return gc.renewSession(processID) },
+			func() error { return gc.closeSession(processID) },
+		)
+	}
+	if !gc.begin() {
+		return newSession(id.Create())
+	}
+	state := &sessionState{
+		ttl:    ttl,
+		remote: gc.createSession(ttl),
+		renew:  make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+	}
+
+	sessionID := id.Create()
+	if state.remote != "" {
+		if remoteID, err := id.Parse([]byte(state.remote)); err == nil {
+			sessionID = remoteID
+		}
+	}
+	s := newSession(sessionID)
+	gc.mu.Lock()
+	gc.sessionStates[processID] = state
+	gc.tokens[processID] = make(map[string]lockservice.FencingToken)
+	gc.mu.Unlock()
+
+	go func() {
+		defer gc.running.Done()
+		runSession(gc.log, processID, state, gc.keepAlive, gc.heartbeat, gc.endSession)
+	}()
+	gc.log.
+		Debug().
+		Str(processID.String(), "connected").
+		Dur("ttl", ttl).
+		Msg("session created")
+	return s
+}
+
+// Disconnect ends the session of the user process right away and releases
+// all the locks acquired in it. An ErrSessionNonExistent error is returned
+// if the session has already ended.
+func (gc *GRPCClient) Disconnect(s session.Session) error {
+	return gc.closeSession(s.ProcessID())
+}
+
+// Acquire allows the user process to acquire a lock in its session. The
+// fencing token issued by the lockservice is returned and is presented by
+// the client when the lock is released.
+func (gc *GRPCClient) Acquire(d lockservice.Object, s session.Session) (lockservice.FencingToken, error) {
+	return gc.AcquireContext(context.Background(), d, s)
+}
+
+// AcquireContext is Acquire with a context. The call to the lockservice
+// is abandoned once the context is done, in which case the error of the
+// context is returned.
+func (gc *GRPCClient) AcquireContext(ctx context.Context, d lockservice.Object, s session.Session) (lockservice.FencingToken, error) {
+	return gc.acquire(ctx, d, s, 0)
+}
+
+// AcquireWait allows the user process to acquire a lock, waiting for it
+// if it's held by another process, like SimpleClient.AcquireWait.
+func (gc *GRPCClient) AcquireWait(d lockservice.Object, s session.Session, wait time.Duration) (lockservice.FencingToken, error) {
+	return gc.AcquireWaitContext(context.Background(), d, s, wait)
+}
+
+// AcquireWaitContext is AcquireWait with a context.
+func (gc *GRPCClient) AcquireWaitContext(ctx context.Context, d lockservice.Object, s session.Session, wait time.Duration) (lockservice.FencingToken, error) {
+	return gc.acquire(ctx, d, s, wait)
+}
+
+// acquire acquires the lock for the process of the session and records
+// its fencing token, so that it's presented once the lock is released.
+func (gc *GRPCClient) acquire(ctx context.Context, d lockservice.Object, s session.Session, wait time.Duration) (lockservice.FencingToken, error) {
+	ctx, cancel, state, err := gc.sessionContext(ctx, s)
+	if err != nil {
+		return 0, err
+	}
+	defer cancel()
+	if !gc.begin() {
+		return 0, ErrClientClosed
+	}
+	defer gc.running.Done()
+
+	req := &rpc.LockRequest{
+		FileId:  d.ID(),
+		UserId:  s.ProcessID().String(),
+		Session: state.remote,
+	}
+	if wait > 0 {
+		req.Wait = durationpb.New(wait)
+	}
+	res, err := gc.rpc.Acquire(ctx, req)
+	if err != nil {
+		return 0, gc.sessionErr(s, rpc.Error(ctx, err))
+	}
+	token := lockservice.FencingToken(res.Token)
+
+	gc.mu.Lock()
+	tokens, live := gc.tokens[s.ProcessID()]
+	if live {
+		tokens[d.ID()] = token
+	}
+	gc.mu.Unlock()
+	if !live {
+		req.Token = res.Token
+		gc.rpc.Release(context.Background(), req)
+		return 0, ErrSessionExpired
+	}
+	return token, nil
+}
+
+// Release allows the user process to release a lock it acquired in its
+// session, presenting the fencing token of the acquisition.
+func (gc *GRPCClient) Release(d lockservice.Object, s session.Session) error {
+	return gc.ReleaseContext(context.Background(), d, s)
+}
+
+// ReleaseContext is Release with a context. If the context is done before
+// the lockservice responds, the lock may or may not have been released.
+func (gc *GRPCClient) ReleaseContext(ctx context.Context, d lockservice.Object, s session.Session) error {
+	ctx, cancel, _, err := gc.sessionContext(ctx, s)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	if !gc.begin() {
+		return ErrClientClosed
+	}
+	defer gc.running.Done()
+
+	gc.mu.Lock()
+	token := gc.tokens[s.ProcessID()][d.ID()]
+	gc.mu.Unlock()
+	_, err = gc.rpc.Release(ctx, &rpc.LockRequest{
+		FileId: d.ID(),
+		UserId: s.ProcessID().String(),
+		Token:  uint64(token),
+	})
+	if err != nil {
+		return gc.sessionErr(s, rpc.Error(ctx, err))
+	}
+	gc.mu.Lock()
+	delete(gc.tokens[s.ProcessID()], d.ID())
+	gc.mu.Unlock()
+	return nil
+}
+
+// CheckAcquire returns the owner of the lock on the object, and a
+// "file is not acquired" error if it isn't acquired.
+func (gc *GRPCClient) CheckAcquire(d lockservice.ObjectDescriptor) (string, error) {
+	return gc.CheckAcquireContext(context.Background(), d)
+}
+
+// CheckAcquireContext is CheckAcquire with a context.
+func (gc *GRPCClient) CheckAcquireContext(ctx context.Context, d lockservice.ObjectDescriptor) (string, error) {
+	if !gc.begin() {
+		return "", ErrClientClosed
+	}
+	defer gc.running.Done()
+
+	res, err := gc.rpc.CheckAcquired(ctx, &rpc.CheckRequest{FileId: d.ObjectID})
+	if err != nil {
+		return "", rpc.Error(ctx, err)
+	}
+	if !res.Acquired {
+		return "", lockservice.ErrCheckAcquireFailure
+	}
+	return res.Owner, nil
+}
+
+// Watch follows the changes in the state of the lock on the object, like
+// SimpleClient.Watch. The events are streamed by the lockservice over the
+// connection of the client.
+func (gc *GRPCClient) Watch(d lockservice.Object) (<-chan lockservice.Event, error) {
+	return gc.WatchContext(context.Background(), d)
+}
+
+// WatchContext is Watch with a context. The stream ends and the channel
+// is closed once the context is done.
+func (gc *GRPCClient) WatchContext(ctx context.Context, d lockservice.Object) (<-chan lockservice.Event, error) {
+	if !gc.begin() {
+		return nil, ErrClientClosed
+	}
+	defer gc.running.Done()
+
+	ctx, cancel := context.WithCancel(ctx)
+	stream, err := gc.rpc.Watch(ctx, &rpc.WatchRequest{FileId: d.ID()})
+	if err != nil {
+		err = rpc.Error(ctx, err)
+		cancel()
+		return nil, err
+	}
+	// The lockservice sends the header once the watch is set up, and
+	// ends the stream without one if it can't set it up, in which case
+	// the error is received in place of the first event.
+	md, err := stream.Header()
+	if err == nil && md.Len() == 0 {
+		_, err = stream.Recv()
+	}
+	if err != nil {
+		err = rpc.Error(ctx, err)
+		cancel()
+		return nil, err
+	}
+
+	events := make(chan lockservice.Event)
+	go func() {
+		defer cancel()
+		defer close(events)
+		for {
+			ev, err := stream.Recv()
+			if err != nil {
+				if rpc.Error(ctx, err) != ctx.Err() {
+					gc.log.
+						Debug().
+						Str("descriptor", d.ID()).
+						Err(err).
+						Msg("stopped watching")
+				}
+				return
+			}
+			select {
+			case events <- rpc.EventOf(ev):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return events, nil
+}
+
+// Close ends all the sessions of the client, releasing the locks acquired
+// in them, stops the lockservice started by the client, if any, waits for
+// the calls that are still running and closes the connection. Calls made
+// once the client is closed fail with ErrClientClosed.
+func (gc *GRPCClient) Close() error {
+	gc.mu.Lock()
+	if gc.closed {
+		gc.mu.Unlock()
+		return nil
+	}
+	var processIDs []id.ID
+	for processID := range gc.sessionStates {
+		processIDs = append(processIDs, processID)
+	}
+	gc.mu.Unlock()
+
+	for _, processID := range processIDs {
+		gc.endSession(processID)
+	}
+
+	gc.mu.Lock()
+	gc.closed = true
+	services := gc.services
+	gc.services = nil
+	gc.mu.Unlock()
+	gc.running.Wait()
+	err := gc.conn.Close()
+	for _, n := range services {
+		ctx, cancel := context.WithTimeout(context.Background(), serviceStopTimeout)
+		n.Stop(ctx)
+		cancel()
+	}
+	gc.log.
+		Debug().
+		Int("sessions", len(processIDs)).
+		Msg("client closed")
+	return err
+}
+
+// createSession creates a session in the lockservice and returns its ID,
+// or an empty ID if the lockservice doesn't keep sessions.
+func (gc *GRPCClient) createSession(ttl time.Duration) string {
+	session, err := gc.rpc.CreateSession(context.Background(), &rpc.CreateSessionRequest{Ttl: durationpb.New(ttl)})
+	if err != nil {
+		gc.log.
+			Debug().
+			Err(err).
+			Msg("lockservice doesn't keep sessions, keeping the session in the client")
+		return ""
+	}
+	return session.Id
+}
+
+// renewSession renews the session of the user process for another TTL.
+func (gc *GRPCClient) renewSession(processID id.ID) error {
+	gc.mu.Lock()
+	state, ok := gc.sessionStates[processID]
+	gc.mu.Unlock()
+	if !ok {
+		return ErrSessionNonExistent
+	}
+
+	if err := gc.heartbeat(state); err != nil {
+		if errors.Is(err, lockservice.ErrSessionNotFound) {
+			gc.endSession(processID)
+			return ErrSessionExpired
+		}
+		return err
+	}
+	select {
+	case state.renew <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// closeSession ends the session of the user process right away and
+// releases everything acquired in it.
+func (gc *GRPCClient) closeSession(processID id.ID) error {
+	if !gc.endSession(processID) {
+		return ErrSessionNonExistent
+	}
+	gc.log.
+		Debug().
+		Str(processID.String(), "user process").
+		Msg("session closed")
+	return nil
+}
+
+// endSession stops the timer of the session of the user process, cancels
+// the calls made in it and releases the locks acquired in it, which the
+// lockservice does on its own once a session it keeps ends. It returns
+// false if the session has already ended.
+func (gc *GRPCClient) endSession(processID id.ID) bool {
+	gc.mu.Lock()
+	state, ok := gc.sessionStates[processID]
+	tokens := gc.tokens[processID]
+	delete(gc.sessionStates, processID)
+	delete(gc.tokens, processID)
+	gc.mu.Unlock()
+	if !ok {
+		return false
+	}
+
+	close(state.stop)
+	if state.remote != "" {
+		gc.rpc.EndSession(context.Background(), &rpc.SessionRequest{Id: state.remote})
+		return true
+	}
+	for fileID, token := range tokens {
+		gc.rpc.Release(context.Background(), &rpc.LockRequest{
+			FileId: fileID,
+			UserId: processID.String(),
+			Token:  uint64(token),
+		})
+	}
+	return true
+}
+
+// heartbeat renews the session in the lockservice, if it keeps one.
+func (gc *GRPCClient) heartbeat(state *sessionState) error {
+	if state.remote == "" {
+		return nil
+	}
+	ctx := context.Background()
+	_, err := gc.rpc.Heartbeat(ctx, &rpc.SessionRequest{Id: state.remote})
+	return rpc.Error(ctx, err)
+}
+
+// sessionContext returns a context for a call made in the session of the
+// user process, which is cancelled once the session ends, along with the
+// state of the session. The returned function must be called once the call
+// is done. ErrSessionNonExistent is returned if the session has already
+// ended.
+func (gc *GRPCClient) sessionContext(ctx context.Context, s session.Session) (context.Context, context.CancelFunc, *sessionState, error) {
+	gc.mu.Lock()
+	state, ok := gc.sessionStates[s.ProcessID()]
+	gc.mu.Unlock()
+	if !ok {
+		return nil, nil, nil, ErrSessionNonExistent
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-state.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel, state, nil
+}
+
+// sessionErr returns ErrSessionExpired in place of the error of a call that
+// was cancelled because its session ended, and the error as is otherwise.
+func (gc *GRPCClient) sessionErr(s session.Session, err error) error {
+	if err != context.Canceled {
+		return err
+	}
+	gc.mu.Lock()
+	_, live := gc.sessionStates[s.ProcessID()]
+	gc.mu.Unlock()
+	if !live {
+		return ErrSessionExpired
+	}
+	return err
+}
+
+// begin counts a call to the lockservice or a session timer as running,
+// unless the client is closed, in which case false is returned. Every
+// successful begin must be followed by gc.running.Done.
+func (gc *GRPCClient) begin() bool {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	if gc.closed {
+		return false
+	}
+	gc.running.Add(1)
+	return true
+}
+
+// connect waits for the connection of the client to be ready, retrying
+// right away if the lockservice couldn't be reached before it was started.
+func (gc *GRPCClient) connect() error {
+	ctx, cancel := context.WithTimeout(context.Background(), connectTimeout)
+	defer cancel()
+	for {
+		state := gc.conn.GetState()
+		switch state {
+		case connectivity.Ready:
+			return nil
+		case connectivity.TransientFailure:
+			gc.conn.ResetConnectBackoff()
+		case connectivity.Idle:
+			gc.conn.Connect()
+		}
+		if !gc.conn.WaitForStateChange(ctx, state) {
+			return ctx.Err()
+		}
+	}
+}
+
+// grpcAddr returns the address of the gRPC API described by the config.
+func grpcAddr(cfg Config) string {
+	return net.JoinHostPort(strings.TrimPrefix(cfg.IP(), "http://"), cfg.Port())
+}
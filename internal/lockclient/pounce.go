@@ -0,0 +1,112 @@
+package lockclient
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/SystemBuilders/LocKey/internal/lockclient/session"
+	"github.com/SystemBuilders/LocKey/internal/lockservice"
+)
+
+// Pounce allows the user process to register its interest in a lock held
+// by another process. The lockservice hands the lock over to the process
+// the moment it's released or its lease runs out, and the fencing token
+// of the acquisition is then sent on the returned channel. If the lock is
+// free, it's acquired right away.
+//
+// The channel is closed without a token if the process stops pouncing
+// using Unpounce, the session ends or the lockservice can't be followed
+// anymore. Like Acquire, the lock is recorded in the session once it's
+// handed over.
+func (sc *SimpleClient) Pounce(d lockservice.Object, s session.Session) (<-chan lockservice.FencingToken, error) {
+	// The lock is watched before pouncing, so that the handover can't
+	// happen before the client is listening for it.
+	ctx, cancel, err := sc.sessionContext(context.Background(), s)
+	if err != nil {
+		return nil, err
+	}
+	events, err := sc.watch(ctx, d)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	ld := lockservice.NewLockDescriptor(d.ID(), s.ProcessID().String())
+	ld.SessionID = sc.remoteSession(s.ProcessID())
+	data := lockservice.LockRequest{FileID: ld.ID(), UserID: ld.Owner(), Session: ld.SessionID}
+	body, err := sc.post(ctx, ld.ID(), "/pounce", data)
+	if err != nil {
+		cancel()
+		return nil, sc.sessionErr(s, err)
+	}
+	var res lockservice.AcquireRes
+	err = json.Unmarshal(body, &res)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	tokens := make(chan lockservice.FencingToken, 1)
+	if res.Token != 0 {
+		cancel()
+		sc.recordPounce(s, ld, res.Token)
+		tokens <- res.Token
+		close(tokens)
+		return tokens, nil
+	}
+
+	key := pounceKey(s, d)
+	sc.mu.Lock()
+	sc.pounces[key] = cancel
+	sc.mu.Unlock()
+	go func() {
+		defer close(tokens)
+		defer sc.stopPounce(key)
+		for ev := range events {
+			if ev.Type == lockservice.EventAcquired && ev.Pounced && ev.Owner == ld.Owner() {
+				sc.recordPounce(s, ld, ev.Token)
+				tokens <- ev.Token
+				return
+			}
+		}
+	}()
+	return tokens, nil
+}
+
+// Unpounce allows the user process to withdraw its interest in the lock.
+// A lockservice.ErrNotPouncing error is returned if the lock has already
+// been handed over to the process.
+func (sc *SimpleClient) Unpounce(d lockservice.Object, s session.Session) error {
+	data := lockservice.LockRequest{FileID: d.ID(), UserID: s.ProcessID().String()}
+	_, err := sc.post(context.Background(), d.ID(), "/unpounce", data)
+	if err != nil {
+		return err
+	}
+	sc.stopPounce(pounceKey(s, d))
+	return nil
+}
+
+// stopPounce stops following the lock the process pounced on.
+func (sc *SimpleClient) stopPounce(key string) {
+	sc.mu.Lock()
+	cancel, ok := sc.pounces[key]
+	delete(sc.pounces, key)
+	sc.mu.Unlock()
+	if ok {
+		cancel()
+	}
+}
+
+// pounceKey returns the key of the pounce of the process on the object.
+func pounceKey(s session.Session, d lockservice.Object) string {
+	return s.ProcessID().String() + "/" + d.ID()
+}
+
+// recordPounce records the lock handed over to the process in its session.
+// If the session has ended in the meantime, the lock is released instead.
+func (sc *SimpleClient) recordPounce(s session.Session, ld *lockservice.LockDescriptor, token lockservice.FencingToken) {
+	ld.Fence = token
+	if !sc.record(s.ProcessID(), ld) {
+		sc.release(context.Background(), ld)
+	}
+}
@@ -0,0 +1,167 @@
+package lockclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/SystemBuilders/LocKey/internal/lockservice"
+	"github.com/SystemBuilders/LocKey/internal/lockservice/partition"
+)
+
+const (
+	// maxRedirects is the number of times a call is sent again after
+	// being misdirected to a node that doesn't serve its descriptor.
+	maxRedirects = 3
+	// maxMigrationRetries is the number of times a call is sent again
+	// while the partition of its descriptor is being migrated.
+	maxMigrationRetries = 50
+	// migrationBackoff is the time waited before sending a call again
+	// while its partition is being migrated.
+	migrationBackoff = 100 * time.Millisecond
+)
+
+// baseURL returns the base URL of the node that serves the descriptor
+// ID. The ring of a partitioned deployment is fetched from the node of
+// the config the first time it's needed. Without a ring, every call
+// goes to the node of the config.
+func (sc *SimpleClient) baseURL(id string) string {
+	sc.mu.Lock()
+	checked, ring := sc.ringChecked, sc.ring
+	sc.mu.Unlock()
+	base := sc.config.IP() + ":" + sc.config.Port()
+	if !checked {
+		sc.fetchRing(base)
+		sc.mu.Lock()
+		ring = sc.ring
+		sc.mu.Unlock()
+	}
+	if ring != nil {
+		if url := ring.URL(id); url != "" {
+			return url
+		}
+	}
+	return base
+}
+
+// fetchRing fetches the ring from the node at the base URL and keeps it
+// if it's newer than the one the client has. A node that has no ring is
+// a standalone node.
+func (sc *SimpleClient) fetchRing(base string) {
+	req, err := http.NewRequest("GET", base+"/ring", nil)
+	if err != nil {
+		return
+	}
+	sc.authorize(req)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if resp.StatusCode == http.StatusNotFound {
+		sc.ringChecked = true
+		return
+	}
+	var ring partition.Ring
+	if resp.StatusCode != http.StatusOK || json.NewDecoder(resp.Body).Decode(&ring) != nil {
+		return
+	}
+	sc.ringChecked = true
+	if sc.ring == nil || ring.Version > sc.ring.Version {
+		sc.ring = &ring
+		sc.log.
+			Debug().
+			Uint64("version", ring.Version).
+			Msg("fetched the ring of the lockservice")
+	}
+}
+
+// do makes a HTTP call to the given endpoint of the node that serves the
+// descriptor ID and returns the response if the call succeeds. Calls that
+// reach a node which doesn't serve the ID are sent again to its owner
+// under the ring of that node, and calls on a partition that is being
+// migrated are sent again once it's done. A lockservice error is returned
+// if the call doesn't succeed, and the error of the context if it's done
+// first.
+func (sc *SimpleClient) do(ctx context.Context, method, id, path string, data []byte) (*http.Response, error) {
+	redirects, retries := 0, 0
+	for {
+		base := sc.baseURL(id)
+		req, err := http.NewRequestWithContext(ctx, method, base+path, bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		if data != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		sc.authorize(req)
+
+		client := &http.Client{}
+		resp, err := client.Do(req)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return nil, err
+		}
+		if resp.StatusCode/100 == 2 {
+			return resp, nil
+		}
+
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		serr := errorOf(resp, body)
+		switch {
+		case resp.StatusCode == http.StatusMisdirectedRequest && redirects < maxRedirects:
+			redirects++
+			sc.fetchRing(base)
+		case errors.Is(serr, partition.ErrMigrating) && retries < maxMigrationRetries:
+			retries++
+			select {
+			case <-time.After(migrationBackoff):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		default:
+			return nil, serr
+		}
+	}
+}
+
+// authorize attaches the bearer token of the client to the request, if
+// the client has one.
+func (sc *SimpleClient) authorize(req *http.Request) {
+	if sc.token != "" {
+		req.Header.Set("Authorization", "Bearer "+sc.token)
+	}
+}
+
+// errorOf returns the error of the lockservice that a call failed with.
+// The v2 API and the sessions respond with an ErrorRes, whose code is
+// mapped back to the error of the lockservice or of the partitions, so
+// that it can be matched with errors.Is, while the other endpoints
+// respond with the error itself.
+func errorOf(resp *http.Response, body []byte) error {
+	var res lockservice.ErrorRes
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") && json.Unmarshal(body, &res) == nil {
+		if err, ok := lockservice.ErrorOf(res.Code); ok {
+			return err
+		}
+		if err, ok := partition.ErrorOf(res.Code); ok {
+			return err
+		}
+		return lockservice.Error(res.Message)
+	}
+	return lockservice.Error(strings.TrimSpace(string(body)))
+}
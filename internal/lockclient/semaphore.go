@@ -0,0 +1,110 @@
+package lockclient
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/SystemBuilders/LocKey/internal/lockclient/id"
+	"github.com/SystemBuilders/LocKey/internal/lockclient/session"
+	"github.com/SystemBuilders/LocKey/internal/lockservice"
+)
+
+// AcquirePermits allows the user process to acquire a number of permits
+// of the counting semaphore of the object. The semaphore hands out up to
+// limit permits, which every process using the semaphore must agree on.
+//
+// Like locks, the permits are returned to the semaphore once the session
+// expires.
+func (sc *SimpleClient) AcquirePermits(d lockservice.Object, s session.Session, permits, limit int) error {
+	return sc.AcquirePermitsContext(context.Background(), d, s, permits, limit)
+}
+
+// AcquirePermitsContext is AcquirePermits with a context.
+func (sc *SimpleClient) AcquirePermitsContext(ctx context.Context, d lockservice.Object, s session.Session, permits, limit int) error {
+	ctx, cancel, err := sc.sessionContext(ctx, s)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	data := lockservice.SemaphoreRequest{
+		FileID:  d.ID(),
+		UserID:  s.ProcessID().String(),
+		Permits: permits,
+		Limit:   limit,
+		Session: sc.remoteSession(s.ProcessID()),
+	}
+	_, err = sc.post(ctx, d.ID(), "/acquireSemaphore", data)
+	if err != nil {
+		return sc.sessionErr(s, err)
+	}
+
+	sc.mu.Lock()
+	if _, ok := sc.sessionStates[s.ProcessID()]; !ok {
+		// The session ended in the meantime, so the permits
+		// are returned right away rather than recorded.
+		sc.mu.Unlock()
+		sc.releasePermits(context.Background(), s.ProcessID(), d.ID(), permits)
+		return ErrSessionExpired
+	}
+	if sc.sessionPermits[s.ProcessID()] == nil {
+		sc.sessionPermits[s.ProcessID()] = make(map[string]int)
+	}
+	sc.sessionPermits[s.ProcessID()][d.ID()] += permits
+	sc.mu.Unlock()
+	return nil
+}
+
+// ReleasePermits allows the user process to return a number of the
+// permits it holds to the semaphore of the object.
+func (sc *SimpleClient) ReleasePermits(d lockservice.Object, s session.Session, permits int) error {
+	return sc.ReleasePermitsContext(context.Background(), d, s, permits)
+}
+
+// ReleasePermitsContext is ReleasePermits with a context.
+func (sc *SimpleClient) ReleasePermitsContext(ctx context.Context, d lockservice.Object, s session.Session, permits int) error {
+	ctx, cancel, err := sc.sessionContext(ctx, s)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	err = sc.releasePermits(ctx, s.ProcessID(), d.ID(), permits)
+	if err != nil {
+		return sc.sessionErr(s, err)
+	}
+
+	sc.mu.Lock()
+	if held := sc.sessionPermits[s.ProcessID()]; held != nil {
+		held[d.ID()] -= permits
+		if held[d.ID()] <= 0 {
+			delete(held, d.ID())
+		}
+	}
+	sc.mu.Unlock()
+	return nil
+}
+
+// CheckSemaphore returns the holders and the available permits of the
+// semaphore of the object.
+func (sc *SimpleClient) CheckSemaphore(d lockservice.Object) (lockservice.SemaphoreStatus, error) {
+	var status lockservice.SemaphoreStatus
+	body, err := sc.post(context.Background(), d.ID(), "/checkSemaphore", lockservice.SemaphoreRequest{FileID: d.ID()})
+	if err != nil {
+		return status, err
+	}
+	err = json.Unmarshal(body, &status)
+	return status, err
+}
+
+// releasePermits makes the HTTP call to return the permits to the
+// semaphore. Like release, this doesn't care about sessions.
+func (sc *SimpleClient) releasePermits(ctx context.Context, processID id.ID, objectID string, permits int) error {
+	data := lockservice.SemaphoreRequest{
+		FileID:  objectID,
+		UserID:  processID.String(),
+		Permits: permits,
+	}
+	_, err := sc.post(ctx, objectID, "/releaseSemaphore", data)
+	return err
+}
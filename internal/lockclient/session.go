@@ -0,0 +1,374 @@
+package lockclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/SystemBuilders/LocKey/internal/lockclient/id"
+	"github.com/SystemBuilders/LocKey/internal/lockclient/session"
+	"github.com/SystemBuilders/LocKey/internal/lockservice"
+	"github.com/rs/zerolog"
+)
+
+// DefaultSessionTTL is the time for which a session lives without being
+// renewed, unless the client or the connection asks for another TTL.
+const DefaultSessionTTL = lockservice.DefaultSessionTTL
+
+// settings are the settings of a client that are changed by options.
+type settings struct {
+	// sessionTTL is the TTL of sessions that are created without
+	// one, and keepAlive whether sessions are renewed in the
+	// background.
+	sessionTTL time.Duration
+	keepAlive  bool
+	// token is the bearer token sent with the HTTP calls, if any.
+	token string
+}
+
+// defaultSettings returns the settings of a client without options.
+func defaultSettings() settings {
+	return settings{
+		sessionTTL: DefaultSessionTTL,
+		keepAlive:  true,
+	}
+}
+
+// Option configures a SimpleClient or a GRPCClient.
+type Option func(*settings)
+
+// WithSessionTTL sets the time for which the sessions of the client live
+// without being renewed. A TTL that isn't positive leaves the sessions
+// with DefaultSessionTTL.
+func WithSessionTTL(ttl time.Duration) Option {
+	return func(s *settings) {
+		if ttl > 0 {
+			s.sessionTTL = ttl
+		}
+	}
+}
+
+// WithKeepAlive turns the keepalive of the sessions of the client on or
+// off. With the keepalive on, which it is by default, a session is renewed
+// in the background every third of its TTL until it's closed. With it off,
+// a session ends once its TTL has passed since it was last renewed using
+// Session.Renew.
+func WithKeepAlive(keepAlive bool) Option {
+	return func(s *settings) {
+		s.keepAlive = keepAlive
+	}
+}
+
+// WithToken makes the client authenticate its calls to the lockservice
+// with the bearer token.
+func WithToken(token string) Option {
+	return func(s *settings) {
+		s.token = token
+	}
+}
+
+// sessionState is the state of a live session of a process.
+type sessionState struct {
+	ttl time.Duration
+	// stop is closed once the session ends, which cancels the
+	// calls made in the session.
+	stop chan struct{}
+	// remote is the ID of the session in the lockservice, which releases
+	// the locks of the session on its own if the session isn't renewed.
+	// It's empty if the lockservice doesn't keep sessions, in which case
+	// only the client releases them.
+	remote string
+	renew  chan struct{}
+}
+
+// ConnectWithTTL lets the user process establish a connection with the
+// client, like Connect, in a session that lives for the given TTL without
+// being renewed.
+//
+// A session created once the client is closed has already ended.
+func (sc *SimpleClient) ConnectWithTTL(ttl time.Duration) session.Session {
+	if ttl <= 0 {
+		ttl = sc.sessionTTL
+	}
+	processID := id.Create()
+	newSession := func(sessionID id.ID) session.Session {
+		return session.NewSession(sessionID, sc.id, processID,
+			func() error { return sc.renewSession(processID) },
+			func() error { return sc.closeSession(processID) },
+		)
+	}
+	if !sc.begin() {
+		return newSession(id.Create())
+	}
+	state := &sessionState{
+		ttl:    ttl,
+		remote: sc.createSession(ttl),
+		renew:  make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+	}
+
+	sessionID := id.Create()
+	if state.remote != "" {
+		if remoteID, err := id.Parse([]byte(state.remote)); err == nil {
+			sessionID = remoteID
+		}
+	}
+	s := newSession(sessionID)
+	sc.mu.Lock()
+	sc.sessions[processID] = s
+	sc.mu.Unlock()
+	sc.startSession(processID, state)
+	sc.log.
+		Debug().
+		Str(processID.String(), "connected").
+		Dur("ttl", ttl).
+		Msg("session created")
+	return s
+}
+
+// Disconnect ends the session of the user process right away and releases
+// all the locks and permits acquired in it, like Session.Close does. An
+// ErrSessionNonExistent error is returned if the session has already
+// ended.
+func (sc *SimpleClient) Disconnect(s session.Session) error {
+	return sc.closeSession(s.ProcessID())
+}
+
+// createSession creates a session in the lockservice and returns its ID.
+// The sessions of a lockservice are kept by the node they're created on,
+// so no session is created in a partitioned lockservice, nor in one that
+// doesn't keep sessions, for which an empty ID is returned.
+func (sc *SimpleClient) createSession(ttl time.Duration) string {
+	sc.baseURL("")
+	sc.mu.Lock()
+	partitioned := sc.ring != nil
+	sc.mu.Unlock()
+	if partitioned {
+		return ""
+	}
+
+	body, err := sc.post(context.Background(), "", "/createSession", lockservice.SessionRequest{TTL: ttl})
+	if err != nil {
+		sc.log.
+			Debug().
+			Err(err).
+			Msg("lockservice doesn't keep sessions, keeping the session in the client")
+		return ""
+	}
+	var remote lockservice.Session
+	if err := json.Unmarshal(body, &remote); err != nil {
+		return ""
+	}
+	return remote.ID
+}
+
+// startSession starts the timer of the session of the user process, along
+// with its keepalive if the client has one. This is a non blocking function
+// whose timer runs on a different goroutine, which ends the session once the
+// timer fires.
+//
+// The timer must have been counted as running, and is done once the session
+// ends.
+func (sc *SimpleClient) startSession(processID id.ID, state *sessionState) {
+	sc.mu.Lock()
+	sc.sessionStates[processID] = state
+	sc.mu.Unlock()
+
+	go func() {
+		defer sc.running.Done()
+		runSession(sc.log, processID, state, sc.keepAlive, sc.heartbeat, sc.endSession)
+	}()
+}
+
+// runSession runs the timer of the session of the user process, along with
+// its keepalive if keepAlive is set, until the session ends. The keepalive
+// renews the session using heartbeat, and the session is ended using end
+// once the timer fires or the lockservice no longer knows of the session.
+func runSession(log zerolog.Logger, processID id.ID, state *sessionState, keepAlive bool, heartbeat func(*sessionState) error, end func(id.ID) bool) {
+	log.
+		Debug().
+		Str(processID.String(), "user process").
+		Msg("session timer started")
+	timer := time.NewTimer(state.ttl)
+	defer timer.Stop()
+	var tick <-chan time.Time
+	if keepAlive {
+		ticker := time.NewTicker(state.ttl / 3)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-timer.C:
+			log.
+				Debug().
+				Str(processID.String(), "session timed out").
+				Msg("disconnected")
+			end(processID)
+			return
+		case <-tick:
+			err := heartbeat(state)
+			if err == nil {
+				resetTimer(timer, state.ttl)
+				continue
+			}
+			log.
+				Debug().
+				Str(processID.String(), "user process").
+				Err(err).
+				Msg("session couldn't be renewed")
+			if errors.Is(err, lockservice.ErrSessionNotFound) {
+				end(processID)
+				return
+			}
+		case <-state.renew:
+			resetTimer(timer, state.ttl)
+		case <-state.stop:
+			return
+		}
+	}
+}
+
+// renewSession renews the session of the user process for another TTL.
+func (sc *SimpleClient) renewSession(processID id.ID) error {
+	sc.mu.Lock()
+	state, ok := sc.sessionStates[processID]
+	sc.mu.Unlock()
+	if !ok {
+		return ErrSessionNonExistent
+	}
+
+	if err := sc.heartbeat(state); err != nil {
+		if errors.Is(err, lockservice.ErrSessionNotFound) {
+			sc.endSession(processID)
+			return ErrSessionExpired
+		}
+		return err
+	}
+	select {
+	case state.renew <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// closeSession ends the session of the user process right away and
+// releases everything acquired in it.
+func (sc *SimpleClient) closeSession(processID id.ID) error {
+	if !sc.endSession(processID) {
+		return ErrSessionNonExistent
+	}
+	sc.log.
+		Debug().
+		Str(processID.String(), "user process").
+		Msg("session closed")
+	return nil
+}
+
+// endSession stops the timer of the session of the user process, cancels
+// the calls made in it and releases the locks and permits acquired in it.
+// It returns false if the session has already ended.
+func (sc *SimpleClient) endSession(processID id.ID) bool {
+	sc.mu.Lock()
+	state, ok := sc.sessionStates[processID]
+	delete(sc.sessionStates, processID)
+	sc.mu.Unlock()
+	if !ok {
+		return false
+	}
+
+	close(state.stop)
+	sc.gracefulSessionShutDown(processID)
+	if state.remote != "" {
+		sc.post(context.Background(), "", "/endSession", lockservice.SessionRequest{ID: state.remote})
+	}
+	return true
+}
+
+// heartbeat renews the session in the lockservice, if it keeps one.
+func (sc *SimpleClient) heartbeat(state *sessionState) error {
+	if state.remote == "" {
+		return nil
+	}
+	_, err := sc.post(context.Background(), "", "/heartbeat", lockservice.SessionRequest{ID: state.remote})
+	return err
+}
+
+// sessionContext returns a context for a call made in the session of the
+// user process, which is cancelled once the session ends. The returned
+// function must be called once the call is done. ErrSessionNonExistent is
+// returned if the session has already ended.
+func (sc *SimpleClient) sessionContext(ctx context.Context, s session.Session) (context.Context, context.CancelFunc, error) {
+	sc.mu.Lock()
+	state, ok := sc.sessionStates[s.ProcessID()]
+	sc.mu.Unlock()
+	if !ok {
+		return nil, nil, ErrSessionNonExistent
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-state.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel, nil
+}
+
+// sessionErr returns ErrSessionExpired in place of the error of a call that
+// was cancelled because its session ended, and the error as is otherwise.
+func (sc *SimpleClient) sessionErr(s session.Session, err error) error {
+	if err != context.Canceled {
+		return err
+	}
+	sc.mu.Lock()
+	_, live := sc.sessionStates[s.ProcessID()]
+	sc.mu.Unlock()
+	if !live {
+		return ErrSessionExpired
+	}
+	return err
+}
+
+// record records the locks acquired by the user process in its session,
+// so that they're released once the session ends. It returns false if
+// the session has ended in the meantime, in which case nothing is
+// recorded and the locks must be released by the caller.
+func (sc *SimpleClient) record(processID id.ID, lds ...*lockservice.LockDescriptor) bool {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if _, ok := sc.sessionStates[processID]; !ok {
+		return false
+	}
+	for _, ld := range lds {
+		sc.sessionAcquisitions[processID] = append(sc.sessionAcquisitions[processID], ld)
+	}
+	return true
+}
+
+// remoteSession returns the ID of the session of the user process in the
+// lockservice, which is attached to the locks it acquires.
+func (sc *SimpleClient) remoteSession(processID id.ID) string {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if state, ok := sc.sessionStates[processID]; ok {
+		return state.remote
+	}
+	return ""
+}
+
+// resetTimer resets the timer to fire after d, draining it if it fired
+// in the meantime.
+func resetTimer(timer *time.Timer, d time.Duration) {
+	if !timer.Stop() {
+		select {
+		case <-timer.C:
+		default:
+		}
+	}
+	timer.Reset(d)
+}
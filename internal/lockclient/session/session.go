@@ -15,4 +15,10 @@ type Session interface {
 	// ProcessID the unique ID assigned for the process by the client.
 	// This will be the third layer check in the security mechanism.
 	ProcessID() id.ID
+	// Renew extends the session for another TTL, on top of the
+	// renewals of the keepalive of the client, if any.
+	Renew() error
+	// Close ends the session and releases all the locks acquired
+	// in it.
+	Close() error
 }
@@ -11,6 +11,8 @@ type SimpleSession struct {
 	sessionID id.ID
 	clientID  id.ID
 	processID id.ID
+	renew     func() error
+	close     func() error
 }
 
 // SessionID returns the sessionID of the SimpleSession.
@@ -28,11 +30,25 @@ func (s *SimpleSession) ProcessID() id.ID {
 	return s.processID
 }
 
+// Renew renews the session through the client that handed it out.
+func (s *SimpleSession) Renew() error {
+	return s.renew()
+}
+
+// Close ends the session through the client that handed it out.
+func (s *SimpleSession) Close() error {
+	return s.close()
+}
+
 // NewSession returns a new instance of a session with the given parameters.
-func NewSession(sessionID, clientID, processID id.ID) Session {
+// Renewing and closing the session call renew and close, which are given
+// by the client that hands out the session.
+func NewSession(sessionID, clientID, processID id.ID, renew, close func() error) Session {
 	return &SimpleSession{
 		sessionID: sessionID,
 		clientID:  clientID,
 		processID: processID,
+		renew:     renew,
+		close:     close,
 	}
 }
@@ -1,13 +1,11 @@
 package lockclient
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
-	"errors"
 	"io/ioutil"
-	"net/http"
-	"strings"
+	"net/url"
+	"strconv"
 	"sync"
 	"time"
 
@@ -17,10 +15,16 @@ import (
 	"github.com/SystemBuilders/LocKey/internal/lockclient/cache"
 	"github.com/SystemBuilders/LocKey/internal/lockclient/session"
 	"github.com/SystemBuilders/LocKey/internal/lockservice"
+	"github.com/SystemBuilders/LocKey/internal/lockservice/node"
+	"github.com/SystemBuilders/LocKey/internal/lockservice/partition"
 )
 
 var _ Config = (*lockservice.SimpleConfig)(nil)
 
+// serviceStopTimeout is the time for which the calls being served by a
+// lockservice started by the client are waited for when it's closed.
+const serviceStopTimeout = 10 * time.Second
+
 // SimpleClient implements Client, the lockclient for LocKey.
 type SimpleClient struct {
 	config *lockservice.SimpleConfig
@@ -31,48 +35,122 @@ type SimpleClient struct {
 
 	// sessions holds the mapping of a process to a session.
 	sessions map[id.ID]session.Session
-	// sessionTimers maintains the timers for each session,
-	sessionTimers map[id.ID]chan struct{}
+	// sessionStates holds the TTL and the keepalive of each
+	// live session.
+	sessionStates map[id.ID]*sessionState
+	settings
 	// sessionAcquisitions has a list of all the acquisitions
 	// from a particular process. This has no knowledge of
 	// whether the process owning the lock has an active session
 	// or not, this guarantee has to be ensured by the client.
 	sessionAcquisitions map[id.ID][]lockservice.Descriptors
+	// sessionPermits holds the number of permits held by a
+	// process on each semaphore. Like acquisitions, these are
+	// returned once the session ends.
+	sessionPermits map[id.ID]map[string]int
+	// pounces holds the functions that stop following the
+	// locks that processes are pouncing on.
+	pounces map[string]context.CancelFunc
+	// ring is the ring of a partitioned lockservice, which is nil
+	// for a standalone one. ringChecked is set once the client knows
+	// which of the two it talks to.
+	ring        *partition.Ring
+	ringChecked bool
+	// running counts the calls to the lockservice and the session
+	// timers that are running, which Close waits for. No more are
+	// started once closed is set.
+	running sync.WaitGroup
+	closed  bool
+	// services holds the nodes of the lockservices started by
+	// the client.
+	services []node.Node
 }
 
 // NewSimpleClient returns a new SimpleClient of the given parameters.
 // This client works with or without the existance of a cache.
-func NewSimpleClient(config *lockservice.SimpleConfig, log zerolog.Logger, cache *cache.LRUCache) *SimpleClient {
+func NewSimpleClient(config *lockservice.SimpleConfig, log zerolog.Logger, cache *cache.LRUCache, opts ...Option) *SimpleClient {
 	clientID := id.Create()
 	sessions := make(map[id.ID]session.Session)
-	sessionTimers := make(map[id.ID]chan struct{})
 	sessionAcquisitions := make(map[id.ID][]lockservice.Descriptors)
-	return &SimpleClient{
+	sessionPermits := make(map[id.ID]map[string]int)
+	sc := &SimpleClient{
 		config:              config,
 		cache:               cache,
 		id:                  clientID,
 		log:                 log,
 		sessions:            sessions,
-		sessionTimers:       sessionTimers,
+		sessionStates:       make(map[id.ID]*sessionState),
+		settings:            defaultSettings(),
 		sessionAcquisitions: sessionAcquisitions,
+		sessionPermits:      sessionPermits,
+		pounces:             make(map[string]context.CancelFunc),
+	}
+	for _, opt := range opts {
+		opt(&sc.settings)
 	}
+	return sc
 }
 
 var _ Client = (*SimpleClient)(nil)
 
 // Connect lets the user process to establish a connection with the
-// client.
+// client. The session lives for the TTL of the client and, unless the
+// keepalive is turned off, is renewed in the background until it's
+// closed using Session.Close.
+//
+// If the lockservice keeps sessions, the session is also created in the
+// lockservice, which releases the locks of the session on its own once
+// the client stops renewing it.
 func (sc *SimpleClient) Connect() session.Session {
-	sessionID := id.Create()
-	processID := id.Create()
-	session := session.NewSession(sessionID, sc.id, processID)
-	sc.sessions[processID] = session
-	sc.startSession(processID)
+	return sc.ConnectWithTTL(sc.sessionTTL)
+}
+
+// Close ends all the sessions of the client, releasing everything acquired
+// in them, stops following the locks processes are pouncing on, stops the
+// lockservice started by the client, if any, and waits for the session
+// timers and the calls to the lockservice that are still running. Calls
+// made once the client is closed fail with ErrClientClosed.
+func (sc *SimpleClient) Close() error {
+	sc.mu.Lock()
+	if sc.closed {
+		sc.mu.Unlock()
+		return nil
+	}
+	var processIDs []id.ID
+	for processID := range sc.sessionStates {
+		processIDs = append(processIDs, processID)
+	}
+	var pounces []string
+	for key := range sc.pounces {
+		pounces = append(pounces, key)
+	}
+	sc.mu.Unlock()
+
+	for _, processID := range processIDs {
+		sc.endSession(processID)
+	}
+	for _, key := range pounces {
+		sc.stopPounce(key)
+	}
+
+	sc.mu.Lock()
+	sc.closed = true
+	services := sc.services
+	sc.services = nil
+	sc.mu.Unlock()
+	sc.running.Wait()
+	// The lockservices are stopped last, since the calls of the
+	// client may be served by them until then.
+	for _, n := range services {
+		ctx, cancel := context.WithTimeout(context.Background(), serviceStopTimeout)
+		n.Stop(ctx)
+		cancel()
+	}
 	sc.log.
 		Debug().
-		Str(processID.String(), "connected").
-		Msg("session created")
-	return session
+		Int("sessions", len(processIDs)).
+		Msg("client closed")
+	return nil
 }
 
 // Acquire allows the user process to acquire a lock.
@@ -81,180 +159,167 @@ func (sc *SimpleClient) Connect() session.Session {
 //
 // All locks acquired during the session will be revoked if the session
 // expires.
-func (sc *SimpleClient) Acquire(d lockservice.Object, s session.Session) error {
-	sc.mu.Lock()
-	if _, ok := sc.sessions[s.ProcessID()]; !ok {
-		sc.mu.Unlock()
-		return ErrSessionNonExistent
-	}
-	sc.mu.Unlock()
-	ctx := context.Background()
-	ctx, cancel := context.WithCancel(ctx)
-	close := make(chan struct{}, 1)
-	go func() {
-		for {
-			sc.mu.Lock()
-			select {
-			case <-sc.sessionTimers[s.ProcessID()]:
-				cancel()
-				sc.log.
-					Debug().
-					Str(s.ProcessID().String(), "user process").
-					Msg("session expired, starting graceful shutdown")
-				sc.mu.Unlock()
-				sc.gracefulSessionShutDown(s.ProcessID())
-				return
-			case <-close:
-				sc.mu.Unlock()
-				return
-			default:
-				sc.mu.Unlock()
-			}
-		}
-	}()
+//
+// The fencing token issued by the lockservice is returned and is
+// presented by the client when the lock is released.
+func (sc *SimpleClient) Acquire(d lockservice.Object, s session.Session) (lockservice.FencingToken, error) {
+	return sc.AcquireContext(context.Background(), d, s)
+}
+
+// AcquireContext is Acquire with a context. The call to the lockservice
+// is abandoned once the context is done, in which case the error of the
+// context is returned.
+func (sc *SimpleClient) AcquireContext(ctx context.Context, d lockservice.Object, s session.Session) (lockservice.FencingToken, error) {
+	ld := lockservice.NewLockDescriptor(d.ID(), s.ProcessID().String())
+	return sc.acquireInSession(ctx, ld, s, 0)
+}
+
+// AcquireShared allows the user process to acquire a shared lock, which
+// can be held by many processes at once as long as none of them holds it
+// exclusively. Shared locks are released using Release like any other lock.
+func (sc *SimpleClient) AcquireShared(d lockservice.Object, s session.Session) (lockservice.FencingToken, error) {
+	return sc.AcquireSharedContext(context.Background(), d, s)
+}
+
+// AcquireSharedContext is AcquireShared with a context.
+func (sc *SimpleClient) AcquireSharedContext(ctx context.Context, d lockservice.Object, s session.Session) (lockservice.FencingToken, error) {
+	ld := lockservice.NewSharedLockDescriptor(d.ID(), s.ProcessID().String())
+	return sc.acquireInSession(ctx, ld, s, 0)
+}
+
+// AcquireReentrant allows the user process to acquire a lock it may
+// already hold. Every acquisition must be matched by a Release before
+// the lock is released in the lockservice.
+func (sc *SimpleClient) AcquireReentrant(d lockservice.Object, s session.Session) (lockservice.FencingToken, error) {
+	return sc.AcquireReentrantContext(context.Background(), d, s)
+}
+
+// AcquireReentrantContext is AcquireReentrant with a context.
+func (sc *SimpleClient) AcquireReentrantContext(ctx context.Context, d lockservice.Object, s session.Session) (lockservice.FencingToken, error) {
+	ld := lockservice.NewReentrantLockDescriptor(d.ID(), s.ProcessID().String())
+	return sc.acquireInSession(ctx, ld, s, 0)
+}
+
+// AcquireWait allows the user process to acquire a lock, waiting for it
+// if it's held by another process. Waiting processes are granted the lock
+// in the order they asked for it. A lockservice.ErrAcquireTimeout error is
+// returned if the lock isn't granted within the given wait.
+//
+// Like Acquire, the wait ends with a "session expired" error if the session
+// expires before the lock is granted.
+func (sc *SimpleClient) AcquireWait(d lockservice.Object, s session.Session, wait time.Duration) (lockservice.FencingToken, error) {
+	return sc.AcquireWaitContext(context.Background(), d, s, wait)
+}
+
+// AcquireWaitContext is AcquireWait with a context. The wait also ends
+// once the context is done, which withdraws the process from the queue
+// of the lock.
+func (sc *SimpleClient) AcquireWaitContext(ctx context.Context, d lockservice.Object, s session.Session, wait time.Duration) (lockservice.FencingToken, error) {
 	ld := lockservice.NewLockDescriptor(d.ID(), s.ProcessID().String())
-	err := sc.acquire(ctx, ld)
+	return sc.acquireInSession(ctx, ld, s, wait)
+}
+
+// acquireInSession acquires the lock for the process of the session and
+// records the acquisition, so that it's released once the session ends.
+func (sc *SimpleClient) acquireInSession(ctx context.Context, ld *lockservice.LockDescriptor, s session.Session, wait time.Duration) (lockservice.FencingToken, error) {
+	ctx, cancel, err := sc.sessionContext(ctx, s)
 	if err != nil {
-		return err
+		return 0, err
 	}
+	defer cancel()
+
+	ld.SessionID = sc.remoteSession(s.ProcessID())
+	token, err := sc.acquire(ctx, ld, wait)
+	if err != nil {
+		return 0, sc.sessionErr(s, err)
+	}
+	ld.Fence = token
 	// Once the lock is guaranteed to be acquired, append it to the acquisitions list.
-	sc.mu.Lock()
-	sc.sessionAcquisitions[s.ProcessID()] = append(sc.sessionAcquisitions[s.ProcessID()], ld)
-	sc.mu.Unlock()
-	close <- struct{}{}
-	return nil
+	if !sc.record(s.ProcessID(), ld) {
+		sc.release(context.Background(), ld)
+		return 0, ErrSessionExpired
+	}
+	return token, nil
 }
 
 // acquire makes an HTTP call to the lockserver and acquires the lock.
 // This function makes the acquire call and doesn't care about the contention
-// on the lock service.
+// on the lock service. A non-zero wait asks the lockservice to queue the call
+// for that long if the lock is held.
 // The errors involved may be due the HTTP, cache or the lockservice errors.
 //
 // This function doesn't care about sessions or ordering of the user processes and
-// thus can be used for book-keeping purposes using a nil context.
-//
-// To avoid a race condition  by returning errors from the goroutine and the
-// acquire functionality, a channel is used to capture the errors.
-func (sc *SimpleClient) acquire(ctx context.Context, d lockservice.Descriptors) error {
-
-	errChan := make(chan error, 1)
-	if ctx != nil {
-		go func() {
-			for {
-				select {
-				case <-ctx.Done():
-					errChan <- ErrSessionExpired
-				default:
-				}
-			}
-		}()
-	}
-
-	go func() {
-		// Check for existance of a cache and check
-		// if the element is in the cache.
-		if sc.cache != nil {
-			_, err := sc.getFromCache(lockservice.ObjectDescriptor{ObjectID: d.ID()})
-			// Since there can be cache errors, we have this double check.
-			// We need to exit if a cache doesn't exist but proceed if the cache
-			// failed in persisting this element.
-			if err != nil && err != lockservice.ErrCheckAcquireFailure {
-				errChan <- err
-				return
-			}
-		}
-
-		endPoint := sc.config.IP() + ":" + sc.config.Port() + "/acquire"
-		// Since the cache doesn't have the element, query the server.
-		testData := lockservice.LockRequest{FileID: d.ID(), UserID: d.Owner()}
-		requestJSON, err := json.Marshal(testData)
-		if err != nil {
-			errChan <- err
-			return
+// thus can be used for book-keeping purposes using a background context.
+func (sc *SimpleClient) acquire(ctx context.Context, d lockservice.Descriptors, wait time.Duration) (lockservice.FencingToken, error) {
+	// Check for existance of a cache and check
+	// if the element is in the cache.
+	if sc.cache != nil {
+		_, err := sc.getFromCache(lockservice.ObjectDescriptor{ObjectID: d.ID()})
+		// Since there can be cache errors, we have this double check.
+		// We need to exit if a cache doesn't exist but proceed if the cache
+		// failed in persisting this element.
+		if err != nil && err != lockservice.ErrCheckAcquireFailure {
+			return 0, err
 		}
+	}
 
-		req, err := http.NewRequest("POST", endPoint, bytes.NewBuffer(requestJSON))
-		if err != nil {
-			errChan <- err
-			return
-		}
-		req.Header.Set("Content-Type", "application/json")
+	// Since the cache doesn't have the element, query the server.
+	testData := lockservice.LockRequest{FileID: d.ID(), UserID: d.Owner(), Wait: wait}
+	if md, ok := d.(lockservice.ModeDescriptors); ok {
+		testData.Mode = md.Mode()
+	}
+	if rd, ok := d.(lockservice.ReentrantDescriptors); ok {
+		testData.Reentrant = rd.Reentrant()
+	}
+	if sd, ok := d.(lockservice.SessionDescriptors); ok {
+		testData.Session = sd.Session()
+	}
+	body, err := sc.call(ctx, "PUT", d.ID(), lockPath(d.ID()), testData)
+	if err != nil {
+		return 0, err
+	}
 
-		client := &http.Client{}
-		resp, err := client.Do(req)
-		if err != nil {
-			errChan <- err
-			return
-		}
-		defer resp.Body.Close()
+	var acquireRes lockservice.AcquireRes
+	err = json.Unmarshal(body, &acquireRes)
+	if err != nil {
+		return 0, err
+	}
 
-		body, err := ioutil.ReadAll(resp.Body)
+	if sc.cache != nil {
+		err := sc.addToCache(d)
 		if err != nil {
-			errChan <- err
-			return
-		}
-		if resp.StatusCode != 200 {
-			errChan <- errors.New(strings.TrimSpace(string(body)))
-			return
-		}
-
-		if sc.cache != nil {
-			err := sc.addToCache(d)
-			if err != nil {
-				errChan <- err
-				return
-			}
+			return 0, err
 		}
-		errChan <- nil
-	}()
-
-	return <-errChan
+	}
+	return acquireRes.Token, nil
 }
 
 // Release makes an HTTP call to the lockserver and releases the lock.
 // The errors invloved may be due the HTTP errors or the lockservice errors.
 //
 // Only if there is an active session by the user process, it can release the locks
-// once verified that the locks belong to the user process.
+// once verified that the locks belong to the user process. The fencing token of
+// the acquisition made in the session is presented along with the release.
 func (sc *SimpleClient) Release(d lockservice.Object, s session.Session) error {
-	sc.mu.Lock()
-	if _, ok := sc.sessions[s.ProcessID()]; !ok {
-		sc.mu.Unlock()
-		return ErrSessionNonExistent
-	}
-	sc.mu.Unlock()
-	ctx := context.Background()
-	ctx, cancel := context.WithCancel(ctx)
-	close := make(chan struct{}, 1)
-	go func() {
-		for {
-			sc.mu.Lock()
-			select {
-			case <-sc.sessionTimers[s.ProcessID()]:
-				cancel()
-				sc.log.
-					Debug().
-					Str(s.ProcessID().String(), "user process").
-					Msg("session expired, starting graceful shutdown")
-				sc.mu.Unlock()
-				sc.gracefulSessionShutDown(s.ProcessID())
-				return
-			case <-close:
-				sc.mu.Unlock()
-				return
-			default:
-				sc.mu.Unlock()
-			}
-		}
-	}()
-	ld := lockservice.NewLockDescriptor(d.ID(), s.ProcessID().String())
-	err := sc.release(ctx, ld)
+	return sc.ReleaseContext(context.Background(), d, s)
+}
+
+// ReleaseContext is Release with a context. If the context is done before
+// the lockservice responds, the lock may or may not have been released.
+func (sc *SimpleClient) ReleaseContext(ctx context.Context, d lockservice.Object, s session.Session) error {
+	ctx, cancel, err := sc.sessionContext(ctx, s)
 	if err != nil {
 		return err
 	}
+	defer cancel()
+
+	ld := sc.acquisition(s.ProcessID(), d)
+	err = sc.release(ctx, ld)
+	if err != nil {
+		return sc.sessionErr(s, err)
+	}
 	// Remove the descriptor that was released.
 	sc.removeFromSlice(s.ProcessID(), ld)
-	close <- struct{}{}
 	return nil
 }
 
@@ -264,74 +329,41 @@ func (sc *SimpleClient) Release(d lockservice.Object, s session.Session) error {
 // The errors involved maybe the HTTP, cache or the lockservice errors.
 //
 // This function doesn't care about sessions or ordering of the user processes and
-// thus can be used for book-keeping purposes using a nil context.
+// thus can be used for book-keeping purposes using a background context.
 // TODO: Cache invalidation
-func (sc *SimpleClient) release(ctx context.Context, d lockservice.Descriptors) (err error) {
-
-	errChan := make(chan error, 1)
-	if ctx != nil {
-		go func() {
-			for {
-				select {
-				case <-ctx.Done():
-					errChan <- ErrSessionExpired
-					return
-				default:
-				}
-			}
-		}()
-	}
-
-	go func() {
-		endPoint := sc.config.IPAddr + ":" + sc.config.PortAddr + "/release"
-		data := lockservice.LockRequest{FileID: d.ID(), UserID: d.Owner()}
-		requestJSON, err := json.Marshal(data)
-		if err != nil {
-			errChan <- err
-			return
-		}
-
-		req, err := http.NewRequest("POST", endPoint, bytes.NewBuffer(requestJSON))
-		if err != nil {
-			errChan <- err
-			return
-		}
-		req.Header.Set("Content-Type", "application/json")
-
-		client := &http.Client{}
-		resp, err := client.Do(req)
-		if err != nil {
-			errChan <- err
-			return
-		}
-		defer resp.Body.Close()
-
-		body, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			errChan <- err
-			return
-		}
-		if resp.StatusCode != 200 {
-			errChan <- lockservice.Error(strings.TrimSpace(string(body)))
-			return
-		}
-
-		if sc.cache != nil {
-			if err != nil {
-				errChan <- err
-				return
-			}
-		}
-		errChan <- nil
-	}()
-
-	return <-errChan
+func (sc *SimpleClient) release(ctx context.Context, d lockservice.Descriptors) error {
+	query := url.Values{"owner": {d.Owner()}}
+	if td, ok := d.(lockservice.TokenDescriptors); ok && td.Token() != 0 {
+		query.Set("token", strconv.FormatUint(uint64(td.Token()), 10))
+	}
+	_, err := sc.call(ctx, "DELETE", d.ID(), lockPath(d.ID())+"?"+query.Encode(), nil)
+	return err
 }
 
 // StartService starts the lockservice LocKey.
-// This creates a new instance of the service and then starts the server.
+// This creates a new instance of the service and then starts the server
+// on the address of the config in the background. It returns once the
+// server is listening, so the service can be used right away, and the
+// service is stopped once the client is closed.
 func (sc *SimpleClient) StartService(cfg Config) error {
-	panic("TODO")
+	if !sc.begin() {
+		return ErrClientClosed
+	}
+	defer sc.running.Done()
+
+	ls := lockservice.NewSimpleLockService(sc.log)
+	n := node.NewSimpleNode(ls, *lockservice.NewSimpleConfig(cfg.IP(), cfg.Port()))
+	if err := n.Start(); err != nil {
+		return err
+	}
+	sc.mu.Lock()
+	sc.services = append(sc.services, n)
+	sc.mu.Unlock()
+	sc.log.
+		Debug().
+		Str("address", n.Addr()).
+		Msg("lockservice started")
+	return nil
 }
 
 // CheckAcquire checks for acquisition of lock and returns the owner if the lock
@@ -340,46 +372,80 @@ func (sc *SimpleClient) StartService(cfg Config) error {
 // A "file is not acquired" error is returned if so and no error and an owner is
 // returned if the object is acquired.
 func (sc *SimpleClient) CheckAcquire(d lockservice.ObjectDescriptor) (string, error) {
+	return sc.CheckAcquireContext(context.Background(), d)
+}
+
+// CheckAcquireContext is CheckAcquire with a context.
+func (sc *SimpleClient) CheckAcquireContext(ctx context.Context, d lockservice.ObjectDescriptor) (string, error) {
 	if sc.cache != nil {
 		return sc.getFromCache(d)
 	}
 
-	endPoint := sc.config.IPAddr + ":" + sc.config.PortAddr + "/checkAcquire"
-	data := lockservice.LockCheckRequest{FileID: d.ObjectID}
-	requestJSON, err := json.Marshal(data)
+	body, err := sc.call(ctx, "GET", d.ObjectID, lockPath(d.ObjectID), nil)
 	if err != nil {
 		return "", err
 	}
 
-	req, err := http.NewRequest("POST", endPoint, bytes.NewBuffer(requestJSON))
+	var ownerData lockservice.CheckAcquireRes
+	err = json.Unmarshal(body, &ownerData)
 	if err != nil {
 		return "", err
 	}
 
-	req.Header.Set("Content-Type", "application/json")
+	return ownerData.Owner, nil
+}
+
+// post makes a HTTP POST call to the given endpoint of the node that
+// serves the descriptor ID with the JSON encoding of the data and returns
+// the body of the response. A lockservice error is returned if the call
+// doesn't succeed, and the error of the context if it's done first.
+func (sc *SimpleClient) post(ctx context.Context, id, path string, data interface{}) ([]byte, error) {
+	return sc.call(ctx, "POST", id, path, data)
+}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return "", err
+// call makes a HTTP call with the method to the given endpoint like post
+// does. The call has no body if the data is nil.
+func (sc *SimpleClient) call(ctx context.Context, method, id, path string, data interface{}) ([]byte, error) {
+	if !sc.begin() {
+		return nil, ErrClientClosed
 	}
-	defer resp.Body.Close()
+	defer sc.running.Done()
 
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return "", err
-	}
-	if resp.StatusCode != 200 {
-		return "", lockservice.Error(strings.TrimSpace(string(body)))
+	var requestJSON []byte
+	if data != nil {
+		var err error
+		requestJSON, err = json.Marshal(data)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	var ownerData lockservice.CheckAcquireRes
-	err = json.Unmarshal(body, &ownerData)
+	resp, err := sc.do(ctx, method, id, path, requestJSON)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
+	defer resp.Body.Close()
 
-	return ownerData.Owner, nil
+	return ioutil.ReadAll(resp.Body)
+}
+
+// lockPath returns the path of the lock of the descriptor ID in the v2
+// API.
+func lockPath(id string) string {
+	return "/v2/locks/" + url.PathEscape(id)
+}
+
+// begin counts a call to the lockservice or a session timer as running,
+// unless the client is closed, in which case false is returned. Every
+// successful begin must be followed by sc.running.Done.
+func (sc *SimpleClient) begin() bool {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if sc.closed {
+		return false
+	}
+	sc.running.Add(1)
+	return true
 }
 
 // getFromCache checks the lock status on the descriptor in the cache.
@@ -418,58 +484,45 @@ func (sc *SimpleClient) releaseFromCache(d lockservice.Descriptors) error {
 	return cache.ErrCacheDoesntExist
 }
 
-// startSession starts the session by initiating the timer for this user process.
-// This is a non blocking function which runs on a different goroutine. It sends
-// a signal through the "sessionTimers" map for the respective "processID" when
-// the session timer ends.
-//
-// The function starts with creating a new channel, assigning it to the respective
-// object in the map and then ends by closing the channel created.
-func (sc *SimpleClient) startSession(processID id.ID) {
-	go func(id.ID) {
-		sc.log.Debug().
-			Str(processID.String(), "user process").
-			Msg("session timer started")
-		timerChan := make(chan struct{}, 1)
-		sc.mu.Lock()
-		sc.sessionTimers[processID] = timerChan
-		sc.mu.Unlock()
-		// Sessions last for 200ms.
-		time.Sleep(200 * time.Millisecond)
-
-		sc.mu.Lock()
-		sc.sessionTimers[processID] <- struct{}{}
-		close(sc.sessionTimers[processID])
-		delete(sc.sessionTimers, processID)
-		sc.mu.Unlock()
-
-		sc.log.Debug().
-			Str(processID.String(), "session timed out").
-			Msg("disconnected")
-		sc.gracefulSessionShutDown(processID)
-	}(processID)
-}
-
-// gracefulSessionShutdown releases all the locks in the lockservice once the
-// session has ended.
+// gracefulSessionShutdown releases all the locks and permits in the
+// lockservice once the session has ended. Nothing more is recorded in
+// the session by then, since it's no longer live.
 func (sc *SimpleClient) gracefulSessionShutDown(processID id.ID) {
 	sc.mu.Lock()
 	var sessionAcquisitons = sc.sessionAcquisitions[processID]
+	var sessionPermits = sc.sessionPermits[processID]
+	delete(sc.sessions, processID)
+	delete(sc.sessionAcquisitions, processID)
+	delete(sc.sessionPermits, processID)
 	sc.mu.Unlock()
 	for i := range sessionAcquisitons {
-		sc.release(nil, sessionAcquisitons[i])
+		sc.release(context.Background(), sessionAcquisitons[i])
+	}
+	for objectID, permits := range sessionPermits {
+		sc.releasePermits(context.Background(), processID, objectID, permits)
 	}
+}
+
+// acquisition returns the descriptor recorded when the process acquired
+// the object. If the process has no record of the object, a descriptor
+// without a fencing token is returned.
+func (sc *SimpleClient) acquisition(processID id.ID, d lockservice.Object) lockservice.Descriptors {
 	sc.mu.Lock()
-	delete(sc.sessions, processID)
-	delete(sc.sessionAcquisitions, processID)
-	sc.mu.Unlock()
+	defer sc.mu.Unlock()
+	for _, ld := range sc.sessionAcquisitions[processID] {
+		if ld.ID() == d.ID() {
+			return ld
+		}
+	}
+	return lockservice.NewLockDescriptor(d.ID(), processID.String())
 }
 
 func (sc *SimpleClient) removeFromSlice(processID id.ID, d lockservice.Descriptors) {
 	sc.mu.Lock()
 	for i := range sc.sessionAcquisitions[processID] {
-		if sc.sessionAcquisitions[processID][i] == d {
+		if sc.sessionAcquisitions[processID][i].ID() == d.ID() {
 			sc.sessionAcquisitions[processID] = append(sc.sessionAcquisitions[processID][:i], sc.sessionAcquisitions[processID][i+1:]...)
+			break
 		}
 	}
 	sc.mu.Unlock()
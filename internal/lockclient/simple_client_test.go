@@ -1,13 +1,20 @@
 package lockclient
 
 import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
 	"os"
+	"runtime"
 	"testing"
 	"time"
 
 	"github.com/SystemBuilders/LocKey/internal/lockclient/cache"
 	"github.com/SystemBuilders/LocKey/internal/lockservice"
 	"github.com/SystemBuilders/LocKey/internal/lockservice/node"
+	"github.com/SystemBuilders/LocKey/internal/lockservice/routing"
 
 	"github.com/rs/zerolog"
 )
@@ -19,20 +26,11 @@ func TestLockService(t *testing.T) {
 	scfg := lockservice.NewSimpleConfig("http://127.0.0.1", "1234")
 	ls := lockservice.NewSimpleLockService(log)
 
-	quit := make(chan bool, 1)
-	go func() {
-		node.Start(ls, *scfg)
-		for {
-			select {
-			case <-quit:
-				return
-			default:
-			}
-		}
-	}()
-
-	// Server takes some time to start.
-	time.Sleep(100 * time.Millisecond)
+	n := node.NewSimpleNode(ls, *scfg)
+	if err := n.Start(); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	defer n.Stop(context.Background())
 
 	// Flow of creating a client and acquiring a lock:
 	// 1. Create a cache for the client.
@@ -48,14 +46,14 @@ func TestLockService(t *testing.T) {
 
 		d := lockservice.NewObjectDescriptor("test")
 
-		got := sc.Acquire(d, session)
+		_, got := sc.Acquire(d, session)
 		var want error
 		if got != want {
 			t.Errorf("acquire: got %q want %q", got, want)
 		}
 
 		d = lockservice.NewObjectDescriptor("test1")
-		got = sc.Acquire(d, session)
+		_, got = sc.Acquire(d, session)
 		if got != want {
 			t.Errorf("acquire: got %q want %q", got, want)
 		}
@@ -81,14 +79,14 @@ func TestLockService(t *testing.T) {
 		session := sc.Connect()
 		d := lockservice.NewObjectDescriptor("test")
 
-		got := sc.Acquire(d, session)
+		_, got := sc.Acquire(d, session)
 		var want error
 		if got != want {
 			t.Errorf("acquire: got %q want %q", got, want)
 		}
 
 		session2 := sc.Connect()
-		got = sc.Acquire(d, session2)
+		_, got = sc.Acquire(d, session2)
 		want = lockservice.ErrFileacquired
 		if got.Error() != want.Error() {
 			t.Errorf("acquire: got %q want %q", got, want)
@@ -108,7 +106,7 @@ func TestLockService(t *testing.T) {
 
 		session := sc.Connect()
 		d := lockservice.NewObjectDescriptor("test")
-		got := sc.Acquire(d, session)
+		_, got := sc.Acquire(d, session)
 		var want error
 		if got != want {
 			t.Errorf("acquire: got %q want %q", got, want)
@@ -122,7 +120,7 @@ func TestLockService(t *testing.T) {
 		}
 
 		d = lockservice.NewObjectDescriptor("test2")
-		got = sc.Acquire(d, session)
+		_, got = sc.Acquire(d, session)
 		want = nil
 		if got != want {
 			t.Errorf("acquire: got %q want %q", got, want)
@@ -144,11 +142,11 @@ func TestLockService(t *testing.T) {
 	})
 
 	t.Run("acquire test and release after session expiry", func(t *testing.T) {
-		sc := NewSimpleClient(scfg, log, nil)
+		sc := NewSimpleClient(scfg, log, nil, WithSessionTTL(200*time.Millisecond), WithKeepAlive(false))
 		session := sc.Connect()
 		d := lockservice.NewObjectDescriptor("test3")
 
-		got := sc.Acquire(d, session)
+		_, got := sc.Acquire(d, session)
 		var want error
 		if got != want {
 			t.Errorf("acquire: got %q want %q", got, want)
@@ -163,8 +161,575 @@ func TestLockService(t *testing.T) {
 		}
 	})
 
-	quit <- true
-	return
+	t.Run("keepalive holds the locks past the TTL of the session", func(t *testing.T) {
+		sc := NewSimpleClient(scfg, log, nil, WithSessionTTL(200*time.Millisecond))
+		session := sc.Connect()
+		d := lockservice.NewObjectDescriptor("test4")
+
+		_, got := sc.Acquire(d, session)
+		var want error
+		if got != want {
+			t.Errorf("acquire: got %q want %q", got, want)
+		}
+
+		time.Sleep(500 * time.Millisecond)
+		got = sc.Release(d, session)
+		if got != want {
+			t.Errorf("release: got %q want %q", got, want)
+		}
+		if got := session.Close(); got != want {
+			t.Errorf("close: got %q want %q", got, want)
+		}
+	})
+
+	t.Run("keepalive holds the locks past the default lease", func(t *testing.T) {
+		leased := node.NewSimpleNode(lockservice.NewSimpleLockService(log, lockservice.WithDefaultLease(200*time.Millisecond)), *lockservice.NewSimpleConfig("127.0.0.1", "0"))
+		if err := leased.Start(); err != nil {
+			t.Fatalf("start: %v", err)
+		}
+		defer leased.Stop(context.Background())
+		_, port, err := net.SplitHostPort(leased.Addr())
+		if err != nil {
+			t.Fatalf("addr: %v", err)
+		}
+
+		sc := NewSimpleClient(lockservice.NewSimpleConfig("http://127.0.0.1", port), log, nil, WithSessionTTL(300*time.Millisecond))
+		defer sc.Close()
+		session := sc.Connect()
+		d := lockservice.NewObjectDescriptor("test4a")
+		if _, err := sc.Acquire(d, session); err != nil {
+			t.Fatalf("acquire: %v", err)
+		}
+
+		time.Sleep(600 * time.Millisecond)
+		if got, err := sc.CheckAcquire(lockservice.ObjectDescriptor{ObjectID: "test4a"}); err != nil || got != session.ProcessID().String() {
+			t.Errorf("checkAcquire: got %q, %v want %q, <nil>", got, err, session.ProcessID().String())
+		}
+		if got := sc.Release(d, session); got != nil {
+			t.Errorf("release: got %q want <nil>", got)
+		}
+	})
+
+	t.Run("renewing a session extends it", func(t *testing.T) {
+		sc := NewSimpleClient(scfg, log, nil, WithKeepAlive(false))
+		session := sc.ConnectWithTTL(time.Second)
+		d := lockservice.NewObjectDescriptor("test5")
+
+		_, got := sc.Acquire(d, session)
+		var want error
+		if got != want {
+			t.Errorf("acquire: got %q want %q", got, want)
+		}
+
+		time.Sleep(600 * time.Millisecond)
+		if got := session.Renew(); got != want {
+			t.Errorf("renew: got %q want %q", got, want)
+		}
+		time.Sleep(600 * time.Millisecond)
+		got = sc.Release(d, session)
+		if got != want {
+			t.Errorf("release: got %q want %q", got, want)
+		}
+		session.Close()
+	})
+
+	t.Run("closing a session releases its locks", func(t *testing.T) {
+		sc := NewSimpleClient(scfg, log, nil)
+		session := sc.Connect()
+		d := lockservice.NewObjectDescriptor("test6")
+
+		_, got := sc.Acquire(d, session)
+		var want error
+		if got != want {
+			t.Errorf("acquire: got %q want %q", got, want)
+		}
+
+		if got := session.Close(); got != want {
+			t.Errorf("close: got %q want %q", got, want)
+		}
+		got = session.Close()
+		want = ErrSessionNonExistent
+		if got != want {
+			t.Errorf("close: got %q want %q", got, want)
+		}
+
+		session2 := sc.Connect()
+		_, got = sc.Acquire(d, session2)
+		want = nil
+		if got != want {
+			t.Errorf("acquire: got %q want %q", got, want)
+		}
+		session2.Close()
+	})
+
+	t.Run("disconnecting a session releases its locks", func(t *testing.T) {
+		sc := NewSimpleClient(scfg, log, nil)
+		session := sc.Connect()
+		d := lockservice.NewObjectDescriptor("test7")
+
+		_, got := sc.Acquire(d, session)
+		var want error
+		if got != want {
+			t.Errorf("acquire: got %q want %q", got, want)
+		}
+
+		if got := sc.Disconnect(session); got != want {
+			t.Errorf("disconnect: got %q want %q", got, want)
+		}
+		got = sc.Release(d, session)
+		want = ErrSessionNonExistent
+		if got != want {
+			t.Errorf("release: got %q want %q", got, want)
+		}
+
+		owner, got := sc.CheckAcquire(lockservice.ObjectDescriptor{ObjectID: d.ID()})
+		want = lockservice.ErrCheckAcquireFailure
+		if got == nil || got.Error() != want.Error() {
+			t.Errorf("checkAcquire: got %q, %v want %v", owner, got, want)
+		}
+	})
+
+	t.Run("closing the client ends all its sessions", func(t *testing.T) {
+		sc := NewSimpleClient(scfg, log, nil)
+		session1 := sc.Connect()
+		session2 := sc.Connect()
+		d1 := lockservice.NewObjectDescriptor("test8")
+		d2 := lockservice.NewObjectDescriptor("test9")
+
+		var want error
+		if _, got := sc.Acquire(d1, session1); got != want {
+			t.Errorf("acquire: got %q want %q", got, want)
+		}
+		if _, got := sc.Acquire(d2, session2); got != want {
+			t.Errorf("acquire: got %q want %q", got, want)
+		}
+
+		if got := sc.Close(); got != want {
+			t.Errorf("close: got %q want %q", got, want)
+		}
+		_, got := sc.Acquire(d1, session1)
+		want = ErrSessionNonExistent
+		if got != want {
+			t.Errorf("acquire: got %q want %q", got, want)
+		}
+		_, got = sc.CheckAcquire(lockservice.ObjectDescriptor{ObjectID: d1.ID()})
+		want = ErrClientClosed
+		if got != want {
+			t.Errorf("checkAcquire: got %q want %q", got, want)
+		}
+
+		// A session connected once the client is closed isn't created
+		// in the lockservice.
+		sessions := len(ls.Snapshot().Sessions)
+		sc.Connect()
+		if got := len(ls.Snapshot().Sessions); got != sessions {
+			t.Errorf("connect: got %d sessions want %d", got, sessions)
+		}
+
+		sc2 := NewSimpleClient(scfg, log, nil)
+		defer sc2.Close()
+		session := sc2.Connect()
+		for _, d := range []lockservice.Object{d1, d2} {
+			_, got = sc2.Acquire(d, session)
+			want = nil
+			if got != want {
+				t.Errorf("acquire: got %q want %q", got, want)
+			}
+		}
+	})
+
+	t.Run("a wait ends once its context is done", func(t *testing.T) {
+		sc := NewSimpleClient(scfg, log, nil)
+		defer sc.Close()
+		session1 := sc.Connect()
+		session2 := sc.Connect()
+		d := lockservice.NewObjectDescriptor("test10")
+
+		_, got := sc.Acquire(d, session1)
+		var want error
+		if got != want {
+			t.Errorf("acquire: got %q want %q", got, want)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		_, got = sc.AcquireWaitContext(ctx, d, session2, 5*time.Second)
+		want = context.DeadlineExceeded
+		if got != want {
+			t.Errorf("acquireWait: got %q want %q", got, want)
+		}
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Errorf("acquireWait: took %v", elapsed)
+		}
+	})
+
+	t.Run("a wait ends once its session expires", func(t *testing.T) {
+		sc := NewSimpleClient(scfg, log, nil)
+		defer sc.Close()
+		session1 := sc.Connect()
+		d := lockservice.NewObjectDescriptor("test11")
+
+		_, got := sc.Acquire(d, session1)
+		var want error
+		if got != want {
+			t.Errorf("acquire: got %q want %q", got, want)
+		}
+
+		sc2 := NewSimpleClient(scfg, log, nil, WithKeepAlive(false))
+		defer sc2.Close()
+		session2 := sc2.ConnectWithTTL(300 * time.Millisecond)
+		_, got = sc2.AcquireWait(d, session2, 5*time.Second)
+		want = ErrSessionExpired
+		if got != want {
+			t.Errorf("acquireWait: got %q want %q", got, want)
+		}
+	})
+
+	t.Run("no goroutines are left behind", func(t *testing.T) {
+		transport := http.DefaultTransport.(*http.Transport)
+		transport.CloseIdleConnections()
+		before := runtime.NumGoroutine()
+
+		sc := NewSimpleClient(scfg, log, nil)
+		session1 := sc.Connect()
+		session2 := sc.Connect()
+		d := lockservice.NewObjectDescriptor("test12")
+		for i := 0; i < 20; i++ {
+			if _, err := sc.Acquire(d, session1); err != nil {
+				t.Fatalf("acquire: %v", err)
+			}
+			if err := sc.Release(d, session1); err != nil {
+				t.Fatalf("release: %v", err)
+			}
+		}
+		if _, err := sc.Acquire(d, session1); err != nil {
+			t.Fatalf("acquire: %v", err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		sc.AcquireWaitContext(ctx, d, session2, 5*time.Second)
+		cancel()
+		sc.Close()
+
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			transport.CloseIdleConnections()
+			after := runtime.NumGoroutine()
+			if after <= before {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("goroutines: got %d want at most %d", after, before)
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	})
+
+}
+
+func TestStartService(t *testing.T) {
+	log := zerolog.New(os.Stdout).With().Logger().Level(zerolog.Disabled)
+	scfg := lockservice.NewSimpleConfig("http://127.0.0.1", "1235")
+
+	t.Run("the service can be used once started and stops on close", func(t *testing.T) {
+		sc := NewSimpleClient(scfg, log, nil)
+		if err := sc.StartService(scfg); err != nil {
+			t.Fatalf("startService: %v", err)
+		}
+		if err := sc.StartService(scfg); err == nil {
+			t.Errorf("startService: got <nil> want an error for the port in use")
+		}
+
+		session := sc.Connect()
+		d := lockservice.NewObjectDescriptor("test")
+		_, got := sc.Acquire(d, session)
+		var want error
+		if got != want {
+			t.Errorf("acquire: got %q want %q", got, want)
+		}
+
+		if got := sc.Close(); got != want {
+			t.Errorf("close: got %q want %q", got, want)
+		}
+		l, err := net.Listen("tcp", "127.0.0.1:1235")
+		if err != nil {
+			t.Fatalf("listen: the service still holds the port: %v", err)
+		}
+		l.Close()
+	})
+}
+
+func TestAuthentication(t *testing.T) {
+	log := zerolog.New(os.Stdout).With().Logger().Level(zerolog.Disabled)
+	scfg := lockservice.NewSimpleConfig("http://127.0.0.1", "1237")
+
+	f, err := ioutil.TempFile("", "keyfile")
+	if err != nil {
+		t.Fatalf("keyfile: %v", err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("# principal token\nteamA tokenA\nteamB tokenB\n")
+	f.Close()
+	auth, err := routing.LoadKeyfile(f.Name())
+	if err != nil {
+		t.Fatalf("loadKeyfile: %v", err)
+	}
+
+	n := node.NewSimpleNode(lockservice.NewSimpleLockService(log), *scfg, node.WithAuth(auth), node.WithGRPC("127.0.0.1:0"))
+	if err := n.Start(); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	defer n.Stop(context.Background())
+
+	scA := NewSimpleClient(scfg, log, nil, WithToken("tokenA"))
+	defer scA.Close()
+	scB := NewSimpleClient(scfg, log, nil, WithToken("tokenB"))
+	defer scB.Close()
+
+	t.Run("calls without a valid token are refused", func(t *testing.T) {
+		for _, sc := range []*SimpleClient{
+			NewSimpleClient(scfg, log, nil),
+			NewSimpleClient(scfg, log, nil, WithToken("tokenC")),
+		} {
+			session := sc.Connect()
+			_, got := sc.Acquire(lockservice.NewObjectDescriptor("test"), session)
+			want := lockservice.ErrUnauthenticated
+			if got != want {
+				t.Errorf("acquire: got %v want %v", got, want)
+			}
+			sc.Close()
+		}
+	})
+
+	t.Run("a principal can't release the locks of another with their owner", func(t *testing.T) {
+		session := scA.Connect()
+		d := lockservice.NewObjectDescriptor("test")
+		if _, err := scA.Acquire(d, session); err != nil {
+			t.Fatalf("acquire: %v", err)
+		}
+		owner := session.ProcessID().String()
+		got, err := scA.CheckAcquire(lockservice.ObjectDescriptor{ObjectID: "test"})
+		if err != nil || got != owner {
+			t.Errorf("checkAcquire: got %q, %v want %q, <nil>", got, err, owner)
+		}
+
+		err = scB.release(context.Background(), lockservice.NewLockDescriptor("test", owner))
+		if err != lockservice.ErrUnauthorizedAccess {
+			t.Errorf("release: got %v want %v", err, lockservice.ErrUnauthorizedAccess)
+		}
+		if err := scA.Release(d, session); err != nil {
+			t.Errorf("release: got %v want <nil>", err)
+		}
+	})
+
+	t.Run("the gRPC client authenticates with its token", func(t *testing.T) {
+		host, port, err := net.SplitHostPort(n.GRPCAddr())
+		if err != nil {
+			t.Fatalf("grpcAddr: %v", err)
+		}
+		gcfg := lockservice.NewSimpleConfig("http://"+host, port)
+		for token, want := range map[string]error{"": lockservice.ErrUnauthenticated, "tokenA": nil} {
+			gc, err := NewGRPCClient(gcfg, log, WithToken(token))
+			if err != nil {
+				t.Fatalf("newGRPCClient: %v", err)
+			}
+			session := gc.Connect()
+			d := lockservice.NewObjectDescriptor("grpc")
+			if _, err := gc.Acquire(d, session); !errors.Is(err, want) {
+				t.Errorf("acquire with %q: got %v want %v", token, err, want)
+			}
+			if want == nil {
+				owner := session.ProcessID().String()
+				if got, err := scA.CheckAcquire(lockservice.ObjectDescriptor{ObjectID: "grpc"}); err != nil || got != owner {
+					t.Errorf("checkAcquire: got %q, %v want %q, <nil>", got, err, owner)
+				}
+				if err := gc.Release(d, session); err != nil {
+					t.Errorf("release: got %v want <nil>", err)
+				}
+			}
+			gc.Close()
+		}
+	})
+
+	t.Run("a principal can't use the sessions of another", func(t *testing.T) {
+		ctx := context.Background()
+		session := scA.Connect()
+		defer scA.Disconnect(session)
+		remote := scA.remoteSession(session.ProcessID())
+		if remote == "" {
+			t.Fatalf("connect: no session was created in the lockservice")
+		}
+
+		d := lockservice.NewLockDescriptor("test", "owner")
+		d.SessionID = remote
+		if _, err := scB.acquire(ctx, d, 0); !errors.Is(err, lockservice.ErrSessionNotFound) {
+			t.Errorf("acquire: got %v want %v", err, lockservice.ErrSessionNotFound)
+		}
+		for _, path := range []string{"/heartbeat", "/endSession"} {
+			_, err := scB.post(ctx, "", path, lockservice.SessionRequest{ID: remote})
+			if !errors.Is(err, lockservice.ErrSessionNotFound) {
+				t.Errorf("%s: got %v want %v", path, err, lockservice.ErrSessionNotFound)
+			}
+		}
+		if err := scA.renewSession(session.ProcessID()); err != nil {
+			t.Errorf("renewSession: got %v want <nil>", err)
+		}
+	})
+}
+
+func TestGRPCClient(t *testing.T) {
+	log := zerolog.New(os.Stdout).With().Logger().Level(zerolog.Disabled)
+	scfg := lockservice.NewSimpleConfig("http://127.0.0.1", "1236")
+
+	gc, err := NewGRPCClient(scfg, log)
+	if err != nil {
+		t.Fatalf("newGRPCClient: %v", err)
+	}
+	if err := gc.StartService(scfg); err != nil {
+		t.Fatalf("startService: %v", err)
+	}
+	defer gc.Close()
+
+	t.Run("acquire test, acquire and release test as another session should fail", func(t *testing.T) {
+		s1, s2 := gc.Connect(), gc.Connect()
+		defer s1.Close()
+		defer s2.Close()
+		d := lockservice.NewObjectDescriptor("test")
+
+		token, err := gc.Acquire(d, s1)
+		if err != nil || token == 0 {
+			t.Fatalf("acquire: got %d, %v want a token, <nil>", token, err)
+		}
+		owner, err := gc.CheckAcquire(*d)
+		if err != nil || owner != s1.ProcessID().String() {
+			t.Errorf("checkAcquire: got %q, %v want %q, <nil>", owner, err, s1.ProcessID().String())
+		}
+
+		_, got := gc.Acquire(d, s2)
+		want := lockservice.ErrFileacquired
+		if got != want {
+			t.Errorf("acquire: got %v want %q", got, want)
+		}
+		got = gc.Release(d, s2)
+		want = lockservice.ErrUnauthorizedAccess
+		if got != want {
+			t.Errorf("release: got %v want %q", got, want)
+		}
+
+		if got := gc.Release(d, s1); got != nil {
+			t.Errorf("release: got %q want <nil>", got)
+		}
+		_, got = gc.CheckAcquire(*d)
+		want = lockservice.ErrCheckAcquireFailure
+		if got != want {
+			t.Errorf("checkAcquire: got %v want %q", got, want)
+		}
+	})
+
+	t.Run("watchers are streamed the events of the lock", func(t *testing.T) {
+		s := gc.Connect()
+		defer s.Close()
+		d := lockservice.NewObjectDescriptor("watched")
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		events, err := gc.WatchContext(ctx, d)
+		if err != nil {
+			t.Fatalf("watch: %v", err)
+		}
+
+		if _, err := gc.Acquire(d, s); err != nil {
+			t.Fatalf("acquire: %v", err)
+		}
+		if err := gc.Release(d, s); err != nil {
+			t.Fatalf("release: %v", err)
+		}
+		for _, want := range []lockservice.EventType{lockservice.EventAcquired, lockservice.EventReleased} {
+			select {
+			case ev := <-events:
+				if ev.Type != want || ev.Owner != s.ProcessID().String() {
+					t.Errorf("watch: got %q by %q want %q by %q", ev.Type, ev.Owner, want, s.ProcessID().String())
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("watch: no %q event", want)
+			}
+		}
+
+		cancel()
+		select {
+		case _, ok := <-events:
+			if ok {
+				t.Errorf("watch: got an event want the channel closed")
+			}
+		case <-time.After(5 * time.Second):
+			t.Errorf("watch: the channel isn't closed once the context is done")
+		}
+	})
+
+	t.Run("the locks of a session are released by the lockservice once it lapses", func(t *testing.T) {
+		gc, err := NewGRPCClient(scfg, log, WithSessionTTL(200*time.Millisecond), WithKeepAlive(false))
+		if err != nil {
+			t.Fatalf("newGRPCClient: %v", err)
+		}
+		defer gc.Close()
+
+		s := gc.Connect()
+		d := lockservice.NewObjectDescriptor("lapsed")
+		if _, err := gc.Acquire(d, s); err != nil {
+			t.Fatalf("acquire: %v", err)
+		}
+		time.Sleep(400 * time.Millisecond)
+
+		_, got := gc.CheckAcquire(*d)
+		want := lockservice.ErrCheckAcquireFailure
+		if got != want {
+			t.Errorf("checkAcquire: got %v want %q", got, want)
+		}
+		if _, got := gc.Acquire(d, s); got != ErrSessionNonExistent {
+			t.Errorf("acquire: got %v want %q", got, ErrSessionNonExistent)
+		}
+	})
+
+	t.Run("a wait ends once its context is done", func(t *testing.T) {
+		s1, s2 := gc.Connect(), gc.Connect()
+		defer s1.Close()
+		defer s2.Close()
+		d := lockservice.NewObjectDescriptor("waited")
+		if _, err := gc.Acquire(d, s1); err != nil {
+			t.Fatalf("acquire: %v", err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		_, got := gc.AcquireWaitContext(ctx, d, s2, time.Minute)
+		want := context.DeadlineExceeded
+		if got != want {
+			t.Errorf("acquireWait: got %v want %q", got, want)
+		}
+	})
+
+	t.Run("calls fail once the client is closed", func(t *testing.T) {
+		c, err := NewGRPCClient(scfg, log)
+		if err != nil {
+			t.Fatalf("newGRPCClient: %v", err)
+		}
+		s := c.Connect()
+		d := lockservice.NewObjectDescriptor("closed")
+		if _, err := c.Acquire(d, s); err != nil {
+			t.Fatalf("acquire: %v", err)
+		}
+
+		if err := c.Close(); err != nil {
+			t.Errorf("close: got %q want <nil>", err)
+		}
+		if owner, err := gc.CheckAcquire(*d); err == nil {
+			t.Errorf("checkAcquire: got %q want the lock released on close", owner)
+		}
+		_, got := c.Acquire(d, c.Connect())
+		want := ErrSessionNonExistent
+		if got != want {
+			t.Errorf("acquire: got %v want %q", got, want)
+		}
+	})
 }
 
 // BenchmarkLocKeyWithoutCache stats:     2130	  28828088 ns/op	   15952 B/op	   190 allocs/op
@@ -175,24 +740,17 @@ func BenchmarkLocKeyWithoutCache(b *testing.B) {
 	scfg := lockservice.NewSimpleConfig("http://127.0.0.1", "1234")
 	ls := lockservice.NewSimpleLockService(log)
 
-	quit := make(chan bool, 1)
-	go func() {
-		node.Start(ls, *scfg)
-		for {
-			select {
-			case <-quit:
-				return
-			default:
-			}
-		}
-	}()
-	time.Sleep(100 * time.Millisecond)
+	n := node.NewSimpleNode(ls, *scfg)
+	if err := n.Start(); err != nil {
+		b.Fatalf("start: %v", err)
+	}
+	defer n.Stop(context.Background())
 
 	sc := NewSimpleClient(scfg, log, nil)
 	session := sc.Connect()
 	d := lockservice.NewObjectDescriptor("test")
 	for n := 0; n < b.N; n++ {
-		got := sc.Acquire(d, session)
+		_, got := sc.Acquire(d, session)
 		var want error
 		if got != want {
 			b.Errorf("acquire: got %q want %q", got, want)
@@ -213,18 +771,11 @@ func BenchmarkLocKeyWithCache(b *testing.B) {
 	scfg := lockservice.NewSimpleConfig("http://127.0.0.1", "1234")
 	ls := lockservice.NewSimpleLockService(log)
 
-	quit := make(chan bool, 1)
-	go func() {
-		node.Start(ls, *scfg)
-		for {
-			select {
-			case <-quit:
-				return
-			default:
-			}
-		}
-	}()
-	time.Sleep(100 * time.Millisecond)
+	n := node.NewSimpleNode(ls, *scfg)
+	if err := n.Start(); err != nil {
+		b.Fatalf("start: %v", err)
+	}
+	defer n.Stop(context.Background())
 
 	size := 5
 	cache := cache.NewLRUCache(size)
@@ -232,7 +783,7 @@ func BenchmarkLocKeyWithCache(b *testing.B) {
 	session := sc.Connect()
 	d := lockservice.NewObjectDescriptor("test")
 	for n := 0; n < b.N; n++ {
-		got := sc.Acquire(d, session)
+		_, got := sc.Acquire(d, session)
 		var want error
 		if got != want {
 			b.Errorf("acquire: got %q want %q", got, want)
@@ -244,3 +795,34 @@ func BenchmarkLocKeyWithCache(b *testing.B) {
 		}
 	}
 }
+
+// BenchmarkLocKeyGRPC stats: 18673	    107219 ns/op	   20676 B/op	     367 allocs/op
+func BenchmarkLocKeyGRPC(b *testing.B) {
+	log := zerolog.New(os.Stdout).With().Logger().Level(zerolog.Disabled)
+	scfg := lockservice.NewSimpleConfig("http://127.0.0.1", "1236")
+
+	gc, err := NewGRPCClient(scfg, log)
+	if err != nil {
+		b.Fatalf("newGRPCClient: %v", err)
+	}
+	if err := gc.StartService(scfg); err != nil {
+		b.Fatalf("startService: %v", err)
+	}
+	defer gc.Close()
+
+	session := gc.Connect()
+	d := lockservice.NewObjectDescriptor("test")
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		_, got := gc.Acquire(d, session)
+		var want error
+		if got != want {
+			b.Errorf("acquire: got %q want %q", got, want)
+		}
+
+		got = gc.Release(d, session)
+		if got != want {
+			b.Errorf("release: got %q want %q", got, want)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package lockclient
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/url"
+	"strings"
+
+	"github.com/SystemBuilders/LocKey/internal/lockservice"
+)
+
+// Watch follows the changes in the state of the lock on the object. The
+// events are streamed by the lockservice and delivered on the returned
+// channel in the order they happen. The channel is closed once the stream
+// ends.
+//
+// Watching needs no session, since it doesn't touch the lock.
+func (sc *SimpleClient) Watch(d lockservice.Object) (<-chan lockservice.Event, error) {
+	return sc.WatchContext(context.Background(), d)
+}
+
+// WatchContext is Watch with a context. The stream ends and the channel
+// is closed once the context is done.
+func (sc *SimpleClient) WatchContext(ctx context.Context, d lockservice.Object) (<-chan lockservice.Event, error) {
+	return sc.watch(ctx, d)
+}
+
+// watch streams the events of the lock until the stream ends or the
+// context is cancelled.
+func (sc *SimpleClient) watch(ctx context.Context, d lockservice.Object) (<-chan lockservice.Event, error) {
+	resp, err := sc.do(ctx, "GET", d.ID(), "/watch?fileID="+url.QueryEscape(d.ID()), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make(chan lockservice.Event)
+	go func() {
+		defer close(events)
+		defer resp.Body.Close()
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if !strings.HasPrefix(line, "data: ") {
+				continue
+			}
+			var ev lockservice.Event
+			err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &ev)
+			if err != nil {
+				sc.log.
+					Debug().
+					Str("descriptor", d.ID()).
+					Msg("malformed watch event, stopped watching")
+				return
+			}
+			select {
+			case events <- ev:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return events, nil
+}
@@ -0,0 +1,184 @@
+package lockservice
+
+import (
+	"sort"
+	"time"
+)
+
+// AcquireBatch lets a client acquire locks on a set of objects at once.
+// The whole set is acquired while the shards of all the locks are held:
+// if any of the locks can't be acquired right away, none of them is and
+// the error of the first such lock is returned.
+//
+// The shards are locked in the order of their indices, so that batches
+// that overlap can never deadlock on each other, and the descriptors are
+// visited in the canonical order of their IDs. A batch must not name the
+// same descriptor twice.
+func (ls *SimpleLockService) AcquireBatch(sds []Descriptors) ([]FencingToken, error) {
+	return ls.acquireBatchAt(sds, time.Now())
+}
+
+// acquireBatchAt acquires the locks on the descriptors as AcquireBatch
+// does, at the given time.
+func (ls *SimpleLockService) acquireBatchAt(sds []Descriptors, now time.Time) ([]FencingToken, error) {
+	order, err := canonicalOrder(sds)
+	if err != nil {
+		return nil, err
+	}
+
+	defer ls.lockShards(idsOf(sds))()
+	for _, i := range order {
+		ls.expire(sds[i].ID(), now)
+	}
+	for _, i := range order {
+		if !ls.acquirable(sds[i]) {
+			ls.
+				log.
+				Debug().
+				Str("descriptor", sds[i].ID()).
+				Int("batch", len(sds)).
+				Msg("can't acquire batch, already been acquired")
+			return nil, ErrFileacquired
+		}
+	}
+	for _, i := range order {
+		if err := ls.attach(sds[i]); err != nil {
+			return nil, err
+		}
+	}
+	// The watchers only hear of the locks once the whole batch is
+	// acquired, since a lock that can't be logged undoes the batch.
+	tokens := make([]FencingToken, len(sds))
+	holds := make([]*Hold, len(sds))
+	events := make([]Event, 0, len(sds))
+	for n, i := range order {
+		if hold, held := ls.shard(sds[i].ID()).LockMap[sds[i].ID()].Holders[sds[i].Owner()]; held {
+			holds[i] = &hold
+		}
+		if ls.reentering(sds[i]) {
+			tokens[i], err = ls.reenter(sds[i], now)
+		} else {
+			var ev Event
+			ev, err = ls.issue(sds[i], now, false)
+			tokens[i] = ev.Token
+			events = append(events, ev)
+		}
+		if err != nil {
+			for _, j := range order[:n] {
+				ls.undo(sds[j], holds[j])
+			}
+			return nil, err
+		}
+	}
+	for _, ev := range events {
+		ls.notify(ev)
+	}
+	return tokens, nil
+}
+
+// undo puts back the hold that the owner of the descriptor had before
+// the lock was acquired, or removes the owner from the holders if prev
+// is nil, so that a batch that can't be acquired as a whole leaves
+// no trace. The lock isn't handed over to its waiters and its watchers
+// aren't notified, since they never heard of the acquisition. The
+// mutex of the shard of the lock must be held.
+func (ls *SimpleLockService) undo(sd Descriptors, prev *Hold) {
+	m := ls.shard(sd.ID())
+	entry, ok := m.LockMap[sd.ID()]
+	if !ok {
+		return
+	}
+	rec := Record{
+		Type:  RecordDrop,
+		ID:    sd.ID(),
+		Owner: sd.Owner(),
+	}
+	if prev != nil {
+		rec = Record{
+			Type:  RecordHold,
+			ID:    sd.ID(),
+			Owner: sd.Owner(),
+			Mode:  entry.Mode,
+			Hold:  prev,
+		}
+	}
+	if err := ls.record(rec); err != nil {
+		return
+	}
+	if prev != nil {
+		entry.Holders[sd.Owner()] = *prev
+	} else {
+		delete(entry.Holders, sd.Owner())
+	}
+	if len(entry.Holders) == 0 {
+		delete(m.LockMap, sd.ID())
+	}
+	ls.
+		log.
+		Debug().
+		Str("descriptor", sd.ID()).
+		Str("owner", sd.Owner()).
+		Msg("acquisition undone")
+}
+
+// ReleaseBatch lets a client release locks on a set of objects at once.
+// Like AcquireBatch, either all the locks are released or none of them
+// is, in which case the error of the first lock that couldn't be released
+// is returned. The only exception is a release that can't be appended to
+// the log of the service, in which case the locks released before it
+// stay released.
+func (ls *SimpleLockService) ReleaseBatch(sds []Descriptors) error {
+	return ls.releaseBatchAt(sds, time.Now())
+}
+
+// releaseBatchAt releases the locks on the descriptors as ReleaseBatch
+// does, at the given time.
+func (ls *SimpleLockService) releaseBatchAt(sds []Descriptors, now time.Time) error {
+	order, err := canonicalOrder(sds)
+	if err != nil {
+		return err
+	}
+
+	defer ls.lockShards(idsOf(sds))()
+	for _, i := range order {
+		ls.expire(sds[i].ID(), now)
+	}
+	for _, i := range order {
+		if err := ls.releasable(sds[i]); err != nil {
+			return err
+		}
+	}
+	for _, i := range order {
+		if err := ls.release(sds[i], now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// canonicalOrder returns the indices of the descriptors sorted by their
+// IDs. An error is returned if a descriptor appears more than once.
+func canonicalOrder(sds []Descriptors) ([]int, error) {
+	order := make([]int, len(sds))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool {
+		return sds[order[i]].ID() < sds[order[j]].ID()
+	})
+	for i := 1; i < len(order); i++ {
+		if sds[order[i]].ID() == sds[order[i-1]].ID() {
+			return nil, ErrDuplicateDescriptor
+		}
+	}
+	return order, nil
+}
+
+// idsOf returns the IDs of the descriptors.
+func idsOf(sds []Descriptors) []string {
+	ids := make([]string, len(sds))
+	for i, sd := range sds {
+		ids[i] = sd.ID()
+	}
+	return ids
+}
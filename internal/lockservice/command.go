@@ -0,0 +1,168 @@
+package lockservice
+
+import "time"
+
+// Op is an operation of the lockservice that can be applied as a Command.
+type Op string
+
+const (
+	// OpAcquire acquires the lock of the single request of the command.
+	OpAcquire Op = "acquire"
+	// OpRelease releases the lock of the single request of the command.
+	OpRelease Op = "release"
+	// OpCheckAcquired checks the lock of the single request of the command.
+	OpCheckAcquired Op = "checkAcquired"
+	// OpCheckReleased checks the lock of the single request of the command.
+	OpCheckReleased Op = "checkReleased"
+	// OpAcquireBatch acquires the locks of all the requests of the command.
+	OpAcquireBatch Op = "acquireBatch"
+	// OpReleaseBatch releases the locks of all the requests of the command.
+	OpReleaseBatch Op = "releaseBatch"
+	// OpReap reaps the holds whose lease has run out.
+	OpReap Op = "reap"
+)
+
+// Command is an operation of the lockservice along with the time at
+// which it takes place. Replicas of the lockservice that apply the same
+// commands in the same order end up with the same locks, since nothing
+// in a command depends on the clock of the replica applying it.
+type Command struct {
+	Op       Op            `json:"op"`
+	Requests []LockRequest `json:"requests,omitempty"`
+	Time     time.Time     `json:"time"`
+}
+
+// CommandResult is the result of applying a Command. Err holds the
+// message of the error of the operation, if any.
+type CommandResult struct {
+	Tokens []FencingToken `json:"tokens,omitempty"`
+	Owner  string         `json:"owner,omitempty"`
+	Owners []string       `json:"owners,omitempty"`
+	Mode   LockMode       `json:"mode,omitempty"`
+	OK     bool           `json:"ok,omitempty"`
+	Err    string         `json:"err,omitempty"`
+}
+
+// Error returns the error of the operation as an Error, so that it
+// compares equal to the constant errors of the lockservice.
+func (res CommandResult) Error() error {
+	if res.Err == "" {
+		return nil
+	}
+	return Error(res.Err)
+}
+
+// Descriptor returns the descriptor described by the lock request.
+func (req LockRequest) Descriptor() *LockDescriptor {
+	return &LockDescriptor{
+		FileID:    req.FileID,
+		UserID:    req.UserID,
+		Duration:  req.Lease,
+		Fence:     req.Token,
+		LockMode:  req.Mode,
+		Reenter:   req.Reentrant,
+		SessionID: req.Session,
+	}
+}
+
+// Read answers a command that only reads the lock table, at the time
+// of the command, without changing the table. Holds whose lease has run
+// out are reported as released, but are left for a reap to drop, so that
+// replicas can serve reads without going through their log. Only
+// OpCheckAcquired and OpCheckReleased can be read.
+func (ls *SimpleLockService) Read(cmd Command) CommandResult {
+	var res CommandResult
+	if len(cmd.Requests) != 1 {
+		res.Err = ErrInvalidCommand.Error()
+		return res
+	}
+	sd := cmd.Requests[0].Descriptor()
+	mode, holds := ls.liveHolds(sd.ID(), cmd.Time)
+
+	switch cmd.Op {
+	case OpCheckAcquired:
+		for _, hold := range holds {
+			if tokenMatches(sd, hold) {
+				res.Owner, res.OK = hold.Owner, true
+				break
+			}
+		}
+		if res.OK {
+			res.Mode = mode
+			for _, hold := range holds {
+				res.Owners = append(res.Owners, hold.Owner)
+			}
+		}
+	case OpCheckReleased:
+		res.OK = len(holds) == 0
+	default:
+		res.Err = ErrInvalidCommand.Error()
+	}
+	return res
+}
+
+// liveHolds returns the mode of the lock and the holds on it whose lease
+// hasn't run out at the given time, in the order in which they were
+// acquired.
+func (ls *SimpleLockService) liveHolds(id string, now time.Time) (LockMode, []Hold) {
+	m := ls.shard(id)
+	m.Mutex.RLock()
+	defer m.Mutex.RUnlock()
+	entry := m.LockMap[id]
+	var holds []Hold
+	for _, hold := range entry.Holds() {
+		if !hold.Expired(now) {
+			holds = append(holds, hold)
+		}
+	}
+	if len(holds) == 0 {
+		return "", nil
+	}
+	return entry.Mode, holds
+}
+
+// Apply applies the command to the lockservice at the time of the
+// command. Commands that act on a single lock must carry exactly one
+// request.
+func (ls *SimpleLockService) Apply(cmd Command) CommandResult {
+	var res CommandResult
+	sds := make([]Descriptors, len(cmd.Requests))
+	for i, req := range cmd.Requests {
+		sds[i] = req.Descriptor()
+	}
+	if cmd.Op != OpReap && cmd.Op != OpAcquireBatch && cmd.Op != OpReleaseBatch && len(sds) != 1 {
+		res.Err = ErrInvalidCommand.Error()
+		return res
+	}
+
+	var err error
+	switch cmd.Op {
+	case OpAcquire:
+		var token FencingToken
+		token, err = ls.acquireAt(sds[0], cmd.Time)
+		if err == nil {
+			res.Tokens = []FencingToken{token}
+		}
+	case OpRelease:
+		err = ls.releaseAt(sds[0], cmd.Time)
+	case OpCheckAcquired:
+		res.Owner, res.OK = ls.checkAcquiredAt(sds[0], cmd.Time)
+		if res.OK {
+			res.Mode, res.Owners = ls.holdersAt(sds[0], cmd.Time)
+		}
+	case OpCheckReleased:
+		res.OK = ls.checkReleasedAt(sds[0], cmd.Time)
+	case OpAcquireBatch:
+		res.Tokens, err = ls.acquireBatchAt(sds, cmd.Time)
+	case OpReleaseBatch:
+		err = ls.releaseBatchAt(sds, cmd.Time)
+	case OpReap:
+		ls.reapAt(cmd.Time)
+	default:
+		err = ErrInvalidCommand
+	}
+	if err != nil {
+		res.Err = err.Error()
+	}
+	return res
+}
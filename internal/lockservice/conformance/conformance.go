@@ -0,0 +1,323 @@
+package conformance
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/SystemBuilders/LocKey/internal/lockservice"
+)
+
+// waitTimeout bounds the waits of the suite, so that a misbehaving
+// implementation fails the suite instead of hanging it.
+const waitTimeout = 5 * time.Second
+
+// Run runs the conformance suite against the lockservice returned by
+// newService, which is called for a fresh lockservice in every test. The
+// tests of an extension interface are skipped if the lockservice doesn't
+// implement it.
+func Run(t *testing.T, newService func(t *testing.T) lockservice.LockService) {
+	t.Run("acquire and release", func(t *testing.T) {
+		ls := newService(t)
+		d := lockservice.NewLockDescriptor("test", "owner1")
+
+		if !ls.CheckReleased(d) {
+			t.Errorf("checkRelease: a lock that was never acquired is held")
+		}
+		if _, err := ls.Acquire(d); err != nil {
+			t.Fatalf("acquire: got %q want <nil>", err)
+		}
+		owner, ok := ls.CheckAcquired(lockservice.NewLockDescriptor("test", ""))
+		if !ok || owner != "owner1" {
+			t.Errorf("checkAcquire: got %q, %v want %q, true", owner, ok, "owner1")
+		}
+		if ls.CheckReleased(d) {
+			t.Errorf("checkRelease: an acquired lock is reported released")
+		}
+		if err := ls.Release(d); err != nil {
+			t.Fatalf("release: got %q want <nil>", err)
+		}
+		if _, ok := ls.CheckAcquired(d); ok {
+			t.Errorf("checkAcquire: a released lock is still held")
+		}
+		if !ls.CheckReleased(d) {
+			t.Errorf("checkRelease: a released lock is still held")
+		}
+	})
+
+	t.Run("a held lock can't be acquired or released by others", func(t *testing.T) {
+		ls := newService(t)
+		if _, err := ls.Acquire(lockservice.NewLockDescriptor("test", "owner1")); err != nil {
+			t.Fatalf("acquire: got %q want <nil>", err)
+		}
+
+		_, got := ls.Acquire(lockservice.NewLockDescriptor("test", "owner2"))
+		checkError(t, "acquire", got, lockservice.ErrFileacquired)
+		got = ls.Release(lockservice.NewLockDescriptor("test", "owner2"))
+		checkError(t, "release", got, lockservice.ErrUnauthorizedAccess)
+		if owner, _ := ls.CheckAcquired(lockservice.NewLockDescriptor("test", "")); owner != "owner1" {
+			t.Errorf("checkAcquire: got %q want %q", owner, "owner1")
+		}
+	})
+
+	t.Run("a lock that isn't held can't be released", func(t *testing.T) {
+		ls := newService(t)
+		got := ls.Release(lockservice.NewLockDescriptor("test", "owner1"))
+		checkError(t, "release", got, lockservice.ErrCantReleaseFile)
+	})
+
+	t.Run("fencing tokens increase with every acquisition", func(t *testing.T) {
+		ls := newService(t)
+		var last lockservice.FencingToken
+		for _, owner := range []string{"owner1", "owner2", "owner3"} {
+			d := lockservice.NewLockDescriptor("test", owner)
+			token, err := ls.Acquire(d)
+			if err != nil {
+				t.Fatalf("acquire: got %q want <nil>", err)
+			}
+			if token <= last {
+				t.Errorf("acquire: got token %d want more than %d", token, last)
+			}
+			last = token
+			if err := ls.Release(d); err != nil {
+				t.Fatalf("release: got %q want <nil>", err)
+			}
+		}
+	})
+
+	t.Run("a stale token can't release the lock", func(t *testing.T) {
+		ls := newService(t)
+		d := lockservice.NewLockDescriptor("test", "owner1")
+		token, err := ls.Acquire(d)
+		if err != nil {
+			t.Fatalf("acquire: got %q want <nil>", err)
+		}
+
+		stale := lockservice.NewLockDescriptor("test", "owner1")
+		stale.Fence = token + 1
+		checkError(t, "release", ls.Release(stale), lockservice.ErrStaleToken)
+
+		d.Fence = token
+		if err := ls.Release(d); err != nil {
+			t.Errorf("release: got %q want <nil>", err)
+		}
+	})
+
+	t.Run("batches are acquired and released all at once", func(t *testing.T) {
+		ls := newService(t)
+		if _, err := ls.Acquire(lockservice.NewLockDescriptor("b", "owner2")); err != nil {
+			t.Fatalf("acquire: got %q want <nil>", err)
+		}
+
+		batch := []lockservice.Descriptors{
+			lockservice.NewLockDescriptor("a", "owner1"),
+			lockservice.NewLockDescriptor("b", "owner1"),
+		}
+		_, got := ls.AcquireBatch(batch)
+		checkError(t, "acquireBatch", got, lockservice.ErrFileacquired)
+		if !ls.CheckReleased(batch[0]) {
+			t.Errorf("checkRelease: a failed batch left a lock held")
+		}
+
+		if err := ls.Release(lockservice.NewLockDescriptor("b", "owner2")); err != nil {
+			t.Fatalf("release: got %q want <nil>", err)
+		}
+		tokens, err := ls.AcquireBatch(batch)
+		if err != nil {
+			t.Fatalf("acquireBatch: got %q want <nil>", err)
+		}
+		if len(tokens) != len(batch) {
+			t.Errorf("acquireBatch: got %d tokens want %d", len(tokens), len(batch))
+		}
+		if err := ls.ReleaseBatch(batch); err != nil {
+			t.Fatalf("releaseBatch: got %q want <nil>", err)
+		}
+		for _, d := range batch {
+			if !ls.CheckReleased(d) {
+				t.Errorf("checkRelease: %q is still held after the batch was released", d.ID())
+			}
+		}
+	})
+
+	t.Run("waiters are handed the lock on release", func(t *testing.T) {
+		ls := newService(t)
+		waiter, ok := ls.(lockservice.Waiter)
+		skip(t, ok, "Waiter")
+		if _, err := ls.Acquire(lockservice.NewLockDescriptor("test", "owner1")); err != nil {
+			t.Fatalf("acquire: got %q want <nil>", err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		_, got := waiter.AcquireWait(ctx, lockservice.NewLockDescriptor("test", "owner2"))
+		cancel()
+		checkError(t, "acquireWait", got, lockservice.ErrAcquireTimeout)
+
+		granted := make(chan error, 1)
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), waitTimeout)
+			defer cancel()
+			_, err := waiter.AcquireWait(ctx, lockservice.NewLockDescriptor("test", "owner2"))
+			granted <- err
+		}()
+		time.Sleep(50 * time.Millisecond)
+		if err := ls.Release(lockservice.NewLockDescriptor("test", "owner1")); err != nil {
+			t.Fatalf("release: got %q want <nil>", err)
+		}
+		if err := <-granted; err != nil {
+			t.Fatalf("acquireWait: got %q want <nil>", err)
+		}
+		if owner, _ := ls.CheckAcquired(lockservice.NewLockDescriptor("test", "")); owner != "owner2" {
+			t.Errorf("checkAcquire: got %q want %q", owner, "owner2")
+		}
+	})
+
+	t.Run("holders extend their leases", func(t *testing.T) {
+		ls := newService(t)
+		extender, ok := ls.(lockservice.Extender)
+		skip(t, ok, "Extender")
+		d := lockservice.NewLeasedLockDescriptor("test", "owner1", 100*time.Millisecond)
+		if _, err := ls.Acquire(d); err != nil {
+			t.Fatalf("acquire: got %q want <nil>", err)
+		}
+
+		if err := extender.Extend(lockservice.NewLeasedLockDescriptor("test", "owner1", time.Minute)); err != nil {
+			t.Fatalf("extend: got %q want <nil>", err)
+		}
+		time.Sleep(150 * time.Millisecond)
+		if owner, _ := ls.CheckAcquired(lockservice.NewLockDescriptor("test", "")); owner != "owner1" {
+			t.Errorf("checkAcquire: got %q want %q", owner, "owner1")
+		}
+		got := extender.Extend(lockservice.NewLockDescriptor("test", "owner2"))
+		checkError(t, "extend", got, lockservice.ErrNotHeld)
+	})
+
+	t.Run("holders of a shared lock are listed", func(t *testing.T) {
+		ls := newService(t)
+		lister, ok := ls.(lockservice.Lister)
+		skip(t, ok, "Lister")
+		for _, owner := range []string{"owner1", "owner2"} {
+			if _, err := ls.Acquire(lockservice.NewSharedLockDescriptor("test", owner)); err != nil {
+				t.Fatalf("acquire: got %q want <nil>", err)
+			}
+		}
+
+		mode, owners := lister.Holders(lockservice.NewLockDescriptor("test", ""))
+		sort.Strings(owners)
+		if mode != lockservice.Shared || len(owners) != 2 || owners[0] != "owner1" || owners[1] != "owner2" {
+			t.Errorf("holders: got %q, %q want %q, [owner1 owner2]", mode, owners, lockservice.Shared)
+		}
+	})
+
+	t.Run("watchers see the lock change hands", func(t *testing.T) {
+		ls := newService(t)
+		watcher, ok := ls.(lockservice.Watcher)
+		skip(t, ok, "Watcher")
+		events, stop := watcher.Watch(lockservice.NewObjectDescriptor("test"))
+		defer stop()
+
+		d := lockservice.NewLockDescriptor("test", "owner1")
+		if _, err := ls.Acquire(d); err != nil {
+			t.Fatalf("acquire: got %q want <nil>", err)
+		}
+		if err := ls.Release(d); err != nil {
+			t.Fatalf("release: got %q want <nil>", err)
+		}
+		for _, want := range []lockservice.EventType{lockservice.EventAcquired, lockservice.EventReleased} {
+			select {
+			case e := <-events:
+				if e.Type != want || e.Owner != "owner1" {
+					t.Errorf("watch: got %q by %q want %q by %q", e.Type, e.Owner, want, "owner1")
+				}
+			case <-time.After(waitTimeout):
+				t.Fatalf("watch: no %q event", want)
+			}
+		}
+	})
+
+	t.Run("pouncers get the lock once it's released", func(t *testing.T) {
+		ls := newService(t)
+		pouncer, ok := ls.(lockservice.Pouncer)
+		skip(t, ok, "Pouncer")
+		if _, err := ls.Acquire(lockservice.NewLockDescriptor("test", "owner1")); err != nil {
+			t.Fatalf("acquire: got %q want <nil>", err)
+		}
+		pouncer.Pounce(lockservice.NewLockDescriptor("test", "owner2"))
+
+		if err := ls.Release(lockservice.NewLockDescriptor("test", "owner1")); err != nil {
+			t.Fatalf("release: got %q want <nil>", err)
+		}
+		if owner, _ := ls.CheckAcquired(lockservice.NewLockDescriptor("test", "")); owner != "owner2" {
+			t.Errorf("checkAcquire: got %q want %q", owner, "owner2")
+		}
+		got := pouncer.Unpounce(lockservice.NewLockDescriptor("test", "owner3"))
+		checkError(t, "unpounce", got, lockservice.ErrNotPouncing)
+	})
+
+	t.Run("semaphores hand out at most their limit", func(t *testing.T) {
+		ls := newService(t)
+		sk, ok := ls.(lockservice.SemaphoreKeeper)
+		skip(t, ok, "SemaphoreKeeper")
+		d := lockservice.NewLockDescriptor("test", "owner1")
+		if err := sk.AcquirePermits(d, 2, 3); err != nil {
+			t.Fatalf("acquirePermits: got %q want <nil>", err)
+		}
+		got := sk.AcquirePermits(lockservice.NewLockDescriptor("test", "owner2"), 2, 3)
+		checkError(t, "acquirePermits", got, lockservice.ErrNoPermits)
+		if status := sk.CheckSemaphore(d); status.Available != 1 {
+			t.Errorf("checkSemaphore: got %d available want 1", status.Available)
+		}
+		if err := sk.ReleasePermits(d, 2); err != nil {
+			t.Fatalf("releasePermits: got %q want <nil>", err)
+		}
+		if err := sk.AcquirePermits(lockservice.NewLockDescriptor("test", "owner2"), 3, 3); err != nil {
+			t.Errorf("acquirePermits: got %q want <nil>", err)
+		}
+	})
+
+	t.Run("ending a session releases its locks", func(t *testing.T) {
+		ls := newService(t)
+		sk, ok := ls.(lockservice.SessionKeeper)
+		skip(t, ok, "SessionKeeper")
+		s, err := sk.CreateSession(time.Minute)
+		if err != nil {
+			t.Fatalf("createSession: got %q want <nil>", err)
+		}
+		d := lockservice.NewLockDescriptor("test", "owner1")
+		d.SessionID = s.ID
+		if _, err := ls.Acquire(d); err != nil {
+			t.Fatalf("acquire: got %q want <nil>", err)
+		}
+		if _, err := sk.Heartbeat(s.ID); err != nil {
+			t.Errorf("heartbeat: got %q want <nil>", err)
+		}
+
+		if err := sk.EndSession(s.ID); err != nil {
+			t.Fatalf("endSession: got %q want <nil>", err)
+		}
+		if !ls.CheckReleased(d) {
+			t.Errorf("checkRelease: the lock of an ended session is still held")
+		}
+		_, got := sk.Heartbeat(s.ID)
+		checkError(t, "heartbeat", got, lockservice.ErrSessionNotFound)
+	})
+}
+
+// skip skips the test of an extension interface that the lockservice
+// doesn't implement.
+func skip(t *testing.T, ok bool, name string) {
+	t.Helper()
+	if !ok {
+		t.Skipf("lockservice isn't a %s", name)
+	}
+}
+
+// checkError fails the test if the error of the call isn't the wanted
+// one. Errors are compared by their message, so that implementations
+// that pass them over the network can conform.
+func checkError(t *testing.T, call string, got, want error) {
+	t.Helper()
+	if got == nil || got.Error() != want.Error() {
+		t.Errorf("%s: got %v want %q", call, got, want)
+	}
+}
@@ -0,0 +1,31 @@
+package conformance
+
+import (
+	"os"
+	"testing"
+
+	"github.com/SystemBuilders/LocKey/internal/lockservice"
+	"github.com/rs/zerolog"
+)
+
+// coreService hides the extensions of a lockservice, leaving only the
+// calls of the LockService interface.
+type coreService struct {
+	lockservice.LockService
+}
+
+func TestConformance(t *testing.T) {
+	log := zerolog.New(os.Stdout).With().Logger().Level(zerolog.Disabled)
+
+	t.Run("simple lockservice", func(t *testing.T) {
+		Run(t, func(t *testing.T) lockservice.LockService {
+			return lockservice.NewSimpleLockService(log)
+		})
+	})
+
+	t.Run("lockservice without extensions", func(t *testing.T) {
+		Run(t, func(t *testing.T) lockservice.LockService {
+			return coreService{lockservice.NewSimpleLockService(log)}
+		})
+	})
+}
@@ -0,0 +1,6 @@
+// Package conformance implements a suite of tests that any implementation
+// of the lockservice can run to check that it behaves like the lockservice
+// expects it to. The calls of the LockService interface are always tested,
+// while the features of the extension interfaces are tested only if the
+// implementation offers them.
+package conformance
@@ -13,4 +13,63 @@ const (
 	ErrUnauthorizedAccess  = Error("file cannot be released, unauthorized access")
 	ErrCheckAcquireFailure = Error("file is not acquired")
 	ErrFileUnlocked        = Error("file doesn't have a lock")
+	ErrStaleToken          = Error("fencing token doesn't belong to the current acquisition")
+	ErrAcquireTimeout      = Error("timed out waiting for the lock")
+	ErrDuplicateDescriptor = Error("descriptor appears more than once in the batch")
+	ErrInvalidPermits      = Error("number of permits must be positive and within the limit")
+	ErrNoPermits           = Error("not enough permits available")
+	ErrSemaphoreLimit      = Error("semaphore exists with a different number of permits")
+	ErrPermitsNotHeld      = Error("permits cannot be released, not held")
+	ErrNotPouncing         = Error("process isn't pouncing on the file")
+	ErrCorruptSnapshot     = Error("snapshot is corrupt")
+	ErrInvalidCommand      = Error("command has an unknown operation or the wrong number of requests")
+	ErrSessionNotFound     = Error("session doesn't exist or has lapsed")
+	ErrUnsupported         = Error("lockservice doesn't support this call")
+	ErrNotHeld             = Error("file isn't held by the owner")
+	ErrUnauthenticated     = Error("request doesn't carry a valid token")
 )
+
+// errorCodes are the codes by which the HTTP API reports the errors.
+// Unlike the messages of the errors, the codes are stable, so clients
+// can tell the errors apart by them.
+var errorCodes = map[Error]string{
+	ErrFileacquired:        "lock_held",
+	ErrCantReleaseFile:     "lock_not_found",
+	ErrUnauthorizedAccess:  "unauthorized_owner",
+	ErrCheckAcquireFailure: "lock_not_acquired",
+	ErrFileUnlocked:        "lock_unlocked",
+	ErrStaleToken:          "stale_token",
+	ErrAcquireTimeout:      "acquire_timeout",
+	ErrDuplicateDescriptor: "duplicate_descriptor",
+	ErrInvalidPermits:      "invalid_permits",
+	ErrNoPermits:           "no_permits",
+	ErrSemaphoreLimit:      "semaphore_limit_mismatch",
+	ErrPermitsNotHeld:      "permits_not_held",
+	ErrNotPouncing:         "not_pouncing",
+	ErrCorruptSnapshot:     "corrupt_snapshot",
+	ErrInvalidCommand:      "invalid_command",
+	ErrSessionNotFound:     "session_not_found",
+	ErrUnsupported:         "unsupported",
+	ErrNotHeld:             "not_held",
+	ErrUnauthenticated:     "unauthenticated",
+}
+
+// CodeOf returns the stable code of the error, which is empty if it
+// isn't an error of the lockservice.
+func CodeOf(err error) string {
+	if e, ok := err.(Error); ok {
+		return errorCodes[e]
+	}
+	return ""
+}
+
+// ErrorOf returns the error of the lockservice that has the code, and
+// false if no error has it.
+func ErrorOf(code string) (Error, bool) {
+	for err, c := range errorCodes {
+		if c == code {
+			return err, true
+		}
+	}
+	return "", false
+}
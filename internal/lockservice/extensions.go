@@ -0,0 +1,97 @@
+package lockservice
+
+import (
+	"context"
+	"time"
+)
+
+// The extension interfaces describe the features a LockService may offer
+// on top of its locks. The HTTP layer serves a feature only if the service
+// implements its interface, so any LockService can be served.
+
+// Waiter describes a lockservice whose acquisitions can wait for a held
+// lock to be handed over to them.
+type Waiter interface {
+	AcquireWait(context.Context, Descriptors) (FencingToken, error)
+}
+
+// Lister describes a lockservice that lists the holders of a lock along
+// with the mode in which they hold it.
+type Lister interface {
+	Holders(Descriptors) (LockMode, []string)
+}
+
+// Watcher describes a lockservice that streams the changes in the state
+// of a lock. The returned function stops the stream.
+type Watcher interface {
+	Watch(Object) (<-chan Event, func())
+}
+
+// Pouncer describes a lockservice that hands a held lock over to the
+// processes pouncing on it once it's released.
+type Pouncer interface {
+	Pounce(Descriptors) (FencingToken, error)
+	Unpounce(Descriptors) error
+}
+
+// SemaphoreKeeper describes a lockservice that keeps counting semaphores.
+type SemaphoreKeeper interface {
+	AcquirePermits(sd Descriptors, permits, limit int) error
+	ReleasePermits(sd Descriptors, permits int) error
+	CheckSemaphore(Descriptors) SemaphoreStatus
+}
+
+// SessionKeeper describes a lockservice that keeps the sessions of its
+// clients and releases their locks once they lapse.
+type SessionKeeper interface {
+	CreateSession(ttl time.Duration) (Session, error)
+	Heartbeat(id string) (Session, error)
+	EndSession(id string) error
+}
+
+// PrefixedSessionKeeper describes a session keeper whose sessions can be
+// given IDs that start with a prefix. The HTTP layer prefixes the sessions
+// of an authenticated request with its principal, so that a principal
+// can't renew, end or acquire in the sessions of another.
+type PrefixedSessionKeeper interface {
+	SessionKeeper
+	CreatePrefixedSession(prefix string, ttl time.Duration) (Session, error)
+}
+
+// Extender describes a lockservice whose holders can extend the lease
+// of their hold.
+type Extender interface {
+	Extend(Descriptors) error
+}
+
+// Snapshotter describes a lockservice that takes snapshots of its lock
+// table.
+type Snapshotter interface {
+	Snapshot() Snapshot
+}
+
+// Reaper describes a lockservice whose expired leases are reaped in the
+// background, at the given interval until the context is done.
+type Reaper interface {
+	RunReaper(ctx context.Context, interval time.Duration)
+}
+
+// Checkpointer describes a lockservice that persists snapshots in the
+// background, at the given interval until the context is done.
+type Checkpointer interface {
+	RunCheckpointer(ctx context.Context, interval time.Duration)
+}
+
+var (
+	_ Waiter                = (*SimpleLockService)(nil)
+	_ Lister                = (*SimpleLockService)(nil)
+	_ Watcher               = (*SimpleLockService)(nil)
+	_ Pouncer               = (*SimpleLockService)(nil)
+	_ SemaphoreKeeper       = (*SimpleLockService)(nil)
+	_ SessionKeeper         = (*SimpleLockService)(nil)
+	_ PrefixedSessionKeeper = (*SimpleLockService)(nil)
+	_ Extender              = (*SimpleLockService)(nil)
+	_ Snapshotter           = (*SimpleLockService)(nil)
+	_ Reaper                = (*SimpleLockService)(nil)
+	_ Checkpointer          = (*SimpleLockService)(nil)
+)
@@ -0,0 +1,56 @@
+package lockservice
+
+import "time"
+
+// Extend renews the lease of the hold of the owner of the descriptor,
+// which then runs out once the lease of the descriptor has passed from
+// now. A descriptor without a lease renews the hold for the default
+// lease of the lockservice.
+//
+// ErrFileUnlocked is returned if the lock isn't held, ErrNotHeld if
+// the owner doesn't hold it and ErrStaleToken if the descriptor presents
+// a fencing token that doesn't belong to the hold.
+func (ls *SimpleLockService) Extend(sd Descriptors) error {
+	return ls.extendAt(sd, time.Now())
+}
+
+// extendAt renews the lease of the hold as Extend does, at the given
+// time.
+func (ls *SimpleLockService) extendAt(sd Descriptors, now time.Time) error {
+	m := ls.shard(sd.ID())
+	m.Mutex.Lock()
+	defer m.Mutex.Unlock()
+	ls.expire(sd.ID(), now)
+	entry, ok := m.LockMap[sd.ID()]
+	if !ok {
+		return ErrFileUnlocked
+	}
+	hold, held := entry.Holders[sd.Owner()]
+	if !held {
+		return ErrNotHeld
+	}
+	if !tokenMatches(sd, hold) {
+		return ErrStaleToken
+	}
+
+	hold.Timestamp = now
+	hold.Lease = ls.leaseOf(sd)
+	if err := ls.record(Record{
+		Type:  RecordHold,
+		ID:    sd.ID(),
+		Owner: sd.Owner(),
+		Mode:  entry.Mode,
+		Hold:  &hold,
+	}); err != nil {
+		return err
+	}
+	entry.Holders[sd.Owner()] = hold
+	ls.
+		log.
+		Debug().
+		Str("descriptor", sd.ID()).
+		Str("owner", sd.Owner()).
+		Dur("lease", hold.Lease).
+		Msg("lease extended")
+	return nil
+}
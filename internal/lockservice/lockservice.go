@@ -1,5 +1,7 @@
 package lockservice
 
+import "time"
+
 // LockService describes a lock service component that enables
 // maintaining a set of locks. This service is a standalone component
 // that can be implemented on any server component, distributed or not.
@@ -7,18 +9,33 @@ type LockService interface {
 	// Acquire allows the service to set a lock on the given descriptors.
 	// An error is generated if the same isn't possible for any reason,
 	// including already existing locks on the descriptor.
-	Acquire(Descriptors) error
+	// Every successful acquisition returns a fencing token which is
+	// greater than any token issued before for the same descriptor.
+	Acquire(Descriptors) (FencingToken, error)
 	// Release allows the service to release the lock on the given descriptors.
 	// An error is generated if the same isn't possible for any reason,
 	// including releasing locks on non-acquired descriptors.
+	// If the descriptor presents a fencing token, it must be the token
+	// of the current acquisition.
 	Release(Descriptors) error
 	// CheckAcquired checks whether a lock has been acquired on the given descriptor.
 	// The function returns true if the lock has been acquired on the component.
-	// It also returns the owner of the lock on query.
+	// It also returns the owner of the lock on query. If the descriptor
+	// presents a fencing token, the lock is reported as acquired only if
+	// the token belongs to the current acquisition.
 	CheckAcquired(Descriptors) (string, bool)
 	// CheckReleased checks whether a lock has been released (or not acquired) on the
 	// given component. Returns true if there are no locks on the descriptor.
 	CheckReleased(Descriptors) bool
+	// AcquireBatch allows the service to set locks on all the given
+	// descriptors at once. Either all the locks are acquired or none
+	// of them is. The fencing tokens are returned in the order of the
+	// descriptors.
+	AcquireBatch([]Descriptors) ([]FencingToken, error)
+	// ReleaseBatch allows the service to release the locks on all the
+	// given descriptors at once. Either all the locks are released or
+	// none of them is.
+	ReleaseBatch([]Descriptors) error
 }
 
 // Descriptors describe the type of data that a lock acquiring component must describe.
@@ -27,6 +44,52 @@ type Descriptors interface {
 	Owner() string
 }
 
+// LeasedDescriptors describe descriptors that carry the duration
+// for which the lock on them must be leased.
+type LeasedDescriptors interface {
+	Descriptors
+	Lease() time.Duration
+}
+
+// FencingToken is a number issued on every acquisition of a lock.
+// Tokens of a lock strictly increase with every acquisition, which
+// lets the storage guarded by the lock reject writes from holders
+// whose lock has since been taken over.
+type FencingToken uint64
+
+// TokenDescriptors describe descriptors that present the fencing
+// token of the acquisition they refer to. A zero token presents nothing.
+type TokenDescriptors interface {
+	Descriptors
+	Token() FencingToken
+}
+
+// LockMode describes how a lock is shared between its holders.
+type LockMode string
+
+const (
+	// Exclusive locks are held by a single process at a time.
+	Exclusive LockMode = "exclusive"
+	// Shared locks can be held by many processes at once, as long
+	// as none of them holds the lock exclusively.
+	Shared LockMode = "shared"
+)
+
+// ModeDescriptors describe descriptors that choose the mode in which
+// the lock on them is acquired. Locks are exclusive unless asked otherwise.
+type ModeDescriptors interface {
+	Descriptors
+	Mode() LockMode
+}
+
+// ReentrantDescriptors describe descriptors that may acquire a lock
+// their owner already holds. Every such acquisition must be matched
+// by a release before the lock is freed.
+type ReentrantDescriptors interface {
+	Descriptors
+	Reentrant() bool
+}
+
 // Object describes any object that can be used with the lockservice.
 type Object interface {
 	ID() string
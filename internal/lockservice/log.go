@@ -0,0 +1,200 @@
+package lockservice
+
+import "time"
+
+// RecordType describes a change made to the lock map.
+type RecordType string
+
+const (
+	// RecordHold is appended when a hold on a lock is granted or
+	// changed. It carries the hold as it is after the change.
+	RecordHold RecordType = "hold"
+	// RecordDrop is appended when a holder loses its lock, either
+	// by releasing it or by letting its lease run out.
+	RecordDrop RecordType = "drop"
+	// RecordPermits is appended when an owner acquires or returns
+	// permits of a semaphore. It carries the permits held after
+	// the change.
+	RecordPermits RecordType = "permits"
+	// RecordDropPermits is appended when an owner no longer holds
+	// any permits of a semaphore.
+	RecordDropPermits RecordType = "dropPermits"
+)
+
+// Record is a single change made to the lock map. Records carry the
+// state of the hold they change rather than the request that changed
+// it, so that replaying them doesn't depend on the time of the replay.
+type Record struct {
+	Type    RecordType  `json:"type"`
+	ID      string      `json:"id"`
+	Owner   string      `json:"owner"`
+	Mode    LockMode    `json:"mode,omitempty"`
+	Hold    *Hold       `json:"hold,omitempty"`
+	Limit   int         `json:"limit,omitempty"`
+	Permits *PermitHold `json:"permits,omitempty"`
+}
+
+// Log is a durable log of the changes made to the lock map. The
+// lockservice appends every change to the log before making it, while
+// it holds the mutex of the shard of the change, and replays the log
+// to recover its locks. A change that can't be appended isn't made.
+// Changes made in different shards may be appended concurrently.
+type Log interface {
+	// Append adds the record to the end of the log.
+	Append(Record) error
+	// Replay calls apply with every record of the log, in the
+	// order in which they were appended.
+	Replay(apply func(Record) error) error
+	// Reset discards all the records of the log, once they are
+	// covered by a snapshot.
+	Reset() error
+	// Close flushes the log and releases its resources.
+	Close() error
+}
+
+// WithLog makes the service append every change of its lock map to the
+// given log. The locks in the log are only recovered by Recover.
+func WithLog(l Log) Option {
+	return func(ls *SimpleLockService) {
+		ls.wal = l
+	}
+}
+
+// Recover rebuilds the lock map from the latest valid snapshot of the
+// service and replays the log of the changes made since on top of it.
+// It is meant to be called once, before the service starts serving.
+// Holds whose lease ran out while the service was down are recovered
+// as well and are reaped like any other expired hold. The sessions of
+// the snapshot are recovered along with their holds. Sessions aren't
+// logged, so the sessions of the holds that were logged since are
+// recovered with DefaultSessionTTL, and lapse unless their clients
+// resume their heartbeats.
+func (ls *SimpleLockService) Recover() error {
+	defer ls.lockAll()()
+	defer ls.bindSessions(true, time.Now())
+	if ls.snapshots != nil {
+		snap, ok, err := ls.snapshots.Latest()
+		if err != nil {
+			return err
+		}
+		if ok {
+			ls.restore(snap)
+			ls.
+				log.
+				Debug().
+				Time("time", snap.Time).
+				Int("locks", len(snap.Locks)).
+				Msg("restored snapshot")
+		}
+	}
+	if ls.wal == nil {
+		return nil
+	}
+	replayed := 0
+	err := ls.wal.Replay(func(rec Record) error {
+		ls.apply(rec)
+		replayed++
+		return nil
+	})
+	ls.
+		log.
+		Debug().
+		Int("records", replayed).
+		Int("locks", ls.locks()).
+		Msg("recovered lock map")
+	return err
+}
+
+// Close takes a final checkpoint of the service and closes its log,
+// if any.
+func (ls *SimpleLockService) Close() error {
+	if err := ls.Checkpoint(); err != nil {
+		ls.
+			log.
+			Error().
+			Err(err).
+			Msg("can't take a checkpoint")
+	}
+	if ls.wal == nil {
+		return nil
+	}
+	return ls.wal.Close()
+}
+
+// record appends the record to the log of the service. It is called
+// before the change is made to the lock map, which must be left alone
+// if the record can't be appended, so that the map never holds a change
+// that would be lost on a crash. The mutex of the shard of the change
+// must be held.
+func (ls *SimpleLockService) record(rec Record) error {
+	if ls.wal == nil {
+		return nil
+	}
+	if err := ls.wal.Append(rec); err != nil {
+		ls.
+			log.
+			Error().
+			Err(err).
+			Str("descriptor", rec.ID).
+			Str("record", string(rec.Type)).
+			Msg("can't append to the log")
+		return err
+	}
+	return nil
+}
+
+// apply makes the change described by the record to the lock map,
+// without notifying watchers or handing locks over to waiters. The
+// mutex of the shard of the change must be held.
+func (ls *SimpleLockService) apply(rec Record) {
+	m := ls.shard(rec.ID)
+	switch rec.Type {
+	case RecordHold:
+		if rec.Hold == nil {
+			return
+		}
+		entry, ok := m.LockMap[rec.ID]
+		if !ok {
+			entry = LockMapEntry{
+				Mode:    rec.Mode,
+				Holders: make(map[string]Hold),
+			}
+			m.LockMap[rec.ID] = entry
+		}
+		entry.Holders[rec.Owner] = *rec.Hold
+		if rec.Hold.Token > m.Tokens[rec.ID] {
+			m.Tokens[rec.ID] = rec.Hold.Token
+		}
+	case RecordDrop:
+		entry, ok := m.LockMap[rec.ID]
+		if !ok {
+			return
+		}
+		delete(entry.Holders, rec.Owner)
+		if len(entry.Holders) == 0 {
+			delete(m.LockMap, rec.ID)
+		}
+	case RecordPermits:
+		if rec.Permits == nil {
+			return
+		}
+		entry, ok := m.Semaphores[rec.ID]
+		if !ok {
+			entry = SemaphoreEntry{
+				Limit:   rec.Limit,
+				Holders: make(map[string]PermitHold),
+			}
+			m.Semaphores[rec.ID] = entry
+		}
+		entry.Holders[rec.Owner] = *rec.Permits
+	case RecordDropPermits:
+		entry, ok := m.Semaphores[rec.ID]
+		if !ok {
+			return
+		}
+		delete(entry.Holders, rec.Owner)
+		if len(entry.Holders) == 0 {
+			delete(m.Semaphores, rec.ID)
+		}
+	}
+}
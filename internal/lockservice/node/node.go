@@ -1,8 +1,21 @@
 package node
 
+import "context"
+
 // Node describes the elements of a node of the distributed lockservice.
-// This
+// A node serves its lockservice in the background from the moment it's
+// started until it's stopped, and never exits the process hosting it.
 type Node interface {
-	// Start starts up the node by initialising the necessary data/
+	// Start starts up the node by binding its listener and serving
+	// the lockservice in the background. It returns once the node
+	// is ready to take calls.
 	Start() error
+	// Stop stops the node, waiting for the calls that are being
+	// served until the context is done, and closes the lockservice.
+	Stop(context.Context) error
+	// Ready is closed once the listener of the node is bound.
+	Ready() <-chan struct{}
+	// Addr returns the address that the node listens on, which is
+	// only known once the node is ready if it was asked for port 0.
+	Addr() string
 }
@@ -0,0 +1,343 @@
+package node
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/SystemBuilders/LocKey/internal/lockservice"
+	"github.com/SystemBuilders/LocKey/internal/lockservice/routing"
+	"github.com/rs/zerolog"
+)
+
+// createSession creates a session on the node at the address.
+func createSession(addr string) error {
+	resp, err := http.Post("http://"+addr+"/createSession", "application/json", bytes.NewReader([]byte(`{}`)))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func TestSimpleNode(t *testing.T) {
+	log := zerolog.New(os.Stdout).With().Logger().Level(zerolog.Disabled)
+
+	t.Run("a node on port 0 reports its address and stops", func(t *testing.T) {
+		ls := lockservice.NewSimpleLockService(log)
+		n := NewSimpleNode(ls, *lockservice.NewSimpleConfig("http://127.0.0.1", "0"))
+		if err := n.Start(); err != nil {
+			t.Fatalf("start: %v", err)
+		}
+		select {
+		case <-n.Ready():
+		default:
+			t.Errorf("ready: the node isn't ready once started")
+		}
+		if n.Addr() == "127.0.0.1:0" {
+			t.Errorf("addr: got %q want the bound port", n.Addr())
+		}
+		if err := createSession(n.Addr()); err != nil {
+			t.Errorf("createSession: %v", err)
+		}
+
+		if err := n.Stop(context.Background()); err != nil {
+			t.Errorf("stop: got %q want <nil>", err)
+		}
+		if err := n.Wait(); err != nil {
+			t.Errorf("wait: got %q want <nil>", err)
+		}
+		if err := createSession(n.Addr()); err == nil {
+			t.Errorf("createSession: got <nil> want an error once stopped")
+		}
+	})
+
+	t.Run("a signal stops the node without exiting the process", func(t *testing.T) {
+		ls := lockservice.NewSimpleLockService(log)
+		n := NewSimpleNode(ls, *lockservice.NewSimpleConfig("127.0.0.1", "0"), WithSignals())
+		if err := n.Start(); err != nil {
+			t.Fatalf("start: %v", err)
+		}
+		if err := syscall.Kill(os.Getpid(), syscall.SIGTERM); err != nil {
+			t.Fatalf("kill: %v", err)
+		}
+
+		stopped := make(chan error, 1)
+		go func() { stopped <- n.Wait() }()
+		select {
+		case err := <-stopped:
+			if err != nil {
+				t.Errorf("wait: got %q want <nil>", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("wait: the node didn't stop on the signal")
+		}
+	})
+
+	t.Run("a node can't start on a port in use", func(t *testing.T) {
+		ls := lockservice.NewSimpleLockService(log)
+		n := NewSimpleNode(ls, *lockservice.NewSimpleConfig("127.0.0.1", "0"))
+		if err := n.Start(); err != nil {
+			t.Fatalf("start: %v", err)
+		}
+		defer n.Stop(context.Background())
+
+		_, port, err := net.SplitHostPort(n.Addr())
+		if err != nil {
+			t.Fatalf("splitHostPort: %v", err)
+		}
+		cfg := lockservice.NewSimpleConfig("127.0.0.1", port)
+		if err := NewSimpleNode(ls, *cfg).Start(); err == nil {
+			t.Errorf("start: got <nil> want an error")
+		}
+	})
+
+	t.Run("a lockservice without extensions serves only the locks", func(t *testing.T) {
+		ls := struct{ lockservice.LockService }{lockservice.NewSimpleLockService(log)}
+		n := NewSimpleNode(ls, *lockservice.NewSimpleConfig("127.0.0.1", "0"))
+		if err := n.Start(); err != nil {
+			t.Fatalf("start: %v", err)
+		}
+		defer n.Stop(context.Background())
+
+		req := `{"fileID":"test","userID":"owner1"}`
+		resp, err := http.Post("http://"+n.Addr()+"/acquire", "application/json", bytes.NewReader([]byte(req)))
+		if err != nil {
+			t.Fatalf("acquire: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("acquire: got %d want %d", resp.StatusCode, http.StatusOK)
+		}
+
+		resp, err = http.Post("http://"+n.Addr()+"/createSession", "application/json", bytes.NewReader([]byte(`{}`)))
+		if err != nil {
+			t.Fatalf("createSession: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("createSession: got %d want %d", resp.StatusCode, http.StatusNotFound)
+		}
+
+		req = `{"fileID":"test","userID":"owner2","wait":1000000000}`
+		resp, err = http.Post("http://"+n.Addr()+"/acquire", "application/json", bytes.NewReader([]byte(req)))
+		if err != nil {
+			t.Fatalf("acquire: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotImplemented {
+			t.Errorf("acquire: got %d want %d", resp.StatusCode, http.StatusNotImplemented)
+		}
+	})
+
+	t.Run("the v2 API answers with the status and code of the error", func(t *testing.T) {
+		n := NewSimpleNode(lockservice.NewSimpleLockService(log), *lockservice.NewSimpleConfig("127.0.0.1", "0"))
+		if err := n.Start(); err != nil {
+			t.Fatalf("start: %v", err)
+		}
+		defer n.Stop(context.Background())
+
+		url := "http://" + n.Addr() + "/v2/locks/test"
+		tests := []struct {
+			method, url, body string
+			status            int
+			code              string
+		}{
+			{http.MethodPut, url, `{"userID":"owner1"}`, http.StatusOK, ""},
+			{http.MethodPut, url, `{"userID":"owner2"}`, http.StatusConflict, "lock_held"},
+			{http.MethodGet, url, "", http.StatusOK, ""},
+			{http.MethodDelete, url + "?owner=owner2", "", http.StatusForbidden, "unauthorized_owner"},
+			{http.MethodDelete, url + "?owner=owner1&token=2", "", http.StatusConflict, "stale_token"},
+			{http.MethodDelete, url + "?owner=owner1&token=1", "", http.StatusNoContent, ""},
+			{http.MethodGet, url, "", http.StatusNotFound, "lock_not_acquired"},
+			{http.MethodDelete, url + "?owner=owner1", "", http.StatusNotFound, "lock_not_found"},
+			{http.MethodPut, url, `{"userID":`, http.StatusBadRequest, "bad_request"},
+		}
+		for _, tt := range tests {
+			req, err := http.NewRequest(tt.method, tt.url, strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatalf("%s: %v", tt.method, err)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("%s: %v", tt.method, err)
+			}
+			var res lockservice.ErrorRes
+			if tt.code != "" {
+				json.NewDecoder(resp.Body).Decode(&res)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.status || res.Code != tt.code {
+				t.Errorf("%s %s: got %d %q want %d %q", tt.method, tt.url, resp.StatusCode, res.Code, tt.status, tt.code)
+			}
+		}
+	})
+
+	t.Run("a node with authentication scopes owners to the principals of tokens", func(t *testing.T) {
+		auth := routing.NewHMACAuthenticator([]byte("secret"))
+		n := NewSimpleNode(lockservice.NewSimpleLockService(log), *lockservice.NewSimpleConfig("127.0.0.1", "0"), WithAuth(auth))
+		if err := n.Start(); err != nil {
+			t.Fatalf("start: %v", err)
+		}
+		defer n.Stop(context.Background())
+
+		url := "http://" + n.Addr() + "/v2/locks/test"
+		teamA, teamB := auth.Issue("teamA", time.Time{}), auth.Issue("teamB", time.Now().Add(time.Hour))
+		expired := auth.Issue("teamA", time.Now().Add(-time.Second))
+		forged := routing.NewHMACAuthenticator([]byte("guess")).Issue("teamA", time.Time{})
+		tests := []struct {
+			method, url, token, body string
+			status                   int
+			owner                    string
+		}{
+			{http.MethodPut, url, "", `{"userID":"owner1"}`, http.StatusUnauthorized, ""},
+			{http.MethodPut, url, expired, `{"userID":"owner1"}`, http.StatusUnauthorized, ""},
+			{http.MethodPut, url, forged, `{"userID":"owner1"}`, http.StatusUnauthorized, ""},
+			{http.MethodPut, url, teamA, `{"userID":"owner1"}`, http.StatusOK, ""},
+			{http.MethodGet, url, teamA, "", http.StatusOK, "owner1"},
+			{http.MethodGet, url, teamB, "", http.StatusOK, "teamA/owner1"},
+			{http.MethodDelete, url + "?owner=owner1", teamB, "", http.StatusForbidden, ""},
+			{http.MethodDelete, url + "?owner=owner1", teamA, "", http.StatusNoContent, ""},
+		}
+		for _, tt := range tests {
+			req, err := http.NewRequest(tt.method, tt.url, strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatalf("%s: %v", tt.method, err)
+			}
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("%s: %v", tt.method, err)
+			}
+			var res lockservice.CheckAcquireRes
+			if tt.owner != "" {
+				json.NewDecoder(resp.Body).Decode(&res)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.status || res.Owner != tt.owner {
+				t.Errorf("%s %s: got %d %q want %d %q", tt.method, tt.url, resp.StatusCode, res.Owner, tt.status, tt.owner)
+			}
+		}
+	})
+
+	t.Run("only the admin of a node with authentication takes snapshots", func(t *testing.T) {
+		auth := routing.NewHMACAuthenticator([]byte("secret"))
+		n := NewSimpleNode(lockservice.NewSimpleLockService(log), *lockservice.NewSimpleConfig("127.0.0.1", "0"), WithAuth(auth), WithAdmin("ops"))
+		if err := n.Start(); err != nil {
+			t.Fatalf("start: %v", err)
+		}
+		defer n.Stop(context.Background())
+
+		for token, want := range map[string]int{
+			auth.Issue("teamA", time.Time{}): http.StatusForbidden,
+			auth.Issue("ops", time.Time{}):   http.StatusOK,
+		} {
+			req, err := http.NewRequest(http.MethodGet, "http://"+n.Addr()+"/admin/snapshot", nil)
+			if err != nil {
+				t.Fatalf("snapshot: %v", err)
+			}
+			req.Header.Set("Authorization", "Bearer "+token)
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("snapshot: %v", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != want {
+				t.Errorf("snapshot: got %d want %d", resp.StatusCode, want)
+			}
+		}
+	})
+
+	t.Run("a node with authentication serves authenticated gRPC and redis clients", func(t *testing.T) {
+		auth := routing.NewHMACAuthenticator([]byte("secret"))
+		ls := lockservice.NewSimpleLockService(log)
+		n := NewSimpleNode(ls, *lockservice.NewSimpleConfig("127.0.0.1", "0"), WithAuth(auth), WithGRPC("127.0.0.1:0"), WithRESP("127.0.0.1:0"))
+		if err := n.Start(); err != nil {
+			t.Fatalf("start: %v", err)
+		}
+		defer n.Stop(context.Background())
+
+		conn, err := net.Dial("tcp", n.RESPAddr())
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		for _, tt := range []struct{ cmd, want string }{
+			{"SET test owner1 NX", "-NOAUTH Authentication required.\r\n"},
+			{"AUTH " + auth.Issue("teamA", time.Time{}), "+OK\r\n"},
+			{"SET test owner1 NX", "+OK\r\n"},
+		} {
+			if _, err := conn.Write([]byte(tt.cmd + "\r\n")); err != nil {
+				t.Fatalf("write: %v", err)
+			}
+			if got, err := r.ReadString('\n'); err != nil || got != tt.want {
+				t.Errorf("%s: got %q, %v want %q", tt.cmd, got, err, tt.want)
+			}
+		}
+		if owner, ok := ls.CheckAcquired(lockservice.NewLockDescriptor("test", "")); !ok || owner != "teamA/owner1" {
+			t.Errorf("checkAcquired: got %q, %v want teamA/owner1, true", owner, ok)
+		}
+	})
+
+	t.Run("a node serves gRPC alongside HTTP", func(t *testing.T) {
+		ls := lockservice.NewSimpleLockService(log)
+		n := NewSimpleNode(ls, *lockservice.NewSimpleConfig("127.0.0.1", "0"), WithGRPC("127.0.0.1:0"))
+		if err := n.Start(); err != nil {
+			t.Fatalf("start: %v", err)
+		}
+		if n.GRPCAddr() == "127.0.0.1:0" || n.GRPCAddr() == n.Addr() {
+			t.Errorf("grpcAddr: got %q want its own bound port", n.GRPCAddr())
+		}
+		conn, err := net.Dial("tcp", n.GRPCAddr())
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		conn.Close()
+
+		if err := n.Stop(context.Background()); err != nil {
+			t.Errorf("stop: got %q want <nil>", err)
+		}
+		if _, err := net.Dial("tcp", n.GRPCAddr()); err == nil {
+			t.Errorf("dial: got <nil> want an error once stopped")
+		}
+	})
+	t.Run("a node serves redis clients alongside HTTP", func(t *testing.T) {
+		ls := lockservice.NewSimpleLockService(log)
+		n := NewSimpleNode(ls, *lockservice.NewSimpleConfig("127.0.0.1", "0"), WithRESP("127.0.0.1:0"))
+		if err := n.Start(); err != nil {
+			t.Fatalf("start: %v", err)
+		}
+		conn, err := net.Dial("tcp", n.RESPAddr())
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		if _, err := conn.Write([]byte("SET test owner1 NX PX 30000\r\n")); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		got, err := bufio.NewReader(conn).ReadString('\n')
+		if err != nil || got != "+OK\r\n" {
+			t.Errorf("set: got %q, %v want %q", got, err, "+OK\r\n")
+		}
+		if owner, ok := ls.CheckAcquired(lockservice.NewLockDescriptor("test", "")); !ok || owner != "owner1" {
+			t.Errorf("checkAcquired: got %q, %v want owner1, true", owner, ok)
+		}
+
+		if err := n.Stop(context.Background()); err != nil {
+			t.Errorf("stop: got %q want <nil>", err)
+		}
+		if _, err := conn.Read(make([]byte, 1)); err == nil {
+			t.Errorf("read: got <nil> want an error once stopped")
+		}
+	})
+}
@@ -0,0 +1,40 @@
+package node
+
+import (
+	"context"
+	"net/url"
+
+	"github.com/SystemBuilders/LocKey/internal/lockservice/replicated"
+)
+
+// NewReplicatedNode returns a node of a replicated lockservice that serves
+// at the address of the node in the peers of the cluster.
+func NewReplicatedNode(rls *replicated.LockService, peerURL string, opts ...Option) (*SimpleNode, error) {
+
+	u, err := url.Parse(peerURL)
+	if err != nil {
+		return nil, err
+	}
+
+	n := newNode(u.Host, rls.Handler(), func(context.Context) { rls.Start() }, rls)
+	for _, opt := range opts {
+		opt(n)
+	}
+	if n.auth != nil {
+		// The nodes of the cluster call each other without tokens.
+		return nil, errAuthUnsupported
+	}
+	return n, nil
+}
+
+// StartReplicated begins the operation of a node of a replicated
+// lockservice as a http server, at the address of the node in the
+// peers of the cluster, until the process gets an interrupt or a
+// SIGTERM.
+func StartReplicated(rls *replicated.LockService, peerURL string, opts ...Option) error {
+	n, err := NewReplicatedNode(rls, peerURL, append(opts, WithSignals())...)
+	if err != nil {
+		return err
+	}
+	return run(n)
+}
@@ -3,63 +3,456 @@ package node
 import (
 	"context"
 	"errors"
+	"io"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/SystemBuilders/LocKey/internal/lockservice"
+	"github.com/SystemBuilders/LocKey/internal/lockservice/partition"
+	"github.com/SystemBuilders/LocKey/internal/lockservice/resp"
 	"github.com/SystemBuilders/LocKey/internal/lockservice/routing"
+	"github.com/SystemBuilders/LocKey/internal/lockservice/rpc"
 	"github.com/gorilla/mux"
+	"google.golang.org/grpc"
 )
 
-// Start begins the node's operation as a http server.
-func Start(ls *lockservice.SimpleLockService, scfg lockservice.SimpleConfig) error {
+// reapInterval is the interval at which expired leases are reaped.
+const reapInterval = time.Second
 
-	IP := scfg.IP()
-	IP = strings.TrimPrefix(IP, "http://")
-	port := scfg.Port()
+// checkpointInterval is the interval at which snapshots of the lock
+// table are taken, if the lockservice keeps snapshots.
+const checkpointInterval = time.Minute
 
+// shutdownTimeout is the time for which the calls being served are
+// waited for when the node is stopped by a signal.
+const shutdownTimeout = 10 * time.Second
+
+// errAuthUnsupported is returned for a node with authentication that
+// takes calls which can't carry a token.
+var errAuthUnsupported = errors.New("authentication isn't supported by replicated nodes")
+
+var _ Node = (*SimpleNode)(nil)
+
+// SimpleNode implements Node, a node that serves a lockservice over
+// HTTP, and over gRPC and the Redis protocol if it's asked to.
+type SimpleNode struct {
+	addr    string
+	handler http.Handler
+	// run starts the background work of the lockservice, which
+	// lasts until the context is done.
+	run     func(ctx context.Context)
+	ls      lockservice.LockService
+	service io.Closer
+	signals bool
+	// grpcAddr is the address of the gRPC API, which isn't served
+	// if it's empty, and serves the check of the descriptor IDs
+	// that the node serves.
+	grpcAddr string
+	serves   func(id string) error
+	// respAddr is the address of the Redis protocol front end, which
+	// isn't served if it's empty.
+	respAddr string
+	// auth authenticates the HTTP calls, which aren't authenticated if
+	// it's nil.
+	auth routing.Authenticator
+	// admin is the principal whose calls of the admin routes are
+	// served, if the calls are authenticated.
+	admin string
+
+	mu           sync.Mutex
+	server       *http.Server
+	listener     net.Listener
+	grpcServer   *grpc.Server
+	grpcListener net.Listener
+	respServer   *resp.Server
+	respListener net.Listener
+	cancel       context.CancelFunc
+	started      bool
+	stopped      bool
+	err          error
+	// ready is closed once the listener is bound, served once the
+	// server stops serving and done once the node has stopped.
+	ready  chan struct{}
+	served chan struct{}
+	done   chan struct{}
+}
+
+// Option configures a SimpleNode.
+type Option func(*SimpleNode)
+
+// WithPartitioner makes the node serve only the partitions that the
+// partitioner assigns to it.
+func WithPartitioner(p *partition.Partitioner) Option {
+	return func(n *SimpleNode) {
+		if r, ok := n.handler.(*mux.Router); ok {
+			n.handler = p.Routes(r)
+		}
+		n.serves = p.Serves
+	}
+}
+
+// WithGRPC makes the node serve the gRPC API of the lockservice at the
+// address, alongside its HTTP API. The port of the address may be 0, in
+// which case GRPCAddr reports the port picked by the system.
+func WithGRPC(addr string) Option {
+	return func(n *SimpleNode) {
+		n.grpcAddr = addr
+	}
+}
+
+// WithRESP makes the node serve the locks of the lockservice to Redis
+// clients at the address, alongside its HTTP API. The port of the address
+// may be 0, in which case RESPAddr reports the port picked by the system.
+func WithRESP(addr string) Option {
+	return func(n *SimpleNode) {
+		n.respAddr = addr
+	}
+}
+
+// WithAuth makes the node serve only the calls that carry a bearer token
+// that the authenticator accepts, and scopes the owners of their locks to
+// the principal of the token. The gRPC calls carry the token as their
+// metadata and the Redis clients send it with AUTH. The nodes of a
+// partitioned deployment call each other with the token of their admin,
+// given with partition.WithToken.
+func WithAuth(a routing.Authenticator) Option {
+	return func(n *SimpleNode) {
+		n.auth = a
+	}
+}
+
+// WithAdmin makes the principal the admin of a node with authentication,
+// which alone is served the admin routes, such as /admin/snapshot. The
+// admin routes of a node with authentication but without an admin aren't
+// served to any principal.
+func WithAdmin(principal string) Option {
+	return func(n *SimpleNode) {
+		n.admin = principal
+	}
+}
+
+// WithSignals makes the node stop once the process gets an interrupt or
+// a SIGTERM. Without it, the node leaves signals to the process hosting
+// it.
+func WithSignals() Option {
+	return func(n *SimpleNode) {
+		n.signals = true
+	}
+}
+
+// NewSimpleNode returns a node that serves the lockservice at the address
+// of the config. The port of the config may be 0, in which case the node
+// listens on a port picked by the system, which Addr reports.
+//
+// Any LockService can be served. The features beyond the locks are served
+// only if the lockservice implements their extension interfaces, and it's
+// closed along with the node if it's an io.Closer.
+func NewSimpleNode(ls lockservice.LockService, scfg lockservice.SimpleConfig, opts ...Option) *SimpleNode {
+	n := newNode(
+		strings.TrimPrefix(scfg.IP(), "http://")+":"+scfg.Port(),
+		routing.SetupRouting(ls, mux.NewRouter()),
+		func(ctx context.Context) {
+			// Expired leases are reaped and snapshots are taken in
+			// the background for as long as the node is up.
+			if r, ok := ls.(lockservice.Reaper); ok {
+				go r.RunReaper(ctx, reapInterval)
+			}
+			if c, ok := ls.(lockservice.Checkpointer); ok {
+				go c.RunCheckpointer(ctx, checkpointInterval)
+			}
+		},
+		ls,
+	)
+	for _, opt := range opts {
+		opt(n)
+	}
+	if n.auth != nil {
+		n.handler = routing.Authenticate(n.auth)(routing.Admin(n.admin)(n.handler))
+	}
+	return n
+}
+
+// newNode returns a node that serves the handler of the lockservice at the
+// address. The lockservice is closed along with the node if it's an
+// io.Closer.
+func newNode(addr string, handler http.Handler, run func(context.Context), ls lockservice.LockService) *SimpleNode {
+	service, ok := ls.(io.Closer)
+	if !ok {
+		service = nopCloser{}
+	}
+	return &SimpleNode{
+		addr:    addr,
+		handler: handler,
+		run:     run,
+		ls:      ls,
+		service: service,
+		ready:   make(chan struct{}),
+		served:  make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+// Start binds the listener of the node and serves the lockservice in the
+// background. It returns once the node is ready to take calls, or with the
+// error that kept it from listening. A node can only be started once.
+func (n *SimpleNode) Start() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.started {
+		return errors.New("node already started")
+	}
+
+	_, port, err := net.SplitHostPort(n.addr)
+	if err != nil {
+		return err
+	}
 	if err := checkValidPort(port); err != nil {
 		return err
 	}
+	l, err := net.Listen("tcp", n.addr)
+	if err != nil {
+		return err
+	}
+	if n.grpcAddr != "" {
+		gl, err := net.Listen("tcp", n.grpcAddr)
+		if err != nil {
+			l.Close()
+			return err
+		}
+		n.grpcListener = gl
+		var serverOpts []grpc.ServerOption
+		if n.auth != nil {
+			serverOpts = rpc.Authenticate(n.auth)
+		}
+		n.grpcServer = grpc.NewServer(serverOpts...)
+		var opts []rpc.Option
+		if n.serves != nil {
+			opts = append(opts, rpc.WithServes(n.serves))
+		}
+		rpc.RegisterLockServiceServer(n.grpcServer, rpc.NewServer(n.ls, opts...))
+	}
+	if n.respAddr != "" {
+		rl, err := net.Listen("tcp", n.respAddr)
+		if err != nil {
+			l.Close()
+			if n.grpcListener != nil {
+				n.grpcListener.Close()
+			}
+			return err
+		}
+		n.respListener = rl
+		var opts []resp.Option
+		if n.serves != nil {
+			opts = append(opts, resp.WithServes(n.serves))
+		}
+		if n.auth != nil {
+			opts = append(opts, resp.WithAuth(n.auth))
+		}
+		n.respServer = resp.NewServer(n.ls, opts...)
+	}
 
-	router := mux.NewRouter()
+	ctx, cancel := context.WithCancel(context.Background())
+	n.started = true
+	n.listener = l
+	n.cancel = cancel
+	n.server = &http.Server{Handler: n.handler}
+	n.run(ctx)
+	close(n.ready)
 
-	router = routing.SetupRouting(ls, router)
+	go func() {
+		err := n.server.Serve(l)
+		if err == http.ErrServerClosed {
+			err = nil
+		}
+		n.mu.Lock()
+		n.err = err
+		n.mu.Unlock()
+		close(n.served)
+	}()
+	if n.grpcServer != nil {
+		go func() {
+			if err := n.grpcServer.Serve(n.grpcListener); err != nil {
+				log.Println("Serving gRPC: " + err.Error())
+			}
+		}()
+		log.Println("Starting gRPC Server on " + n.grpcListener.Addr().String())
+	}
+	if n.respServer != nil {
+		go func() {
+			if err := n.respServer.Serve(n.respListener); err != nil {
+				log.Println("Serving RESP: " + err.Error())
+			}
+		}()
+		log.Println("Starting RESP Server on " + n.respListener.Addr().String())
+	}
+	if n.signals {
+		interruptChan := make(chan os.Signal, 1)
+		signal.Notify(interruptChan, os.Interrupt, syscall.SIGTERM)
+		go n.handleSignals(interruptChan)
+	}
+
+	log.Println("Starting Server on " + l.Addr().String())
+	return nil
+}
+
+// Stop stops serving, waits for the calls that are being served until the
+// context is done, after which they are cut off, and then closes the
+// lockservice, which takes a final snapshot. Stopping a node that isn't
+// running does nothing.
+func (n *SimpleNode) Stop(ctx context.Context) error {
+	n.mu.Lock()
+	if !n.started || n.stopped {
+		n.mu.Unlock()
+		return nil
+	}
+	n.stopped = true
+	server := n.server
+	grpcServer := n.grpcServer
+	respServer := n.respServer
+	n.mu.Unlock()
+
+	err := server.Shutdown(ctx)
+	if err != nil {
+		server.Close()
+	}
+	if grpcServer != nil {
+		stopGRPC(ctx, grpcServer)
+	}
+	if respServer != nil {
+		respServer.Close()
+	}
+	n.cancel()
+	<-n.served
+	if cerr := n.service.Close(); cerr != nil {
+		log.Println("Closing the lockservice: " + cerr.Error())
+	}
+	close(n.done)
+	log.Println("Shutting down")
+	return err
+}
+
+// Ready is closed once the listener of the node is bound.
+func (n *SimpleNode) Ready() <-chan struct{} {
+	return n.ready
+}
+
+// Addr returns the address that the node listens on once it's ready, and
+// the address it was asked to listen on before.
+func (n *SimpleNode) Addr() string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.listener != nil {
+		return n.listener.Addr().String()
+	}
+	return n.addr
+}
 
-	server := &http.Server{
-		Handler: router,
-		Addr:    IP + ":" + port,
+// GRPCAddr returns the address that the gRPC API of the node listens on
+// once it's ready, and the address it was asked to listen on before. It's
+// empty if the node doesn't serve the gRPC API.
+func (n *SimpleNode) GRPCAddr() string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.grpcListener != nil {
+		return n.grpcListener.Addr().String()
 	}
+	return n.grpcAddr
+}
 
-	go gracefulShutdown(server)
+// RESPAddr returns the address that the Redis protocol front end of the
+// node listens on once it's ready, and the address it was asked to listen
+// on before. It's empty if the node doesn't serve it.
+func (n *SimpleNode) RESPAddr() string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.respListener != nil {
+		return n.respListener.Addr().String()
+	}
+	return n.respAddr
+}
 
-	log.Println("Starting Server on " + IP + ":" + port)
-	return server.ListenAndServe()
+// Wait blocks until the node stops, and returns the error that made it
+// stop serving, if it wasn't stopped using Stop.
+func (n *SimpleNode) Wait() error {
+	<-n.served
+	n.mu.Lock()
+	err := n.err
+	n.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	<-n.done
+	return nil
 }
 
-// gracefulShutdown shuts down the server on getting a ^C signal
-func gracefulShutdown(server *http.Server) {
-	interruptChan := make(chan os.Signal, 1)
-	signal.Notify(interruptChan, os.Interrupt, syscall.SIGTERM)
+// handleSignals stops the node on getting a ^C signal or a SIGTERM on
+// the channel, which is notified of them.
+func (n *SimpleNode) handleSignals(interruptChan chan os.Signal) {
+	defer signal.Stop(interruptChan)
 
-	// Block until we receive our signal.
-	<-interruptChan
+	// Block until we receive our signal, or the node stops otherwise.
+	select {
+	case <-interruptChan:
+	case <-n.served:
+		return
+	}
 
 	// Create a deadline to wait for currently serving items.
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
 	defer cancel()
-	server.Shutdown(ctx)
+	n.Stop(ctx)
+}
 
-	log.Println("Shutting down")
-	os.Exit(0)
+// Start begins the node's operation as a http server, until the process
+// gets an interrupt or a SIGTERM.
+func Start(ls lockservice.LockService, scfg lockservice.SimpleConfig, opts ...Option) error {
+	return run(NewSimpleNode(ls, scfg, append(opts, WithSignals())...))
 }
 
+// StartPartitioned begins the node's operation as a http server that
+// serves only the partitions that the partitioner assigns to it, until
+// the process gets an interrupt or a SIGTERM.
+func StartPartitioned(ls lockservice.LockService, p *partition.Partitioner, scfg lockservice.SimpleConfig, opts ...Option) error {
+	return run(NewSimpleNode(ls, scfg, append(opts, WithPartitioner(p), WithSignals())...))
+}
+
+// run starts the node and blocks until it stops.
+func run(n *SimpleNode) error {
+	if err := n.Start(); err != nil {
+		return err
+	}
+	return n.Wait()
+}
+
+// stopGRPC stops the gRPC server, waiting for the calls that are being
+// served until the context is done, after which they're cut off.
+func stopGRPC(ctx context.Context, s *grpc.Server) {
+	stopped := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		s.Stop()
+		<-stopped
+	}
+}
+
+// nopCloser is the closer of a lockservice that has nothing to close.
+type nopCloser struct{}
+
+func (nopCloser) Close() error { return nil }
+
 func checkValidPort(port string) error {
 	portInt, err := strconv.Atoi(port)
 	if err != nil {
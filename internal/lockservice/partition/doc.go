@@ -0,0 +1,4 @@
+// Package partition implements the partitioned deployment of the
+// lockservice, in which descriptor IDs are spread across the nodes by
+// a consistent-hash ring and every node serves only its partitions.
+package partition
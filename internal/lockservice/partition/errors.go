@@ -0,0 +1,15 @@
+package partition
+
+// Error provides constant error strings to the driver functions.
+type Error string
+
+func (e Error) Error() string { return string(e) }
+
+// Constant errors.
+// Rule of thumb, all errors start with a small letter and end with no full stop.
+const (
+	ErrWrongNode  = Error("descriptor is served by another node")
+	ErrMigrating  = Error("descriptor is being migrated to this node")
+	ErrCrossBatch = Error("batch spans descriptors served by different nodes")
+	ErrStaleRing  = Error("ring is older than the ring of the node")
+)
@@ -0,0 +1,213 @@
+package partition
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/SystemBuilders/LocKey/internal/lockservice"
+	"github.com/gorilla/mux"
+)
+
+// Routes adds the routes through which the nodes of a partitioned
+// deployment exchange their rings and handoffs, and makes the router
+// serve only the descriptor IDs of the partitions of this node.
+//
+// A call on a descriptor ID that another node owns fails with 421
+// Misdirected Request, after which the client fetches the ring from
+// GET /ring and calls the owner. A call on a partition that is still
+// being handed over to this node fails with 503 Service Unavailable.
+func (p *Partitioner) Routes(r *mux.Router) *mux.Router {
+	r.HandleFunc("/ring", p.getRing).Methods(http.MethodGet)
+	r.HandleFunc("/ring", p.setRing).Methods(http.MethodPost)
+	r.HandleFunc("/admin/handoff", p.handoff).Methods(http.MethodPost)
+	r.Use(p.middleware)
+	return r
+}
+
+// getRing responds with the current ring of the node.
+func (p *Partitioner) getRing(w http.ResponseWriter, r *http.Request) {
+
+	byteData, err := json.Marshal(p.Ring())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(byteData)
+}
+
+// setRing moves the node to the ring in the request, if it is newer than
+// the current one.
+func (p *Partitioner) setRing(w http.ResponseWriter, r *http.Request) {
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var ring Ring
+	if err := json.Unmarshal(body, &ring); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	p.SetRing(&ring)
+	w.Write([]byte("ring set"))
+}
+
+// handoff adopts the locks handed over by another node.
+func (p *Partitioner) handoff(w http.ResponseWriter, r *http.Request) {
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var h Handoff
+	if err := json.Unmarshal(body, &h); err != nil || h.Ring == nil {
+		http.Error(w, "invalid handoff", http.StatusBadRequest)
+		return
+	}
+
+	if err := p.Adopt(h); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write([]byte("handoff adopted"))
+}
+
+// nodePaths are the paths that concern the node itself rather than any
+// descriptor, which every node serves. Sessions live on the node they
+// were created on.
+var nodePaths = map[string]bool{
+	"/ring":          true,
+	"/createSession": true,
+	"/heartbeat":     true,
+	"/endSession":    true,
+}
+
+// middleware rejects the calls on descriptor IDs that the node doesn't
+// serve. The calls that it lets through are served under the current
+// ring, which only changes once they are done, except for the streams
+// of /watch which only read.
+func (p *Partitioner) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if nodePaths[r.URL.Path] || strings.HasPrefix(r.URL.Path, "/admin/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var ids []string
+		if id, ok := mux.Vars(r)["id"]; ok {
+			ids = append(ids, id)
+		} else if r.URL.Path == "/watch" {
+			ids = append(ids, r.URL.Query().Get("fileID"))
+		} else {
+			body, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+			ids = idsOf(body)
+		}
+
+		if r.URL.Path != "/watch" {
+			p.serving.RLock()
+			defer p.serving.RUnlock()
+		}
+		if err := p.servesAll(ids); err != nil {
+			status := http.StatusMisdirectedRequest
+			switch err {
+			case ErrMigrating:
+				status = http.StatusServiceUnavailable
+			case ErrCrossBatch:
+				status = http.StatusBadRequest
+			}
+			if strings.HasPrefix(r.URL.Path, "/v2/") {
+				writeError(w, status, err)
+				return
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// errorCodes are the codes by which the v2 API reports the errors of
+// the partitions, like the errors of the lockservice.
+var errorCodes = map[error]string{
+	ErrWrongNode:  "wrong_node",
+	ErrMigrating:  "migrating",
+	ErrCrossBatch: "cross_batch",
+}
+
+// ErrorOf returns the error of the partitions that has the code, and
+// false if no error has it.
+func ErrorOf(code string) (Error, bool) {
+	for err, c := range errorCodes {
+		if c == code {
+			return err.(Error), true
+		}
+	}
+	return "", false
+}
+
+// writeError responds to a call of the v2 API with the status and an
+// ErrorRes of the error of the partition.
+func writeError(w http.ResponseWriter, status int, err error) {
+	byteData, _ := json.Marshal(lockservice.ErrorRes{Code: errorCodes[err], Message: err.Error()})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(byteData)
+}
+
+// servesAll returns nil if the node serves all the descriptor IDs. A
+// batch is only served if all its IDs are on this node.
+func (p *Partitioner) servesAll(ids []string) error {
+	var wrong, served bool
+	for _, id := range ids {
+		switch err := p.Serves(id); err {
+		case nil:
+			served = true
+		case ErrWrongNode:
+			wrong = true
+		default:
+			return err
+		}
+	}
+	if wrong && served {
+		return ErrCrossBatch
+	}
+	if wrong {
+		return ErrWrongNode
+	}
+	return nil
+}
+
+// idsOf returns the descriptor IDs named in the body of a call, which
+// is either a single request or a batch of requests.
+func idsOf(body []byte) []string {
+	var req struct {
+		FileID   string `json:"fileID"`
+		Requests []struct {
+			FileID string `json:"fileID"`
+		} `json:"requests"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil
+	}
+	if req.Requests == nil {
+		return []string{req.FileID}
+	}
+	ids := make([]string, len(req.Requests))
+	for i, lr := range req.Requests {
+		ids[i] = lr.FileID
+	}
+	return ids
+}
@@ -0,0 +1,309 @@
+package partition
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/SystemBuilders/LocKey/internal/lockservice"
+	"github.com/rs/zerolog"
+)
+
+// retryInterval is the interval at which a failed handoff or a failed
+// spread of a ring is retried.
+const retryInterval = time.Second
+
+// Handoff is the set of locks that a node hands over to the new owner of
+// their partitions, along with the ring under which they moved. A node
+// sends a handoff, even an empty one, to every node that takes over some
+// of its partitions, so that the new owner knows when it can serve them.
+type Handoff struct {
+	From     string               `json:"from"`
+	Ring     *Ring                `json:"ring"`
+	Snapshot lockservice.Snapshot `json:"snapshot"`
+}
+
+// Partitioner lets a node serve only its partitions of the descriptor
+// IDs, as assigned by a consistent-hash ring, and migrates the locks of
+// the partitions that move when the ring changes.
+//
+// A partition that moves to this node is only served once the previous
+// owner has handed over its locks, so that a lock is never held on two
+// nodes at once. Until then, calls on the partition fail with
+// ErrMigrating and clients retry them.
+type Partitioner struct {
+	log    zerolog.Logger
+	self   string
+	ls     *lockservice.SimpleLockService
+	client *http.Client
+	// token is the bearer token that the node calls the other nodes
+	// with, which it doesn't if it's empty.
+	token string
+
+	mu   sync.Mutex
+	ring *Ring
+	// prev is the ring that the node had before the current one.
+	prev *Ring
+	// pending holds the nodes whose handoff for the current ring
+	// hasn't arrived yet.
+	pending map[string]bool
+
+	// serving is held for reading by the calls being served and
+	// for writing while the ring changes, so that no call acts on
+	// a partition after it has moved away.
+	serving sync.RWMutex
+	// migrating serializes the handoffs of the node.
+	migrating sync.Mutex
+}
+
+// Option configures a Partitioner.
+type Option func(*Partitioner)
+
+// WithToken makes the node call the other nodes with the bearer token,
+// which nodes with authentication need from each other. The posts of the
+// rings and the handoffs are admin calls, so the token must be one of the
+// admin of the nodes.
+func WithToken(token string) Option {
+	return func(p *Partitioner) {
+		p.token = token
+	}
+}
+
+// NewPartitioner returns a Partitioner for the node with the given ID,
+// which serves the lockservice under the given ring.
+func NewPartitioner(log zerolog.Logger, self string, ring *Ring, ls *lockservice.SimpleLockService, opts ...Option) *Partitioner {
+	p := &Partitioner{
+		log:     log,
+		self:    self,
+		ls:      ls,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		ring:    ring,
+		pending: make(map[string]bool),
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// Ring returns the current ring of the node.
+func (p *Partitioner) Ring() *Ring {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.ring
+}
+
+// Serves returns nil if the node serves the descriptor ID. Otherwise it
+// returns ErrWrongNode if another node owns the ID and ErrMigrating if
+// the locks of the ID are still being handed over to this node.
+func (p *Partitioner) Serves(id string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.ring.Owner(id) != p.self {
+		return ErrWrongNode
+	}
+	if p.prev != nil && p.pending[p.prev.Owner(id)] {
+		return ErrMigrating
+	}
+	return nil
+}
+
+// SetRing moves the node to the ring, if it is newer than the current
+// one, and spreads the ring to the other nodes of both rings. The locks
+// of the partitions that the node no longer owns are handed over to
+// their new owners in the background.
+func (p *Partitioner) SetRing(ring *Ring) {
+	p.serving.Lock()
+	defer p.serving.Unlock()
+	p.mu.Lock()
+	if ring.Version <= p.ring.Version {
+		p.mu.Unlock()
+		return
+	}
+	p.prev, p.ring = p.ring, ring
+	p.pending = make(map[string]bool)
+	for from, tos := range transfers(p.prev, p.ring) {
+		if from != p.self && tos[p.self] {
+			p.pending[from] = true
+		}
+	}
+	peers := make(map[string]string)
+	for _, r := range []*Ring{p.prev, p.ring} {
+		for node, url := range r.Nodes {
+			if node != p.self {
+				peers[node] = url
+			}
+		}
+	}
+	pending := len(p.pending)
+	p.mu.Unlock()
+	p.
+		log.
+		Debug().
+		Str("node", p.self).
+		Uint64("version", ring.Version).
+		Int("pending", pending).
+		Msg("moved to a new ring")
+
+	for _, url := range peers {
+		go p.spread(url, ring)
+	}
+	go p.migrate()
+}
+
+// Adopt takes over the locks handed over by another node. The ring
+// of the handoff is adopted first, if it is newer than the current one,
+// and locks that the node doesn't own under its current ring are handed
+// over further. An error is returned if the locks can't be imported, in
+// which case the handoff is retried by the node that sent it.
+func (p *Partitioner) Adopt(h Handoff) error {
+	p.SetRing(h.Ring)
+	if err := p.ls.Import(h.Snapshot); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	if h.Ring.Version == p.ring.Version {
+		delete(p.pending, h.From)
+	}
+	p.mu.Unlock()
+	p.
+		log.
+		Debug().
+		Str("node", p.self).
+		Str("from", h.From).
+		Int("locks", len(h.Snapshot.Locks)).
+		Msg("adopted handed over locks")
+
+	go p.migrate()
+	return nil
+}
+
+// migrate hands over the locks of the partitions that the node doesn't
+// own under its current ring to their new owners. It keeps retrying
+// until every new owner has them, and only then evicts them.
+func (p *Partitioner) migrate() {
+	p.migrating.Lock()
+	defer p.migrating.Unlock()
+
+	for {
+		p.mu.Lock()
+		ring, prev := p.ring, p.prev
+		p.mu.Unlock()
+
+		snap := p.ls.Export(func(id string) bool {
+			return ring.Owner(id) != p.self
+		})
+		// Every node that takes over partitions of this node gets
+		// a handoff, even if there are no locks to hand over.
+		handoffs := make(map[string]*Handoff)
+		if prev != nil {
+			for to := range transfers(prev, ring)[p.self] {
+				handoffs[to] = p.newHandoff(ring)
+			}
+		}
+		for id, entry := range snap.Locks {
+			h := p.handoffTo(handoffs, ring, id)
+			h.Snapshot.Locks[id] = entry
+		}
+		for id, token := range snap.Tokens {
+			h := p.handoffTo(handoffs, ring, id)
+			h.Snapshot.Tokens[id] = token
+		}
+		for id, entry := range snap.Semaphores {
+			h := p.handoffTo(handoffs, ring, id)
+			h.Snapshot.Semaphores[id] = entry
+		}
+
+		failed := false
+		for to, h := range handoffs {
+			if err := p.send(ring.Nodes[to], "/admin/handoff", h); err != nil {
+				p.
+					log.
+					Error().
+					Err(err).
+					Str("node", p.self).
+					Str("to", to).
+					Msg("can't hand over locks")
+				failed = true
+				continue
+			}
+			if err := p.ls.Evict(h.Snapshot); err != nil {
+				p.
+					log.
+					Error().
+					Err(err).
+					Str("node", p.self).
+					Str("to", to).
+					Msg("can't evict handed over locks")
+				failed = true
+			}
+		}
+		if !failed && p.Ring() == ring {
+			return
+		}
+		time.Sleep(retryInterval)
+	}
+}
+
+// newHandoff returns an empty handoff from this node under the ring.
+func (p *Partitioner) newHandoff(ring *Ring) *Handoff {
+	return &Handoff{
+		From: p.self,
+		Ring: ring,
+		Snapshot: lockservice.Snapshot{
+			Time:       time.Now(),
+			Locks:      make(map[string]lockservice.LockMapEntry),
+			Tokens:     make(map[string]lockservice.FencingToken),
+			Semaphores: make(map[string]lockservice.SemaphoreEntry),
+		},
+	}
+}
+
+// handoffTo returns the handoff to the owner of the descriptor ID.
+func (p *Partitioner) handoffTo(handoffs map[string]*Handoff, ring *Ring, id string) *Handoff {
+	to := ring.Owner(id)
+	if handoffs[to] == nil {
+		handoffs[to] = p.newHandoff(ring)
+	}
+	return handoffs[to]
+}
+
+// spread sends the ring to another node until it gets there or a newer
+// ring replaces it.
+func (p *Partitioner) spread(url string, ring *Ring) {
+	for p.Ring() == ring {
+		if err := p.send(url, "/ring", ring); err == nil {
+			return
+		}
+		time.Sleep(retryInterval)
+	}
+}
+
+// send posts the JSON encoding of the data to the path of a node, along
+// with the token of the node if it has one.
+func (p *Partitioner) send(url, path string, data interface{}) error {
+	body, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, url+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if p.token != "" {
+		req.Header.Set("Authorization", "Bearer "+p.token)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return lockservice.Error(resp.Status)
+	}
+	return nil
+}
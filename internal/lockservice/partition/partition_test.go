@@ -0,0 +1,206 @@
+package partition
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/SystemBuilders/LocKey/internal/lockservice"
+	"github.com/SystemBuilders/LocKey/internal/lockservice/routing"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog"
+)
+
+// startNode serves a partitioned node on the listener under the ring.
+func startNode(t *testing.T, id string, l net.Listener, ring *Ring) (*lockservice.SimpleLockService, *Partitioner) {
+	return startNodeWith(t, id, l, ring, nil)
+}
+
+// startNodeWith serves a partitioned node like startNode does, whose
+// calls are authenticated by the authenticator if it isn't nil. The admin
+// of the node is the principal "nodes", whose token is issued by the
+// authenticator and used by the node to call the others.
+func startNodeWith(t *testing.T, id string, l net.Listener, ring *Ring, auth *routing.HMACAuthenticator) (*lockservice.SimpleLockService, *Partitioner) {
+	log := zerolog.New(os.Stdout).With().Logger().Level(zerolog.Disabled)
+	ls := lockservice.NewSimpleLockService(log)
+	var opts []Option
+	if auth != nil {
+		opts = append(opts, WithToken(auth.Issue("nodes", time.Time{})))
+	}
+	p := NewPartitioner(log, id, ring, ls, opts...)
+	var handler http.Handler = p.Routes(routing.SetupRouting(ls, mux.NewRouter()))
+	if auth != nil {
+		handler = routing.Authenticate(auth)(routing.Admin("nodes")(handler))
+	}
+	server := &http.Server{Handler: handler}
+	go server.Serve(l)
+	t.Cleanup(func() { server.Close() })
+	return ls, p
+}
+
+func TestRing(t *testing.T) {
+	t.Run("adding a node only moves IDs to that node", func(t *testing.T) {
+		nodes := map[string]string{"node0": "", "node1": "", "node2": ""}
+		old := NewRing(1, nodes, DefaultVNodes)
+		nodes = map[string]string{"node0": "", "node1": "", "node2": "", "node3": ""}
+		new := NewRing(2, nodes, DefaultVNodes)
+
+		moved := 0
+		for i := 0; i < 1000; i++ {
+			id := "file" + strconv.Itoa(i)
+			from, to := old.Owner(id), new.Owner(id)
+			if from == to {
+				continue
+			}
+			moved++
+			if to != "node3" {
+				t.Errorf("owner: got %q want %q", to, "node3")
+			}
+			if !transfers(old, new)[from][to] {
+				t.Errorf("transfers: missing %s to %s", from, to)
+			}
+		}
+		if moved == 0 || moved > 400 {
+			t.Errorf("moved: got %d want about 250", moved)
+		}
+	})
+}
+
+func TestPartitioner(t *testing.T) {
+	t.Run("locks move to their new owner when the ring changes", func(t *testing.T) {
+		l0, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("listen: %v", err)
+		}
+		l1, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("listen: %v", err)
+		}
+		nodes := map[string]string{
+			"node0": "http://" + l0.Addr().String(),
+			"node1": "http://" + l1.Addr().String(),
+		}
+		ring := NewRing(1, map[string]string{"node0": nodes["node0"]}, DefaultVNodes)
+		ls0, p0 := startNode(t, "node0", l0, ring)
+		ls1, p1 := startNode(t, "node1", l1, ring)
+
+		var id string
+		for i := 0; id == ""; i++ {
+			if candidate := "file" + strconv.Itoa(i); NewRing(2, nodes, DefaultVNodes).Owner(candidate) == "node1" {
+				id = candidate
+			}
+		}
+		token, err := ls0.Acquire(lockservice.NewLockDescriptor(id, "owner1"))
+		if err != nil || token != 1 {
+			t.Fatalf("acquire: got %d, %v want 1, <nil>", token, err)
+		}
+
+		p0.SetRing(NewRing(2, nodes, DefaultVNodes))
+
+		deadline := time.Now().Add(5 * time.Second)
+		for p1.Serves(id) != nil || !ls0.CheckReleased(lockservice.NewLockDescriptor(id, "")) {
+			if time.Now().After(deadline) {
+				t.Fatalf("serves: the lock never moved to node1")
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		if owner, _ := ls1.CheckAcquired(lockservice.NewLockDescriptor(id, "")); owner != "owner1" {
+			t.Errorf("checkAcquire: got %q want %q", owner, "owner1")
+		}
+		_, got := ls1.Acquire(lockservice.NewLockDescriptor(id, "owner2"))
+		if got != lockservice.ErrFileacquired {
+			t.Errorf("acquire: got %q want %q", got, lockservice.ErrFileacquired)
+		}
+
+		body := []byte(`{"fileID":"` + id + `","userID":"owner2"}`)
+		resp, err := http.Post(nodes["node0"]+"/acquire", "application/json", bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("post: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusMisdirectedRequest {
+			t.Errorf("acquire: got %d want %d", resp.StatusCode, http.StatusMisdirectedRequest)
+		}
+
+		// The fencing tokens keep increasing on the new owner.
+		if err := ls1.Release(lockservice.NewLockDescriptor(id, "owner1")); err != nil {
+			t.Fatalf("release: %v", err)
+		}
+		token, err = ls1.Acquire(lockservice.NewLockDescriptor(id, "owner2"))
+		if err != nil || token != 2 {
+			t.Errorf("acquire: got %d, %v want 2, <nil>", token, err)
+		}
+	})
+	t.Run("nodes with authentication hand locks over with their token", func(t *testing.T) {
+		auth := routing.NewHMACAuthenticator([]byte("secret"))
+		l0, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("listen: %v", err)
+		}
+		l1, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("listen: %v", err)
+		}
+		nodes := map[string]string{
+			"node0": "http://" + l0.Addr().String(),
+			"node1": "http://" + l1.Addr().String(),
+		}
+		ring := NewRing(1, map[string]string{"node0": nodes["node0"]}, DefaultVNodes)
+		ls0, _ := startNodeWith(t, "node0", l0, ring, auth)
+		ls1, p1 := startNodeWith(t, "node1", l1, ring, auth)
+
+		// Only the admin of the nodes can move them to another ring.
+		body, err := json.Marshal(NewRing(2, nodes, DefaultVNodes))
+		if err != nil {
+			t.Fatalf("marshal: %v", err)
+		}
+		for token, want := range map[string]int{
+			"":                               http.StatusUnauthorized,
+			auth.Issue("teamA", time.Time{}): http.StatusForbidden,
+			auth.Issue("nodes", time.Time{}): http.StatusOK,
+		} {
+			req, err := http.NewRequest(http.MethodPost, nodes["node0"]+"/ring", bytes.NewReader(body))
+			if err != nil {
+				t.Fatalf("post: %v", err)
+			}
+			if token != "" {
+				req.Header.Set("Authorization", "Bearer "+token)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("post: %v", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != want {
+				t.Errorf("setRing: got %d want %d", resp.StatusCode, want)
+			}
+		}
+
+		// node0 spreads the ring to node1 and hands it an empty handoff,
+		// both of which are admin calls.
+		var id string
+		for i := 0; id == ""; i++ {
+			if candidate := "file" + strconv.Itoa(i); NewRing(2, nodes, DefaultVNodes).Owner(candidate) == "node1" {
+				id = candidate
+			}
+		}
+		deadline := time.Now().Add(5 * time.Second)
+		for p1.Serves(id) != nil {
+			if time.Now().After(deadline) {
+				t.Fatalf("serves: node1 never took over its partitions")
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		if _, err := ls1.Acquire(lockservice.NewLockDescriptor(id, "owner1")); err != nil {
+			t.Errorf("acquire: got %v want <nil>", err)
+		}
+		if !ls0.CheckReleased(lockservice.NewLockDescriptor(id, "")) {
+			t.Errorf("checkReleased: the lock is held on node0")
+		}
+	})
+}
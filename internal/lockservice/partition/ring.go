@@ -0,0 +1,134 @@
+package partition
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"sort"
+	"strconv"
+)
+
+// DefaultVNodes is the number of points that every node has on a ring,
+// which spreads the partitions of a node evenly around the ring.
+const DefaultVNodes = 64
+
+// Ring is a consistent-hash ring that assigns descriptor IDs to the
+// nodes of a partitioned deployment. Every node owns the arcs of the
+// ring that end at its points, so adding or removing a node only moves
+// the IDs on the arcs of that node.
+//
+// Rings are versioned, and a node only ever moves to a ring with a
+// higher version than the one it has.
+type Ring struct {
+	Version uint64 `json:"version"`
+	// Nodes maps the IDs of the nodes to the base URLs at which
+	// they serve the lockservice.
+	Nodes  map[string]string `json:"nodes"`
+	VNodes int               `json:"vnodes"`
+
+	points []point
+}
+
+// point is a point of a node on the ring.
+type point struct {
+	hash uint64
+	node string
+}
+
+// NewRing returns a ring of the given version over the nodes, with
+// vnodes points for every node.
+func NewRing(version uint64, nodes map[string]string, vnodes int) *Ring {
+	r := &Ring{
+		Version: version,
+		Nodes:   nodes,
+		VNodes:  vnodes,
+	}
+	r.build()
+	return r
+}
+
+// UnmarshalJSON decodes the ring and places its nodes on it.
+func (r *Ring) UnmarshalJSON(data []byte) error {
+	type ring Ring
+	if err := json.Unmarshal(data, (*ring)(r)); err != nil {
+		return err
+	}
+	r.build()
+	return nil
+}
+
+// Owner returns the ID of the node that owns the descriptor ID, which
+// is empty if the ring has no nodes.
+func (r *Ring) Owner(id string) string {
+	return r.ownerOf(hash(id))
+}
+
+// URL returns the base URL of the node that owns the descriptor ID.
+func (r *Ring) URL(id string) string {
+	return r.Nodes[r.Owner(id)]
+}
+
+// build places the points of the nodes on the ring.
+func (r *Ring) build() {
+	vnodes := r.VNodes
+	if vnodes <= 0 {
+		vnodes = DefaultVNodes
+	}
+	r.points = make([]point, 0, len(r.Nodes)*vnodes)
+	for node := range r.Nodes {
+		for i := 0; i < vnodes; i++ {
+			r.points = append(r.points, point{
+				hash: hash(node + "#" + strconv.Itoa(i)),
+				node: node,
+			})
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool {
+		if r.points[i].hash == r.points[j].hash {
+			return r.points[i].node < r.points[j].node
+		}
+		return r.points[i].hash < r.points[j].hash
+	})
+}
+
+// transfers returns the pairs of nodes between which descriptor IDs
+// move when moving from the old ring to the new one, mapped from the
+// old owner to the new owners.
+func transfers(old, new *Ring) map[string]map[string]bool {
+	moves := make(map[string]map[string]bool)
+	// Every arc between two consecutive points of either ring has
+	// the same owners in both rings, the owners of its end.
+	for _, rr := range []*Ring{old, new} {
+		for _, p := range rr.points {
+			from, to := old.ownerOf(p.hash), new.ownerOf(p.hash)
+			if from == to || from == "" || to == "" {
+				continue
+			}
+			if moves[from] == nil {
+				moves[from] = make(map[string]bool)
+			}
+			moves[from][to] = true
+		}
+	}
+	return moves
+}
+
+// ownerOf returns the node that owns the given hash.
+func (r *Ring) ownerOf(h uint64) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+	i := sort.Search(len(r.points), func(i int) bool {
+		return r.points[i].hash >= h
+	})
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.points[i].node
+}
+
+// hash hashes a string onto the ring.
+func hash(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	return h.Sum64()
+}
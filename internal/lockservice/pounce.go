@@ -0,0 +1,130 @@
+package lockservice
+
+import "time"
+
+// Pounce lets a client register its interest in a lock held by another
+// process. The moment the lock is released or its lease runs out, it is
+// handed over to the pouncer within the same critical section, so that no
+// other process can take it in between. Pouncers are queued along with
+// the processes blocked in AcquireWait, in FIFO order.
+//
+// The pouncer is notified of the handover through an EventAcquired event
+// with Pounced set, sent to the watchers of the lock.
+//
+// If the lock can be acquired right away, it is acquired and the fencing
+// token is returned. A zero token is returned if the pounce was registered.
+//
+// A pounce lasts for the lease requested by the descriptor, or the default
+// lease of the service, and is dropped once it runs out, just like a hold.
+// Pouncing again while registered renews the pounce. A pounce made in a
+// session is dropped along with the session, and ErrSessionNotFound is
+// returned if the session isn't live.
+func (ls *SimpleLockService) Pounce(sd Descriptors) (FencingToken, error) {
+	return ls.pounceAt(sd, time.Now())
+}
+
+// pounceAt pounces on the lock of the descriptor as Pounce does, at the
+// given time.
+func (ls *SimpleLockService) pounceAt(sd Descriptors, now time.Time) (FencingToken, error) {
+	id := sd.ID()
+	m := ls.shard(id)
+	m.Mutex.Lock()
+	defer m.Mutex.Unlock()
+	ls.expire(id, now)
+	if err := ls.attach(sd); err != nil {
+		return 0, err
+	}
+	if ls.acquirable(sd) {
+		return ls.acquire(sd, now)
+	}
+	var deadline time.Time
+	if lease := ls.leaseOf(sd); lease > 0 {
+		deadline = now.Add(lease)
+	}
+	if w := ls.pouncer(id, sd.Owner()); w != nil {
+		w.deadline = deadline
+		return 0, nil
+	}
+	m.Waiters[id] = append(m.Waiters[id], &waiter{
+		sd:       sd,
+		pounce:   true,
+		deadline: deadline,
+	})
+	ls.
+		log.
+		Debug().
+		Str("descriptor", id).
+		Str("owner", sd.Owner()).
+		Msg("pouncing on the lock")
+	return 0, nil
+}
+
+// Unpounce withdraws the interest of the process in the lock, registered
+// with Pounce. ErrNotPouncing is returned if the process isn't pouncing,
+// which is also the case once the lock has been handed over.
+func (ls *SimpleLockService) Unpounce(sd Descriptors) error {
+	id := sd.ID()
+	m := ls.shard(id)
+	m.Mutex.Lock()
+	defer m.Mutex.Unlock()
+	w := ls.pouncer(id, sd.Owner())
+	if w == nil {
+		return ErrNotPouncing
+	}
+	ls.dequeue(id, w)
+	ls.
+		log.
+		Debug().
+		Str("descriptor", id).
+		Str("owner", sd.Owner()).
+		Msg("stopped pouncing on the lock")
+	return nil
+}
+
+// pouncer returns the queued pounce of the owner on the lock, if any.
+// The mutex of the shard of the lock must be held.
+func (ls *SimpleLockService) pouncer(id, owner string) *waiter {
+	for _, w := range ls.shard(id).Waiters[id] {
+		if w.pounce && w.sd.Owner() == owner {
+			return w
+		}
+	}
+	return nil
+}
+
+// expirePounces drops the pounces on the lock that have run out, and hands
+// the lock over to the waiters behind them if it can. The mutex of the
+// shard of the lock must be held.
+func (ls *SimpleLockService) expirePounces(id string, now time.Time) {
+	expired := false
+	for _, w := range ls.shard(id).Waiters[id] {
+		if w.lapsed(now) {
+			ls.dequeue(id, w)
+			ls.
+				log.
+				Debug().
+				Str("descriptor", id).
+				Str("owner", w.sd.Owner()).
+				Msg("pounce expired")
+			expired = true
+		}
+	}
+	if expired {
+		ls.promote(id, now)
+	}
+}
+
+// dropPounces drops the pounces on the lock made in the session. The mutex
+// of the shard of the lock must be held.
+func (ls *SimpleLockService) dropPounces(id, session string, now time.Time) {
+	dropped := false
+	for _, w := range ls.shard(id).Waiters[id] {
+		if w.pounce && sessionOf(w.sd) == session {
+			ls.dequeue(id, w)
+			dropped = true
+		}
+	}
+	if dropped {
+		ls.promote(id, now)
+	}
+}
@@ -0,0 +1,3 @@
+// Package replicated implements a lockservice whose locks are replicated
+// across a cluster of nodes through the Raft log of the cluster.
+package replicated
@@ -0,0 +1,12 @@
+package replicated
+
+// Error provides constant error strings to the driver functions.
+type Error string
+
+func (e Error) Error() string { return string(e) }
+
+// Constant errors.
+// Rule of thumb, all errors start with a small letter and end with no full stop.
+const (
+	ErrNoLeader = Error("no leader of the cluster could be reached")
+)
@@ -0,0 +1,136 @@
+package replicated
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/SystemBuilders/LocKey/internal/lockservice"
+	"github.com/SystemBuilders/LocKey/internal/lockservice/routing"
+	"github.com/gorilla/mux"
+)
+
+// Handler returns the HTTP handler of the node. It serves the calls of
+// the other nodes of the cluster along with the lock endpoints of the
+// lockservice, in the same format as a standalone node, so that clients
+// can talk to any node of the cluster, and the v2 API of the locks.
+func (rls *LockService) Handler() http.Handler {
+	r := mux.NewRouter()
+	r.PathPrefix("/raft/").Handler(rls.raft.Handler())
+	r.HandleFunc(proposePath, rls.proposeHandler).Methods(http.MethodPost)
+	r.HandleFunc("/acquire", rls.opHandler(lockservice.OpAcquire)).Methods(http.MethodPost)
+	r.HandleFunc("/checkAcquire", rls.opHandler(lockservice.OpCheckAcquired)).Methods(http.MethodPost)
+	r.HandleFunc("/release", rls.opHandler(lockservice.OpRelease)).Methods(http.MethodPost)
+	r.HandleFunc("/checkRelease", rls.opHandler(lockservice.OpCheckReleased)).Methods(http.MethodPost)
+	r.HandleFunc("/acquireBatch", rls.opHandler(lockservice.OpAcquireBatch)).Methods(http.MethodPost)
+	r.HandleFunc("/releaseBatch", rls.opHandler(lockservice.OpReleaseBatch)).Methods(http.MethodPost)
+	return routing.SetupV2Routing(rls, r)
+}
+
+// proposeHandler commits a command forwarded by a follower. It fails
+// with the error of Raft if this node isn't the leader.
+func (rls *LockService) proposeHandler(w http.ResponseWriter, r *http.Request) {
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var cmd lockservice.Command
+	if err := json.Unmarshal(body, &cmd); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	res, err := rls.propose(r.Context(), cmd)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	byteData, err := json.Marshal(res)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(byteData)
+}
+
+// opHandler returns the handler of the lock endpoint of the operation.
+// The endpoint takes a LockRequest, or a BatchRequest for batches, and
+// responds like the endpoint of a standalone node does.
+func (rls *LockService) opHandler(op lockservice.Op) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var sds []lockservice.Descriptors
+		if op == lockservice.OpAcquireBatch || op == lockservice.OpReleaseBatch {
+			var req lockservice.BatchRequest
+			err = json.Unmarshal(body, &req)
+			for _, lr := range req.Requests {
+				sds = append(sds, lr.Descriptor())
+			}
+		} else {
+			var req lockservice.LockRequest
+			err = json.Unmarshal(body, &req)
+			sds = append(sds, req.Descriptor())
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		res, err := rls.execute(op, sds...)
+		if err == nil {
+			err = res.Error()
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		var reply interface{}
+		switch op {
+		case lockservice.OpAcquire:
+			reply = lockservice.AcquireRes{Token: res.Tokens[0]}
+		case lockservice.OpAcquireBatch:
+			reply = lockservice.AcquireBatchRes{Tokens: res.Tokens}
+		case lockservice.OpCheckAcquired:
+			if !res.OK {
+				http.Error(w, lockservice.ErrCheckAcquireFailure.Error(), http.StatusInternalServerError)
+				return
+			}
+			reply = lockservice.CheckAcquireRes{
+				Owner:  res.Owner,
+				Owners: res.Owners,
+				Mode:   res.Mode,
+			}
+		case lockservice.OpCheckReleased:
+			if res.OK {
+				w.Write([]byte("checkRelease success"))
+			} else {
+				w.Write([]byte("checkRelease failure"))
+			}
+			return
+		case lockservice.OpRelease:
+			w.Write([]byte("lock released"))
+			return
+		case lockservice.OpReleaseBatch:
+			w.Write([]byte("locks released"))
+			return
+		}
+
+		byteData, err := json.Marshal(reply)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Write(byteData)
+	}
+}
@@ -0,0 +1,415 @@
+package replicated
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/SystemBuilders/LocKey/internal/lockservice"
+	"github.com/SystemBuilders/LocKey/internal/raft"
+	"github.com/rs/zerolog"
+)
+
+const (
+	// DefaultTimeout is the time for which a call waits for its
+	// command to be committed, including the time it takes to find
+	// the leader of the cluster.
+	DefaultTimeout = 5 * time.Second
+	// reapInterval is the interval at which the leader reaps the
+	// expired leases of the cluster.
+	reapInterval = time.Second
+	// proposePath is the path at which the leader accepts the
+	// commands forwarded by its followers.
+	proposePath = "/cluster/propose"
+)
+
+// Config configures a node of a replicated lockservice.
+type Config struct {
+	// ID is the ID of the node, which must be a key of Peers.
+	ID string
+	// Peers maps the IDs of all the nodes of the cluster, including
+	// this one, to the base URLs at which they serve the Handler of
+	// their LockService, such as "http://127.0.0.1:1234".
+	Peers map[string]string
+	// Storage keeps the Raft state of the node across restarts.
+	Storage           raft.Storage
+	ElectionTimeout   time.Duration
+	HeartbeatInterval time.Duration
+	// SnapshotThreshold is the number of commands after which the
+	// Raft log is compacted into a snapshot of the lock table.
+	SnapshotThreshold uint64
+	// Timeout is the time for which a call waits for its command to
+	// be committed. It defaults to DefaultTimeout.
+	Timeout time.Duration
+}
+
+var _ lockservice.LockService = (*LockService)(nil)
+
+// LockService is a lockservice replicated across a cluster of 3 or 5
+// nodes. Every call that changes the locks is turned into a
+// lockservice.Command which is committed through the Raft log of the
+// cluster and applied to a SimpleLockService on every node, so that the
+// locks survive the crash of any minority of the nodes. Checks are read
+// from the lock table of the leader once it has confirmed that it still
+// leads the cluster, without going through the log.
+//
+// Only the leader proposes commands and stamps them with its clock.
+// Followers forward the calls they get to the leader.
+type LockService struct {
+	log     zerolog.Logger
+	id      string
+	ls      *lockservice.SimpleLockService
+	sm      *stateMachine
+	raft    *raft.Raft
+	client  *http.Client
+	timeout time.Duration
+	cancel  context.CancelFunc
+}
+
+// stateMachine applies the committed commands to the lockservice of a
+// node. Time never goes back in the state machine, even if the clock
+// of a new leader lags behind the one of the old leader.
+type stateMachine struct {
+	mu   sync.Mutex
+	ls   *lockservice.SimpleLockService
+	last time.Time
+}
+
+// Apply applies a command encoded as JSON and returns its result.
+func (sm *stateMachine) Apply(command []byte) []byte {
+	var cmd lockservice.Command
+	var res lockservice.CommandResult
+	if err := json.Unmarshal(command, &cmd); err != nil {
+		res.Err = err.Error()
+	} else {
+		sm.mu.Lock()
+		if cmd.Time.Before(sm.last) {
+			cmd.Time = sm.last
+		}
+		sm.last = cmd.Time
+		sm.mu.Unlock()
+		res = sm.ls.Apply(cmd)
+	}
+	data, _ := json.Marshal(res)
+	return data
+}
+
+// Snapshot encodes the lock table in the snapshot format of the
+// lockservice, stamped with the time of the state machine.
+func (sm *stateMachine) Snapshot() ([]byte, error) {
+	snap := sm.ls.Snapshot()
+	sm.mu.Lock()
+	snap.Time = sm.last
+	sm.mu.Unlock()
+	var buf bytes.Buffer
+	if err := lockservice.EncodeSnapshot(&buf, snap); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Restore replaces the lock table with the one of the snapshot and
+// picks up the time of the state machine from it.
+func (sm *stateMachine) Restore(data []byte) error {
+	snap, err := lockservice.DecodeSnapshot(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	if err := sm.ls.Restore(snap); err != nil {
+		return err
+	}
+	sm.mu.Lock()
+	sm.last = snap.Time
+	sm.mu.Unlock()
+	return nil
+}
+
+// read answers a command that only reads the lock table, at the time of
+// the state machine unless the command comes later.
+func (sm *stateMachine) read(cmd lockservice.Command) lockservice.CommandResult {
+	sm.mu.Lock()
+	if cmd.Time.Before(sm.last) {
+		cmd.Time = sm.last
+	}
+	sm.mu.Unlock()
+	return sm.ls.Read(cmd)
+}
+
+// NewLockService creates a node of a replicated lockservice. The node
+// takes part in the cluster once it is started and its Handler is being
+// served at its URL in the peers.
+func NewLockService(log zerolog.Logger, cfg Config) (*LockService, error) {
+	if cfg.Timeout == 0 {
+		cfg.Timeout = DefaultTimeout
+	}
+	// Leases are reaped by the leader through the log, never
+	// by the clock of a node.
+	ls := lockservice.NewSimpleLockService(log)
+	sm := &stateMachine{ls: ls}
+	rf, err := raft.New(raft.Config{
+		ID:                cfg.ID,
+		Peers:             cfg.Peers,
+		Storage:           cfg.Storage,
+		ElectionTimeout:   cfg.ElectionTimeout,
+		HeartbeatInterval: cfg.HeartbeatInterval,
+		SnapshotThreshold: cfg.SnapshotThreshold,
+		Log:               log,
+	}, sm)
+	if err != nil {
+		return nil, err
+	}
+	return &LockService{
+		log:     log,
+		id:      cfg.ID,
+		ls:      ls,
+		sm:      sm,
+		raft:    rf,
+		client:  &http.Client{Timeout: cfg.Timeout},
+		timeout: cfg.Timeout,
+	}, nil
+}
+
+// Start makes the node take part in the cluster.
+func (rls *LockService) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	rls.cancel = cancel
+	rls.raft.Start()
+	go rls.reap(ctx)
+}
+
+// Close stops the node.
+func (rls *LockService) Close() error {
+	if rls.cancel != nil {
+		rls.cancel()
+	}
+	rls.raft.Stop()
+	return nil
+}
+
+// Leader returns the ID of the leader of the cluster as far as this
+// node knows, which is empty if it knows of no leader.
+func (rls *LockService) Leader() string {
+	id, _ := rls.raft.Leader()
+	return id
+}
+
+// IsLeader returns true if this node is the leader of the cluster.
+func (rls *LockService) IsLeader() bool {
+	_, role := rls.raft.State()
+	return role == raft.Leader
+}
+
+// Acquire acquires the lock on the descriptor across the cluster.
+func (rls *LockService) Acquire(sd lockservice.Descriptors) (lockservice.FencingToken, error) {
+	res, err := rls.execute(lockservice.OpAcquire, sd)
+	if err != nil {
+		return 0, err
+	}
+	if err := res.Error(); err != nil {
+		return 0, err
+	}
+	return res.Tokens[0], nil
+}
+
+// Release releases the lock on the descriptor across the cluster.
+func (rls *LockService) Release(sd lockservice.Descriptors) error {
+	res, err := rls.execute(lockservice.OpRelease, sd)
+	if err != nil {
+		return err
+	}
+	return res.Error()
+}
+
+// CheckAcquired checks whether the lock on the descriptor is held. The
+// check is read from the leader once it has applied every committed
+// command, so it never sees stale locks. A check that the leader can't
+// serve reports the lock as not acquired.
+func (rls *LockService) CheckAcquired(sd lockservice.Descriptors) (string, bool) {
+	res, err := rls.execute(lockservice.OpCheckAcquired, sd)
+	if err != nil {
+		return "", false
+	}
+	return res.Owner, res.OK
+}
+
+// CheckReleased checks whether the lock on the descriptor is free. The
+// check is read from the leader like the one of CheckAcquired. A check
+// that the leader can't serve reports the lock as not released.
+func (rls *LockService) CheckReleased(sd lockservice.Descriptors) bool {
+	res, err := rls.execute(lockservice.OpCheckReleased, sd)
+	if err != nil {
+		return false
+	}
+	return res.OK
+}
+
+// AcquireBatch acquires the locks on all the descriptors across the
+// cluster, or none of them.
+func (rls *LockService) AcquireBatch(sds []lockservice.Descriptors) ([]lockservice.FencingToken, error) {
+	res, err := rls.execute(lockservice.OpAcquireBatch, sds...)
+	if err != nil {
+		return nil, err
+	}
+	if err := res.Error(); err != nil {
+		return nil, err
+	}
+	return res.Tokens, nil
+}
+
+// ReleaseBatch releases the locks on all the descriptors across the
+// cluster, or none of them.
+func (rls *LockService) ReleaseBatch(sds []lockservice.Descriptors) error {
+	res, err := rls.execute(lockservice.OpReleaseBatch, sds...)
+	if err != nil {
+		return err
+	}
+	return res.Error()
+}
+
+// execute commits the operation on the descriptors through the leader
+// of the cluster and returns its result. The operation is retried on a
+// new leader as long as it certainly wasn't committed, until the timeout
+// of the service runs out.
+func (rls *LockService) execute(op lockservice.Op, sds ...lockservice.Descriptors) (lockservice.CommandResult, error) {
+	cmd := lockservice.Command{
+		Op:       op,
+		Requests: make([]lockservice.LockRequest, len(sds)),
+	}
+	for i, sd := range sds {
+		cmd.Requests[i] = requestOf(sd)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), rls.timeout)
+	defer cancel()
+	for {
+		res, err := rls.forward(ctx, cmd)
+		if err == nil || !retriable(err) {
+			return res, err
+		}
+		select {
+		case <-ctx.Done():
+			return res, ErrNoLeader
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
+
+// forward proposes the command on this node if it is the leader and
+// forwards it to the leader otherwise.
+func (rls *LockService) forward(ctx context.Context, cmd lockservice.Command) (lockservice.CommandResult, error) {
+	if rls.IsLeader() {
+		return rls.propose(ctx, cmd)
+	}
+
+	var res lockservice.CommandResult
+	leader, url := rls.raft.Leader()
+	if leader == "" || leader == rls.id {
+		return res, ErrNoLeader
+	}
+	data, err := json.Marshal(cmd)
+	if err != nil {
+		return res, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url+proposePath, bytes.NewReader(data))
+	if err != nil {
+		return res, err
+	}
+	resp, err := rls.client.Do(req)
+	if err != nil {
+		return res, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return res, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return res, raft.Error(bytes.TrimSpace(body))
+	}
+	err = json.Unmarshal(body, &res)
+	return res, err
+}
+
+// propose stamps the command with the clock of the leader and commits it.
+// Commands that only read the locks aren't committed, they are read from
+// the lock table of the leader once Raft confirms that it is up to date.
+func (rls *LockService) propose(ctx context.Context, cmd lockservice.Command) (lockservice.CommandResult, error) {
+	var res lockservice.CommandResult
+	cmd.Time = time.Now()
+	if cmd.Op == lockservice.OpCheckAcquired || cmd.Op == lockservice.OpCheckReleased {
+		if err := rls.raft.ReadIndex(ctx); err != nil {
+			return res, err
+		}
+		return rls.sm.read(cmd), nil
+	}
+	data, err := json.Marshal(cmd)
+	if err != nil {
+		return res, err
+	}
+	result, err := rls.raft.Propose(ctx, data)
+	if err != nil {
+		return res, err
+	}
+	err = json.Unmarshal(result, &res)
+	return res, err
+}
+
+// reap makes the leader reap the expired leases of the cluster every
+// reapInterval until the context is cancelled. A reap is only proposed
+// if a lease has run out on the leader, so that an idle cluster doesn't
+// grow its log.
+func (rls *LockService) reap(ctx context.Context) {
+	ticker := time.NewTicker(reapInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if rls.IsLeader() && rls.ls.Reapable(time.Now()) {
+				rls.propose(ctx, lockservice.Command{Op: lockservice.OpReap})
+			}
+		}
+	}
+}
+
+// retriable returns true if the command that failed with the error was
+// certainly not committed, so that it can be sent again. A leader that
+// is stopped fails its proposals with raft.ErrStopped whether or not
+// they were appended to its log, so they might still be committed and
+// aren't retried.
+func retriable(err error) bool {
+	switch err {
+	case ErrNoLeader, raft.ErrNotLeader, raft.ErrLeadershipLost:
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// requestOf returns the lock request that describes the descriptor.
+func requestOf(sd lockservice.Descriptors) lockservice.LockRequest {
+	req := lockservice.LockRequest{
+		FileID: sd.ID(),
+		UserID: sd.Owner(),
+	}
+	if lsd, ok := sd.(lockservice.LeasedDescriptors); ok {
+		req.Lease = lsd.Lease()
+	}
+	if tsd, ok := sd.(lockservice.TokenDescriptors); ok {
+		req.Token = tsd.Token()
+	}
+	if msd, ok := sd.(lockservice.ModeDescriptors); ok {
+		req.Mode = msd.Mode()
+	}
+	if rsd, ok := sd.(lockservice.ReentrantDescriptors); ok {
+		req.Reentrant = rsd.Reentrant()
+	}
+	return req
+}
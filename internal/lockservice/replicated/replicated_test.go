@@ -0,0 +1,277 @@
+package replicated
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/SystemBuilders/LocKey/internal/lockservice"
+	"github.com/SystemBuilders/LocKey/internal/lockservice/conformance"
+	"github.com/SystemBuilders/LocKey/internal/raft"
+	"github.com/rs/zerolog"
+)
+
+// cluster is a cluster of nodes that run in the test process.
+type cluster struct {
+	t         *testing.T
+	log       zerolog.Logger
+	peers     map[string]string
+	storages  map[string]*raft.MemoryStorage
+	nodes     map[string]*LockService
+	servers   map[string]*http.Server
+	threshold uint64
+}
+
+// newCluster starts a cluster of n nodes on localhost.
+func newCluster(t *testing.T, n int) *cluster {
+	return newClusterWith(t, n, 0)
+}
+
+// newClusterWith starts a cluster of n nodes on localhost which compact
+// their logs once the given number of commands has been applied.
+func newClusterWith(t *testing.T, n int, threshold uint64) *cluster {
+	c := &cluster{
+		t:         t,
+		log:       zerolog.New(os.Stdout).With().Logger().Level(zerolog.Disabled),
+		peers:     make(map[string]string),
+		storages:  make(map[string]*raft.MemoryStorage),
+		nodes:     make(map[string]*LockService),
+		servers:   make(map[string]*http.Server),
+		threshold: threshold,
+	}
+	listeners := make(map[string]net.Listener)
+	for i := 0; i < n; i++ {
+		id := "node" + strconv.Itoa(i)
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("listen: %v", err)
+		}
+		listeners[id] = l
+		c.peers[id] = "http://" + l.Addr().String()
+		c.storages[id] = raft.NewMemoryStorage()
+	}
+	for id, l := range listeners {
+		c.start(id, l)
+	}
+	t.Cleanup(func() {
+		for id := range c.nodes {
+			c.stop(id)
+		}
+	})
+	return c
+}
+
+// start starts the node on the listener.
+func (c *cluster) start(id string, l net.Listener) {
+	rls, err := NewLockService(c.log, Config{
+		ID:                id,
+		Peers:             c.peers,
+		Storage:           c.storages[id],
+		ElectionTimeout:   150 * time.Millisecond,
+		HeartbeatInterval: 30 * time.Millisecond,
+		SnapshotThreshold: c.threshold,
+	})
+	if err != nil {
+		c.t.Fatalf("newLockService: %v", err)
+	}
+	server := &http.Server{Handler: rls.Handler()}
+	go server.Serve(l)
+	rls.Start()
+	c.nodes[id] = rls
+	c.servers[id] = server
+}
+
+// stop crashes the node. Its storage survives the crash.
+func (c *cluster) stop(id string) {
+	c.servers[id].Close()
+	c.nodes[id].Close()
+	delete(c.nodes, id)
+	delete(c.servers, id)
+}
+
+// restart starts a crashed node again at its old address.
+func (c *cluster) restart(id string) {
+	l, err := net.Listen("tcp", c.peers[id][len("http://"):])
+	if err != nil {
+		c.t.Fatalf("listen: %v", err)
+	}
+	c.start(id, l)
+}
+
+// leader waits for the running nodes to elect a leader and returns it.
+func (c *cluster) leader() string {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		for id, rls := range c.nodes {
+			if rls.IsLeader() {
+				return id
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	c.t.Fatalf("leader: no leader elected")
+	return ""
+}
+
+// entries returns the number of entries in the stored log of the node.
+func (c *cluster) entries(id string) int {
+	_, _, entries, err := c.storages[id].Load()
+	if err != nil {
+		c.t.Fatalf("load: %v", err)
+	}
+	return len(entries)
+}
+
+// follower returns a running node that isn't the leader.
+func (c *cluster) follower(leader string) string {
+	for id := range c.nodes {
+		if id != leader {
+			return id
+		}
+	}
+	c.t.Fatalf("follower: no follower running")
+	return ""
+}
+
+func TestReplicatedLockService(t *testing.T) {
+	t.Run("locks survive the crash of the leader", func(t *testing.T) {
+		c := newCluster(t, 3)
+		leader := c.leader()
+		follower := c.follower(leader)
+
+		// Followers forward the calls they get to the leader.
+		token, err := c.nodes[follower].Acquire(lockservice.NewLockDescriptor("test", "owner1"))
+		if err != nil || token != 1 {
+			t.Fatalf("acquire: got %d, %v want 1, <nil>", token, err)
+		}
+		if owner, _ := c.nodes[leader].CheckAcquired(lockservice.NewLockDescriptor("test", "")); owner != "owner1" {
+			t.Errorf("checkAcquire: got %q want %q", owner, "owner1")
+		}
+
+		c.stop(leader)
+		leader = c.leader()
+
+		_, got := c.nodes[leader].Acquire(lockservice.NewLockDescriptor("test", "owner2"))
+		want := lockservice.ErrFileacquired
+		if got != want {
+			t.Errorf("acquire: got %q want %q", got, want)
+		}
+		if err := c.nodes[c.follower(leader)].Release(lockservice.NewLockDescriptor("test", "owner1")); err != nil {
+			t.Fatalf("release: %v", err)
+		}
+		token, err = c.nodes[leader].Acquire(lockservice.NewLockDescriptor("test", "owner2"))
+		if err != nil || token != 2 {
+			t.Errorf("acquire: got %d, %v want 2, <nil>", token, err)
+		}
+	})
+
+	t.Run("restarted nodes catch up with the cluster", func(t *testing.T) {
+		c := newCluster(t, 3)
+		leader := c.leader()
+		follower := c.follower(leader)
+
+		c.stop(follower)
+		if _, err := c.nodes[leader].Acquire(lockservice.NewLockDescriptor("test", "owner1")); err != nil {
+			t.Fatalf("acquire: %v", err)
+		}
+		c.restart(follower)
+
+		// With the leader down, the restarted node is needed for a
+		// majority and must have caught up to keep the lock.
+		c.stop(leader)
+		leader = c.leader()
+		if owner, _ := c.nodes[leader].CheckAcquired(lockservice.NewLockDescriptor("test", "")); owner != "owner1" {
+			t.Errorf("checkAcquire: got %q want %q", owner, "owner1")
+		}
+	})
+
+	t.Run("lagging nodes catch up from a snapshot", func(t *testing.T) {
+		c := newClusterWith(t, 3, 8)
+		leader := c.leader()
+		follower := c.follower(leader)
+
+		c.stop(follower)
+		for i := 0; i < 20; i++ {
+			if _, err := c.nodes[leader].Acquire(lockservice.NewLockDescriptor("test"+strconv.Itoa(i), "owner1")); err != nil {
+				t.Fatalf("acquire: %v", err)
+			}
+		}
+		snap, err := c.storages[leader].Snapshot()
+		if err != nil || snap.Index == 0 {
+			t.Fatalf("snapshot: got %d, %v want a snapshot", snap.Index, err)
+		}
+		if got := c.entries(leader); got >= 20 {
+			t.Errorf("entries: got %d want the log compacted", got)
+		}
+
+		// The entries the follower is missing are gone from the log
+		// of the leader, so it is sent the snapshot instead.
+		c.restart(follower)
+		deadline := time.Now().Add(5 * time.Second)
+		for c.nodes[follower].ls.CheckReleased(lockservice.NewLockDescriptor("test19", "")) && time.Now().Before(deadline) {
+			time.Sleep(20 * time.Millisecond)
+		}
+		if owner, _ := c.nodes[follower].ls.CheckAcquired(lockservice.NewLockDescriptor("test19", "")); owner != "owner1" {
+			t.Errorf("checkAcquire: got %q want %q", owner, "owner1")
+		}
+	})
+
+	t.Run("reads and idle reaps don't grow the log", func(t *testing.T) {
+		c := newCluster(t, 3)
+		leader := c.leader()
+
+		if _, err := c.nodes[leader].Acquire(lockservice.NewLeasedLockDescriptor("test", "owner1", 100*time.Millisecond)); err != nil {
+			t.Fatalf("acquire: %v", err)
+		}
+		// Wait for the lease to be reaped.
+		time.Sleep(reapInterval + 500*time.Millisecond)
+		if !c.nodes[leader].CheckReleased(lockservice.NewLockDescriptor("test", "")) {
+			t.Errorf("checkRelease: got false want true")
+		}
+
+		before := c.entries(leader)
+		if _, ok := c.nodes[c.follower(leader)].CheckAcquired(lockservice.NewLockDescriptor("test", "")); ok {
+			t.Errorf("checkAcquire: got true want false")
+		}
+		time.Sleep(reapInterval + 100*time.Millisecond)
+		if got := c.entries(leader); got != before {
+			t.Errorf("entries: got %d want %d", got, before)
+		}
+	})
+
+	t.Run("calls fail without a majority", func(t *testing.T) {
+		c := newCluster(t, 3)
+		leader := c.leader()
+		c.stop(c.follower(leader))
+		c.stop(c.follower(leader))
+		c.nodes[leader].timeout = 500 * time.Millisecond
+
+		_, got := c.nodes[leader].Acquire(lockservice.NewLockDescriptor("test", "owner1"))
+		if got == nil {
+			t.Errorf("acquire: got <nil> want an error")
+		}
+	})
+
+	t.Run("only commands that certainly weren't committed are retried", func(t *testing.T) {
+		for err, want := range map[error]bool{
+			ErrNoLeader:            true,
+			raft.ErrNotLeader:      true,
+			raft.ErrLeadershipLost: true,
+			raft.ErrStopped:        false,
+		} {
+			if got := retriable(err); got != want {
+				t.Errorf("retriable(%v): got %v want %v", err, got, want)
+			}
+		}
+	})
+
+	t.Run("conforms to the lockservice", func(t *testing.T) {
+		conformance.Run(t, func(t *testing.T) lockservice.LockService {
+			c := newCluster(t, 1)
+			return c.nodes[c.leader()]
+		})
+	})
+}
@@ -0,0 +1,9 @@
+// Package resp implements a front end of the lockservice that speaks the
+// subset of the Redis protocol (RESP) that Redis locking clients use, so
+// that Redlock-style clients can take locks in LocKey without changes.
+//
+// A Redis key is the ID of a lock and the value set on it is the owner
+// of the lock. SET with NX acquires the lock, GET returns its owner and
+// the compare-and-delete and compare-and-pexpire scripts that Redlock
+// clients run with EVAL release and extend it.
+package resp
@@ -0,0 +1,109 @@
+package resp
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// maxArgs and maxBulk bound the commands a client can send, so that a
+// client can't make the server allocate without limit.
+const (
+	maxArgs = 1024
+	maxBulk = 1 << 20
+)
+
+// errProtocol is returned for a command that doesn't follow the protocol,
+// after which the connection is closed.
+var errProtocol = errors.New("Protocol error")
+
+// readCommand reads the next command from the reader. Commands are arrays
+// of bulk strings, or inline commands whose arguments are separated by
+// spaces, as sent by hand over telnet.
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return strings.Fields(line), nil
+	}
+
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n > maxArgs {
+		return nil, errProtocol
+	}
+	args := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(line, "$") {
+			return nil, errProtocol
+		}
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 || size > maxBulk {
+			return nil, errProtocol
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		if string(buf[size:]) != "\r\n" {
+			return nil, errProtocol
+		}
+		args = append(args, string(buf[:size]))
+	}
+	return args, nil
+}
+
+// readLine reads a line terminated by CRLF, or by LF alone for inline
+// commands, and returns it without the terminator.
+func readLine(r *bufio.Reader) (string, error) {
+	var line []byte
+	for {
+		chunk, isPrefix, err := r.ReadLine()
+		if err != nil {
+			return "", err
+		}
+		line = append(line, chunk...)
+		if len(line) > maxBulk {
+			return "", errProtocol
+		}
+		if !isPrefix {
+			return string(line), nil
+		}
+	}
+}
+
+// writer writes the replies to the commands of a connection.
+type writer struct {
+	w *bufio.Writer
+}
+
+func (w writer) simple(s string) {
+	w.w.WriteString("+" + s + "\r\n")
+}
+
+func (w writer) err(s string) {
+	w.w.WriteString("-" + s + "\r\n")
+}
+
+func (w writer) integer(n int) {
+	w.w.WriteString(":" + strconv.Itoa(n) + "\r\n")
+}
+
+func (w writer) bulk(s string) {
+	w.w.WriteString("$" + strconv.Itoa(len(s)) + "\r\n" + s + "\r\n")
+}
+
+func (w writer) null() {
+	w.w.WriteString("$-1\r\n")
+}
+
+func (w writer) array(n int) {
+	w.w.WriteString("*" + strconv.Itoa(n) + "\r\n")
+}
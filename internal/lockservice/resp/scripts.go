@@ -0,0 +1,57 @@
+package resp
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"strings"
+)
+
+// script is a Lua script that the server knows how to run on the
+// lockservice, since it can't run Lua itself.
+type script int
+
+const (
+	unknownScript script = iota
+	// compareAndDelete deletes KEYS[1] if its value is ARGV[1].
+	compareAndDelete
+	// compareAndPExpire sets the TTL of KEYS[1] to ARGV[2] milliseconds
+	// if its value is ARGV[1].
+	compareAndPExpire
+)
+
+// knownScripts maps the normalized bodies of the scripts that Redlock
+// clients run to what they do.
+var knownScripts = map[string]script{
+	normalize(`if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("del", KEYS[1]) else return 0 end`):              compareAndDelete,
+	normalize(`if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("pexpire", KEYS[1], ARGV[2]) else return 0 end`): compareAndPExpire,
+}
+
+// scriptOf returns the script that the body does, which is unknown if it
+// isn't one of the known scripts. Bodies are compared regardless of case,
+// white space and the quotes of their strings.
+func scriptOf(body string) script {
+	return knownScripts[normalize(body)]
+}
+
+// normalize returns the body of a script in lower case, with no white
+// space and with double quotes only.
+func normalize(body string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(body) {
+		switch r {
+		case ' ', '\t', '\r', '\n':
+		case '\'':
+			b.WriteRune('"')
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// sha returns the SHA1 digest of the body of a script, which EVALSHA
+// names it by.
+func sha(body string) string {
+	sum := sha1.Sum([]byte(body))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,572 @@
+package resp
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/SystemBuilders/LocKey/internal/lockservice"
+	"github.com/SystemBuilders/LocKey/internal/lockservice/routing"
+)
+
+var _ io.Closer = (*Server)(nil)
+
+// unsupportedScript is the reply to the scripts that the server doesn't
+// know how to run.
+const unsupportedScript = "ERR script isn't supported, only the compare-and-delete and compare-and-pexpire scripts of locks are"
+
+// Server serves a lockservice to the clients of the Redis protocol. Any
+// LockService can be served, but PEXPIRE and the compare-and-pexpire
+// script need the lockservice to be an Extender.
+type Server struct {
+	ls lockservice.LockService
+	// serves returns an error if the descriptor ID isn't served by
+	// the node, in which case the command isn't run.
+	serves func(id string) error
+	// auth authenticates the connections, which aren't authenticated
+	// if it's nil.
+	auth routing.Authenticator
+
+	mu        sync.Mutex
+	scripts   map[string]script
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	closed    bool
+	wg        sync.WaitGroup
+}
+
+// Option configures a Server.
+type Option func(*Server)
+
+// WithServes makes the server run only the commands on the keys that
+// serves returns no error for, such as the IDs of the partitions of a
+// node.
+func WithServes(serves func(id string) error) Option {
+	return func(s *Server) {
+		s.serves = serves
+	}
+}
+
+// WithAuth makes the server run the commands of a connection only once
+// the client authenticates with AUTH and a token that the authenticator
+// accepts. The owners of the locks of the connection are then scoped to
+// the principal of the token, like the owners of the HTTP API are, and
+// DEL and PEXPIRE only act on the locks of that principal.
+func WithAuth(a routing.Authenticator) Option {
+	return func(s *Server) {
+		s.auth = a
+	}
+}
+
+// connState is the state of a connection. Its context carries the
+// principal of the connection once the client has authenticated.
+type connState struct {
+	ctx           context.Context
+	authenticated bool
+}
+
+// NewServer returns a server of the lockservice.
+func NewServer(ls lockservice.LockService, opts ...Option) *Server {
+	s := &Server{
+		ls:        ls,
+		serves:    func(string) error { return nil },
+		scripts:   make(map[string]script),
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[net.Conn]struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Serve accepts the connections of the listener and serves each of them
+// in its own goroutine, until the listener fails or the server is
+// closed, in which case it returns nil.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		l.Close()
+		return nil
+	}
+	s.listeners[l] = struct{}{}
+	s.mu.Unlock()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			delete(s.listeners, l)
+			s.mu.Unlock()
+			if closed {
+				return nil
+			}
+			return err
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return nil
+		}
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+		go s.serveConn(conn)
+	}
+}
+
+// Close stops the listeners of the server, closes its connections and
+// waits for the commands that are being run.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	for l := range s.listeners {
+		l.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return nil
+}
+
+// serveConn runs the commands of the connection until the client quits
+// or breaks the protocol. Replies are flushed once no more commands are
+// buffered, so that pipelined commands are answered together.
+func (s *Server) serveConn(conn net.Conn) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+		s.wg.Done()
+	}()
+
+	r := bufio.NewReader(conn)
+	w := writer{bufio.NewWriter(conn)}
+	c := &connState{ctx: context.Background(), authenticated: s.auth == nil}
+	for {
+		args, err := readCommand(r)
+		if err == errProtocol {
+			w.err("ERR " + err.Error())
+			w.w.Flush()
+			return
+		}
+		if err != nil {
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		quit := strings.EqualFold(args[0], "quit")
+		if quit {
+			w.simple("OK")
+		} else {
+			s.run(c, w, args)
+		}
+		if r.Buffered() == 0 || quit {
+			if err := w.w.Flush(); err != nil || quit {
+				return
+			}
+		}
+	}
+}
+
+// run runs the command of the arguments and writes its reply. Only AUTH
+// is run until the client of a server with authentication authenticates.
+func (s *Server) run(c *connState, w writer, args []string) {
+	name := strings.ToLower(args[0])
+	args = args[1:]
+	if name == "auth" {
+		s.authenticate(c, w, args)
+		return
+	}
+	if !c.authenticated {
+		w.err("NOAUTH Authentication required.")
+		return
+	}
+	switch name {
+	case "ping":
+		switch len(args) {
+		case 0:
+			w.simple("PONG")
+		case 1:
+			w.bulk(args[0])
+		default:
+			wrongArgs(w, name)
+		}
+	case "echo":
+		if len(args) != 1 {
+			wrongArgs(w, name)
+			return
+		}
+		w.bulk(args[0])
+	case "select":
+		if len(args) != 1 {
+			wrongArgs(w, name)
+			return
+		}
+		if args[0] != "0" {
+			w.err("ERR DB index is out of range")
+			return
+		}
+		w.simple("OK")
+	case "set":
+		s.set(c, w, args)
+	case "get":
+		s.get(c, w, args)
+	case "del":
+		s.del(c, w, args)
+	case "pexpire":
+		s.pexpire(c, w, args)
+	case "eval":
+		if len(args) < 2 {
+			wrongArgs(w, name)
+			return
+		}
+		sc := scriptOf(args[0])
+		if sc == unknownScript {
+			w.err(unsupportedScript)
+			return
+		}
+		s.mu.Lock()
+		s.scripts[sha(args[0])] = sc
+		s.mu.Unlock()
+		s.eval(c, w, sc, args[1:])
+	case "evalsha":
+		if len(args) < 2 {
+			wrongArgs(w, name)
+			return
+		}
+		s.mu.Lock()
+		sc, ok := s.scripts[strings.ToLower(args[0])]
+		s.mu.Unlock()
+		if !ok {
+			w.err("NOSCRIPT No matching script. Please use EVAL.")
+			return
+		}
+		s.eval(c, w, sc, args[1:])
+	case "script":
+		s.script(w, args)
+	default:
+		w.err("ERR unknown command '" + name + "'")
+	}
+}
+
+// authenticate authenticates the connection with the token of AUTH token,
+// or of AUTH principal token, in which case the token must belong to the
+// principal, as Redis clients send a username along with the password.
+func (s *Server) authenticate(c *connState, w writer, args []string) {
+	if len(args) != 1 && len(args) != 2 {
+		wrongArgs(w, "auth")
+		return
+	}
+	if s.auth == nil {
+		w.err("ERR AUTH called without any password configured")
+		return
+	}
+	principal, err := s.auth.Authenticate(args[len(args)-1])
+	if err != nil || (len(args) == 2 && args[0] != principal) {
+		w.err("WRONGPASS " + lockservice.ErrUnauthenticated.Error())
+		return
+	}
+	c.ctx = routing.WithPrincipal(context.Background(), principal)
+	c.authenticated = true
+	w.simple("OK")
+}
+
+// set acquires the lock of the key for the value, which is the owner of
+// the lock, if the command is SET key value NX with an optional PX or EX.
+// The lock is taken for the default lease of the lockservice if neither
+// is given. A lock that is held already is replied to with a null, as
+// Redis does for a key that exists.
+func (s *Server) set(c *connState, w writer, args []string) {
+	if len(args) < 2 {
+		wrongArgs(w, "set")
+		return
+	}
+	desc := &lockservice.LockDescriptor{
+		FileID: args[0],
+		UserID: routing.Scope(c.ctx, args[1]),
+	}
+	nx := false
+	for i := 2; i < len(args); i++ {
+		switch strings.ToLower(args[i]) {
+		case "nx":
+			nx = true
+		case "px", "ex":
+			if i+1 == len(args) || desc.Duration != 0 {
+				w.err("ERR syntax error")
+				return
+			}
+			n, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil || n <= 0 {
+				w.err("ERR invalid expire time in 'set' command")
+				return
+			}
+			unit := time.Millisecond
+			if strings.EqualFold(args[i], "ex") {
+				unit = time.Second
+			}
+			desc.Duration = time.Duration(n) * unit
+			i++
+		default:
+			w.err("ERR syntax error")
+			return
+		}
+	}
+	if !nx {
+		w.err("ERR only SET with NX is supported, as keys are locks")
+		return
+	}
+	if !s.served(w, desc.FileID) {
+		return
+	}
+
+	_, err := s.ls.Acquire(desc)
+	switch err {
+	case nil:
+		w.simple("OK")
+	case lockservice.ErrFileacquired:
+		w.null()
+	default:
+		w.err("ERR " + err.Error())
+	}
+}
+
+// get replies with the owner of the lock of the key, or a null if the
+// lock isn't held.
+func (s *Server) get(c *connState, w writer, args []string) {
+	if len(args) != 1 {
+		wrongArgs(w, "get")
+		return
+	}
+	if !s.served(w, args[0]) {
+		return
+	}
+	owner, ok := s.ls.CheckAcquired(lockservice.NewLockDescriptor(args[0], ""))
+	if !ok {
+		w.null()
+		return
+	}
+	w.bulk(routing.Unscope(c.ctx, owner))
+}
+
+// del releases the locks of the keys, whoever of the principal of the
+// connection holds them, and replies with the number of locks released.
+// Clients that must only release their own locks use the compare-and-
+// delete script instead.
+func (s *Server) del(c *connState, w writer, args []string) {
+	if len(args) == 0 {
+		wrongArgs(w, "del")
+		return
+	}
+	for _, key := range args {
+		if !s.served(w, key) {
+			return
+		}
+	}
+	n := 0
+	for _, key := range args {
+		owner, ok := s.ls.CheckAcquired(lockservice.NewLockDescriptor(key, ""))
+		if !ok || !owns(c, owner) {
+			continue
+		}
+		if released, err := s.release(key, owner); err != nil {
+			w.err("ERR " + err.Error())
+			return
+		} else if released {
+			n++
+		}
+	}
+	w.integer(n)
+}
+
+// pexpire renews the lease of the lock of the key for the milliseconds,
+// whoever of the principal of the connection holds it, and replies with
+// 1, or 0 if the lock isn't held.
+func (s *Server) pexpire(c *connState, w writer, args []string) {
+	if len(args) != 2 {
+		wrongArgs(w, "pexpire")
+		return
+	}
+	lease, ok := parseLease(w, args[1], "pexpire")
+	if !ok || !s.served(w, args[0]) {
+		return
+	}
+	owner, ok := s.ls.CheckAcquired(lockservice.NewLockDescriptor(args[0], ""))
+	if !ok || !owns(c, owner) {
+		w.integer(0)
+		return
+	}
+	s.extend(w, args[0], owner, lease)
+}
+
+// eval runs the known script on the keys and arguments, which are given
+// as the number of keys followed by the keys and then the arguments.
+func (s *Server) eval(c *connState, w writer, sc script, args []string) {
+	numKeys, err := strconv.Atoi(args[0])
+	if err != nil || numKeys < 0 {
+		w.err("ERR value is not an integer or out of range")
+		return
+	}
+	if numKeys > len(args)-1 {
+		w.err("ERR Number of keys can't be greater than number of args")
+		return
+	}
+	keys, argv := args[1:1+numKeys], args[1+numKeys:]
+
+	switch sc {
+	case compareAndDelete:
+		if len(keys) != 1 || len(argv) != 1 {
+			wrongArgs(w, "eval")
+			return
+		}
+		if !s.served(w, keys[0]) {
+			return
+		}
+		released, err := s.release(keys[0], routing.Scope(c.ctx, argv[0]))
+		if err != nil {
+			w.err("ERR " + err.Error())
+			return
+		}
+		if released {
+			w.integer(1)
+		} else {
+			w.integer(0)
+		}
+	case compareAndPExpire:
+		if len(keys) != 1 || len(argv) != 2 {
+			wrongArgs(w, "eval")
+			return
+		}
+		lease, ok := parseLease(w, argv[1], "pexpire")
+		if !ok || !s.served(w, keys[0]) {
+			return
+		}
+		s.extend(w, keys[0], routing.Scope(c.ctx, argv[0]), lease)
+	}
+}
+
+// script runs the subcommands of SCRIPT that clients use to load the
+// scripts that they run with EVALSHA.
+func (s *Server) script(w writer, args []string) {
+	if len(args) == 0 {
+		wrongArgs(w, "script")
+		return
+	}
+	switch strings.ToLower(args[0]) {
+	case "load":
+		if len(args) != 2 {
+			wrongArgs(w, "script|load")
+			return
+		}
+		sc := scriptOf(args[1])
+		if sc == unknownScript {
+			w.err(unsupportedScript)
+			return
+		}
+		digest := sha(args[1])
+		s.mu.Lock()
+		s.scripts[digest] = sc
+		s.mu.Unlock()
+		w.bulk(digest)
+	case "exists":
+		if len(args) < 2 {
+			wrongArgs(w, "script|exists")
+			return
+		}
+		w.array(len(args) - 1)
+		s.mu.Lock()
+		for _, digest := range args[1:] {
+			if _, ok := s.scripts[strings.ToLower(digest)]; ok {
+				w.integer(1)
+			} else {
+				w.integer(0)
+			}
+		}
+		s.mu.Unlock()
+	case "flush":
+		s.mu.Lock()
+		s.scripts = make(map[string]script)
+		s.mu.Unlock()
+		w.simple("OK")
+	default:
+		w.err("ERR unknown subcommand '" + args[0] + "'")
+	}
+}
+
+// release releases the lock of the key held by the owner, and reports
+// false if the owner doesn't hold it.
+func (s *Server) release(key, owner string) (bool, error) {
+	err := s.ls.Release(lockservice.NewLockDescriptor(key, owner))
+	switch err {
+	case nil:
+		return true, nil
+	case lockservice.ErrCantReleaseFile, lockservice.ErrUnauthorizedAccess:
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+// extend renews the lease of the hold of the owner on the key, and
+// replies with 1, or 0 if the owner doesn't hold the lock.
+func (s *Server) extend(w writer, key, owner string, lease time.Duration) {
+	extender, ok := s.ls.(lockservice.Extender)
+	if !ok {
+		w.err("ERR " + lockservice.ErrUnsupported.Error())
+		return
+	}
+	err := extender.Extend(lockservice.NewLeasedLockDescriptor(key, owner, lease))
+	switch err {
+	case nil:
+		w.integer(1)
+	case lockservice.ErrFileUnlocked, lockservice.ErrNotHeld:
+		w.integer(0)
+	default:
+		w.err("ERR " + err.Error())
+	}
+}
+
+// owns reports whether the owner of a lock belongs to the principal of
+// the connection, which every owner does if it has none.
+func owns(c *connState, owner string) bool {
+	return routing.Scope(c.ctx, routing.Unscope(c.ctx, owner)) == owner
+}
+
+// served replies with an error if the key isn't served by the server,
+// and reports whether it is.
+func (s *Server) served(w writer, key string) bool {
+	if err := s.serves(key); err != nil {
+		w.err("ERR " + err.Error())
+		return false
+	}
+	return true
+}
+
+// parseLease parses a lease in milliseconds, replying with an error if
+// it isn't a positive integer.
+func parseLease(w writer, ms, command string) (time.Duration, bool) {
+	n, err := strconv.ParseInt(ms, 10, 64)
+	if err != nil || n <= 0 {
+		w.err("ERR invalid expire time in '" + command + "' command")
+		return 0, false
+	}
+	return time.Duration(n) * time.Millisecond, true
+}
+
+func wrongArgs(w writer, command string) {
+	w.err("ERR wrong number of arguments for '" + command + "' command")
+}
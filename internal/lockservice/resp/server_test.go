@@ -0,0 +1,283 @@
+package resp
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SystemBuilders/LocKey/internal/lockservice"
+	"github.com/SystemBuilders/LocKey/internal/lockservice/routing"
+	"github.com/rs/zerolog"
+)
+
+const (
+	unlockScript = `if redis.call("get",KEYS[1]) == ARGV[1] then
+    return redis.call("del",KEYS[1])
+else
+    return 0
+end`
+	extendScript = `if redis.call('get', KEYS[1]) == ARGV[1] then return redis.call('pexpire', KEYS[1], ARGV[2]) else return 0 end`
+)
+
+// client is a client of the Redis protocol that sends commands as
+// arrays of bulk strings and reads their replies.
+type client struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// serve serves the lockservice on a port picked by the system and returns
+// a client of it.
+func serve(t *testing.T, ls lockservice.LockService) *client {
+	return dial(t, listen(t, ls))
+}
+
+// listen serves the lockservice with the options on a port picked by the
+// system and returns its address.
+func listen(t *testing.T, ls lockservice.LockService, opts ...Option) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	s := NewServer(ls, opts...)
+	go s.Serve(l)
+	t.Cleanup(func() { s.Close() })
+	return l.Addr().String()
+}
+
+// dial returns a client of the server at the address.
+func dial(t *testing.T, addr string) *client {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &client{t: t, conn: conn, r: bufio.NewReader(conn)}
+}
+
+// do sends the command and returns its reply, with the line of a bulk
+// string following its length and the elements of an array following
+// its size, separated by spaces.
+func (c *client) do(args ...string) string {
+	c.send(args...)
+	return c.reply()
+}
+
+func (c *client) send(args ...string) {
+	var b strings.Builder
+	b.WriteString("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		b.WriteString("$" + strconv.Itoa(len(arg)) + "\r\n" + arg + "\r\n")
+	}
+	if _, err := c.conn.Write([]byte(b.String())); err != nil {
+		c.t.Fatalf("write: %v", err)
+	}
+}
+
+func (c *client) reply() string {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		c.t.Fatalf("read: %v", err)
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	switch {
+	case strings.HasPrefix(line, "$") && line != "$-1":
+		bulk, err := c.r.ReadString('\n')
+		if err != nil {
+			c.t.Fatalf("read: %v", err)
+		}
+		return line + " " + strings.TrimSuffix(bulk, "\r\n")
+	case strings.HasPrefix(line, "*"):
+		n, _ := strconv.Atoi(line[1:])
+		for i := 0; i < n; i++ {
+			line += " " + c.reply()
+		}
+	}
+	return line
+}
+
+func TestServer(t *testing.T) {
+	log := zerolog.New(os.Stdout).With().Logger().Level(zerolog.Disabled)
+
+	tests := []struct {
+		name string
+		ls   func() lockservice.LockService
+		cmds [][]string
+		want []string
+	}{
+		{
+			"redlock clients acquire, extend and release locks",
+			func() lockservice.LockService { return lockservice.NewSimpleLockService(log) },
+			[][]string{
+				{"SET", "test", "owner1", "NX", "PX", "30000"},
+				{"SET", "test", "owner2", "NX", "PX", "30000"},
+				{"GET", "test"},
+				{"EVAL", unlockScript, "1", "test", "owner2"},
+				{"SCRIPT", "LOAD", extendScript},
+				{"EVALSHA", sha(extendScript), "1", "test", "owner1", "60000"},
+				{"EVALSHA", sha(extendScript), "1", "test", "owner2", "60000"},
+				{"EVAL", unlockScript, "1", "test", "owner1"},
+				{"GET", "test"},
+				{"SET", "test", "owner2", "PX", "30000", "NX"},
+				{"GET", "test"},
+			},
+			[]string{
+				"+OK",
+				"$-1",
+				"$6 owner1",
+				":0",
+				"$40 " + sha(extendScript),
+				":1",
+				":0",
+				":1",
+				"$-1",
+				"+OK",
+				"$6 owner2",
+			},
+		},
+		{
+			"scripts are run by their digest once loaded",
+			func() lockservice.LockService { return lockservice.NewSimpleLockService(log) },
+			[][]string{
+				{"SET", "test", "owner1", "NX"},
+				{"EVALSHA", sha(unlockScript), "1", "test", "owner1"},
+				{"SCRIPT", "EXISTS", sha(unlockScript)},
+				{"EVAL", unlockScript, "1", "test", "owner1"},
+				{"SCRIPT", "EXISTS", sha(unlockScript), sha(extendScript)},
+				{"EVAL", `return redis.call("flushall")`, "0"},
+			},
+			[]string{
+				"+OK",
+				"-NOSCRIPT No matching script. Please use EVAL.",
+				"*1 :0",
+				":1",
+				"*2 :1 :0",
+				"-" + unsupportedScript,
+			},
+		},
+		{
+			"keys are deleted and expired whoever holds them",
+			func() lockservice.LockService { return lockservice.NewSimpleLockService(log) },
+			[][]string{
+				{"SET", "test1", "owner1", "NX", "EX", "30"},
+				{"SET", "test2", "owner2", "NX"},
+				{"PEXPIRE", "test1", "60000"},
+				{"PEXPIRE", "test3", "60000"},
+				{"DEL", "test1", "test2", "test3"},
+				{"GET", "test2"},
+			},
+			[]string{"+OK", "+OK", ":1", ":0", ":2", "$-1"},
+		},
+		{
+			"commands beyond locks are refused",
+			func() lockservice.LockService { return lockservice.NewSimpleLockService(log) },
+			[][]string{
+				{"SET", "test", "owner1"},
+				{"SET", "test", "owner1", "NX", "PX", "0"},
+				{"SET", "test", "owner1", "NX", "GET"},
+				{"INCR", "test"},
+				{"SELECT", "1"},
+				{"PING"},
+			},
+			[]string{
+				"-ERR only SET with NX is supported, as keys are locks",
+				"-ERR invalid expire time in 'set' command",
+				"-ERR syntax error",
+				"-ERR unknown command 'incr'",
+				"-ERR DB index is out of range",
+				"+PONG",
+			},
+		},
+		{
+			"leases aren't extended by lockservices that can't",
+			func() lockservice.LockService {
+				return struct{ lockservice.LockService }{lockservice.NewSimpleLockService(log)}
+			},
+			[][]string{
+				{"SET", "test", "owner1", "NX"},
+				{"PEXPIRE", "test", "60000"},
+				{"EVAL", unlockScript, "1", "test", "owner1"},
+			},
+			[]string{
+				"+OK",
+				"-ERR " + lockservice.ErrUnsupported.Error(),
+				":1",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := serve(t, tt.ls())
+			for i, cmd := range tt.cmds {
+				if got := c.do(cmd...); got != tt.want[i] {
+					t.Errorf("%s: got %q want %q", strings.Join(cmd, " "), got, tt.want[i])
+				}
+			}
+		})
+	}
+
+	t.Run("pipelined and inline commands are answered in order", func(t *testing.T) {
+		c := serve(t, lockservice.NewSimpleLockService(log))
+		c.send("SET", "test", "owner1", "NX")
+		c.send("GET", "test")
+		if _, err := c.conn.Write([]byte("PING hello\r\nQUIT\r\n")); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+
+		want := []string{"+OK", "$6 owner1", "$5 hello", "+OK"}
+		for _, want := range want {
+			if got := c.reply(); got != want {
+				t.Errorf("reply: got %q want %q", got, want)
+			}
+		}
+		if _, err := c.r.ReadByte(); err == nil {
+			t.Errorf("quit: connection wasn't closed")
+		}
+	})
+
+	t.Run("authenticated clients act only on the locks of their principal", func(t *testing.T) {
+		auth := routing.NewHMACAuthenticator([]byte("secret"))
+		addr := listen(t, lockservice.NewSimpleLockService(log), WithAuth(auth))
+		a, b := dial(t, addr), dial(t, addr)
+
+		steps := []struct {
+			c    *client
+			cmd  []string
+			want string
+		}{
+			{a, []string{"SET", "test", "owner1", "NX"}, "-NOAUTH Authentication required."},
+			{a, []string{"AUTH", "guess"}, "-WRONGPASS " + lockservice.ErrUnauthenticated.Error()},
+			{a, []string{"AUTH", "teamB", auth.Issue("teamA", time.Time{})}, "-WRONGPASS " + lockservice.ErrUnauthenticated.Error()},
+			{a, []string{"AUTH", "teamA", auth.Issue("teamA", time.Time{})}, "+OK"},
+			{b, []string{"AUTH", auth.Issue("teamB", time.Time{})}, "+OK"},
+			{a, []string{"SET", "test", "owner1", "NX"}, "+OK"},
+			{a, []string{"GET", "test"}, "$6 owner1"},
+			{b, []string{"GET", "test"}, "$12 teamA/owner1"},
+			{b, []string{"EVAL", unlockScript, "1", "test", "owner1"}, ":0"},
+			{b, []string{"DEL", "test"}, ":0"},
+			{a, []string{"DEL", "test"}, ":1"},
+		}
+		for _, step := range steps {
+			if got := step.c.do(step.cmd...); got != step.want {
+				t.Errorf("%s: got %q want %q", strings.Join(step.cmd, " "), got, step.want)
+			}
+		}
+	})
+
+	t.Run("scripts are recognised regardless of their formatting", func(t *testing.T) {
+		if got := scriptOf(unlockScript); got != compareAndDelete {
+			t.Errorf("scriptOf: got %v want %v", got, compareAndDelete)
+		}
+		if got := scriptOf(extendScript); got != compareAndPExpire {
+			t.Errorf("scriptOf: got %v want %v", got, compareAndPExpire)
+		}
+		if got := scriptOf(`return redis.call("del", KEYS[1])`); got != unknownScript {
+			t.Errorf("scriptOf: got %v want %v", got, unknownScript)
+		}
+	})
+}
@@ -1,6 +1,7 @@
 package routing
 
 import (
+	"context"
 	"encoding/json"
 	"io/ioutil"
 	"net/http"
@@ -9,7 +10,9 @@ import (
 )
 
 // acquire wraps the lock Acquire function and creates a clean HTTP service.
-func acquire(w http.ResponseWriter, r *http.Request, ls *lockservice.SimpleLockService) {
+// Requests that ask to wait block on a held lock until it's handed over to
+// them or the wait runs out, if the lockservice is a Waiter.
+func acquire(w http.ResponseWriter, r *http.Request, ls lockservice.LockService) {
 
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
@@ -25,21 +28,37 @@ func acquire(w http.ResponseWriter, r *http.Request, ls *lockservice.SimpleLockS
 		return
 	}
 
-	desc := &lockservice.LockDescriptor{
-		FileID: req.FileID,
-		UserID: req.UserID,
+	req.UserID = ownerOf(r, req.UserID)
+	req.Session = sessionOf(r, req.Session)
+	desc := req.Descriptor()
+	var token lockservice.FencingToken
+	if req.Wait > 0 {
+		waiter, ok := ls.(lockservice.Waiter)
+		if !ok {
+			http.Error(w, lockservice.ErrUnsupported.Error(), http.StatusNotImplemented)
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), req.Wait)
+		token, err = waiter.AcquireWait(ctx, desc)
+		cancel()
+	} else {
+		token, err = ls.Acquire(desc)
 	}
-	err = ls.Acquire(desc)
 
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	w.Write([]byte("lock acquired"))
+	byteData, err := json.Marshal(lockservice.AcquireRes{Token: token})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(byteData)
 }
 
-func checkAcquired(w http.ResponseWriter, r *http.Request, ls *lockservice.SimpleLockService) {
+func checkAcquired(w http.ResponseWriter, r *http.Request, ls lockservice.LockService) {
 
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
@@ -57,11 +76,17 @@ func checkAcquired(w http.ResponseWriter, r *http.Request, ls *lockservice.Simpl
 
 	desc := &lockservice.LockDescriptor{
 		FileID: req.FileID,
+		Fence:  req.Token,
 	}
 
 	owner, ok := ls.CheckAcquired(desc)
 	if ok {
-		byteData, err := json.Marshal(lockservice.CheckAcquireRes{Owner: owner})
+		res := lockservice.CheckAcquireRes{Owner: userOf(r, owner)}
+		if lister, ok := ls.(lockservice.Lister); ok {
+			res.Mode, res.Owners = lister.Holders(desc)
+			res.Owners = usersOf(r, res.Owners)
+		}
+		byteData, err := json.Marshal(res)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
@@ -0,0 +1,286 @@
+package routing
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SystemBuilders/LocKey/internal/lockservice"
+)
+
+// Authenticator validates the bearer tokens of requests.
+type Authenticator interface {
+	// Authenticate returns the principal that the token was issued to,
+	// or ErrUnauthenticated if the token isn't valid.
+	Authenticate(token string) (string, error)
+}
+
+var (
+	_ Authenticator = (*HMACAuthenticator)(nil)
+	_ Authenticator = (*KeyfileAuthenticator)(nil)
+)
+
+// HMACAuthenticator authenticates tokens signed with a secret key, so
+// that tokens can be issued without the node knowing of them.
+type HMACAuthenticator struct {
+	key []byte
+}
+
+// NewHMACAuthenticator returns an authenticator of the tokens signed with
+// the key.
+func NewHMACAuthenticator(key []byte) *HMACAuthenticator {
+	return &HMACAuthenticator{key: key}
+}
+
+// Issue returns a token of the principal, signed with the key of the
+// authenticator, which is valid until it expires. A zero expiry makes a
+// token that never expires.
+//
+// A token is the principal and the expiry as Unix seconds, followed by
+// the HMAC-SHA256 of both, separated by dots.
+func (a *HMACAuthenticator) Issue(principal string, expires time.Time) string {
+	var unix int64
+	if !expires.IsZero() {
+		unix = expires.Unix()
+	}
+	payload := base64.RawURLEncoding.EncodeToString([]byte(principal)) + "." + strconv.FormatInt(unix, 10)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(a.sign(payload))
+}
+
+// Authenticate returns the principal of a token issued with the key that
+// hasn't expired.
+func (a *HMACAuthenticator) Authenticate(token string) (string, error) {
+	i := strings.LastIndexByte(token, '.')
+	if i < 0 {
+		return "", lockservice.ErrUnauthenticated
+	}
+	payload := token[:i]
+	mac, err := base64.RawURLEncoding.DecodeString(token[i+1:])
+	if err != nil || !hmac.Equal(mac, a.sign(payload)) {
+		return "", lockservice.ErrUnauthenticated
+	}
+
+	parts := strings.Split(payload, ".")
+	if len(parts) != 2 {
+		return "", lockservice.ErrUnauthenticated
+	}
+	principal, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || !validPrincipal(string(principal)) {
+		return "", lockservice.ErrUnauthenticated
+	}
+	unix, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || (unix != 0 && time.Now().Unix() >= unix) {
+		return "", lockservice.ErrUnauthenticated
+	}
+	return string(principal), nil
+}
+
+func (a *HMACAuthenticator) sign(payload string) []byte {
+	h := hmac.New(sha256.New, a.key)
+	h.Write([]byte(payload))
+	return h.Sum(nil)
+}
+
+// KeyfileAuthenticator authenticates the tokens listed in a keyfile.
+type KeyfileAuthenticator struct {
+	keys []key
+}
+
+// key is a token of the keyfile and the principal it belongs to.
+type key struct {
+	principal string
+	token     []byte
+}
+
+// LoadKeyfile reads the tokens of the keyfile at the path. Every line of
+// the keyfile holds a principal and its token separated by white space,
+// while empty lines and lines starting with a # are skipped. A principal
+// may have several tokens, so that they can be rotated.
+func LoadKeyfile(path string) (*KeyfileAuthenticator, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	a := &KeyfileAuthenticator{}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, errors.New("keyfile line " + strconv.Itoa(line) + ": want a principal and a token")
+		}
+		if !validPrincipal(fields[0]) {
+			return nil, errors.New("keyfile line " + strconv.Itoa(line) + ": principal can't contain a /")
+		}
+		a.keys = append(a.keys, key{principal: fields[0], token: []byte(fields[1])})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(a.keys) == 0 {
+		return nil, errors.New("keyfile has no tokens")
+	}
+	return a, nil
+}
+
+// Authenticate returns the principal of a token of the keyfile. Every
+// token is compared in constant time, so the time taken doesn't tell
+// how much of a token was guessed.
+func (a *KeyfileAuthenticator) Authenticate(token string) (string, error) {
+	principal := ""
+	for _, k := range a.keys {
+		if subtle.ConstantTimeCompare(k.token, []byte(token)) == 1 {
+			principal = k.principal
+		}
+	}
+	if principal == "" {
+		return "", lockservice.ErrUnauthenticated
+	}
+	return principal, nil
+}
+
+// validPrincipal reports whether the principal can scope the user IDs of
+// its requests, which it can't if it contains the / that separates it
+// from the user IDs.
+func validPrincipal(principal string) bool {
+	return principal != "" && !strings.Contains(principal, "/")
+}
+
+// principalKey is the key of the principal in the context of a request.
+type principalKey struct{}
+
+// WithPrincipal returns a copy of the context that carries the principal.
+func WithPrincipal(ctx context.Context, principal string) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// PrincipalOf returns the principal that the context carries, and false
+// if it carries none.
+func PrincipalOf(ctx context.Context) (string, bool) {
+	principal, ok := ctx.Value(principalKey{}).(string)
+	return principal, ok
+}
+
+// Authenticate returns a middleware that serves only the requests that
+// carry a token that the authenticator accepts, as an "Authorization:
+// Bearer <token>" header, and binds the principal of the token to the
+// context of the request. Other requests are answered with a 401.
+func Authenticate(a Authenticator) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			const prefix = "Bearer "
+			header := r.Header.Get("Authorization")
+			principal, err := "", error(lockservice.ErrUnauthenticated)
+			if len(header) > len(prefix) && strings.EqualFold(header[:len(prefix)], prefix) {
+				principal, err = a.Authenticate(header[len(prefix):])
+			}
+			if err != nil {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				if strings.HasPrefix(r.URL.Path, "/v2/") {
+					writeLockserviceError(w, lockservice.ErrUnauthenticated)
+				} else {
+					http.Error(w, lockservice.ErrUnauthenticated.Error(), http.StatusUnauthorized)
+				}
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), principal)))
+		})
+	}
+}
+
+// Admin returns a middleware that serves the admin calls, the calls of
+// the routes under /admin/ and the posts of rings to /ring, only to the
+// given principal, since they see and change the locks and sessions of
+// every principal. The admin calls of other principals are answered
+// with a 403, and so are all of them if the principal is empty. The
+// middleware checks the principal that the Authenticate middleware bound
+// to the request, and serves every call of a request that isn't
+// authenticated.
+func Admin(principal string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			caller, ok := PrincipalOf(r.Context())
+			if ok && adminCall(r) && (principal == "" || caller != principal) {
+				http.Error(w, lockservice.ErrUnauthorizedAccess.Error(), http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// adminCall reports whether the request is an admin call.
+func adminCall(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, "/admin/") || (r.URL.Path == "/ring" && r.Method == http.MethodPost)
+}
+
+// ownerOf returns the owner that the user ID of the request acts as in
+// the lockservice. The user IDs of authenticated requests are scoped to
+// their principal, so that a principal can't act on the locks of another
+// even if it learns the user IDs of the other.
+func ownerOf(r *http.Request, userID string) string {
+	return Scope(r.Context(), userID)
+}
+
+// sessionOf returns the ID that the session of the request has in the
+// lockservice. The sessions of authenticated requests are created with
+// their principal as the prefix of their ID, so like the user IDs, a
+// principal can't renew, end or acquire in the sessions of another.
+func sessionOf(r *http.Request, id string) string {
+	return Scope(r.Context(), id)
+}
+
+// userOf returns the user ID that the owner of a lock is reported as to
+// the request, which is the owner itself unless it belongs to the
+// principal of the request.
+func userOf(r *http.Request, owner string) string {
+	return Unscope(r.Context(), owner)
+}
+
+// Scope returns the ID, of an owner or a session, scoped to the principal
+// that the context carries, which is the ID itself if the context carries
+// no principal or the ID is empty. The APIs of a node scope the IDs of the
+// calls of principals, so that the principals can't act on each other's
+// locks and sessions.
+func Scope(ctx context.Context, id string) string {
+	principal, ok := PrincipalOf(ctx)
+	if !ok || id == "" {
+		return id
+	}
+	return principal + "/" + id
+}
+
+// Unscope returns the ID that an ID of the lockservice is reported as to
+// the principal that the context carries, which is the ID itself unless
+// it's scoped to that principal.
+func Unscope(ctx context.Context, id string) string {
+	principal, ok := PrincipalOf(ctx)
+	if !ok {
+		return id
+	}
+	return strings.TrimPrefix(id, principal+"/")
+}
+
+// usersOf returns the user IDs that the owners are reported as to the
+// request.
+func usersOf(r *http.Request, owners []string) []string {
+	users := make([]string, len(owners))
+	for i, owner := range owners {
+		users[i] = userOf(r, owner)
+	}
+	return users
+}
@@ -0,0 +1,74 @@
+package routing
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/SystemBuilders/LocKey/internal/lockservice"
+)
+
+// acquireBatch wraps the lock AcquireBatch function. Either all the
+// requested locks are acquired or none of them is.
+func acquireBatch(w http.ResponseWriter, r *http.Request, ls lockservice.LockService) {
+
+	descs, err := batchDescriptors(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tokens, err := ls.AcquireBatch(descs)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	byteData, err := json.Marshal(lockservice.AcquireBatchRes{Tokens: tokens})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(byteData)
+}
+
+// releaseBatch wraps the lock ReleaseBatch function. Either all the
+// requested locks are released or none of them is.
+func releaseBatch(w http.ResponseWriter, r *http.Request, ls lockservice.LockService) {
+
+	descs, err := batchDescriptors(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = ls.ReleaseBatch(descs)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Write([]byte("locks released"))
+}
+
+// batchDescriptors reads the descriptors of a batch request.
+func batchDescriptors(r *http.Request) ([]lockservice.Descriptors, error) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var req lockservice.BatchRequest
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		return nil, err
+	}
+
+	descs := make([]lockservice.Descriptors, len(req.Requests))
+	for i := range req.Requests {
+		req.Requests[i].UserID = ownerOf(r, req.Requests[i].UserID)
+		req.Requests[i].Session = sessionOf(r, req.Requests[i].Session)
+		descs[i] = req.Requests[i].Descriptor()
+	}
+	return descs, nil
+}
@@ -0,0 +1,202 @@
+package routing
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/SystemBuilders/LocKey/internal/lockservice"
+	"github.com/gorilla/mux"
+)
+
+// errBadRequest is the code of the failures of calls of the v2 API whose
+// request can't be read.
+const errBadRequest = "bad_request"
+
+// errInternal is the code of the failures of calls of the v2 API that
+// aren't errors of the lockservice.
+const errInternal = "internal"
+
+// SetupV2Routing adds the routes of the v2 API of the locks, which treats
+// every lock as a resource at /v2/locks/{id}. A lock is acquired with a
+// PUT, whose body is a LockRequest, released with a DELETE, which takes
+// the owner and the fencing token as query parameters, and checked with a
+// GET. Failed calls are answered with the status that fits their error
+// and an ErrorRes.
+func SetupV2Routing(ls lockservice.LockService, r *mux.Router) *mux.Router {
+	r.HandleFunc("/v2/locks/{id}", makeputLockHandler(ls)).Methods(http.MethodPut)
+	r.HandleFunc("/v2/locks/{id}", makedeleteLockHandler(ls)).Methods(http.MethodDelete)
+	r.HandleFunc("/v2/locks/{id}", makegetLockHandler(ls)).Methods(http.MethodGet)
+	return r
+}
+
+func makeputLockHandler(ls lockservice.LockService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		putLock(w, r, ls)
+	}
+}
+
+func makedeleteLockHandler(ls lockservice.LockService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		deleteLock(w, r, ls)
+	}
+}
+
+func makegetLockHandler(ls lockservice.LockService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		getLock(w, r, ls)
+	}
+}
+
+// putLock acquires the lock of the path for the request in the body and
+// responds with the fencing token of the acquisition. Requests that ask
+// to wait block on a held lock until it's handed over to them or the
+// wait runs out, if the lockservice is a Waiter.
+func putLock(w http.ResponseWriter, r *http.Request, ls lockservice.LockService) {
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errBadRequest, err.Error())
+		return
+	}
+
+	var req lockservice.LockRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeError(w, http.StatusBadRequest, errBadRequest, err.Error())
+		return
+	}
+	req.FileID = mux.Vars(r)["id"]
+	req.UserID = ownerOf(r, req.UserID)
+	req.Session = sessionOf(r, req.Session)
+
+	desc := req.Descriptor()
+	var token lockservice.FencingToken
+	if req.Wait > 0 {
+		waiter, ok := ls.(lockservice.Waiter)
+		if !ok {
+			writeLockserviceError(w, lockservice.ErrUnsupported)
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), req.Wait)
+		token, err = waiter.AcquireWait(ctx, desc)
+		cancel()
+	} else {
+		token, err = ls.Acquire(desc)
+	}
+	if err != nil {
+		writeLockserviceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, lockservice.AcquireRes{Token: token})
+}
+
+// deleteLock releases the hold of the owner on the lock of the path. The
+// fencing token is optional, but if it's given it must belong to the
+// hold.
+func deleteLock(w http.ResponseWriter, r *http.Request, ls lockservice.LockService) {
+	desc := &lockservice.LockDescriptor{
+		FileID: mux.Vars(r)["id"],
+		UserID: ownerOf(r, r.URL.Query().Get("owner")),
+	}
+	token, err := tokenOf(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errBadRequest, err.Error())
+		return
+	}
+	desc.Fence = token
+
+	if err := ls.Release(desc); err != nil {
+		writeLockserviceError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getLock responds with the holders of the lock of the path, or the
+// holder of the acquisition of the fencing token if one is given.
+func getLock(w http.ResponseWriter, r *http.Request, ls lockservice.LockService) {
+	token, err := tokenOf(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errBadRequest, err.Error())
+		return
+	}
+	desc := &lockservice.LockDescriptor{
+		FileID: mux.Vars(r)["id"],
+		Fence:  token,
+	}
+
+	owner, ok := ls.CheckAcquired(desc)
+	if !ok {
+		writeLockserviceError(w, lockservice.ErrCheckAcquireFailure)
+		return
+	}
+	res := lockservice.CheckAcquireRes{Owner: userOf(r, owner)}
+	if lister, ok := ls.(lockservice.Lister); ok {
+		res.Mode, res.Owners = lister.Holders(desc)
+		res.Owners = usersOf(r, res.Owners)
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// tokenOf returns the fencing token of the query of the request, which
+// is zero if there is none.
+func tokenOf(r *http.Request) (lockservice.FencingToken, error) {
+	s := r.URL.Query().Get("token")
+	if s == "" {
+		return 0, nil
+	}
+	token, err := strconv.ParseUint(s, 10, 64)
+	return lockservice.FencingToken(token), err
+}
+
+// statusOf returns the status of the response to a call that failed with
+// the error of the lockservice.
+func statusOf(err error) int {
+	switch err {
+	case lockservice.ErrFileacquired, lockservice.ErrStaleToken, lockservice.ErrAcquireTimeout,
+		lockservice.ErrNoPermits, lockservice.ErrSemaphoreLimit, lockservice.ErrNotHeld:
+		return http.StatusConflict
+	case lockservice.ErrUnauthenticated:
+		return http.StatusUnauthorized
+	case lockservice.ErrUnauthorizedAccess:
+		return http.StatusForbidden
+	case lockservice.ErrCantReleaseFile, lockservice.ErrCheckAcquireFailure, lockservice.ErrFileUnlocked,
+		lockservice.ErrPermitsNotHeld, lockservice.ErrNotPouncing, lockservice.ErrSessionNotFound:
+		return http.StatusNotFound
+	case lockservice.ErrDuplicateDescriptor, lockservice.ErrInvalidPermits, lockservice.ErrInvalidCommand:
+		return http.StatusBadRequest
+	case lockservice.ErrUnsupported:
+		return http.StatusNotImplemented
+	}
+	return http.StatusInternalServerError
+}
+
+// writeLockserviceError responds with the error of the lockservice, along
+// with its code. Errors without a code are internal errors.
+func writeLockserviceError(w http.ResponseWriter, err error) {
+	code := lockservice.CodeOf(err)
+	if code == "" {
+		code = errInternal
+	}
+	writeError(w, statusOf(err), code, err.Error())
+}
+
+// writeError responds with the status and an ErrorRes of the code and the
+// message.
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, lockservice.ErrorRes{Code: code, Message: message})
+}
+
+// writeJSON responds with the status and the JSON encoding of the value.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	byteData, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(byteData)
+}
@@ -0,0 +1,68 @@
+package routing
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/SystemBuilders/LocKey/internal/lockservice"
+)
+
+// pounce wraps the lock Pounce function. The response carries the fencing
+// token if the lock was acquired right away and a zero token otherwise.
+func pounce(w http.ResponseWriter, r *http.Request, ls lockservice.Pouncer) {
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var req lockservice.LockRequest
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	req.UserID = ownerOf(r, req.UserID)
+	req.Session = sessionOf(r, req.Session)
+	token, err := ls.Pounce(req.Descriptor())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	byteData, err := json.Marshal(lockservice.AcquireRes{Token: token})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(byteData)
+}
+
+// unpounce wraps the lock Unpounce function.
+func unpounce(w http.ResponseWriter, r *http.Request, ls lockservice.Pouncer) {
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var req lockservice.LockRequest
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	req.UserID = ownerOf(r, req.UserID)
+	err = ls.Unpounce(req.Descriptor())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Write([]byte("pounce withdrawn"))
+}
@@ -8,7 +8,7 @@ import (
 	"github.com/SystemBuilders/LocKey/internal/lockservice"
 )
 
-func release(w http.ResponseWriter, r *http.Request, ls *lockservice.SimpleLockService) {
+func release(w http.ResponseWriter, r *http.Request, ls lockservice.LockService) {
 
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
@@ -26,7 +26,8 @@ func release(w http.ResponseWriter, r *http.Request, ls *lockservice.SimpleLockS
 
 	desc := &lockservice.LockDescriptor{
 		FileID: req.FileID,
-		UserID: req.UserID,
+		UserID: ownerOf(r, req.UserID),
+		Fence:  req.Token,
 	}
 	err = ls.Release(desc)
 
@@ -43,7 +44,7 @@ func release(w http.ResponseWriter, r *http.Request, ls *lockservice.SimpleLockS
 	w.Write([]byte("lock released"))
 }
 
-func checkReleased(w http.ResponseWriter, r *http.Request, ls *lockservice.SimpleLockService) {
+func checkReleased(w http.ResponseWriter, r *http.Request, ls lockservice.LockService) {
 
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
@@ -61,7 +62,7 @@ func checkReleased(w http.ResponseWriter, r *http.Request, ls *lockservice.Simpl
 
 	desc := &lockservice.LockDescriptor{
 		FileID: req.FileID,
-		UserID: req.UserID,
+		UserID: ownerOf(r, req.UserID),
 	}
 
 	if ls.CheckReleased(desc) {
@@ -7,35 +7,133 @@ import (
 	"github.com/gorilla/mux"
 )
 
-// SetupRouting adds all the routes on the http server.
-func SetupRouting(ls *lockservice.SimpleLockService, r *mux.Router) *mux.Router {
+// SetupRouting adds all the routes on the http server. The routes of the
+// locks are served for any LockService, while the routes of the other
+// features are only added if the lockservice implements the extension
+// interface of the feature.
+func SetupRouting(ls lockservice.LockService, r *mux.Router) *mux.Router {
 	r.HandleFunc("/acquire", makeacquireHandler(ls)).Methods(http.MethodPost)
 	r.HandleFunc("/checkAcquire", makecheckAcquiredHandler(ls)).Methods(http.MethodPost)
 	r.HandleFunc("/release", makereleaseHandler(ls)).Methods(http.MethodPost)
 	r.HandleFunc("/checkRelease", makecheckReleaseHandler(ls)).Methods(http.MethodPost)
+	r.HandleFunc("/acquireBatch", makeacquireBatchHandler(ls)).Methods(http.MethodPost)
+	r.HandleFunc("/releaseBatch", makereleaseBatchHandler(ls)).Methods(http.MethodPost)
+	SetupV2Routing(ls, r)
+	if sk, ok := ls.(lockservice.SemaphoreKeeper); ok {
+		r.HandleFunc("/acquireSemaphore", makeacquireSemaphoreHandler(sk)).Methods(http.MethodPost)
+		r.HandleFunc("/releaseSemaphore", makereleaseSemaphoreHandler(sk)).Methods(http.MethodPost)
+		r.HandleFunc("/checkSemaphore", makecheckSemaphoreHandler(sk)).Methods(http.MethodPost)
+	}
+	if w, ok := ls.(lockservice.Watcher); ok {
+		r.HandleFunc("/watch", makewatchHandler(w)).Methods(http.MethodGet)
+	}
+	if p, ok := ls.(lockservice.Pouncer); ok {
+		r.HandleFunc("/pounce", makepounceHandler(p)).Methods(http.MethodPost)
+		r.HandleFunc("/unpounce", makeunpounceHandler(p)).Methods(http.MethodPost)
+	}
+	if s, ok := ls.(lockservice.Snapshotter); ok {
+		r.HandleFunc("/admin/snapshot", makesnapshotHandler(s)).Methods(http.MethodGet)
+	}
+	if sk, ok := ls.(lockservice.SessionKeeper); ok {
+		r.HandleFunc("/createSession", makecreateSessionHandler(sk)).Methods(http.MethodPost)
+		r.HandleFunc("/heartbeat", makeheartbeatHandler(sk)).Methods(http.MethodPost)
+		r.HandleFunc("/endSession", makeendSessionHandler(sk)).Methods(http.MethodPost)
+	}
 	return r
 }
 
-func makeacquireHandler(ls *lockservice.SimpleLockService) http.HandlerFunc {
+func makeacquireHandler(ls lockservice.LockService) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		acquire(w, r, ls)
 	}
 }
 
-func makecheckAcquiredHandler(ls *lockservice.SimpleLockService) http.HandlerFunc {
+func makecheckAcquiredHandler(ls lockservice.LockService) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		checkAcquired(w, r, ls)
 	}
 }
 
-func makereleaseHandler(ls *lockservice.SimpleLockService) http.HandlerFunc {
+func makereleaseHandler(ls lockservice.LockService) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		release(w, r, ls)
 	}
 }
 
-func makecheckReleaseHandler(ls *lockservice.SimpleLockService) http.HandlerFunc {
+func makecheckReleaseHandler(ls lockservice.LockService) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		checkReleased(w, r, ls)
 	}
 }
+
+func makeacquireBatchHandler(ls lockservice.LockService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		acquireBatch(w, r, ls)
+	}
+}
+
+func makereleaseBatchHandler(ls lockservice.LockService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		releaseBatch(w, r, ls)
+	}
+}
+
+func makeacquireSemaphoreHandler(ls lockservice.SemaphoreKeeper) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		acquireSemaphore(w, r, ls)
+	}
+}
+
+func makereleaseSemaphoreHandler(ls lockservice.SemaphoreKeeper) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		releaseSemaphore(w, r, ls)
+	}
+}
+
+func makecheckSemaphoreHandler(ls lockservice.SemaphoreKeeper) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		checkSemaphore(w, r, ls)
+	}
+}
+
+func makewatchHandler(ls lockservice.Watcher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		watch(w, r, ls)
+	}
+}
+
+func makepounceHandler(ls lockservice.Pouncer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		pounce(w, r, ls)
+	}
+}
+
+func makeunpounceHandler(ls lockservice.Pouncer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		unpounce(w, r, ls)
+	}
+}
+
+func makesnapshotHandler(ls lockservice.Snapshotter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		snapshot(w, r, ls)
+	}
+}
+
+func makecreateSessionHandler(ls lockservice.SessionKeeper) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		createSession(w, r, ls)
+	}
+}
+
+func makeheartbeatHandler(ls lockservice.SessionKeeper) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		heartbeat(w, r, ls)
+	}
+}
+
+func makeendSessionHandler(ls lockservice.SessionKeeper) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		endSession(w, r, ls)
+	}
+}
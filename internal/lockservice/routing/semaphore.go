@@ -0,0 +1,83 @@
+package routing
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/SystemBuilders/LocKey/internal/lockservice"
+)
+
+// acquireSemaphore wraps the AcquirePermits function of the lockservice.
+func acquireSemaphore(w http.ResponseWriter, r *http.Request, ls lockservice.SemaphoreKeeper) {
+
+	req, err := semaphoreRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	desc := lockservice.NewLeasedLockDescriptor(req.FileID, ownerOf(r, req.UserID), req.Lease)
+	desc.SessionID = sessionOf(r, req.Session)
+	err = ls.AcquirePermits(desc, req.Permits, req.Limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Write([]byte("permits acquired"))
+}
+
+// releaseSemaphore wraps the ReleasePermits function of the lockservice.
+func releaseSemaphore(w http.ResponseWriter, r *http.Request, ls lockservice.SemaphoreKeeper) {
+
+	req, err := semaphoreRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	desc := lockservice.NewLockDescriptor(req.FileID, ownerOf(r, req.UserID))
+	err = ls.ReleasePermits(desc, req.Permits)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Write([]byte("permits released"))
+}
+
+// checkSemaphore wraps the CheckSemaphore function of the lockservice
+// and responds with the holders and the available permits.
+func checkSemaphore(w http.ResponseWriter, r *http.Request, ls lockservice.SemaphoreKeeper) {
+
+	req, err := semaphoreRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	status := ls.CheckSemaphore(lockservice.NewLockDescriptor(req.FileID, ""))
+	holders := make(map[string]int, len(status.Holders))
+	for owner, permits := range status.Holders {
+		holders[userOf(r, owner)] = permits
+	}
+	status.Holders = holders
+	byteData, err := json.Marshal(status)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(byteData)
+}
+
+// semaphoreRequest reads the semaphore request in the body.
+func semaphoreRequest(r *http.Request) (lockservice.SemaphoreRequest, error) {
+	var req lockservice.SemaphoreRequest
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return req, err
+	}
+	err = json.Unmarshal(body, &req)
+	return req, err
+}
@@ -0,0 +1,97 @@
+package routing
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/SystemBuilders/LocKey/internal/lockservice"
+)
+
+// createSession wraps the CreateSession function of the lockservice and
+// responds with the session. The session of an authenticated request is
+// prefixed with its principal, which needs a PrefixedSessionKeeper.
+func createSession(w http.ResponseWriter, r *http.Request, ls lockservice.SessionKeeper) {
+
+	req, err := sessionRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var session lockservice.Session
+	if principal, ok := PrincipalOf(r.Context()); ok {
+		pk, ok := ls.(lockservice.PrefixedSessionKeeper)
+		if !ok {
+			http.Error(w, lockservice.ErrUnsupported.Error(), http.StatusNotImplemented)
+			return
+		}
+		session, err = pk.CreatePrefixedSession(principal+"/", req.TTL)
+	} else {
+		session, err = ls.CreateSession(req.TTL)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeSession(w, r, session)
+}
+
+// heartbeat wraps the Heartbeat function of the lockservice and responds
+// with the renewed session. Failures are answered with an ErrorRes, like
+// the calls of the v2 API, so that clients can tell a lapsed session by
+// its code.
+func heartbeat(w http.ResponseWriter, r *http.Request, ls lockservice.SessionKeeper) {
+
+	req, err := sessionRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	session, err := ls.Heartbeat(sessionOf(r, req.ID))
+	if err != nil {
+		writeLockserviceError(w, err)
+		return
+	}
+	writeSession(w, r, session)
+}
+
+// endSession wraps the EndSession function of the lockservice.
+func endSession(w http.ResponseWriter, r *http.Request, ls lockservice.SessionKeeper) {
+
+	req, err := sessionRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := ls.EndSession(sessionOf(r, req.ID)); err != nil {
+		writeLockserviceError(w, err)
+		return
+	}
+	w.Write([]byte("session ended"))
+}
+
+// sessionRequest decodes the session request in the body of the request.
+func sessionRequest(r *http.Request) (lockservice.SessionRequest, error) {
+	var req lockservice.SessionRequest
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return req, err
+	}
+	err = json.Unmarshal(body, &req)
+	return req, err
+}
+
+// writeSession writes the JSON encoding of the session as the response,
+// with the ID that the session is known by to the request.
+func writeSession(w http.ResponseWriter, r *http.Request, session lockservice.Session) {
+	session.ID = Unscope(r.Context(), session.ID)
+	byteData, err := json.Marshal(session)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(byteData)
+}
@@ -0,0 +1,20 @@
+package routing
+
+import (
+	"net/http"
+
+	"github.com/SystemBuilders/LocKey/internal/lockservice"
+)
+
+// snapshot streams a snapshot of the lock table of the lockservice,
+// which can be restored on a replacement node to seed it.
+func snapshot(w http.ResponseWriter, r *http.Request, ls lockservice.Snapshotter) {
+
+	snap := ls.Snapshot()
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", `attachment; filename="lockey.snap"`)
+	// The response can't be turned into an error once the
+	// snapshot has started streaming, but a broken stream
+	// fails the checksum of the snapshot.
+	lockservice.EncodeSnapshot(w, snap)
+}
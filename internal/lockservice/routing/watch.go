@@ -0,0 +1,55 @@
+package routing
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/SystemBuilders/LocKey/internal/lockservice"
+)
+
+// watch streams the events of the lock named by the "fileID" query
+// parameter as server-sent events, until the client goes away. Every
+// event is sent as a single "data" line holding its JSON encoding.
+func watch(w http.ResponseWriter, r *http.Request, ls lockservice.Watcher) {
+
+	fileID := r.URL.Query().Get("fileID")
+	if fileID == "" {
+		http.Error(w, "fileID is required", http.StatusBadRequest)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	events, cancel := ls.Watch(lockservice.NewObjectDescriptor(fileID))
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case ev, ok := <-events:
+			if !ok {
+				return
+			}
+			ev.Owner = userOf(r, ev.Owner)
+			byteData, err := json.Marshal(ev)
+			if err != nil {
+				return
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, byteData); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package rpc
+
+import (
+	"context"
+	"strings"
+
+	"github.com/SystemBuilders/LocKey/internal/lockservice"
+	"github.com/SystemBuilders/LocKey/internal/lockservice/routing"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// Authenticate returns the options of a gRPC server that make it serve
+// only the calls that carry a token that the authenticator accepts, as
+// "authorization: Bearer <token>" metadata, like routing.Authenticate
+// does for HTTP. The principal of the token is bound to the context of
+// the call, by which the Server scopes the owners and the sessions of the
+// call. Other calls fail with an Unauthenticated status.
+func Authenticate(a routing.Authenticator) []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			ctx, err := authenticate(ctx, a)
+			if err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			ctx, err := authenticate(ss.Context(), a)
+			if err != nil {
+				return err
+			}
+			return handler(srv, authenticatedStream{ServerStream: ss, ctx: ctx})
+		}),
+	}
+}
+
+// authenticate returns a copy of the context of a call that carries the
+// principal of the token of the call, or the status of ErrUnauthenticated
+// if the call carries no token that the authenticator accepts.
+func authenticate(ctx context.Context, a routing.Authenticator) (context.Context, error) {
+	const prefix = "Bearer "
+	principal, err := "", error(lockservice.ErrUnauthenticated)
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("authorization"); len(values) > 0 {
+			header := values[0]
+			if len(header) > len(prefix) && strings.EqualFold(header[:len(prefix)], prefix) {
+				principal, err = a.Authenticate(header[len(prefix):])
+			}
+		}
+	}
+	if err != nil {
+		return nil, statusOf(lockservice.ErrUnauthenticated)
+	}
+	return routing.WithPrincipal(ctx, principal), nil
+}
+
+// authenticatedStream is a stream whose context carries the principal of
+// the call.
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s authenticatedStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,7 @@
+// Package rpc implements the gRPC API of the lockservice, which a node
+// serves alongside its HTTP API. The service is defined in
+// lockservice.proto, from which the messages and the stubs are
+// generated.
+package rpc
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative lockservice.proto
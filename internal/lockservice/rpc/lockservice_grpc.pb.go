@@ -0,0 +1,407 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.2.0
+// - protoc             (unknown)
+// source: lockservice.proto
+
+package rpc
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// LockServiceClient is the client API for LockService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type LockServiceClient interface {
+	// Acquire sets a lock on the file. Requests that ask to wait block
+	// on a held lock until it's handed over to them or the wait runs out.
+	Acquire(ctx context.Context, in *LockRequest, opts ...grpc.CallOption) (*AcquireResponse, error)
+	// Release releases the lock on the file.
+	Release(ctx context.Context, in *LockRequest, opts ...grpc.CallOption) (*ReleaseResponse, error)
+	// CheckAcquired checks whether the lock on the file is held.
+	CheckAcquired(ctx context.Context, in *CheckRequest, opts ...grpc.CallOption) (*CheckAcquiredResponse, error)
+	// CheckReleased checks whether the lock on the file is free.
+	CheckReleased(ctx context.Context, in *CheckRequest, opts ...grpc.CallOption) (*CheckReleasedResponse, error)
+	// CreateSession creates a session that lives for its TTL unless it's
+	// renewed with a Heartbeat.
+	CreateSession(ctx context.Context, in *CreateSessionRequest, opts ...grpc.CallOption) (*Session, error)
+	// Heartbeat renews the session for another TTL.
+	Heartbeat(ctx context.Context, in *SessionRequest, opts ...grpc.CallOption) (*Session, error)
+	// EndSession ends the session and releases the locks held in it.
+	EndSession(ctx context.Context, in *SessionRequest, opts ...grpc.CallOption) (*EndSessionResponse, error)
+	// Watch streams the changes in the state of the lock on the file,
+	// until the call is cancelled.
+	Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (LockService_WatchClient, error)
+}
+
+type lockServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewLockServiceClient(cc grpc.ClientConnInterface) LockServiceClient {
+	return &lockServiceClient{cc}
+}
+
+func (c *lockServiceClient) Acquire(ctx context.Context, in *LockRequest, opts ...grpc.CallOption) (*AcquireResponse, error) {
+	out := new(AcquireResponse)
+	err := c.cc.Invoke(ctx, "/lockey.v1.LockService/Acquire", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *lockServiceClient) Release(ctx context.Context, in *LockRequest, opts ...grpc.CallOption) (*ReleaseResponse, error) {
+	out := new(ReleaseResponse)
+	err := c.cc.Invoke(ctx, "/lockey.v1.LockService/Release", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *lockServiceClient) CheckAcquired(ctx context.Context, in *CheckRequest, opts ...grpc.CallOption) (*CheckAcquiredResponse, error) {
+	out := new(CheckAcquiredResponse)
+	err := c.cc.Invoke(ctx, "/lockey.v1.LockService/CheckAcquired", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *lockServiceClient) CheckReleased(ctx context.Context, in *CheckRequest, opts ...grpc.CallOption) (*CheckReleasedResponse, error) {
+	out := new(CheckReleasedResponse)
+	err := c.cc.Invoke(ctx, "/lockey.v1.LockService/CheckReleased", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *lockServiceClient) CreateSession(ctx context.Context, in *CreateSessionRequest, opts ...grpc.CallOption) (*Session, error) {
+	out := new(Session)
+	err := c.cc.Invoke(ctx, "/lockey.v1.LockService/CreateSession", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *lockServiceClient) Heartbeat(ctx context.Context, in *SessionRequest, opts ...grpc.CallOption) (*Session, error) {
+	out := new(Session)
+	err := c.cc.Invoke(ctx, "/lockey.v1.LockService/Heartbeat", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *lockServiceClient) EndSession(ctx context.Context, in *SessionRequest, opts ...grpc.CallOption) (*EndSessionResponse, error) {
+	out := new(EndSessionResponse)
+	err := c.cc.Invoke(ctx, "/lockey.v1.LockService/EndSession", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *lockServiceClient) Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (LockService_WatchClient, error) {
+	stream, err := c.cc.NewStream(ctx, &LockService_ServiceDesc.Streams[0], "/lockey.v1.LockService/Watch", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &lockServiceWatchClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type LockService_WatchClient interface {
+	Recv() (*Event, error)
+	grpc.ClientStream
+}
+
+type lockServiceWatchClient struct {
+	grpc.ClientStream
+}
+
+func (x *lockServiceWatchClient) Recv() (*Event, error) {
+	m := new(Event)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// LockServiceServer is the server API for LockService service.
+// All implementations must embed UnimplementedLockServiceServer
+// for forward compatibility
+type LockServiceServer interface {
+	// Acquire sets a lock on the file. Requests that ask to wait block
+	// on a held lock until it's handed over to them or the wait runs out.
+	Acquire(context.Context, *LockRequest) (*AcquireResponse, error)
+	// Release releases the lock on the file.
+	Release(context.Context, *LockRequest) (*ReleaseResponse, error)
+	// CheckAcquired checks whether the lock on the file is held.
+	CheckAcquired(context.Context, *CheckRequest) (*CheckAcquiredResponse, error)
+	// CheckReleased checks whether the lock on the file is free.
+	CheckReleased(context.Context, *CheckRequest) (*CheckReleasedResponse, error)
+	// CreateSession creates a session that lives for its TTL unless it's
+	// renewed with a Heartbeat.
+	CreateSession(context.Context, *CreateSessionRequest) (*Session, error)
+	// Heartbeat renews the session for another TTL.
+	Heartbeat(context.Context, *SessionRequest) (*Session, error)
+	// EndSession ends the session and releases the locks held in it.
+	EndSession(context.Context, *SessionRequest) (*EndSessionResponse, error)
+	// Watch streams the changes in the state of the lock on the file,
+	// until the call is cancelled.
+	Watch(*WatchRequest, LockService_WatchServer) error
+	mustEmbedUnimplementedLockServiceServer()
+}
+
+// UnimplementedLockServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedLockServiceServer struct {
+}
+
+func (UnimplementedLockServiceServer) Acquire(context.Context, *LockRequest) (*AcquireResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Acquire not implemented")
+}
+func (UnimplementedLockServiceServer) Release(context.Context, *LockRequest) (*ReleaseResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Release not implemented")
+}
+func (UnimplementedLockServiceServer) CheckAcquired(context.Context, *CheckRequest) (*CheckAcquiredResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CheckAcquired not implemented")
+}
+func (UnimplementedLockServiceServer) CheckReleased(context.Context, *CheckRequest) (*CheckReleasedResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CheckReleased not implemented")
+}
+func (UnimplementedLockServiceServer) CreateSession(context.Context, *CreateSessionRequest) (*Session, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateSession not implemented")
+}
+func (UnimplementedLockServiceServer) Heartbeat(context.Context, *SessionRequest) (*Session, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Heartbeat not implemented")
+}
+func (UnimplementedLockServiceServer) EndSession(context.Context, *SessionRequest) (*EndSessionResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method EndSession not implemented")
+}
+func (UnimplementedLockServiceServer) Watch(*WatchRequest, LockService_WatchServer) error {
+	return status.Errorf(codes.Unimplemented, "method Watch not implemented")
+}
+func (UnimplementedLockServiceServer) mustEmbedUnimplementedLockServiceServer() {}
+
+// UnsafeLockServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to LockServiceServer will
+// result in compilation errors.
+type UnsafeLockServiceServer interface {
+	mustEmbedUnimplementedLockServiceServer()
+}
+
+func RegisterLockServiceServer(s grpc.ServiceRegistrar, srv LockServiceServer) {
+	s.RegisterService(&LockService_ServiceDesc, srv)
+}
+
+func _LockService_Acquire_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LockRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LockServiceServer).Acquire(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/lockey.v1.LockService/Acquire",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LockServiceServer).Acquire(ctx, req.(*LockRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LockService_Release_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LockRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LockServiceServer).Release(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/lockey.v1.LockService/Release",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LockServiceServer).Release(ctx, req.(*LockRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LockService_CheckAcquired_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CheckRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LockServiceServer).CheckAcquired(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/lockey.v1.LockService/CheckAcquired",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LockServiceServer).CheckAcquired(ctx, req.(*CheckRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LockService_CheckReleased_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CheckRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LockServiceServer).CheckReleased(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/lockey.v1.LockService/CheckReleased",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LockServiceServer).CheckReleased(ctx, req.(*CheckRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LockService_CreateSession_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateSessionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LockServiceServer).CreateSession(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/lockey.v1.LockService/CreateSession",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LockServiceServer).CreateSession(ctx, req.(*CreateSessionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LockService_Heartbeat_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SessionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LockServiceServer).Heartbeat(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/lockey.v1.LockService/Heartbeat",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LockServiceServer).Heartbeat(ctx, req.(*SessionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LockService_EndSession_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SessionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LockServiceServer).EndSession(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/lockey.v1.LockService/EndSession",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LockServiceServer).EndSession(ctx, req.(*SessionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LockService_Watch_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(LockServiceServer).Watch(m, &lockServiceWatchServer{stream})
+}
+
+type LockService_WatchServer interface {
+	Send(*Event) error
+	grpc.ServerStream
+}
+
+type lockServiceWatchServer struct {
+	grpc.ServerStream
+}
+
+func (x *lockServiceWatchServer) Send(m *Event) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// LockService_ServiceDesc is the grpc.ServiceDesc for LockService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var LockService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "lockey.v1.LockService",
+	HandlerType: (*LockServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Acquire",
+			Handler:    _LockService_Acquire_Handler,
+		},
+		{
+			MethodName: "Release",
+			Handler:    _LockService_Release_Handler,
+		},
+		{
+			MethodName: "CheckAcquired",
+			Handler:    _LockService_CheckAcquired_Handler,
+		},
+		{
+			MethodName: "CheckReleased",
+			Handler:    _LockService_CheckReleased_Handler,
+		},
+		{
+			MethodName: "CreateSession",
+			Handler:    _LockService_CreateSession_Handler,
+		},
+		{
+			MethodName: "Heartbeat",
+			Handler:    _LockService_Heartbeat_Handler,
+		},
+		{
+			MethodName: "EndSession",
+			Handler:    _LockService_EndSession_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Watch",
+			Handler:       _LockService_Watch_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "lockservice.proto",
+}
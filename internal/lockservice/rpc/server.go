@@ -0,0 +1,263 @@
+package rpc
+
+import (
+	"context"
+
+	"github.com/SystemBuilders/LocKey/internal/lockservice"
+	"github.com/SystemBuilders/LocKey/internal/lockservice/routing"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var _ LockServiceServer = (*Server)(nil)
+
+// Server implements LockServiceServer, serving a lockservice over gRPC.
+// Like the HTTP API, any LockService can be served. The calls of the
+// features beyond the locks fail with an Unimplemented status if the
+// lockservice doesn't implement their extension interface.
+type Server struct {
+	UnimplementedLockServiceServer
+
+	ls lockservice.LockService
+	// serves returns an error if the descriptor ID isn't served by
+	// the node, in which case the call isn't made.
+	serves func(id string) error
+}
+
+// Option configures a Server.
+type Option func(*Server)
+
+// WithServes makes the server take only the calls on the descriptor IDs
+// that serves returns no error for, such as the IDs of the partitions of
+// a node.
+func WithServes(serves func(id string) error) Option {
+	return func(s *Server) {
+		s.serves = serves
+	}
+}
+
+// NewServer returns a server of the lockservice.
+func NewServer(ls lockservice.LockService, opts ...Option) *Server {
+	s := &Server{
+		ls:     ls,
+		serves: func(string) error { return nil },
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Acquire acquires the lock of the request. Requests that ask to wait
+// block on a held lock until it's handed over to them, the wait runs out
+// or the call is cancelled, if the lockservice is a Waiter.
+func (s *Server) Acquire(ctx context.Context, req *LockRequest) (*AcquireResponse, error) {
+	if err := s.serves(req.FileId); err != nil {
+		return nil, statusOf(err)
+	}
+
+	desc := lockRequestOf(ctx, req).Descriptor()
+	var token lockservice.FencingToken
+	var err error
+	if wait := req.Wait.AsDuration(); wait > 0 {
+		waiter, ok := s.ls.(lockservice.Waiter)
+		if !ok {
+			return nil, statusOf(lockservice.ErrUnsupported)
+		}
+		ctx, cancel := context.WithTimeout(ctx, wait)
+		token, err = waiter.AcquireWait(ctx, desc)
+		cancel()
+	} else {
+		token, err = s.ls.Acquire(desc)
+	}
+	if err != nil {
+		return nil, statusOf(err)
+	}
+	return &AcquireResponse{Token: uint64(token)}, nil
+}
+
+// Release releases the lock of the request.
+func (s *Server) Release(ctx context.Context, req *LockRequest) (*ReleaseResponse, error) {
+	if err := s.serves(req.FileId); err != nil {
+		return nil, statusOf(err)
+	}
+	if err := s.ls.Release(lockRequestOf(ctx, req).Descriptor()); err != nil {
+		return nil, statusOf(err)
+	}
+	return &ReleaseResponse{}, nil
+}
+
+// CheckAcquired checks whether the lock on the file is held, and by whom.
+// The holders of a shared lock are listed if the lockservice is a Lister.
+func (s *Server) CheckAcquired(ctx context.Context, req *CheckRequest) (*CheckAcquiredResponse, error) {
+	if err := s.serves(req.FileId); err != nil {
+		return nil, statusOf(err)
+	}
+	desc := checkRequestOf(req).Descriptor()
+	owner, ok := s.ls.CheckAcquired(desc)
+	if !ok {
+		return &CheckAcquiredResponse{}, nil
+	}
+	res := &CheckAcquiredResponse{Acquired: true, Owner: routing.Unscope(ctx, owner)}
+	if lister, ok := s.ls.(lockservice.Lister); ok {
+		mode, owners := lister.Holders(desc)
+		res.Mode = string(mode)
+		for _, owner := range owners {
+			res.Owners = append(res.Owners, routing.Unscope(ctx, owner))
+		}
+	}
+	return res, nil
+}
+
+// CheckReleased checks whether the lock on the file is free.
+func (s *Server) CheckReleased(ctx context.Context, req *CheckRequest) (*CheckReleasedResponse, error) {
+	if err := s.serves(req.FileId); err != nil {
+		return nil, statusOf(err)
+	}
+	released := s.ls.CheckReleased(checkRequestOf(req).Descriptor())
+	return &CheckReleasedResponse{Released: released}, nil
+}
+
+// CreateSession creates a session, if the lockservice is a SessionKeeper.
+// The session of an authenticated call is prefixed with its principal,
+// like the HTTP API does, which needs a PrefixedSessionKeeper.
+func (s *Server) CreateSession(ctx context.Context, req *CreateSessionRequest) (*Session, error) {
+	sk, ok := s.ls.(lockservice.SessionKeeper)
+	if !ok {
+		return nil, statusOf(lockservice.ErrUnsupported)
+	}
+	var session lockservice.Session
+	var err error
+	if principal, ok := routing.PrincipalOf(ctx); ok {
+		pk, ok := sk.(lockservice.PrefixedSessionKeeper)
+		if !ok {
+			return nil, statusOf(lockservice.ErrUnsupported)
+		}
+		session, err = pk.CreatePrefixedSession(principal+"/", req.Ttl.AsDuration())
+	} else {
+		session, err = sk.CreateSession(req.Ttl.AsDuration())
+	}
+	if err != nil {
+		return nil, statusOf(err)
+	}
+	return sessionOf(ctx, session), nil
+}
+
+// Heartbeat renews the session, if the lockservice is a SessionKeeper.
+func (s *Server) Heartbeat(ctx context.Context, req *SessionRequest) (*Session, error) {
+	sk, ok := s.ls.(lockservice.SessionKeeper)
+	if !ok {
+		return nil, statusOf(lockservice.ErrUnsupported)
+	}
+	session, err := sk.Heartbeat(routing.Scope(ctx, req.Id))
+	if err != nil {
+		return nil, statusOf(err)
+	}
+	return sessionOf(ctx, session), nil
+}
+
+// EndSession ends the session, if the lockservice is a SessionKeeper.
+func (s *Server) EndSession(ctx context.Context, req *SessionRequest) (*EndSessionResponse, error) {
+	sk, ok := s.ls.(lockservice.SessionKeeper)
+	if !ok {
+		return nil, statusOf(lockservice.ErrUnsupported)
+	}
+	if err := sk.EndSession(routing.Scope(ctx, req.Id)); err != nil {
+		return nil, statusOf(err)
+	}
+	return &EndSessionResponse{}, nil
+}
+
+// Watch streams the events of the lock on the file until the call is
+// cancelled, if the lockservice is a Watcher. The stream also ends if
+// the watcher falls behind and is dropped by the lockservice.
+func (s *Server) Watch(req *WatchRequest, stream LockService_WatchServer) error {
+	w, ok := s.ls.(lockservice.Watcher)
+	if !ok {
+		return statusOf(lockservice.ErrUnsupported)
+	}
+	if err := s.serves(req.FileId); err != nil {
+		return statusOf(err)
+	}
+
+	events, cancel := w.Watch(lockservice.NewObjectDescriptor(req.FileId))
+	defer cancel()
+	// The header tells the client that the watch is set up, so that
+	// no event after the call returns is missed.
+	if err := stream.SendHeader(nil); err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case ev, ok := <-events:
+			if !ok {
+				return nil
+			}
+			ev.Owner = routing.Unscope(stream.Context(), ev.Owner)
+			if err := stream.Send(eventOf(ev)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// lockRequestOf returns the lock request of the lockservice that the
+// request describes, whose owner and session are scoped to the principal
+// of the call.
+func lockRequestOf(ctx context.Context, req *LockRequest) lockservice.LockRequest {
+	return lockservice.LockRequest{
+		FileID:    req.FileId,
+		UserID:    routing.Scope(ctx, req.UserId),
+		Lease:     req.Lease.AsDuration(),
+		Token:     lockservice.FencingToken(req.Token),
+		Mode:      lockservice.LockMode(req.Mode),
+		Reentrant: req.Reentrant,
+		Session:   routing.Scope(ctx, req.Session),
+	}
+}
+
+// checkRequestOf returns the lock request of the lockservice that the
+// check describes.
+func checkRequestOf(req *CheckRequest) lockservice.LockRequest {
+	return lockservice.LockRequest{
+		FileID: req.FileId,
+		Token:  lockservice.FencingToken(req.Token),
+	}
+}
+
+func sessionOf(ctx context.Context, s lockservice.Session) *Session {
+	return &Session{
+		Id:     routing.Unscope(ctx, s.ID),
+		Ttl:    durationpb.New(s.TTL),
+		Expiry: timestamppb.New(s.Expiry),
+	}
+}
+
+func eventOf(ev lockservice.Event) *Event {
+	return &Event{
+		Type:    string(ev.Type),
+		Id:      ev.ID,
+		Owner:   ev.Owner,
+		Mode:    string(ev.Mode),
+		Token:   uint64(ev.Token),
+		Free:    ev.Free,
+		Pounced: ev.Pounced,
+		Time:    timestamppb.New(ev.Time),
+	}
+}
+
+// EventOf returns the event of the lockservice that the event describes.
+func EventOf(ev *Event) lockservice.Event {
+	return lockservice.Event{
+		Type:    lockservice.EventType(ev.Type),
+		ID:      ev.Id,
+		Owner:   ev.Owner,
+		Mode:    lockservice.LockMode(ev.Mode),
+		Token:   lockservice.FencingToken(ev.Token),
+		Free:    ev.Free,
+		Pounced: ev.Pounced,
+		Time:    ev.Time.AsTime(),
+	}
+}
@@ -0,0 +1,136 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/SystemBuilders/LocKey/internal/lockservice"
+	"github.com/SystemBuilders/LocKey/internal/lockservice/routing"
+	"github.com/rs/zerolog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// serve serves the lockservice over gRPC on a port picked by the system,
+// with the options of the server, and returns a client of it.
+func serve(t *testing.T, ls lockservice.LockService, opts ...grpc.ServerOption) LockServiceClient {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	s := grpc.NewServer(opts...)
+	RegisterLockServiceServer(s, NewServer(ls))
+	go s.Serve(l)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.Dial(l.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return NewLockServiceClient(conn)
+}
+
+func TestServer(t *testing.T) {
+	log := zerolog.New(os.Stdout).With().Logger().Level(zerolog.Disabled)
+	ctx := context.Background()
+
+	t.Run("errors of the lockservice are returned as they are", func(t *testing.T) {
+		c := serve(t, lockservice.NewSimpleLockService(log))
+		if _, err := c.Acquire(ctx, &LockRequest{FileId: "test", UserId: "owner1"}); err != nil {
+			t.Fatalf("acquire: %v", err)
+		}
+
+		_, err := c.Acquire(ctx, &LockRequest{FileId: "test", UserId: "owner2"})
+		if code := status.Code(err); code != codes.FailedPrecondition {
+			t.Errorf("acquire: got %s want %s", code, codes.FailedPrecondition)
+		}
+		got := Error(ctx, err)
+		want := lockservice.ErrFileacquired
+		if got != want {
+			t.Errorf("acquire: got %v want %q", got, want)
+		}
+
+		_, err = c.Release(ctx, &LockRequest{FileId: "test", UserId: "owner2"})
+		if code := status.Code(err); code != codes.PermissionDenied {
+			t.Errorf("release: got %s want %s", code, codes.PermissionDenied)
+		}
+		_, err = c.Heartbeat(ctx, &SessionRequest{Id: "none"})
+		if code := status.Code(err); code != codes.NotFound {
+			t.Errorf("heartbeat: got %s want %s", code, codes.NotFound)
+		}
+		if err := Error(ctx, err); !errors.Is(err, lockservice.ErrSessionNotFound) {
+			t.Errorf("heartbeat: got %v want %q", err, lockservice.ErrSessionNotFound)
+		}
+	})
+
+	t.Run("authenticated calls are scoped to their principal", func(t *testing.T) {
+		auth := routing.NewHMACAuthenticator([]byte("secret"))
+		ls := lockservice.NewSimpleLockService(log)
+		c := serve(t, ls, Authenticate(auth)...)
+		teamA := metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+auth.Issue("teamA", time.Time{}))
+		teamB := metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+auth.Issue("teamB", time.Time{}))
+
+		_, err := c.Acquire(ctx, &LockRequest{FileId: "test", UserId: "owner1"})
+		if err := Error(ctx, err); err != lockservice.ErrUnauthenticated {
+			t.Errorf("acquire: got %v want %v", err, lockservice.ErrUnauthenticated)
+		}
+		if _, err := c.Acquire(teamA, &LockRequest{FileId: "test", UserId: "owner1"}); err != nil {
+			t.Fatalf("acquire: %v", err)
+		}
+		if owner, _ := ls.CheckAcquired(lockservice.NewLockDescriptor("test", "")); owner != "teamA/owner1" {
+			t.Errorf("checkAcquired: got %q want %q", owner, "teamA/owner1")
+		}
+		res, err := c.CheckAcquired(teamA, &CheckRequest{FileId: "test"})
+		if err != nil || res.Owner != "owner1" {
+			t.Errorf("checkAcquired: got %v, %v want owner1, <nil>", res, err)
+		}
+		_, err = c.Release(teamB, &LockRequest{FileId: "test", UserId: "owner1"})
+		if err := Error(teamB, err); err != lockservice.ErrUnauthorizedAccess {
+			t.Errorf("release: got %v want %v", err, lockservice.ErrUnauthorizedAccess)
+		}
+
+		session, err := c.CreateSession(teamA, &CreateSessionRequest{})
+		if err != nil {
+			t.Fatalf("createSession: %v", err)
+		}
+		_, err = c.Heartbeat(teamB, &SessionRequest{Id: session.Id})
+		if err := Error(teamB, err); err != lockservice.ErrSessionNotFound {
+			t.Errorf("heartbeat: got %v want %v", err, lockservice.ErrSessionNotFound)
+		}
+		if _, err := c.Heartbeat(teamA, &SessionRequest{Id: session.Id}); err != nil {
+			t.Errorf("heartbeat: got %v want <nil>", err)
+		}
+	})
+
+	t.Run("a lockservice without extensions serves only the locks", func(t *testing.T) {
+		c := serve(t, struct{ lockservice.LockService }{lockservice.NewSimpleLockService(log)})
+		if _, err := c.Acquire(ctx, &LockRequest{FileId: "test", UserId: "owner1"}); err != nil {
+			t.Fatalf("acquire: %v", err)
+		}
+		res, err := c.CheckAcquired(ctx, &CheckRequest{FileId: "test"})
+		if err != nil || !res.Acquired || res.Owner != "owner1" {
+			t.Errorf("checkAcquired: got %v, %v want owner1, <nil>", res, err)
+		}
+
+		_, err = c.CreateSession(ctx, &CreateSessionRequest{})
+		if code := status.Code(err); code != codes.Unimplemented {
+			t.Errorf("createSession: got %s want %s", code, codes.Unimplemented)
+		}
+		stream, err := c.Watch(ctx, &WatchRequest{FileId: "test"})
+		if err != nil {
+			t.Fatalf("watch: %v", err)
+		}
+		_, err = stream.Recv()
+		if code := status.Code(err); code != codes.Unimplemented {
+			t.Errorf("watch: got %s want %s", code, codes.Unimplemented)
+		}
+	})
+}
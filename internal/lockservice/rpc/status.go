@@ -0,0 +1,78 @@
+package rpc
+
+import (
+	"context"
+
+	"github.com/SystemBuilders/LocKey/internal/lockservice"
+	"github.com/SystemBuilders/LocKey/internal/lockservice/partition"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// statusOf returns the status that the error of the lockservice is sent
+// as. The message of the status is the error, so that the client can
+// return it as the lockservice would.
+func statusOf(err error) error {
+	if err == nil {
+		return nil
+	}
+	return status.Error(codeOf(err), err.Error())
+}
+
+// codeOf returns the code of the status of the error.
+func codeOf(err error) codes.Code {
+	switch err {
+	case lockservice.ErrUnauthorizedAccess:
+		return codes.PermissionDenied
+	case lockservice.ErrUnauthenticated:
+		return codes.Unauthenticated
+	case lockservice.ErrAcquireTimeout, context.DeadlineExceeded:
+		return codes.DeadlineExceeded
+	case lockservice.ErrSessionNotFound:
+		return codes.NotFound
+	case lockservice.ErrUnsupported:
+		return codes.Unimplemented
+	case lockservice.ErrDuplicateDescriptor, lockservice.ErrInvalidPermits:
+		return codes.InvalidArgument
+	case partition.ErrMigrating:
+		return codes.Aborted
+	case context.Canceled:
+		return codes.Canceled
+	}
+	switch err.(type) {
+	case lockservice.Error, partition.Error:
+		return codes.FailedPrecondition
+	}
+	return codes.Unknown
+}
+
+// Error returns the error of the lockservice that the status returned by
+// a call carries. The codes that only one error is sent as are mapped back
+// to that error, so that it can be matched with errors.Is. Errors of the
+// transport and of the server itself are returned as they are, and the
+// error of the context if it's done.
+func Error(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	st, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+	switch st.Code() {
+	case codes.Unavailable, codes.Internal, codes.Canceled:
+		return err
+	case codes.PermissionDenied:
+		return lockservice.ErrUnauthorizedAccess
+	case codes.Unauthenticated:
+		return lockservice.ErrUnauthenticated
+	case codes.NotFound:
+		return lockservice.ErrSessionNotFound
+	case codes.Aborted:
+		return partition.ErrMigrating
+	}
+	return lockservice.Error(st.Message())
+}
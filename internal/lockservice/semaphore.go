@@ -0,0 +1,248 @@
+package lockservice
+
+import "time"
+
+// SemaphoreEntry is a counting semaphore held in the SafeLockMap.
+// It hands out up to Limit permits, which are held by their owners.
+type SemaphoreEntry struct {
+	Limit   int
+	Holders map[string]PermitHold
+}
+
+// PermitHold is the set of permits of a semaphore held by an owner.
+// Like holds on locks, the permits are leased and are returned to the
+// semaphore when the lease runs out.
+type PermitHold struct {
+	Owner     string
+	Permits   int
+	Timestamp time.Time
+	Lease     time.Duration
+	Session   string `json:",omitempty"`
+}
+
+// Expired returns true if the lease of the permits has run out at
+// the given time. Permits with no lease never expire.
+func (h PermitHold) Expired(now time.Time) bool {
+	return h.Lease > 0 && !now.Before(h.Timestamp.Add(h.Lease))
+}
+
+// Available returns the number of permits that aren't held.
+func (e SemaphoreEntry) Available() int {
+	available := e.Limit
+	for _, hold := range e.Holders {
+		available -= hold.Permits
+	}
+	return available
+}
+
+// SemaphoreRequest is an instance of a request for permits of a semaphore.
+// Limit is the number of permits of the semaphore, which is set by the
+// first request on it and must be repeated by every later request.
+type SemaphoreRequest struct {
+	FileID  string        `json:"fileID"`
+	UserID  string        `json:"userID"`
+	Permits int           `json:"permits"`
+	Limit   int           `json:"limit,omitempty"`
+	Lease   time.Duration `json:"lease,omitempty"`
+	Session string        `json:"session,omitempty"`
+}
+
+// SemaphoreStatus describes the state of a semaphore. It is also
+// the response of a CheckSemaphore.
+type SemaphoreStatus struct {
+	Limit     int `json:"limit"`
+	Available int `json:"available"`
+	// Holders maps the owners of the semaphore to the
+	// number of permits they hold.
+	Holders map[string]int `json:"holders"`
+}
+
+// AcquirePermits lets a client acquire a number of permits of a counting
+// semaphore. The semaphore is created with limit permits on the first
+// acquisition, and lives until all of its permits are released. An owner
+// acquiring more permits adds them to the ones it holds and renews their
+// lease.
+//
+// Permits are leased for the duration requested by the descriptor or the
+// default lease of the service, like locks are.
+func (ls *SimpleLockService) AcquirePermits(sd Descriptors, permits, limit int) error {
+	if permits <= 0 || permits > limit {
+		return ErrInvalidPermits
+	}
+
+	m := ls.shard(sd.ID())
+	m.Mutex.Lock()
+	defer m.Mutex.Unlock()
+	now := time.Now()
+	ls.expirePermits(sd.ID(), now)
+	entry, ok := m.Semaphores[sd.ID()]
+	if !ok {
+		entry = SemaphoreEntry{
+			Limit:   limit,
+			Holders: make(map[string]PermitHold),
+		}
+	}
+	if entry.Limit != limit {
+		ls.
+			log.
+			Debug().
+			Str("semaphore", sd.ID()).
+			Int("limit", entry.Limit).
+			Msg("can't acquire permits, limit mismatch")
+		return ErrSemaphoreLimit
+	}
+	if entry.Available() < permits {
+		ls.
+			log.
+			Debug().
+			Str("semaphore", sd.ID()).
+			Int("permits", permits).
+			Msg("can't acquire permits, not enough available")
+		return ErrNoPermits
+	}
+	if err := ls.attachPermits(sd); err != nil {
+		return err
+	}
+	hold := entry.Holders[sd.Owner()]
+	hold = PermitHold{
+		Owner:     sd.Owner(),
+		Permits:   hold.Permits + permits,
+		Timestamp: now,
+		Lease:     ls.leaseOf(sd),
+		Session:   sessionOf(sd),
+	}
+	if err := ls.record(Record{
+		Type:    RecordPermits,
+		ID:      sd.ID(),
+		Owner:   sd.Owner(),
+		Limit:   entry.Limit,
+		Permits: &hold,
+	}); err != nil {
+		return err
+	}
+	entry.Holders[sd.Owner()] = hold
+	m.Semaphores[sd.ID()] = entry
+	ls.
+		log.
+		Debug().
+		Str("semaphore", sd.ID()).
+		Str("owner", sd.Owner()).
+		Int("permits", permits).
+		Msg("permits acquired")
+	return nil
+}
+
+// ReleasePermits lets a client return a number of the permits it holds
+// to the semaphore. The semaphore is removed once none of its permits
+// are held.
+func (ls *SimpleLockService) ReleasePermits(sd Descriptors, permits int) error {
+	if permits <= 0 {
+		return ErrInvalidPermits
+	}
+
+	m := ls.shard(sd.ID())
+	m.Mutex.Lock()
+	defer m.Mutex.Unlock()
+	ls.expirePermits(sd.ID(), time.Now())
+	entry := m.Semaphores[sd.ID()]
+	hold, ok := entry.Holders[sd.Owner()]
+	if !ok || hold.Permits < permits {
+		ls.
+			log.
+			Debug().
+			Str("semaphore", sd.ID()).
+			Str("owner", sd.Owner()).
+			Int("permits", permits).
+			Msg("can't release permits, not held")
+		return ErrPermitsNotHeld
+	}
+	hold.Permits -= permits
+	if hold.Permits == 0 {
+		if err := ls.dropPermits(sd.ID(), sd.Owner()); err != nil {
+			return err
+		}
+	} else {
+		if err := ls.record(Record{
+			Type:    RecordPermits,
+			ID:      sd.ID(),
+			Owner:   sd.Owner(),
+			Limit:   entry.Limit,
+			Permits: &hold,
+		}); err != nil {
+			return err
+		}
+		entry.Holders[sd.Owner()] = hold
+	}
+	ls.
+		log.
+		Debug().
+		Str("semaphore", sd.ID()).
+		Str("owner", sd.Owner()).
+		Int("permits", permits).
+		Msg("permits released")
+	return nil
+}
+
+// CheckSemaphore returns the state of the semaphore of the descriptor.
+// A semaphore none of whose permits are held is reported with no limit.
+func (ls *SimpleLockService) CheckSemaphore(sd Descriptors) SemaphoreStatus {
+	m := ls.shard(sd.ID())
+	m.Mutex.Lock()
+	defer m.Mutex.Unlock()
+	ls.expirePermits(sd.ID(), time.Now())
+	entry := m.Semaphores[sd.ID()]
+	status := SemaphoreStatus{
+		Limit:     entry.Limit,
+		Available: entry.Available(),
+		Holders:   make(map[string]int, len(entry.Holders)),
+	}
+	for owner, hold := range entry.Holders {
+		status.Holders[owner] = hold.Permits
+	}
+	return status
+}
+
+// dropPermits removes the owner from the holders of the semaphore and
+// removes the semaphore once it has no holders. The permits are kept if
+// the drop can't be appended to the log. The mutex of the shard of the
+// semaphore must be held.
+func (ls *SimpleLockService) dropPermits(id, owner string) error {
+	m := ls.shard(id)
+	entry, ok := m.Semaphores[id]
+	if !ok {
+		return nil
+	}
+	if err := ls.record(Record{
+		Type:  RecordDropPermits,
+		ID:    id,
+		Owner: owner,
+	}); err != nil {
+		return err
+	}
+	delete(entry.Holders, owner)
+	if len(entry.Holders) == 0 {
+		delete(m.Semaphores, id)
+	}
+	return nil
+}
+
+// expirePermits returns the permits of the semaphore whose lease has
+// run out and returns the number of holders that were dropped. The
+// mutex of the shard of the semaphore must be held.
+func (ls *SimpleLockService) expirePermits(id string, now time.Time) int {
+	expired := 0
+	for owner, hold := range ls.shard(id).Semaphores[id].Holders {
+		if hold.Expired(now) {
+			ls.
+				log.
+				Debug().
+				Str("semaphore", id).
+				Str("owner", owner).
+				Msg("lease expired, permits released")
+			if ls.dropPermits(id, owner) == nil {
+				expired++
+			}
+		}
+	}
+	return expired
+}
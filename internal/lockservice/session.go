@@ -0,0 +1,335 @@
+package lockservice
+
+import (
+	"crypto/rand"
+	"sync"
+	"time"
+
+	"github.com/oklog/ulid"
+)
+
+// DefaultSessionTTL is the time for which a session lives without a
+// heartbeat when it is created without a TTL of its own.
+const DefaultSessionTTL = 10 * time.Second
+
+// Session is a liveness lease of a client of the lockservice. Locks and
+// permits acquired with descriptors that name a session belong to it,
+// and are all released once the session lapses because the client
+// stopped sending heartbeats, or once it is ended.
+type Session struct {
+	ID     string        `json:"id"`
+	TTL    time.Duration `json:"ttl"`
+	Expiry time.Time     `json:"expiry"`
+}
+
+// SessionRequest is an instance of a request to create, renew or end a
+// session.
+type SessionRequest struct {
+	ID  string        `json:"id,omitempty"`
+	TTL time.Duration `json:"ttl,omitempty"`
+}
+
+// SessionDescriptors describe descriptors whose locks belong to a
+// session. An empty session leaves the lock to its lease alone.
+type SessionDescriptors interface {
+	Descriptors
+	Session() string
+}
+
+// sessionTable keeps the live sessions of the lockservice along with the
+// IDs of the locks and semaphores acquired in them. Its mutex is taken
+// while the mutex of a shard is held, never the other way around.
+type sessionTable struct {
+	mu       sync.Mutex
+	sessions map[string]*sessionEntry
+}
+
+// sessionEntry is a live session. The IDs of its locks and semaphores
+// may outlive the holds, which are checked when the session lapses.
+type sessionEntry struct {
+	Session
+	locks      map[string]bool
+	semaphores map[string]bool
+}
+
+// CreateSession creates a session that lapses unless it gets a heartbeat
+// within the given TTL. A zero TTL gives the session DefaultSessionTTL.
+func (ls *SimpleLockService) CreateSession(ttl time.Duration) (Session, error) {
+	return ls.CreatePrefixedSession("", ttl)
+}
+
+// CreatePrefixedSession creates a session like CreateSession does, whose
+// ID starts with the given prefix.
+func (ls *SimpleLockService) CreatePrefixedSession(prefix string, ttl time.Duration) (Session, error) {
+	if ttl <= 0 {
+		ttl = DefaultSessionTTL
+	}
+	now := time.Now()
+	id, err := ulid.New(ulid.Timestamp(now), rand.Reader)
+	if err != nil {
+		return Session{}, err
+	}
+	s := Session{
+		ID:     prefix + id.String(),
+		TTL:    ttl,
+		Expiry: now.Add(ttl),
+	}
+
+	ls.sessions.mu.Lock()
+	ls.sessions.sessions[s.ID] = &sessionEntry{
+		Session:    s,
+		locks:      make(map[string]bool),
+		semaphores: make(map[string]bool),
+	}
+	ls.sessions.mu.Unlock()
+	ls.
+		log.
+		Debug().
+		Str("session", s.ID).
+		Dur("ttl", ttl).
+		Msg("session created")
+	return s, nil
+}
+
+// Heartbeat renews the session for another TTL. ErrSessionNotFound is
+// returned if the session has lapsed or was never created, in which case
+// its locks are gone.
+func (ls *SimpleLockService) Heartbeat(id string) (Session, error) {
+	now := time.Now()
+	ls.sessions.mu.Lock()
+	defer ls.sessions.mu.Unlock()
+	entry, ok := ls.sessions.sessions[id]
+	if !ok || !now.Before(entry.Expiry) {
+		return Session{}, ErrSessionNotFound
+	}
+	entry.Expiry = now.Add(entry.TTL)
+	return entry.Session, nil
+}
+
+// EndSession ends the session right away and releases all of its locks
+// and permits. ErrSessionNotFound is returned if the session has lapsed
+// or was never created.
+func (ls *SimpleLockService) EndSession(id string) error {
+	ls.sessions.mu.Lock()
+	entry, ok := ls.sessions.sessions[id]
+	if ok {
+		delete(ls.sessions.sessions, id)
+	}
+	ls.sessions.mu.Unlock()
+	if !ok {
+		return ErrSessionNotFound
+	}
+	ls.
+		log.
+		Debug().
+		Str("session", id).
+		Msg("session ended")
+	ls.releaseSession(entry, EventReleased, time.Now())
+	return nil
+}
+
+// reapSessions ends the sessions that have lapsed at the given time and
+// releases their locks and permits. It returns the number of sessions
+// that lapsed.
+func (ls *SimpleLockService) reapSessions(now time.Time) int {
+	var lapsed []*sessionEntry
+	ls.sessions.mu.Lock()
+	for id, entry := range ls.sessions.sessions {
+		if !now.Before(entry.Expiry) {
+			lapsed = append(lapsed, entry)
+			delete(ls.sessions.sessions, id)
+		}
+	}
+	ls.sessions.mu.Unlock()
+
+	for _, entry := range lapsed {
+		ls.
+			log.
+			Debug().
+			Str("session", entry.ID).
+			Msg("session lapsed, releasing its locks")
+		ls.releaseSession(entry, EventExpired, now)
+	}
+	return len(lapsed)
+}
+
+// releaseSession drops the holds, pounces and permits of an ended
+// session. The session has already been removed from the table, so no
+// new lock can be acquired in it.
+// A hold whose drop can't be appended to the log is left to its lease.
+func (ls *SimpleLockService) releaseSession(entry *sessionEntry, typ EventType, now time.Time) {
+	for id := range entry.locks {
+		m := ls.shard(id)
+		m.Mutex.Lock()
+		ls.dropPounces(id, entry.ID, now)
+		for owner, hold := range m.LockMap[id].Holders {
+			if hold.Session == entry.ID {
+				ls.drop(id, owner, typ, now)
+			}
+		}
+		m.Mutex.Unlock()
+	}
+	for id := range entry.semaphores {
+		m := ls.shard(id)
+		m.Mutex.Lock()
+		for owner, hold := range m.Semaphores[id].Holders {
+			if hold.Session == entry.ID {
+				ls.dropPermits(id, owner)
+			}
+		}
+		m.Mutex.Unlock()
+	}
+}
+
+// attach records the lock on the descriptor as belonging to the session
+// of the descriptor, if it names one. ErrSessionNotFound is returned if
+// the session isn't live, in which case the lock must not be acquired.
+// The mutex of the shard of the lock must be held.
+func (ls *SimpleLockService) attach(sd Descriptors) error {
+	return ls.attachTo(sd, func(entry *sessionEntry) map[string]bool {
+		return entry.locks
+	})
+}
+
+// attachPermits records the semaphore of the descriptor as used by the
+// session of the descriptor, like attach does for locks. The mutex of
+// the shard of the semaphore must be held.
+func (ls *SimpleLockService) attachPermits(sd Descriptors) error {
+	return ls.attachTo(sd, func(entry *sessionEntry) map[string]bool {
+		return entry.semaphores
+	})
+}
+
+// attachTo adds the ID of the descriptor to the set of the session of
+// the descriptor picked by the given function.
+func (ls *SimpleLockService) attachTo(sd Descriptors, set func(*sessionEntry) map[string]bool) error {
+	session := sessionOf(sd)
+	if session == "" {
+		return nil
+	}
+	ls.sessions.mu.Lock()
+	defer ls.sessions.mu.Unlock()
+	entry, ok := ls.sessions.sessions[session]
+	if !ok || !time.Now().Before(entry.Expiry) {
+		ls.
+			log.
+			Debug().
+			Str("descriptor", sd.ID()).
+			Str("session", session).
+			Msg("can't acquire, session not found")
+		return ErrSessionNotFound
+	}
+	set(entry)[sd.ID()] = true
+	return nil
+}
+
+// liveSessions returns a copy of the sessions that are live at the given
+// time.
+func (ls *SimpleLockService) liveSessions(now time.Time) map[string]Session {
+	ls.sessions.mu.Lock()
+	defer ls.sessions.mu.Unlock()
+	sessions := make(map[string]Session, len(ls.sessions.sessions))
+	for id, entry := range ls.sessions.sessions {
+		if now.Before(entry.Expiry) {
+			sessions[id] = entry.Session
+		}
+	}
+	return sessions
+}
+
+// restoreSessions replaces the sessions of the service with the given
+// ones. Their clients couldn't send heartbeats while the sessions were
+// away, so every session is given a full TTL from the given time to send
+// its next one.
+func (ls *SimpleLockService) restoreSessions(sessions map[string]Session, now time.Time) {
+	ls.sessions.mu.Lock()
+	defer ls.sessions.mu.Unlock()
+	ls.sessions.sessions = make(map[string]*sessionEntry, len(sessions))
+	for id, s := range sessions {
+		s.Expiry = now.Add(s.TTL)
+		ls.sessions.sessions[id] = &sessionEntry{
+			Session:    s,
+			locks:      make(map[string]bool),
+			semaphores: make(map[string]bool),
+		}
+	}
+}
+
+// bindSessions binds the holds and permits of the lock table to their
+// sessions once the table has been restored or recovered. Holds whose
+// session is gone are removed, since nobody would release them once
+// their session lapses, unless the sessions are adopted: sessions created
+// since the latest snapshot are only known by the holds in the log, so a
+// recovered table gets them back with DefaultSessionTTL from the given
+// time, in which their clients can resume their heartbeats. The mutexes
+// of all the shards must be held.
+func (ls *SimpleLockService) bindSessions(adopt bool, now time.Time) {
+	ls.sessions.mu.Lock()
+	defer ls.sessions.mu.Unlock()
+	lookup := func(id string) (*sessionEntry, bool) {
+		if s, ok := ls.sessions.sessions[id]; ok || !adopt {
+			return s, ok
+		}
+		s := &sessionEntry{
+			Session: Session{
+				ID:     id,
+				TTL:    DefaultSessionTTL,
+				Expiry: now.Add(DefaultSessionTTL),
+			},
+			locks:      make(map[string]bool),
+			semaphores: make(map[string]bool),
+		}
+		ls.sessions.sessions[id] = s
+		return s, true
+	}
+	released := 0
+	for _, m := range ls.shards {
+		for id, entry := range m.LockMap {
+			for owner, hold := range entry.Holders {
+				if hold.Session == "" {
+					continue
+				}
+				if s, ok := lookup(hold.Session); ok {
+					s.locks[id] = true
+					continue
+				}
+				delete(entry.Holders, owner)
+				released++
+			}
+			if len(entry.Holders) == 0 {
+				delete(m.LockMap, id)
+			}
+		}
+		for id, entry := range m.Semaphores {
+			for owner, hold := range entry.Holders {
+				if hold.Session == "" {
+					continue
+				}
+				if s, ok := lookup(hold.Session); ok {
+					s.semaphores[id] = true
+					continue
+				}
+				delete(entry.Holders, owner)
+				released++
+			}
+			if len(entry.Holders) == 0 {
+				delete(m.Semaphores, id)
+			}
+		}
+	}
+	if released > 0 {
+		ls.
+			log.
+			Debug().
+			Int("holds", released).
+			Msg("released the holds of sessions that are gone")
+	}
+}
+
+// sessionOf returns the session named by the descriptor, if any.
+func sessionOf(sd Descriptors) string {
+	if ssd, ok := sd.(SessionDescriptors); ok {
+		return ssd.Session()
+	}
+	return ""
+}
@@ -0,0 +1,127 @@
+package lockservice
+
+import (
+	"hash/fnv"
+	"sort"
+	"time"
+)
+
+// DefaultShards is the number of shards of the lock table when the
+// service isn't configured otherwise.
+const DefaultShards = 64
+
+// WithShards sets the number of shards the lock table is split into.
+// Calls on descriptors in different shards never wait on each other,
+// so more shards let more calls run in parallel. A number below one
+// leaves the lock table in a single shard.
+func WithShards(n int) Option {
+	return func(ls *SimpleLockService) {
+		if n < 1 {
+			n = 1
+		}
+		ls.shards = make([]*SafeLockMap, n)
+	}
+}
+
+// newSafeLockMap returns an empty shard of the lock table.
+func newSafeLockMap() *SafeLockMap {
+	return &SafeLockMap{
+		LockMap:    make(map[string]LockMapEntry),
+		Tokens:     make(map[string]FencingToken),
+		Waiters:    make(map[string][]*waiter),
+		Semaphores: make(map[string]SemaphoreEntry),
+		Watchers:   make(map[string][]*watcher),
+	}
+}
+
+// shard returns the shard of the lock table that holds the descriptor
+// ID. Everything kept about a descriptor lives in its shard.
+func (ls *SimpleLockService) shard(id string) *SafeLockMap {
+	return ls.shards[ls.shardIndex(id)]
+}
+
+// shardIndex returns the index of the shard that holds the descriptor
+// ID.
+func (ls *SimpleLockService) shardIndex(id string) int {
+	if len(ls.shards) == 1 {
+		return 0
+	}
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	return int(h.Sum32() % uint32(len(ls.shards)))
+}
+
+// lockShards locks the shards that hold the descriptor IDs, in the order
+// of their indices so that calls locking several shards never deadlock,
+// and returns a function that unlocks them.
+func (ls *SimpleLockService) lockShards(ids []string) func() {
+	seen := make(map[int]bool, len(ids))
+	var indices []int
+	for _, id := range ids {
+		i := ls.shardIndex(id)
+		if !seen[i] {
+			seen[i] = true
+			indices = append(indices, i)
+		}
+	}
+	sort.Ints(indices)
+	for _, i := range indices {
+		ls.shards[i].Mutex.Lock()
+	}
+	return func() {
+		for _, i := range indices {
+			ls.shards[i].Mutex.Unlock()
+		}
+	}
+}
+
+// lockAll locks every shard of the lock table, such as to take a
+// consistent snapshot of it, and returns a function that unlocks them.
+func (ls *SimpleLockService) lockAll() func() {
+	for _, m := range ls.shards {
+		m.Mutex.Lock()
+	}
+	return func() {
+		for _, m := range ls.shards {
+			m.Mutex.Unlock()
+		}
+	}
+}
+
+// lockForCheck locks the shard of the descriptor ID for a check of its
+// lock at the given time. The shard is only locked for reading, so that
+// checks run alongside each other, unless a hold on the lock has expired
+// and has to be dropped first. The returned function unlocks the shard.
+func (ls *SimpleLockService) lockForCheck(id string, now time.Time) func() {
+	m := ls.shard(id)
+	m.Mutex.RLock()
+	if !ls.expiring(id, now) {
+		return m.Mutex.RUnlock
+	}
+	m.Mutex.RUnlock()
+	m.Mutex.Lock()
+	ls.expire(id, now)
+	return m.Mutex.Unlock
+}
+
+// expiring returns true if any hold on the lock has expired at the given
+// time. The mutex of the shard of the lock must be held, at least for
+// reading.
+func (ls *SimpleLockService) expiring(id string, now time.Time) bool {
+	for _, hold := range ls.shard(id).LockMap[id].Holders {
+		if hold.Expired(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// locks returns the number of locks held in the lock table. The mutexes
+// of all the shards must be held.
+func (ls *SimpleLockService) locks() int {
+	n := 0
+	for _, m := range ls.shards {
+		n += len(m.LockMap)
+	}
+	return n
+}
@@ -1,15 +1,83 @@
 package lockservice
 
 import (
+	"context"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/rs/zerolog"
 )
 
-// SafeLockMap is the lockserver's data structure
+// DefaultLease is the duration for which a lock is leased when the
+// request doesn't specify a lease of its own.
+const DefaultLease = time.Minute
+
+// SafeLockMap is a shard of the lockserver's data structure. The lock
+// table is split into shards by the hash of the descriptor IDs, each
+// guarded by a mutex of its own.
 type SafeLockMap struct {
-	LockMap map[string]string
-	Mutex   sync.Mutex
+	LockMap map[string]LockMapEntry
+	// Tokens holds the last fencing token issued for every lock.
+	// Tokens outlive the locks so that they never go backwards.
+	Tokens map[string]FencingToken
+	// Waiters holds the FIFO queue of processes blocked on each
+	// lock. A lock with waiters is handed over to the first waiter
+	// as soon as it is freed.
+	Waiters map[string][]*waiter
+	// Semaphores holds the counting semaphores, which live in a
+	// namespace of their own next to the locks.
+	Semaphores map[string]SemaphoreEntry
+	// Watchers holds the watchers of each lock, which are
+	// notified of every change in the state of the lock.
+	Watchers map[string][]*watcher
+	// Mutex guards the shard. Checks that don't change the shard
+	// only take it for reading.
+	Mutex sync.RWMutex
+}
+
+// LockMapEntry is a single lock held in the SafeLockMap.
+// An exclusive lock has exactly one holder while a shared
+// lock can have many, mapped by their owners.
+type LockMapEntry struct {
+	Mode    LockMode
+	Holders map[string]Hold
+}
+
+// Hold is the acquisition of a lock by one of its holders.
+// It records the owner of the lock, the time at which the lock
+// was acquired, the duration for which it was leased and the
+// fencing token issued for the acquisition.
+//
+// Count is the number of times the owner has acquired the lock
+// reentrantly and is at least one. Session is the session that the
+// hold belongs to, if any.
+type Hold struct {
+	Owner     string
+	Timestamp time.Time
+	Lease     time.Duration
+	Token     FencingToken
+	Count     int
+	Session   string `json:",omitempty"`
+}
+
+// Expired returns true if the lease of the hold has run out at
+// the given time. A hold with no lease never expires.
+func (h Hold) Expired(now time.Time) bool {
+	return h.Lease > 0 && !now.Before(h.Timestamp.Add(h.Lease))
+}
+
+// Holds returns all the holds on the lock in the order in which
+// they were acquired.
+func (e LockMapEntry) Holds() []Hold {
+	holds := make([]Hold, 0, len(e.Holders))
+	for _, hold := range e.Holders {
+		holds = append(holds, hold)
+	}
+	sort.Slice(holds, func(i, j int) bool {
+		return holds[i].Token < holds[j].Token
+	})
+	return holds
 }
 
 // SimpleConfig implements Config.
@@ -20,18 +88,55 @@ type SimpleConfig struct {
 
 // LockRequest is an instance of a request for a lock.
 type LockRequest struct {
-	FileID string `json:"fileID"`
-	UserID string `json:"userID"`
+	FileID string        `json:"fileID"`
+	UserID string        `json:"userID"`
+	Lease  time.Duration `json:"lease,omitempty"`
+	Token  FencingToken  `json:"token,omitempty"`
+	Mode   LockMode      `json:"mode,omitempty"`
+	// Reentrant lets the owner acquire a lock it already holds.
+	Reentrant bool `json:"reentrant,omitempty"`
+	// Wait is the duration for which an acquire blocks on a held
+	// lock. A zero duration fails the acquire right away.
+	Wait time.Duration `json:"wait,omitempty"`
+	// Session is the session that the lock belongs to, if any.
+	Session string `json:"session,omitempty"`
 }
 
 // LockCheckRequest is an instance of a lock check request.
 type LockCheckRequest struct {
-	FileID string `json:"fileID"`
+	FileID string       `json:"fileID"`
+	Token  FencingToken `json:"token,omitempty"`
+}
+
+// BatchRequest is an instance of a request for a set of locks.
+type BatchRequest struct {
+	Requests []LockRequest `json:"requests"`
+}
+
+// AcquireBatchRes is the response of an AcquireBatch.
+type AcquireBatchRes struct {
+	Tokens []FencingToken `json:"tokens"`
+}
+
+// AcquireRes is the response of an Acquire.
+type AcquireRes struct {
+	Token FencingToken `json:"token"`
 }
 
 // CheckAcquireRes is the response of a Checkacquire.
+// Owner is the first of the holders of the lock, while
+// Owners lists all of them in the order they acquired it.
 type CheckAcquireRes struct {
-	Owner string `json:"owner"`
+	Owner  string   `json:"owner"`
+	Owners []string `json:"owners,omitempty"`
+	Mode   LockMode `json:"mode,omitempty"`
+}
+
+// ErrorRes is the body of a failed call of the v2 HTTP API. Code is the
+// stable code of the error, while Message is the error itself.
+type ErrorRes struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
 }
 
 // IP returns the IP from the SimpleConfig.
@@ -47,14 +152,42 @@ func (scfg *SimpleConfig) Port() string {
 var _ LockService = (*SimpleLockService)(nil)
 
 // SimpleLockService is a lock service that implements LockService.
-// It uses a golang map to maintain the locks of the descriptors.
-// It can acquire and release locks and has an in-built logger.
+// It uses golang maps, sharded by descriptor ID, to maintain the locks
+// of the descriptors. It can acquire and release locks and has an
+// in-built logger.
 type SimpleLockService struct {
-	log     zerolog.Logger
-	lockMap *SafeLockMap
+	log    zerolog.Logger
+	shards []*SafeLockMap
+	// lease is the duration for which locks are leased when
+	// the descriptor doesn't carry a lease of its own.
+	lease time.Duration
+	// wal is the log to which the changes of the lock table
+	// are appended, if any.
+	wal Log
+	// snapshots is the store in which snapshots of the lock
+	// map are kept, if any.
+	snapshots *SnapshotStore
+	// sessions holds the live sessions of the clients.
+	sessions *sessionTable
+}
+
+// Option configures a SimpleLockService.
+type Option func(*SimpleLockService)
+
+// WithDefaultLease sets the lease used for descriptors that don't
+// specify one. A zero lease makes such locks last until released.
+func WithDefaultLease(lease time.Duration) Option {
+	return func(ls *SimpleLockService) {
+		ls.lease = lease
+	}
 }
 
 var _ Descriptors = (*LockDescriptor)(nil)
+var _ LeasedDescriptors = (*LockDescriptor)(nil)
+var _ TokenDescriptors = (*LockDescriptor)(nil)
+var _ ModeDescriptors = (*LockDescriptor)(nil)
+var _ ReentrantDescriptors = (*LockDescriptor)(nil)
+var _ SessionDescriptors = (*LockDescriptor)(nil)
 var _ Object = (*ObjectDescriptor)(nil)
 
 // ObjectDescriptor describes the object that is subjected to
@@ -72,8 +205,13 @@ func (od *ObjectDescriptor) ID() string {
 // Many descriptors can be added to this struct and the ID
 // can be a combination of all those descriptors.
 type LockDescriptor struct {
-	FileID string
-	UserID string
+	FileID    string
+	UserID    string
+	Duration  time.Duration
+	Fence     FencingToken
+	LockMode  LockMode
+	Reenter   bool
+	SessionID string
 }
 
 // ID represents the distinguishable ID of the descriptor.
@@ -87,6 +225,34 @@ func (sd *LockDescriptor) Owner() string {
 	return sd.UserID
 }
 
+// Lease returns the duration for which the lock is requested.
+// A zero duration leaves the choice to the lockservice.
+func (sd *LockDescriptor) Lease() time.Duration {
+	return sd.Duration
+}
+
+// Token returns the fencing token presented by the descriptor.
+func (sd *LockDescriptor) Token() FencingToken {
+	return sd.Fence
+}
+
+// Mode returns the mode in which the lock is requested.
+// An empty mode requests an exclusive lock.
+func (sd *LockDescriptor) Mode() LockMode {
+	return sd.LockMode
+}
+
+// Reentrant returns true if the owner may acquire the lock again
+// while holding it.
+func (sd *LockDescriptor) Reentrant() bool {
+	return sd.Reenter
+}
+
+// Session returns the session that the lock belongs to, if any.
+func (sd *LockDescriptor) Session() string {
+	return sd.SessionID
+}
+
 // NewSimpleConfig returns an instance of the SimpleConfig.
 func NewSimpleConfig(IPAddr, PortAddr string) *SimpleConfig {
 	return &SimpleConfig{
@@ -103,6 +269,36 @@ func NewLockDescriptor(FileID, UserID string) *LockDescriptor {
 	}
 }
 
+// NewLeasedLockDescriptor returns an instance of the LockDescriptor
+// which requests the lock for the given lease.
+func NewLeasedLockDescriptor(FileID, UserID string, lease time.Duration) *LockDescriptor {
+	return &LockDescriptor{
+		FileID:   FileID,
+		UserID:   UserID,
+		Duration: lease,
+	}
+}
+
+// NewSharedLockDescriptor returns an instance of the LockDescriptor
+// which requests a shared lock.
+func NewSharedLockDescriptor(FileID, UserID string) *LockDescriptor {
+	return &LockDescriptor{
+		FileID:   FileID,
+		UserID:   UserID,
+		LockMode: Shared,
+	}
+}
+
+// NewReentrantLockDescriptor returns an instance of the LockDescriptor
+// which lets the owner acquire the lock again while it holds it.
+func NewReentrantLockDescriptor(FileID, UserID string) *LockDescriptor {
+	return &LockDescriptor{
+		FileID:  FileID,
+		UserID:  UserID,
+		Reenter: true,
+	}
+}
+
 // NewObjectDescriptor returns an instance of the ObjectDescriptor.
 func NewObjectDescriptor(ObjectID string) *ObjectDescriptor {
 	return &ObjectDescriptor{
@@ -111,117 +307,602 @@ func NewObjectDescriptor(ObjectID string) *ObjectDescriptor {
 }
 
 // NewSimpleLockService creates and returns a new lock service ready to use.
-func NewSimpleLockService(log zerolog.Logger) *SimpleLockService {
-	safeLockMap := &SafeLockMap{
-		LockMap: make(map[string]string),
+// Locks are leased for DefaultLease and the lock table is split into
+// DefaultShards shards unless configured otherwise.
+func NewSimpleLockService(log zerolog.Logger, opts ...Option) *SimpleLockService {
+	ls := &SimpleLockService{
+		log:    log,
+		shards: make([]*SafeLockMap, DefaultShards),
+		lease:  DefaultLease,
+		sessions: &sessionTable{
+			sessions: make(map[string]*sessionEntry),
+		},
 	}
-	return &SimpleLockService{
-		log:     log,
-		lockMap: safeLockMap,
+	for _, opt := range opts {
+		opt(ls)
 	}
+	for i := range ls.shards {
+		ls.shards[i] = newSafeLockMap()
+	}
+	return ls
 }
 
 // Acquire function lets a client acquire a lock on an object.
-func (ls *SimpleLockService) Acquire(sd Descriptors) error {
-	ls.lockMap.Mutex.Lock()
-	if _, ok := ls.lockMap.LockMap[sd.ID()]; ok {
-		ls.lockMap.Mutex.Unlock()
+// The lock is leased for the duration requested by the descriptor
+// or the default lease of the service. Locks whose lease has run
+// out are treated as free.
+//
+// A shared lock is granted alongside other shared holders, unless
+// processes are already waiting on the lock. An exclusive lock is
+// only granted if nobody holds the lock.
+//
+// Every successful acquisition is issued a new fencing token. A
+// reentrant descriptor whose owner already holds the lock increments
+// the hold count instead, renews the lease and keeps the token.
+func (ls *SimpleLockService) Acquire(sd Descriptors) (FencingToken, error) {
+	return ls.acquireAt(sd, time.Now())
+}
+
+// acquireAt acquires the lock on the descriptor as Acquire does, at
+// the given time.
+func (ls *SimpleLockService) acquireAt(sd Descriptors, now time.Time) (FencingToken, error) {
+	m := ls.shard(sd.ID())
+	m.Mutex.Lock()
+	ls.expire(sd.ID(), now)
+	if !ls.acquirable(sd) {
+		m.Mutex.Unlock()
 		ls.
 			log.
 			Debug().
 			Str("descriptor", sd.ID()).
 			Msg("can't acquire, already been acquired")
-		return ErrFileacquired
+		return 0, ErrFileacquired
+	}
+	if err := ls.attach(sd); err != nil {
+		m.Mutex.Unlock()
+		return 0, err
+	}
+	token, err := ls.acquire(sd, now)
+	m.Mutex.Unlock()
+	return token, err
+}
+
+// Release lets a client to release a lock on an object.
+// A lock whose lease has run out has already been released and
+// a presented fencing token must belong to the current acquisition.
+//
+// A reentrant hold is only released once it has been released as many
+// times as it was acquired. Once the last holder releases the lock, it
+// is handed over to the processes waiting on it.
+func (ls *SimpleLockService) Release(sd Descriptors) error {
+	return ls.releaseAt(sd, time.Now())
+}
+
+// releaseAt releases the lock on the descriptor as Release does, at
+// the given time.
+func (ls *SimpleLockService) releaseAt(sd Descriptors, now time.Time) error {
+	m := ls.shard(sd.ID())
+	m.Mutex.Lock()
+	ls.expire(sd.ID(), now)
+	if err := ls.releasable(sd); err != nil {
+		m.Mutex.Unlock()
+		return err
+	}
+	err := ls.release(sd, now)
+	m.Mutex.Unlock()
+	return err
+}
+
+// CheckAcquired returns true if the file is Acquired.
+// It also returns the owner of the file, which is the earliest
+// holder of a shared lock. If the descriptor presents a fencing
+// token, it must belong to a current acquisition and the owner
+// of that acquisition is returned.
+func (ls *SimpleLockService) CheckAcquired(sd Descriptors) (string, bool) {
+	return ls.checkAcquiredAt(sd, time.Now())
+}
+
+// checkAcquiredAt checks the lock on the descriptor as CheckAcquired
+// does, at the given time.
+func (ls *SimpleLockService) checkAcquiredAt(sd Descriptors, now time.Time) (string, bool) {
+	id := sd.ID()
+	unlock := ls.lockForCheck(id, now)
+	for _, hold := range ls.shard(id).LockMap[id].Holds() {
+		if tokenMatches(sd, hold) {
+			unlock()
+			ls.
+				log.
+				Debug().
+				Str("descriptor", id).
+				Msg("checkacquire success")
+			return hold.Owner, true
+		}
 	}
-	ls.lockMap.LockMap[sd.ID()] = sd.Owner()
-	ls.lockMap.Mutex.Unlock()
 	ls.
 		log.
 		Debug().
-		Str("descriptor", sd.ID()).
-		Str("owner", sd.Owner()).
-		Msg("locked")
-	return nil
+		Str("descriptor", id).
+		Msg("check acquire failure")
+	unlock()
+	return "", false
 }
 
-// Release lets a client to release a lock on an object.
-func (ls *SimpleLockService) Release(sd Descriptors) error {
-	ls.lockMap.Mutex.Lock()
-	// Only the entity that posseses the lock for this object
-	// is allowed to release the lock
-	if ls.lockMap.LockMap[sd.ID()] == sd.Owner() {
-		delete(ls.lockMap.LockMap, sd.ID())
+// Holders returns the mode of the lock on the descriptor along with
+// all of its holders, in the order in which they acquired it. No
+// holders are returned if the lock isn't held.
+func (ls *SimpleLockService) Holders(sd Descriptors) (LockMode, []string) {
+	return ls.holdersAt(sd, time.Now())
+}
+
+// holdersAt returns the holders of the lock on the descriptor as
+// Holders does, at the given time.
+func (ls *SimpleLockService) holdersAt(sd Descriptors, now time.Time) (LockMode, []string) {
+	defer ls.lockForCheck(sd.ID(), now)()
+	entry, ok := ls.shard(sd.ID()).LockMap[sd.ID()]
+	if !ok {
+		return "", nil
+	}
+	var owners []string
+	for _, hold := range entry.Holds() {
+		owners = append(owners, hold.Owner)
+	}
+	return entry.Mode, owners
+}
+
+// CheckReleased returns true if the file is released
+func (ls *SimpleLockService) CheckReleased(sd Descriptors) bool {
+	return ls.checkReleasedAt(sd, time.Now())
+}
+
+// checkReleasedAt checks the lock on the descriptor as CheckReleased
+// does, at the given time.
+func (ls *SimpleLockService) checkReleasedAt(sd Descriptors, now time.Time) bool {
+	id := sd.ID()
+	unlock := ls.lockForCheck(id, now)
+	if _, ok := ls.shard(id).LockMap[id]; ok {
+		unlock()
 		ls.
 			log.
 			Debug().
-			Str("descriptor", sd.ID()).
-			Str("owner", sd.Owner()).
-			Msg("released")
-		ls.lockMap.Mutex.Unlock()
+			Str("descriptor", id).
+			Msg("checkRelease failure")
+		return false
+	}
+	unlock()
+	ls.
+		log.
+		Debug().
+		Str("descriptor", id).
+		Msg("checkRelease success")
+	return true
+}
+
+// Reap removes all the holds, pounces and permits whose lease has run out
+// and returns the number of holds that were removed. Freed locks are handed
+// over to their waiters, if any.
+func (ls *SimpleLockService) Reap() int {
+	return ls.reapAt(time.Now())
+}
+
+// reapAt reaps the expired holds and permits as Reap does, at the
+// given time.
+func (ls *SimpleLockService) reapAt(now time.Time) int {
+	reaped := 0
+	for _, m := range ls.shards {
+		m.Mutex.Lock()
+		for id := range m.LockMap {
+			reaped += ls.expire(id, now)
+		}
+		for id := range m.Waiters {
+			ls.expirePounces(id, now)
+		}
+		for id := range m.Semaphores {
+			reaped += ls.expirePermits(id, now)
+		}
+		m.Mutex.Unlock()
+	}
+	ls.reapSessions(now)
+	return reaped
+}
+
+// Reapable returns true if a Reap at the given time would find a hold,
+// pounce, permit or session whose lease has run out. The lock table is
+// only read, so that a replica can tell whether a reap is worth making.
+func (ls *SimpleLockService) Reapable(now time.Time) bool {
+	for _, m := range ls.shards {
+		if ls.reapable(m, now) {
+			return true
+		}
+	}
+	ls.sessions.mu.Lock()
+	defer ls.sessions.mu.Unlock()
+	for _, entry := range ls.sessions.sessions {
+		if !now.Before(entry.Expiry) {
+			return true
+		}
+	}
+	return false
+}
+
+// reapable returns true if the shard has a hold, pounce or permit whose
+// lease has run out at the given time.
+func (ls *SimpleLockService) reapable(m *SafeLockMap, now time.Time) bool {
+	m.Mutex.RLock()
+	defer m.Mutex.RUnlock()
+	for id := range m.LockMap {
+		if ls.expiring(id, now) {
+			return true
+		}
+	}
+	for _, queue := range m.Waiters {
+		for _, w := range queue {
+			if w.lapsed(now) {
+				return true
+			}
+		}
+	}
+	for _, entry := range m.Semaphores {
+		for _, hold := range entry.Holders {
+			if hold.Expired(now) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// RunReaper reaps expired locks every interval until the context
+// is cancelled. This is a blocking call.
+func (ls *SimpleLockService) RunReaper(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			ls.Reap()
+		}
+	}
+}
+
+// acquirable returns true if the lock on the descriptor can be acquired
+// right away. The mutex of the shard of the lock must be held.
+func (ls *SimpleLockService) acquirable(sd Descriptors) bool {
+	if ls.reentering(sd) {
+		return true
+	}
+	return ls.grantable(sd) && len(ls.shard(sd.ID()).Waiters[sd.ID()]) == 0
+}
+
+// acquire acquires the lock on an acquirable descriptor, either by
+// reentering the hold of its owner or by granting it a new hold.
+// The mutex of the shard of the lock must be held.
+func (ls *SimpleLockService) acquire(sd Descriptors, now time.Time) (FencingToken, error) {
+	if ls.reentering(sd) {
+		return ls.reenter(sd, now)
+	}
+	return ls.grant(sd, now, false)
+}
+
+// releasable returns the reason why the descriptor can't release
+// its lock, if any. The mutex of the shard of the lock must be held.
+func (ls *SimpleLockService) releasable(sd Descriptors) error {
+	entry, ok := ls.shard(sd.ID()).LockMap[sd.ID()]
+	hold, held := entry.Holders[sd.Owner()]
+	// Only the entity that posseses the lock for this object
+	// is allowed to release the lock
+	if held {
+		if !tokenMatches(sd, hold) {
+			ls.
+				log.
+				Debug().
+				Str("descriptor", sd.ID()).
+				Msg("can't release, stale fencing token")
+			return ErrStaleToken
+		}
 		return nil
-	} else if _, ok := ls.lockMap.LockMap[sd.ID()]; !ok {
+	} else if !ok {
 		ls.
 			log.
 			Debug().
 			Str("descriptor", sd.ID()).
 			Msg("can't release, hasn't been acquired")
-		ls.lockMap.Mutex.Unlock()
 		return ErrCantReleaseFile
+	}
+	ls.
+		log.
+		Debug().
+		Str("descriptor", sd.ID()).
+		Msg("can't release, unauthorized access")
+	return ErrUnauthorizedAccess
+}
 
-	} else {
+// release releases the lock held by a releasable descriptor. A reentrant
+// hold is only dropped once its count runs out. The mutex of the shard of
+// the lock must be held.
+func (ls *SimpleLockService) release(sd Descriptors, now time.Time) error {
+	entry := ls.shard(sd.ID()).LockMap[sd.ID()]
+	hold := entry.Holders[sd.Owner()]
+	if hold.Count > 1 {
+		hold.Count--
+		if err := ls.record(Record{
+			Type:  RecordHold,
+			ID:    sd.ID(),
+			Owner: sd.Owner(),
+			Mode:  entry.Mode,
+			Hold:  &hold,
+		}); err != nil {
+			return err
+		}
+		entry.Holders[sd.Owner()] = hold
 		ls.
 			log.
 			Debug().
 			Str("descriptor", sd.ID()).
-			Msg("can't release, unauthorized access")
-		ls.lockMap.Mutex.Unlock()
-		return ErrUnauthorizedAccess
+			Str("owner", sd.Owner()).
+			Int("count", hold.Count).
+			Msg("released once, still held")
+		return nil
+	}
+	if err := ls.drop(sd.ID(), sd.Owner(), EventReleased, now); err != nil {
+		return err
+	}
+	ls.
+		log.
+		Debug().
+		Str("descriptor", sd.ID()).
+		Str("owner", sd.Owner()).
+		Msg("released")
+	return nil
+}
 
+// grantable returns true if the lock on the descriptor can be granted
+// without waiting for any of its holders, regardless of the processes
+// waiting on it. The mutex of the shard of the lock must be held.
+func (ls *SimpleLockService) grantable(sd Descriptors) bool {
+	entry, ok := ls.shard(sd.ID()).LockMap[sd.ID()]
+	if !ok {
+		return true
+	}
+	if _, held := entry.Holders[sd.Owner()]; held {
+		return false
 	}
+	return entry.Mode == Shared && modeOf(sd) == Shared
+}
 
+// grant adds the descriptor to the holders of its lock and issues a new
+// fencing token for the acquisition. Pounced is true if the lock is handed
+// over to a process that pounced on it. The mutex of the shard of the
+// lock must be held.
+func (ls *SimpleLockService) grant(sd Descriptors, now time.Time, pounced bool) (FencingToken, error) {
+	ev, err := ls.issue(sd, now, pounced)
+	if err != nil {
+		return 0, err
+	}
+	ls.notify(ev)
+	return ev.Token, nil
 }
 
-// CheckAcquired returns true if the file is Acquired.
-// It also returns the owner of the file.
-func (ls *SimpleLockService) CheckAcquired(sd Descriptors) (string, bool) {
-	ls.lockMap.Mutex.Lock()
-	id := sd.ID()
-	if owner, ok := ls.lockMap.LockMap[id]; ok {
-		ls.lockMap.Mutex.Unlock()
-		ls.
-			log.
-			Debug().
-			Str("descriptor", id).
-			Msg("checkacquire success")
-		return owner, true
+// issue grants the lock like grant does, but returns the event of the
+// acquisition instead of sending it to the watchers of the lock. The
+// mutex of the shard of the lock must be held.
+func (ls *SimpleLockService) issue(sd Descriptors, now time.Time, pounced bool) (Event, error) {
+	m := ls.shard(sd.ID())
+	entry, ok := m.LockMap[sd.ID()]
+	if !ok {
+		entry = LockMapEntry{
+			Mode:    modeOf(sd),
+			Holders: make(map[string]Hold),
+		}
+	}
+	token := m.Tokens[sd.ID()] + 1
+	hold := Hold{
+		Owner:     sd.Owner(),
+		Timestamp: now,
+		Lease:     ls.leaseOf(sd),
+		Token:     token,
+		Count:     1,
+		Session:   sessionOf(sd),
 	}
+	if err := ls.record(Record{
+		Type:  RecordHold,
+		ID:    sd.ID(),
+		Owner: sd.Owner(),
+		Mode:  entry.Mode,
+		Hold:  &hold,
+	}); err != nil {
+		return Event{}, err
+	}
+	m.LockMap[sd.ID()] = entry
+	m.Tokens[sd.ID()] = token
+	entry.Holders[sd.Owner()] = hold
 	ls.
 		log.
 		Debug().
-		Str("descriptor", id).
-		Msg("check acquire failure")
-	ls.lockMap.Mutex.Unlock()
-	return "", false
+		Str("descriptor", sd.ID()).
+		Str("owner", sd.Owner()).
+		Str("mode", string(entry.Mode)).
+		Uint64("token", uint64(token)).
+		Msg("locked")
+	return Event{
+		Type:    EventAcquired,
+		ID:      sd.ID(),
+		Owner:   sd.Owner(),
+		Mode:    entry.Mode,
+		Token:   token,
+		Pounced: pounced,
+		Time:    now,
+	}, nil
 }
 
-// CheckReleased returns true if the file is released
-func (ls *SimpleLockService) CheckReleased(sd Descriptors) bool {
-	ls.lockMap.Mutex.Lock()
-	id := sd.ID()
-	if _, ok := ls.lockMap.LockMap[id]; ok {
-		ls.lockMap.Mutex.Unlock()
-		ls.
-			log.
-			Debug().
-			Str("descriptor", id).
-			Msg("checkRelease failure")
+// reentering returns true if the descriptor is reentrant and its owner
+// already holds the lock. An exclusive hold can be reentered in either
+// mode, while a shared hold can't be upgraded. The mutex of the shard of
+// the lock must be held.
+func (ls *SimpleLockService) reentering(sd Descriptors) bool {
+	if rsd, ok := sd.(ReentrantDescriptors); !ok || !rsd.Reentrant() {
 		return false
 	}
-	ls.lockMap.Mutex.Unlock()
+	entry := ls.shard(sd.ID()).LockMap[sd.ID()]
+	_, held := entry.Holders[sd.Owner()]
+	return held && !(entry.Mode == Shared && modeOf(sd) == Exclusive)
+}
+
+// reenter increments the hold count of a reentering descriptor, renews
+// its lease and returns the token of the hold. The mutex of the shard of
+// the lock must be held.
+func (ls *SimpleLockService) reenter(sd Descriptors, now time.Time) (FencingToken, error) {
+	entry := ls.shard(sd.ID()).LockMap[sd.ID()]
+	hold := entry.Holders[sd.Owner()]
+	hold.Count++
+	hold.Timestamp = now
+	hold.Lease = ls.leaseOf(sd)
+	if err := ls.record(Record{
+		Type:  RecordHold,
+		ID:    sd.ID(),
+		Owner: sd.Owner(),
+		Mode:  entry.Mode,
+		Hold:  &hold,
+	}); err != nil {
+		return 0, err
+	}
+	entry.Holders[sd.Owner()] = hold
 	ls.
 		log.
 		Debug().
-		Str("descriptor", id).
-		Msg("checkRelease success")
+		Str("descriptor", sd.ID()).
+		Str("owner", sd.Owner()).
+		Int("count", hold.Count).
+		Msg("reentered")
+	return hold.Token, nil
+}
+
+// drop removes the owner from the holders of the lock and hands the
+// lock over to the processes waiting on it, as far as it can be shared.
+// The watchers of the lock are notified with an event of the given type.
+// The hold is kept if the drop can't be appended to the log. The mutex
+// of the shard of the lock must be held.
+func (ls *SimpleLockService) drop(id, owner string, typ EventType, now time.Time) error {
+	m := ls.shard(id)
+	entry, ok := m.LockMap[id]
+	if !ok {
+		return nil
+	}
+	if err := ls.record(Record{
+		Type:  RecordDrop,
+		ID:    id,
+		Owner: owner,
+	}); err != nil {
+		return err
+	}
+	hold := entry.Holders[owner]
+	delete(entry.Holders, owner)
+	if len(entry.Holders) == 0 {
+		delete(m.LockMap, id)
+	}
+	ls.notify(Event{
+		Type:  typ,
+		ID:    id,
+		Owner: owner,
+		Mode:  entry.Mode,
+		Token: hold.Token,
+		Free:  len(entry.Holders) == 0,
+		Time:  now,
+	})
+	ls.promote(id, now)
+	return nil
+}
+
+// promote grants the lock to the waiters at the head of its queue for
+// as long as they are compatible with the current holders. The mutex
+// of the shard of the lock must be held.
+func (ls *SimpleLockService) promote(id string, now time.Time) {
+	m := ls.shard(id)
+	for {
+		queue := m.Waiters[id]
+		if len(queue) == 0 {
+			return
+		}
+		w := queue[0]
+		if w.lapsed(now) {
+			ls.dequeue(id, w)
+			continue
+		}
+		if !ls.grantable(w.sd) {
+			return
+		}
+		if len(queue) == 1 {
+			delete(m.Waiters, id)
+		} else {
+			m.Waiters[id] = queue[1:]
+		}
+		// The session of the waiter may have lapsed while it
+		// was waiting, or the grant may not make it to the log,
+		// in which case the waiter is told why and the lock is
+		// passed on.
+		token, err := FencingToken(0), ls.attach(w.sd)
+		if err == nil {
+			token, err = ls.grant(w.sd, now, w.pounce)
+		}
+		if w.grant != nil {
+			w.grant <- handover{token: token, err: err}
+		}
+	}
+}
+
+// expire drops the holds on the lock whose lease has run out and
+// returns the number of holds dropped. Pounces on the lock that have
+// run out are dropped first, so that they aren't handed the lock.
+// A hold whose drop can't be appended to the log is kept, and dropped
+// the next time the lock is looked at. The mutex of the shard of the
+// lock must be held.
+func (ls *SimpleLockService) expire(id string, now time.Time) int {
+	ls.expirePounces(id, now)
+	expired := 0
+	for owner, hold := range ls.shard(id).LockMap[id].Holders {
+		if hold.Expired(now) {
+			ls.
+				log.
+				Debug().
+				Str("descriptor", id).
+				Str("owner", owner).
+				Msg("lease expired, released")
+			if ls.drop(id, owner, EventExpired, now) == nil {
+				expired++
+			}
+		}
+	}
+	return expired
+}
+
+// leaseOf returns the lease requested by the descriptor, falling
+// back to the lease of the service. A descriptor of a session that
+// requests no lease isn't leased at all, since the session bounds the
+// life of its holds and the heartbeats of the session keep them alive.
+func (ls *SimpleLockService) leaseOf(sd Descriptors) time.Duration {
+	if lsd, ok := sd.(LeasedDescriptors); ok && lsd.Lease() > 0 {
+		return lsd.Lease()
+	}
+	if sessionOf(sd) != "" {
+		return 0
+	}
+	return ls.lease
+}
+
+// modeOf returns the mode in which the descriptor requests the lock.
+func modeOf(sd Descriptors) LockMode {
+	if msd, ok := sd.(ModeDescriptors); ok && msd.Mode() == Shared {
+		return Shared
+	}
+	return Exclusive
+}
+
+// tokenMatches returns true if the descriptor presents no fencing
+// token or presents the token of the given hold.
+func tokenMatches(sd Descriptors, hold Hold) bool {
+	if tsd, ok := sd.(TokenDescriptors); ok && tsd.Token() != 0 {
+		return tsd.Token() == hold.Token
+	}
 	return true
 }
@@ -0,0 +1,1002 @@
+package lockservice
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+func TestSimpleLockService(t *testing.T) {
+	log := zerolog.New(os.Stdout).With().Logger().Level(zerolog.Disabled)
+
+	t.Run("expired lease frees the lock", func(t *testing.T) {
+		ls := NewSimpleLockService(log)
+
+		d := NewLeasedLockDescriptor("test", "owner1", 50*time.Millisecond)
+		_, got := ls.Acquire(d)
+		var want error
+		if got != want {
+			t.Errorf("acquire: got %q want %q", got, want)
+		}
+
+		_, got = ls.Acquire(NewLockDescriptor("test", "owner2"))
+		want = ErrFileacquired
+		if got != want {
+			t.Errorf("acquire: got %q want %q", got, want)
+		}
+
+		time.Sleep(100 * time.Millisecond)
+
+		if !ls.CheckReleased(d) {
+			t.Errorf("checkRelease: lock with an expired lease is still held")
+		}
+		if _, ok := ls.CheckAcquired(d); ok {
+			t.Errorf("checkAcquire: lock with an expired lease is still held")
+		}
+
+		got = ls.Release(d)
+		want = ErrCantReleaseFile
+		if got != want {
+			t.Errorf("release: got %q want %q", got, want)
+		}
+
+		_, got = ls.Acquire(NewLockDescriptor("test", "owner2"))
+		want = nil
+		if got != want {
+			t.Errorf("acquire: got %q want %q", got, want)
+		}
+	})
+
+	t.Run("extending a lease keeps the lock past it", func(t *testing.T) {
+		ls := NewSimpleLockService(log)
+		start := time.Now()
+
+		d := NewLeasedLockDescriptor("test", "owner1", 100*time.Millisecond)
+		if _, err := ls.acquireAt(d, start); err != nil {
+			t.Fatalf("acquire: %v", err)
+		}
+		got := ls.extendAt(NewLeasedLockDescriptor("test", "owner1", 200*time.Millisecond), start.Add(50*time.Millisecond))
+		var want error
+		if got != want {
+			t.Errorf("extend: got %q want %q", got, want)
+		}
+		if owner, _ := ls.checkAcquiredAt(d, start.Add(200*time.Millisecond)); owner != "owner1" {
+			t.Errorf("checkAcquire: got %q want %q", owner, "owner1")
+		}
+		if _, ok := ls.checkAcquiredAt(d, start.Add(250*time.Millisecond)); ok {
+			t.Errorf("checkAcquire: the extended lease didn't run out")
+		}
+
+		got = ls.extendAt(NewLockDescriptor("test", "owner1"), start.Add(300*time.Millisecond))
+		want = ErrFileUnlocked
+		if got != want {
+			t.Errorf("extend: got %q want %q", got, want)
+		}
+		if _, err := ls.acquireAt(NewLockDescriptor("test", "owner2"), start.Add(300*time.Millisecond)); err != nil {
+			t.Fatalf("acquire: %v", err)
+		}
+		got = ls.extendAt(NewLockDescriptor("test", "owner1"), start.Add(300*time.Millisecond))
+		want = ErrNotHeld
+		if got != want {
+			t.Errorf("extend: got %q want %q", got, want)
+		}
+	})
+
+	t.Run("reaper removes expired locks", func(t *testing.T) {
+		ls := NewSimpleLockService(log, WithDefaultLease(50*time.Millisecond))
+
+		_, _ = ls.Acquire(NewLockDescriptor("test1", "owner1"))
+		_, _ = ls.Acquire(NewLeasedLockDescriptor("test2", "owner1", time.Hour))
+
+		time.Sleep(100 * time.Millisecond)
+
+		got := ls.Reap()
+		want := 1
+		if got != want {
+			t.Errorf("reap: got %d want %d", got, want)
+		}
+		if _, ok := ls.CheckAcquired(NewLockDescriptor("test2", "")); !ok {
+			t.Errorf("checkAcquire: lock with a running lease was reaped")
+		}
+	})
+
+	t.Run("fencing tokens increase and are validated", func(t *testing.T) {
+		ls := NewSimpleLockService(log)
+
+		first, err := ls.Acquire(NewLockDescriptor("test", "owner1"))
+		if err != nil {
+			t.Fatalf("acquire: %v", err)
+		}
+		if err := ls.Release(NewLockDescriptor("test", "owner1")); err != nil {
+			t.Fatalf("release: %v", err)
+		}
+		second, err := ls.Acquire(NewLockDescriptor("test", "owner2"))
+		if err != nil {
+			t.Fatalf("acquire: %v", err)
+		}
+		if second <= first {
+			t.Errorf("acquire: token %d isn't greater than the previous token %d", second, first)
+		}
+
+		stale := &LockDescriptor{FileID: "test", UserID: "owner2", Fence: first}
+		if _, ok := ls.CheckAcquired(stale); ok {
+			t.Errorf("checkAcquire: stale token was accepted")
+		}
+		got := ls.Release(stale)
+		want := ErrStaleToken
+		if got != want {
+			t.Errorf("release: got %q want %q", got, want)
+		}
+
+		current := &LockDescriptor{FileID: "test", UserID: "owner2", Fence: second}
+		if _, ok := ls.CheckAcquired(current); !ok {
+			t.Errorf("checkAcquire: current token was rejected")
+		}
+		if err := ls.Release(current); err != nil {
+			t.Errorf("release: %v", err)
+		}
+	})
+	t.Run("waiters are granted the lock in order", func(t *testing.T) {
+		ls := NewSimpleLockService(log)
+
+		if _, err := ls.Acquire(NewLockDescriptor("test", "owner1")); err != nil {
+			t.Fatalf("acquire: %v", err)
+		}
+
+		granted := make(chan string, 2)
+		for _, owner := range []string{"owner2", "owner3"} {
+			owner := owner
+			go func() {
+				ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+				defer cancel()
+				if _, err := ls.AcquireWait(ctx, NewLockDescriptor("test", owner)); err != nil {
+					t.Errorf("acquireWait: %v", err)
+					return
+				}
+				granted <- owner
+			}()
+			// Let the waiter queue up before the next one.
+			time.Sleep(20 * time.Millisecond)
+		}
+
+		for i, owner := range []string{"owner1", "owner2"} {
+			if err := ls.Release(NewLockDescriptor("test", owner)); err != nil {
+				t.Fatalf("release: %v", err)
+			}
+			got := <-granted
+			want := []string{"owner2", "owner3"}[i]
+			if got != want {
+				t.Errorf("acquireWait: got %q want %q", got, want)
+			}
+		}
+	})
+
+	t.Run("waiter times out", func(t *testing.T) {
+		ls := NewSimpleLockService(log)
+
+		if _, err := ls.Acquire(NewLockDescriptor("test", "owner1")); err != nil {
+			t.Fatalf("acquire: %v", err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		_, got := ls.AcquireWait(ctx, NewLockDescriptor("test", "owner2"))
+		want := ErrAcquireTimeout
+		if got != want {
+			t.Errorf("acquireWait: got %q want %q", got, want)
+		}
+
+		// The timed out waiter must not be handed the lock.
+		if err := ls.Release(NewLockDescriptor("test", "owner1")); err != nil {
+			t.Fatalf("release: %v", err)
+		}
+		if !ls.CheckReleased(NewLockDescriptor("test", "")) {
+			t.Errorf("checkRelease: lock was handed over to a timed out waiter")
+		}
+	})
+
+	t.Run("waiter is granted the lock when the lease runs out", func(t *testing.T) {
+		ls := NewSimpleLockService(log)
+
+		if _, err := ls.Acquire(NewLeasedLockDescriptor("test", "owner1", 50*time.Millisecond)); err != nil {
+			t.Fatalf("acquire: %v", err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		if _, err := ls.AcquireWait(ctx, NewLockDescriptor("test", "owner2")); err != nil {
+			t.Errorf("acquireWait: %v", err)
+		}
+	})
+	t.Run("shared locks are held by many readers but not alongside a writer", func(t *testing.T) {
+		ls := NewSimpleLockService(log)
+
+		for _, owner := range []string{"reader1", "reader2"} {
+			if _, err := ls.Acquire(NewSharedLockDescriptor("test", owner)); err != nil {
+				t.Fatalf("acquire shared: %v", err)
+			}
+		}
+
+		_, got := ls.Acquire(NewLockDescriptor("test", "writer"))
+		want := ErrFileacquired
+		if got != want {
+			t.Errorf("acquire exclusive: got %q want %q", got, want)
+		}
+
+		mode, owners := ls.Holders(NewLockDescriptor("test", ""))
+		if mode != Shared || len(owners) != 2 || owners[0] != "reader1" || owners[1] != "reader2" {
+			t.Errorf("holders: got %q %q want %q [reader1 reader2]", mode, owners, Shared)
+		}
+
+		// A queued writer holds off new readers and is granted the
+		// lock once the current readers are done.
+		granted := make(chan error, 1)
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			_, err := ls.AcquireWait(ctx, NewLockDescriptor("test", "writer"))
+			granted <- err
+		}()
+		time.Sleep(20 * time.Millisecond)
+
+		_, got = ls.Acquire(NewSharedLockDescriptor("test", "reader3"))
+		if got != want {
+			t.Errorf("acquire shared: got %q want %q", got, want)
+		}
+
+		for _, owner := range []string{"reader1", "reader2"} {
+			if err := ls.Release(NewLockDescriptor("test", owner)); err != nil {
+				t.Fatalf("release: %v", err)
+			}
+		}
+		if err := <-granted; err != nil {
+			t.Fatalf("acquireWait: %v", err)
+		}
+		if owner, _ := ls.CheckAcquired(NewLockDescriptor("test", "")); owner != "writer" {
+			t.Errorf("checkAcquire: got %q want %q", owner, "writer")
+		}
+	})
+	t.Run("reentrant holds are released once per acquisition", func(t *testing.T) {
+		ls := NewSimpleLockService(log)
+
+		d := NewReentrantLockDescriptor("test", "owner1")
+		first, err := ls.Acquire(d)
+		if err != nil {
+			t.Fatalf("acquire: %v", err)
+		}
+		second, err := ls.Acquire(d)
+		if err != nil {
+			t.Fatalf("acquire reentrant: %v", err)
+		}
+		if first != second {
+			t.Errorf("acquire reentrant: got token %d want %d", second, first)
+		}
+
+		_, got := ls.Acquire(NewLockDescriptor("test", "owner1"))
+		want := ErrFileacquired
+		if got != want {
+			t.Errorf("acquire non-reentrant: got %q want %q", got, want)
+		}
+
+		if err := ls.Release(d); err != nil {
+			t.Fatalf("release: %v", err)
+		}
+		if ls.CheckReleased(d) {
+			t.Errorf("checkRelease: lock released while still held once")
+		}
+		if err := ls.Release(d); err != nil {
+			t.Fatalf("release: %v", err)
+		}
+		if !ls.CheckReleased(d) {
+			t.Errorf("checkRelease: lock still held after the last release")
+		}
+	})
+	t.Run("batches are acquired and released all or nothing", func(t *testing.T) {
+		ls := NewSimpleLockService(log)
+
+		if _, err := ls.Acquire(NewLockDescriptor("dst", "owner2")); err != nil {
+			t.Fatalf("acquire: %v", err)
+		}
+
+		batch := []Descriptors{
+			NewLockDescriptor("src", "owner1"),
+			NewLockDescriptor("dst", "owner1"),
+		}
+		_, got := ls.AcquireBatch(batch)
+		want := ErrFileacquired
+		if got != want {
+			t.Errorf("acquireBatch: got %q want %q", got, want)
+		}
+		if !ls.CheckReleased(NewLockDescriptor("src", "")) {
+			t.Errorf("checkRelease: failed batch left a lock behind")
+		}
+
+		if err := ls.Release(NewLockDescriptor("dst", "owner2")); err != nil {
+			t.Fatalf("release: %v", err)
+		}
+		tokens, err := ls.AcquireBatch(batch)
+		if err != nil {
+			t.Fatalf("acquireBatch: %v", err)
+		}
+		if len(tokens) != len(batch) {
+			t.Errorf("acquireBatch: got %d tokens want %d", len(tokens), len(batch))
+		}
+
+		got = ls.ReleaseBatch([]Descriptors{
+			NewLockDescriptor("src", "owner1"),
+			NewLockDescriptor("dst", "owner2"),
+		})
+		want = ErrUnauthorizedAccess
+		if got != want {
+			t.Errorf("releaseBatch: got %q want %q", got, want)
+		}
+		if ls.CheckReleased(NewLockDescriptor("src", "")) {
+			t.Errorf("checkRelease: failed batch released a lock")
+		}
+
+		if err := ls.ReleaseBatch(batch); err != nil {
+			t.Errorf("releaseBatch: %v", err)
+		}
+
+		_, got = ls.AcquireBatch([]Descriptors{
+			NewLockDescriptor("src", "owner1"),
+			NewLockDescriptor("src", "owner1"),
+		})
+		want = ErrDuplicateDescriptor
+		if got != want {
+			t.Errorf("acquireBatch: got %q want %q", got, want)
+		}
+	})
+	t.Run("semaphores hand out up to their limit of permits", func(t *testing.T) {
+		ls := NewSimpleLockService(log)
+
+		if err := ls.AcquirePermits(NewLockDescriptor("jobs", "owner1"), 3, 5); err != nil {
+			t.Fatalf("acquirePermits: %v", err)
+		}
+		if err := ls.AcquirePermits(NewLockDescriptor("jobs", "owner2"), 2, 5); err != nil {
+			t.Fatalf("acquirePermits: %v", err)
+		}
+
+		got := ls.AcquirePermits(NewLockDescriptor("jobs", "owner3"), 1, 5)
+		want := ErrNoPermits
+		if got != want {
+			t.Errorf("acquirePermits: got %q want %q", got, want)
+		}
+		got = ls.AcquirePermits(NewLockDescriptor("jobs", "owner3"), 1, 6)
+		want = ErrSemaphoreLimit
+		if got != want {
+			t.Errorf("acquirePermits: got %q want %q", got, want)
+		}
+		got = ls.ReleasePermits(NewLockDescriptor("jobs", "owner2"), 3)
+		want = ErrPermitsNotHeld
+		if got != want {
+			t.Errorf("releasePermits: got %q want %q", got, want)
+		}
+
+		if err := ls.ReleasePermits(NewLockDescriptor("jobs", "owner1"), 2); err != nil {
+			t.Fatalf("releasePermits: %v", err)
+		}
+		status := ls.CheckSemaphore(NewLockDescriptor("jobs", ""))
+		if status.Available != 2 || status.Holders["owner1"] != 1 || status.Holders["owner2"] != 2 {
+			t.Errorf("checkSemaphore: got %+v", status)
+		}
+	})
+	t.Run("watchers are notified of acquisitions and releases", func(t *testing.T) {
+		ls := NewSimpleLockService(log)
+
+		events, cancel := ls.Watch(NewObjectDescriptor("test"))
+		defer cancel()
+
+		if _, err := ls.Acquire(NewLockDescriptor("test", "owner1")); err != nil {
+			t.Fatalf("acquire: %v", err)
+		}
+		if err := ls.Release(NewLockDescriptor("test", "owner1")); err != nil {
+			t.Fatalf("release: %v", err)
+		}
+
+		for _, want := range []Event{
+			{Type: EventAcquired, ID: "test", Owner: "owner1", Free: false},
+			{Type: EventReleased, ID: "test", Owner: "owner1", Free: true},
+		} {
+			got := <-events
+			if got.Type != want.Type || got.ID != want.ID || got.Owner != want.Owner || got.Free != want.Free {
+				t.Errorf("watch: got %+v want %+v", got, want)
+			}
+		}
+
+		cancel()
+		if _, ok := <-events; ok {
+			t.Errorf("watch: channel still open after cancel")
+		}
+	})
+	t.Run("pouncer is handed the lock on release", func(t *testing.T) {
+		ls := NewSimpleLockService(log)
+
+		if _, err := ls.Acquire(NewLockDescriptor("test", "owner1")); err != nil {
+			t.Fatalf("acquire: %v", err)
+		}
+		events, cancel := ls.Watch(NewObjectDescriptor("test"))
+		defer cancel()
+
+		token, err := ls.Pounce(NewLockDescriptor("test", "owner2"))
+		if err != nil || token != 0 {
+			t.Fatalf("pounce: got %d, %v want 0, <nil>", token, err)
+		}
+		if err := ls.Release(NewLockDescriptor("test", "owner1")); err != nil {
+			t.Fatalf("release: %v", err)
+		}
+
+		if owner, _ := ls.CheckAcquired(NewLockDescriptor("test", "")); owner != "owner2" {
+			t.Errorf("checkAcquire: got %q want %q", owner, "owner2")
+		}
+		<-events // released by owner1
+		if ev := <-events; ev.Type != EventAcquired || !ev.Pounced || ev.Owner != "owner2" {
+			t.Errorf("watch: got %+v want a pounced acquisition by owner2", ev)
+		}
+
+		got := ls.Unpounce(NewLockDescriptor("test", "owner2"))
+		want := ErrNotPouncing
+		if got != want {
+			t.Errorf("unpounce: got %q want %q", got, want)
+		}
+	})
+	t.Run("pounce runs out with its lease", func(t *testing.T) {
+		ls := NewSimpleLockService(log)
+		start := time.Now()
+
+		if _, err := ls.acquireAt(NewLeasedLockDescriptor("test", "owner1", time.Hour), start); err != nil {
+			t.Fatalf("acquire: %v", err)
+		}
+		if _, err := ls.pounceAt(NewLeasedLockDescriptor("test", "owner2", 100*time.Millisecond), start); err != nil {
+			t.Fatalf("pounce: %v", err)
+		}
+		ls.reapAt(start.Add(200 * time.Millisecond))
+
+		got := ls.Unpounce(NewLockDescriptor("test", "owner2"))
+		want := ErrNotPouncing
+		if got != want {
+			t.Errorf("unpounce: got %q want %q", got, want)
+		}
+		if err := ls.releaseAt(NewLockDescriptor("test", "owner1"), start.Add(300*time.Millisecond)); err != nil {
+			t.Fatalf("release: %v", err)
+		}
+		if owner, ok := ls.CheckAcquired(NewLockDescriptor("test", "")); ok {
+			t.Errorf("checkAcquire: got %q want the lock to be free", owner)
+		}
+	})
+	t.Run("pounce is dropped with its session", func(t *testing.T) {
+		ls := NewSimpleLockService(log)
+
+		if _, err := ls.Acquire(NewLockDescriptor("test", "owner1")); err != nil {
+			t.Fatalf("acquire: %v", err)
+		}
+		session, err := ls.CreateSession(0)
+		if err != nil {
+			t.Fatalf("createSession: %v", err)
+		}
+		d := NewLockDescriptor("test", "owner2")
+		d.SessionID = session.ID
+		if _, err := ls.Pounce(d); err != nil {
+			t.Fatalf("pounce: %v", err)
+		}
+		if err := ls.EndSession(session.ID); err != nil {
+			t.Fatalf("endSession: %v", err)
+		}
+
+		got := ls.Unpounce(d)
+		want := ErrNotPouncing
+		if got != want {
+			t.Errorf("unpounce: got %q want %q", got, want)
+		}
+		if _, err := ls.Pounce(d); err != ErrSessionNotFound {
+			t.Errorf("pounce: got %v want %v", err, ErrSessionNotFound)
+		}
+	})
+	t.Run("changes that can't be logged aren't made", func(t *testing.T) {
+		wal, err := OpenWAL(filepath.Join(t.TempDir(), "wal"), SyncAlways)
+		if err != nil {
+			t.Fatalf("openWAL: %v", err)
+		}
+		ls := NewSimpleLockService(log, WithLog(wal))
+		if _, err := ls.Acquire(NewLockDescriptor("test", "owner1")); err != nil {
+			t.Fatalf("acquire: %v", err)
+		}
+		// Appending to a closed log fails.
+		wal.Close()
+
+		if _, err := ls.Acquire(NewLockDescriptor("other", "owner1")); err == nil {
+			t.Errorf("acquire: got <nil> want an error")
+		}
+		if !ls.CheckReleased(NewLockDescriptor("other", "")) {
+			t.Errorf("checkRelease: got false want true")
+		}
+		if err := ls.Release(NewLockDescriptor("test", "owner1")); err == nil {
+			t.Errorf("release: got <nil> want an error")
+		}
+		if owner, _ := ls.CheckAcquired(NewLockDescriptor("test", "")); owner != "owner1" {
+			t.Errorf("checkAcquire: got %q want %q", owner, "owner1")
+		}
+		if err := ls.AcquirePermits(NewLockDescriptor("jobs", "owner1"), 1, 1); err == nil {
+			t.Errorf("acquirePermits: got <nil> want an error")
+		}
+		if got := ls.CheckSemaphore(NewLockDescriptor("jobs", "")).Limit; got != 0 {
+			t.Errorf("checkSemaphore: got limit %d want %d", got, 0)
+		}
+	})
+	t.Run("batches that can't be logged are undone without a trace", func(t *testing.T) {
+		wal := &failingLog{fail: 4}
+		ls := NewSimpleLockService(log, WithLog(wal))
+		if _, err := ls.Acquire(NewReentrantLockDescriptor("a", "owner1")); err != nil {
+			t.Fatalf("acquire: %v", err)
+		}
+		events, cancel := ls.Watch(NewObjectDescriptor("b"))
+		defer cancel()
+
+		// The grant of "c" is the fourth append, after the reentry
+		// of "a" and the grant of "b".
+		_, err := ls.AcquireBatch([]Descriptors{
+			NewReentrantLockDescriptor("a", "owner1"),
+			NewReentrantLockDescriptor("b", "owner1"),
+			NewReentrantLockDescriptor("c", "owner1"),
+		})
+		if err != errLogFailed {
+			t.Errorf("acquireBatch: got %v want %v", err, errLogFailed)
+		}
+		if !ls.CheckReleased(NewLockDescriptor("b", "")) {
+			t.Errorf("checkRelease: failed batch left a lock behind")
+		}
+		select {
+		case ev := <-events:
+			t.Errorf("watch: got %+v want no event", ev)
+		default:
+		}
+
+		// The hold of "a" is back to the single acquisition.
+		if err := ls.Release(NewLockDescriptor("a", "owner1")); err != nil {
+			t.Fatalf("release: %v", err)
+		}
+		if !ls.CheckReleased(NewLockDescriptor("a", "")) {
+			t.Errorf("checkRelease: got false want true")
+		}
+	})
+	t.Run("locks are recovered from the write-ahead log", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "wal")
+		wal, err := OpenWAL(path, SyncAlways)
+		if err != nil {
+			t.Fatalf("openWAL: %v", err)
+		}
+		ls := NewSimpleLockService(log, WithLog(wal))
+
+		token, err := ls.Acquire(NewReentrantLockDescriptor("test", "owner1"))
+		if err != nil {
+			t.Fatalf("acquire: %v", err)
+		}
+		if _, err := ls.Acquire(NewReentrantLockDescriptor("test", "owner1")); err != nil {
+			t.Fatalf("acquire: %v", err)
+		}
+		if _, err := ls.Acquire(NewLockDescriptor("other", "owner2")); err != nil {
+			t.Fatalf("acquire: %v", err)
+		}
+		if err := ls.Release(NewLockDescriptor("other", "owner2")); err != nil {
+			t.Fatalf("release: %v", err)
+		}
+		if err := ls.AcquirePermits(NewLockDescriptor("jobs", "owner1"), 2, 5); err != nil {
+			t.Fatalf("acquirePermits: %v", err)
+		}
+		if err := ls.Close(); err != nil {
+			t.Fatalf("close: %v", err)
+		}
+
+		// A record torn by a crash is discarded on replay.
+		file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			t.Fatalf("open: %v", err)
+		}
+		file.WriteString(`{"type":"hold","id":"te`)
+		file.Close()
+
+		wal, err = OpenWAL(path, SyncAlways)
+		if err != nil {
+			t.Fatalf("openWAL: %v", err)
+		}
+		defer wal.Close()
+		ls = NewSimpleLockService(log, WithLog(wal))
+		if err := ls.Recover(); err != nil {
+			t.Fatalf("recover: %v", err)
+		}
+
+		owner, _ := ls.CheckAcquired(&LockDescriptor{FileID: "test", Fence: token})
+		if owner != "owner1" {
+			t.Errorf("checkAcquire: got %q want %q", owner, "owner1")
+		}
+		if !ls.CheckReleased(NewLockDescriptor("other", "")) {
+			t.Errorf("checkRelease: got false want true")
+		}
+		if got := ls.CheckSemaphore(NewLockDescriptor("jobs", "")).Available; got != 3 {
+			t.Errorf("checkSemaphore: got %d available want %d", got, 3)
+		}
+
+		// The recovered hold keeps its count and the tokens
+		// carry on from where they were.
+		if err := ls.Release(NewLockDescriptor("test", "owner1")); err != nil {
+			t.Fatalf("release: %v", err)
+		}
+		if ls.CheckReleased(NewLockDescriptor("test", "")) {
+			t.Errorf("checkRelease: got true want false")
+		}
+		if err := ls.Release(NewLockDescriptor("test", "owner1")); err != nil {
+			t.Fatalf("release: %v", err)
+		}
+		next, err := ls.Acquire(NewLockDescriptor("other", "owner3"))
+		if err != nil || next != 2 {
+			t.Errorf("acquire: got %d, %v want 2, <nil>", next, err)
+		}
+	})
+	t.Run("sessions are recovered from the holds in the write-ahead log", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "wal")
+		wal, err := OpenWAL(path, SyncAlways)
+		if err != nil {
+			t.Fatalf("openWAL: %v", err)
+		}
+		ls := NewSimpleLockService(log, WithLog(wal))
+		session, err := ls.CreateSession(time.Hour)
+		if err != nil {
+			t.Fatalf("createSession: %v", err)
+		}
+		d := NewLockDescriptor("test", "owner1")
+		d.SessionID = session.ID
+		if _, err := ls.Acquire(d); err != nil {
+			t.Fatalf("acquire: %v", err)
+		}
+		if err := ls.Close(); err != nil {
+			t.Fatalf("close: %v", err)
+		}
+
+		wal, err = OpenWAL(path, SyncAlways)
+		if err != nil {
+			t.Fatalf("openWAL: %v", err)
+		}
+		defer wal.Close()
+		ls = NewSimpleLockService(log, WithLog(wal))
+		if err := ls.Recover(); err != nil {
+			t.Fatalf("recover: %v", err)
+		}
+
+		if owner, _ := ls.CheckAcquired(NewLockDescriptor("test", "")); owner != "owner1" {
+			t.Errorf("checkAcquire: got %q want %q", owner, "owner1")
+		}
+		recovered, err := ls.Heartbeat(session.ID)
+		if err != nil {
+			t.Fatalf("heartbeat: %v", err)
+		}
+		if recovered.TTL != DefaultSessionTTL {
+			t.Errorf("heartbeat: got TTL %v want %v", recovered.TTL, DefaultSessionTTL)
+		}
+		if err := ls.EndSession(session.ID); err != nil {
+			t.Fatalf("endSession: %v", err)
+		}
+		if !ls.CheckReleased(NewLockDescriptor("test", "")) {
+			t.Errorf("checkRelease: got false want true")
+		}
+	})
+
+	t.Run("restored snapshots are recovered from the write-ahead log", func(t *testing.T) {
+		source := NewSimpleLockService(log)
+		if _, err := source.Acquire(NewLockDescriptor("test", "owner1")); err != nil {
+			t.Fatalf("acquire: %v", err)
+		}
+		if err := source.AcquirePermits(NewLockDescriptor("jobs", "owner1"), 2, 5); err != nil {
+			t.Fatalf("acquirePermits: %v", err)
+		}
+
+		path := filepath.Join(t.TempDir(), "wal")
+		wal, err := OpenWAL(path, SyncAlways)
+		if err != nil {
+			t.Fatalf("openWAL: %v", err)
+		}
+		ls := NewSimpleLockService(log, WithLog(wal))
+		if _, err := ls.Acquire(NewLockDescriptor("other", "owner2")); err != nil {
+			t.Fatalf("acquire: %v", err)
+		}
+		if err := ls.Restore(source.Snapshot()); err != nil {
+			t.Fatalf("restore: %v", err)
+		}
+		if err := ls.Close(); err != nil {
+			t.Fatalf("close: %v", err)
+		}
+
+		wal, err = OpenWAL(path, SyncAlways)
+		if err != nil {
+			t.Fatalf("openWAL: %v", err)
+		}
+		defer wal.Close()
+		ls = NewSimpleLockService(log, WithLog(wal))
+		if err := ls.Recover(); err != nil {
+			t.Fatalf("recover: %v", err)
+		}
+
+		if owner, _ := ls.CheckAcquired(NewLockDescriptor("test", "")); owner != "owner1" {
+			t.Errorf("checkAcquire: got %q want %q", owner, "owner1")
+		}
+		if !ls.CheckReleased(NewLockDescriptor("other", "")) {
+			t.Errorf("checkRelease: got false want true")
+		}
+		if got := ls.CheckSemaphore(NewLockDescriptor("jobs", "")).Available; got != 3 {
+			t.Errorf("checkSemaphore: got %d available want %d", got, 3)
+		}
+	})
+
+	t.Run("locks are recovered from the latest valid snapshot", func(t *testing.T) {
+		dir := t.TempDir()
+		store, err := OpenSnapshotStore(filepath.Join(dir, "snapshots"))
+		if err != nil {
+			t.Fatalf("openSnapshotStore: %v", err)
+		}
+		wal, err := OpenWAL(filepath.Join(dir, "wal"), SyncAlways)
+		if err != nil {
+			t.Fatalf("openWAL: %v", err)
+		}
+		ls := NewSimpleLockService(log, WithLog(wal), WithSnapshots(store))
+
+		if _, err := ls.Acquire(NewLockDescriptor("test", "owner1")); err != nil {
+			t.Fatalf("acquire: %v", err)
+		}
+		if err := ls.Checkpoint(); err != nil {
+			t.Fatalf("checkpoint: %v", err)
+		}
+		// Changes made after the checkpoint are only in the log.
+		if _, err := ls.Acquire(NewLockDescriptor("other", "owner2")); err != nil {
+			t.Fatalf("acquire: %v", err)
+		}
+		if err := wal.Close(); err != nil {
+			t.Fatalf("close: %v", err)
+		}
+
+		// A corrupt snapshot newer than the valid one is discarded.
+		corrupt := filepath.Join(dir, "snapshots", "snapshot-"+strconv.FormatInt(time.Now().Add(time.Hour).UnixNano(), 10)+".snap")
+		if err := ioutil.WriteFile(corrupt, []byte(snapshotMagic+"garbage!"), 0644); err != nil {
+			t.Fatalf("writeFile: %v", err)
+		}
+
+		wal, err = OpenWAL(filepath.Join(dir, "wal"), SyncAlways)
+		if err != nil {
+			t.Fatalf("openWAL: %v", err)
+		}
+		ls = NewSimpleLockService(log, WithLog(wal), WithSnapshots(store))
+		if err := ls.Recover(); err != nil {
+			t.Fatalf("recover: %v", err)
+		}
+		for id, want := range map[string]string{"test": "owner1", "other": "owner2"} {
+			if got, _ := ls.CheckAcquired(NewLockDescriptor(id, "")); got != want {
+				t.Errorf("checkAcquire %s: got %q want %q", id, got, want)
+			}
+		}
+		if _, err := os.Stat(corrupt); !os.IsNotExist(err) {
+			t.Errorf("corrupt snapshot: got %v want it removed", err)
+		}
+
+		// A snapshot streamed from the service seeds another one.
+		var buf bytes.Buffer
+		if err := EncodeSnapshot(&buf, ls.Snapshot()); err != nil {
+			t.Fatalf("encodeSnapshot: %v", err)
+		}
+		if err := ls.Close(); err != nil {
+			t.Fatalf("close: %v", err)
+		}
+		snap, err := DecodeSnapshot(&buf)
+		if err != nil {
+			t.Fatalf("decodeSnapshot: %v", err)
+		}
+		seeded := NewSimpleLockService(log)
+		if err := seeded.Restore(snap); err != nil {
+			t.Fatalf("restore: %v", err)
+		}
+		if got, _ := seeded.CheckAcquired(NewLockDescriptor("other", "")); got != "owner2" {
+			t.Errorf("checkAcquire: got %q want %q", got, "owner2")
+		}
+	})
+	t.Run("sessions are restored along with their holds", func(t *testing.T) {
+		ls := NewSimpleLockService(log)
+		session, err := ls.CreateSession(time.Hour)
+		if err != nil {
+			t.Fatalf("createSession: %v", err)
+		}
+		d := NewLockDescriptor("test", "owner1")
+		d.SessionID = session.ID
+		if _, err := ls.Acquire(d); err != nil {
+			t.Fatalf("acquire: %v", err)
+		}
+		snap := ls.Snapshot()
+
+		seeded := NewSimpleLockService(log)
+		if err := seeded.Restore(snap); err != nil {
+			t.Fatalf("restore: %v", err)
+		}
+		if _, err := seeded.Heartbeat(session.ID); err != nil {
+			t.Fatalf("heartbeat: %v", err)
+		}
+		if err := seeded.EndSession(session.ID); err != nil {
+			t.Fatalf("endSession: %v", err)
+		}
+		if !seeded.CheckReleased(NewLockDescriptor("test", "")) {
+			t.Errorf("checkRelease: got false want true")
+		}
+
+		// Holds of sessions missing from the snapshot are released.
+		snap = ls.Snapshot()
+		snap.Sessions = nil
+		orphaned := NewSimpleLockService(log)
+		if err := orphaned.Restore(snap); err != nil {
+			t.Fatalf("restore: %v", err)
+		}
+		if !orphaned.CheckReleased(NewLockDescriptor("test", "")) {
+			t.Errorf("checkRelease: got false want true")
+		}
+	})
+
+	t.Run("locks are released when their session lapses", func(t *testing.T) {
+		ls := NewSimpleLockService(log)
+		session, err := ls.CreateSession(100 * time.Millisecond)
+		if err != nil {
+			t.Fatalf("createSession: %v", err)
+		}
+
+		d := NewLockDescriptor("test", "owner1")
+		d.SessionID = session.ID
+		if _, err := ls.Acquire(d); err != nil {
+			t.Fatalf("acquire: %v", err)
+		}
+		if err := ls.AcquirePermits(d, 1, 2); err != nil {
+			t.Fatalf("acquirePermits: %v", err)
+		}
+
+		// Heartbeats keep the session alive past its TTL.
+		for i := 0; i < 3; i++ {
+			time.Sleep(50 * time.Millisecond)
+			if _, err := ls.Heartbeat(session.ID); err != nil {
+				t.Fatalf("heartbeat: %v", err)
+			}
+		}
+		ls.Reap()
+		if owner, _ := ls.CheckAcquired(d); owner != "owner1" {
+			t.Errorf("checkAcquire: got %q want %q", owner, "owner1")
+		}
+
+		time.Sleep(150 * time.Millisecond)
+		ls.Reap()
+		if !ls.CheckReleased(d) {
+			t.Errorf("checkRelease: lock of a lapsed session is still held")
+		}
+		if got := ls.CheckSemaphore(d).Available; got != 0 {
+			t.Errorf("checkSemaphore: got %d want %d", got, 0)
+		}
+
+		_, got := ls.Heartbeat(session.ID)
+		want := ErrSessionNotFound
+		if got != want {
+			t.Errorf("heartbeat: got %q want %q", got, want)
+		}
+		_, got = ls.Acquire(d)
+		if got != want {
+			t.Errorf("acquire: got %q want %q", got, want)
+		}
+	})
+
+	t.Run("ending a session releases its locks", func(t *testing.T) {
+		ls := NewSimpleLockService(log)
+		session, err := ls.CreateSession(0)
+		if err != nil {
+			t.Fatalf("createSession: %v", err)
+		}
+
+		d := NewLockDescriptor("test", "owner1")
+		d.SessionID = session.ID
+		if _, err := ls.Acquire(d); err != nil {
+			t.Fatalf("acquire: %v", err)
+		}
+		// Locks acquired outside of the session are left alone.
+		if _, err := ls.Acquire(NewLockDescriptor("other", "owner1")); err != nil {
+			t.Fatalf("acquire: %v", err)
+		}
+
+		waiter := NewLockDescriptor("test", "owner2")
+		done := make(chan FencingToken)
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			token, _ := ls.AcquireWait(ctx, waiter)
+			done <- token
+		}()
+		time.Sleep(20 * time.Millisecond)
+
+		if err := ls.EndSession(session.ID); err != nil {
+			t.Fatalf("endSession: %v", err)
+		}
+		if token := <-done; token != 2 {
+			t.Errorf("acquireWait: got %d want %d", token, 2)
+		}
+		if ls.CheckReleased(NewLockDescriptor("other", "")) {
+			t.Errorf("checkRelease: lock outside of the session was released")
+		}
+	})
+}
+
+// errLogFailed is the error of the failing appends of a failingLog.
+const errLogFailed = Error("log failed")
+
+// failingLog is a log that keeps no records and fails the append with
+// the given number, counting from one.
+type failingLog struct {
+	Log
+	appends int
+	fail    int
+}
+
+func (l *failingLog) Append(Record) error {
+	l.appends++
+	if l.appends == l.fail {
+		return errLogFailed
+	}
+	return nil
+}
+
+// benchmarkShards runs the benchmark against a single shard, which
+// serializes all the calls as a global mutex would, and against the
+// default number of shards. Run with -cpu 1,2,4,8 to see the calls
+// scale with GOMAXPROCS.
+func benchmarkShards(b *testing.B, bench func(b *testing.B, ls *SimpleLockService)) {
+	log := zerolog.New(os.Stdout).With().Logger().Level(zerolog.Disabled)
+	for _, shards := range []int{1, DefaultShards} {
+		b.Run("shards="+strconv.Itoa(shards), func(b *testing.B) {
+			bench(b, NewSimpleLockService(log, WithShards(shards)))
+		})
+	}
+}
+
+func BenchmarkAcquireRelease(b *testing.B) {
+	benchmarkShards(b, func(b *testing.B, ls *SimpleLockService) {
+		var workers int64
+		b.RunParallel(func(pb *testing.PB) {
+			// Every worker cycles through locks of its own, so
+			// that the calls only contend on the lock table.
+			worker := strconv.FormatInt(atomic.AddInt64(&workers, 1), 10)
+			i := 0
+			for pb.Next() {
+				d := NewLockDescriptor(worker+"/"+strconv.Itoa(i%1024), worker)
+				if _, err := ls.Acquire(d); err != nil {
+					b.Fatalf("acquire: %v", err)
+				}
+				if err := ls.Release(d); err != nil {
+					b.Fatalf("release: %v", err)
+				}
+				i++
+			}
+		})
+	})
+}
+
+func BenchmarkCheckAcquired(b *testing.B) {
+	benchmarkShards(b, func(b *testing.B, ls *SimpleLockService) {
+		for i := 0; i < 1024; i++ {
+			ls.Acquire(NewLockDescriptor("test"+strconv.Itoa(i), "owner1"))
+		}
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			i := 0
+			for pb.Next() {
+				if _, ok := ls.CheckAcquired(NewLockDescriptor("test"+strconv.Itoa(i%1024), "")); !ok {
+					b.Fatalf("checkAcquire: lock not held")
+				}
+				i++
+			}
+		})
+	})
+}
@@ -0,0 +1,489 @@
+package lockservice
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// snapshotMagic opens every encoded snapshot.
+const snapshotMagic = "LKSNAP1\n"
+
+// snapshotsKept is the number of snapshots kept by a SnapshotStore,
+// so that a corrupt latest snapshot can fall back to an older one.
+const snapshotsKept = 2
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Snapshot is the full lock table of a lockservice at a point in time,
+// along with the sessions that its holds belong to.
+type Snapshot struct {
+	Time       time.Time                 `json:"time"`
+	Locks      map[string]LockMapEntry   `json:"locks"`
+	Tokens     map[string]FencingToken   `json:"tokens"`
+	Semaphores map[string]SemaphoreEntry `json:"semaphores"`
+	Sessions   map[string]Session        `json:"sessions,omitempty"`
+}
+
+// EncodeSnapshot writes the snapshot to w. The snapshot is encoded as
+// gzipped JSON behind a magic header and followed by the CRC-32C
+// checksum of the compressed bytes, so that it can be streamed.
+func EncodeSnapshot(w io.Writer, snap Snapshot) error {
+	if _, err := io.WriteString(w, snapshotMagic); err != nil {
+		return err
+	}
+	crc := crc32.New(crcTable)
+	zw := gzip.NewWriter(io.MultiWriter(w, crc))
+	if err := json.NewEncoder(zw).Encode(snap); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	return binary.Write(w, binary.BigEndian, crc.Sum32())
+}
+
+// DecodeSnapshot reads a snapshot written by EncodeSnapshot from r. A
+// snapshot that is truncated or doesn't match its checksum is reported
+// as ErrCorruptSnapshot.
+func DecodeSnapshot(r io.Reader) (Snapshot, error) {
+	var snap Snapshot
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return snap, err
+	}
+	if len(data) < len(snapshotMagic)+4 || string(data[:len(snapshotMagic)]) != snapshotMagic {
+		return snap, ErrCorruptSnapshot
+	}
+	body := data[len(snapshotMagic) : len(data)-4]
+	sum := binary.BigEndian.Uint32(data[len(data)-4:])
+	if crc32.Checksum(body, crcTable) != sum {
+		return snap, ErrCorruptSnapshot
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return snap, ErrCorruptSnapshot
+	}
+	if err := json.NewDecoder(zr).Decode(&snap); err != nil {
+		return snap, ErrCorruptSnapshot
+	}
+	return snap, nil
+}
+
+// SnapshotStore keeps the snapshots of a lockservice in a directory.
+type SnapshotStore struct {
+	dir string
+}
+
+// OpenSnapshotStore opens the store of snapshots in the given
+// directory, creating the directory if it doesn't exist.
+func OpenSnapshotStore(dir string) (*SnapshotStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &SnapshotStore{
+		dir: dir,
+	}, nil
+}
+
+// Save writes the snapshot to the store. The snapshot is written to a
+// temporary file that is only renamed into place once it is synced, so
+// that a crash never leaves a partial snapshot behind. Older snapshots
+// are removed, except for the most recent ones.
+func (s *SnapshotStore) Save(snap Snapshot) error {
+	tmp, err := ioutil.TempFile(s.dir, "snapshot-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := EncodeSnapshot(tmp, snap); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	name := filepath.Join(s.dir, "snapshot-"+strconv.FormatInt(snap.Time.UnixNano(), 10)+".snap")
+	if err := os.Rename(tmp.Name(), name); err != nil {
+		return err
+	}
+
+	names, err := s.names()
+	if err != nil {
+		return err
+	}
+	for len(names) > snapshotsKept {
+		os.Remove(names[len(names)-1])
+		names = names[:len(names)-1]
+	}
+	return nil
+}
+
+// Latest returns the most recent valid snapshot in the store and false
+// if there is none. Corrupt snapshots found on the way are removed.
+func (s *SnapshotStore) Latest() (Snapshot, bool, error) {
+	names, err := s.names()
+	if err != nil {
+		return Snapshot{}, false, err
+	}
+	for _, name := range names {
+		file, err := os.Open(name)
+		if err != nil {
+			return Snapshot{}, false, err
+		}
+		snap, err := DecodeSnapshot(file)
+		file.Close()
+		if err == ErrCorruptSnapshot {
+			os.Remove(name)
+			continue
+		}
+		if err != nil {
+			return Snapshot{}, false, err
+		}
+		return snap, true, nil
+	}
+	return Snapshot{}, false, nil
+}
+
+// names returns the paths of the snapshots in the store, the most
+// recent first.
+func (s *SnapshotStore) names() ([]string, error) {
+	names, err := filepath.Glob(filepath.Join(s.dir, "snapshot-*.snap"))
+	if err != nil {
+		return nil, err
+	}
+	stamp := func(name string) int64 {
+		base := strings.TrimSuffix(filepath.Base(name), ".snap")
+		n, _ := strconv.ParseInt(strings.TrimPrefix(base, "snapshot-"), 10, 64)
+		return n
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return stamp(names[i]) > stamp(names[j])
+	})
+	return names, nil
+}
+
+// WithSnapshots makes the service keep snapshots of its lock table in
+// the given store. Snapshots are taken by Checkpoint.
+func WithSnapshots(store *SnapshotStore) Option {
+	return func(ls *SimpleLockService) {
+		ls.snapshots = store
+	}
+}
+
+// Snapshot returns a copy of the lock table of the service.
+func (ls *SimpleLockService) Snapshot() Snapshot {
+	defer ls.lockAll()()
+	return ls.snapshot(time.Now())
+}
+
+// Restore replaces the lock table and the sessions of the service with
+// the snapshot, such as one streamed from another node to seed this one.
+// Waiters and watchers are kept, and fencing tokens never go back. Holds
+// of sessions that aren't in the snapshot are released. The restored
+// table is saved as the latest snapshot of the service if it keeps
+// snapshots, and written to its log in place of the old records
+// otherwise.
+func (ls *SimpleLockService) Restore(snap Snapshot) error {
+	defer ls.lockAll()()
+	ls.restore(snap)
+	ls.bindSessions(false, time.Now())
+	if ls.snapshots == nil {
+		return ls.rewrite()
+	}
+	return ls.checkpoint()
+}
+
+// Export returns a snapshot of the locks and semaphores whose IDs are
+// picked by the given function, such as the ones that move to another
+// node. The locks are kept until they are evicted.
+func (ls *SimpleLockService) Export(pick func(id string) bool) Snapshot {
+	defer ls.lockAll()()
+	snap := ls.snapshot(time.Now())
+	for id := range snap.Locks {
+		if !pick(id) {
+			delete(snap.Locks, id)
+		}
+	}
+	for id := range snap.Tokens {
+		if !pick(id) {
+			delete(snap.Tokens, id)
+		}
+	}
+	for id := range snap.Semaphores {
+		if !pick(id) {
+			delete(snap.Semaphores, id)
+		}
+	}
+	// Sessions live on the node they were created on.
+	snap.Sessions = nil
+	return snap
+}
+
+// Evict removes the locks and semaphores of the snapshot, once they
+// have been handed over to another node. Watchers aren't notified,
+// since the locks live on elsewhere, and processes waiting on the
+// locks give up once their wait runs out. The locks that are left are
+// kept if the eviction can't be appended to the log.
+func (ls *SimpleLockService) Evict(snap Snapshot) error {
+	defer ls.lockAll()()
+	for id, entry := range snap.Locks {
+		for owner := range entry.Holders {
+			if err := ls.record(Record{
+				Type:  RecordDrop,
+				ID:    id,
+				Owner: owner,
+			}); err != nil {
+				return err
+			}
+		}
+		delete(ls.shard(id).LockMap, id)
+	}
+	for id := range snap.Tokens {
+		delete(ls.shard(id).Tokens, id)
+	}
+	for id, entry := range snap.Semaphores {
+		for owner := range entry.Holders {
+			if err := ls.record(Record{
+				Type:  RecordDropPermits,
+				ID:    id,
+				Owner: owner,
+			}); err != nil {
+				return err
+			}
+		}
+		delete(ls.shard(id).Semaphores, id)
+	}
+	return nil
+}
+
+// Import adds the locks and semaphores of the snapshot, handed over by
+// another node, to the lock table. Fencing tokens never go back, and are
+// taken over first so that no token of the snapshot is issued again. A
+// lock is only added once all of its holds are in the log, and the import
+// stops at the first one that can't be appended. Importing the same
+// snapshot again picks up where it stopped.
+func (ls *SimpleLockService) Import(snap Snapshot) error {
+	defer ls.lockAll()()
+	for id, token := range snap.Tokens {
+		if m := ls.shard(id); token > m.Tokens[id] {
+			m.Tokens[id] = token
+		}
+	}
+	for id, entry := range snap.Locks {
+		if entry.Holders == nil {
+			continue
+		}
+		for owner, hold := range entry.Holders {
+			hold := hold
+			if err := ls.record(Record{
+				Type:  RecordHold,
+				ID:    id,
+				Owner: owner,
+				Mode:  entry.Mode,
+				Hold:  &hold,
+			}); err != nil {
+				return err
+			}
+		}
+		ls.shard(id).LockMap[id] = entry
+	}
+	for id, entry := range snap.Semaphores {
+		if entry.Holders == nil {
+			continue
+		}
+		for owner, hold := range entry.Holders {
+			hold := hold
+			if err := ls.record(Record{
+				Type:    RecordPermits,
+				ID:      id,
+				Owner:   owner,
+				Limit:   entry.Limit,
+				Permits: &hold,
+			}); err != nil {
+				return err
+			}
+		}
+		ls.shard(id).Semaphores[id] = entry
+	}
+	return nil
+}
+
+// Checkpoint saves a snapshot of the lock table to the snapshot store
+// of the service and empties its log, whose records are covered by the
+// snapshot. The service is paused while the snapshot is written, so
+// that no change falls between the snapshot and the log.
+func (ls *SimpleLockService) Checkpoint() error {
+	defer ls.lockAll()()
+	return ls.checkpoint()
+}
+
+// checkpoint saves a snapshot of the lock table and empties the log.
+// The mutexes of all the shards must be held.
+func (ls *SimpleLockService) checkpoint() error {
+	if ls.snapshots == nil {
+		return nil
+	}
+	snap := ls.snapshot(time.Now())
+	if err := ls.snapshots.Save(snap); err != nil {
+		return err
+	}
+	ls.
+		log.
+		Debug().
+		Int("locks", len(snap.Locks)).
+		Int("semaphores", len(snap.Semaphores)).
+		Msg("snapshot saved")
+	if ls.wal == nil {
+		return nil
+	}
+	return ls.wal.Reset()
+}
+
+// rewrite empties the log and appends the holds and permits of the lock
+// table to it, so that the log alone recovers the table. The mutexes of
+// all the shards must be held.
+func (ls *SimpleLockService) rewrite() error {
+	if ls.wal == nil {
+		return nil
+	}
+	if err := ls.wal.Reset(); err != nil {
+		return err
+	}
+	for _, m := range ls.shards {
+		for id, entry := range m.LockMap {
+			for owner, hold := range entry.Holders {
+				hold := hold
+				if err := ls.record(Record{
+					Type:  RecordHold,
+					ID:    id,
+					Owner: owner,
+					Mode:  entry.Mode,
+					Hold:  &hold,
+				}); err != nil {
+					return err
+				}
+			}
+		}
+		for id, entry := range m.Semaphores {
+			for owner, hold := range entry.Holders {
+				hold := hold
+				if err := ls.record(Record{
+					Type:    RecordPermits,
+					ID:      id,
+					Owner:   owner,
+					Limit:   entry.Limit,
+					Permits: &hold,
+				}); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// RunCheckpointer takes a checkpoint every interval until the context
+// is cancelled. This is a blocking call.
+func (ls *SimpleLockService) RunCheckpointer(ctx context.Context, interval time.Duration) {
+	if ls.snapshots == nil {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := ls.Checkpoint(); err != nil {
+				ls.
+					log.
+					Error().
+					Err(err).
+					Msg("can't take a checkpoint")
+			}
+		}
+	}
+}
+
+// snapshot returns a deep copy of the lock table taken at the given
+// time. The mutexes of all the shards must be held.
+func (ls *SimpleLockService) snapshot(now time.Time) Snapshot {
+	snap := Snapshot{
+		Time:       now,
+		Locks:      make(map[string]LockMapEntry),
+		Tokens:     make(map[string]FencingToken),
+		Semaphores: make(map[string]SemaphoreEntry),
+		Sessions:   ls.liveSessions(now),
+	}
+	for _, m := range ls.shards {
+		for id, entry := range m.LockMap {
+			holders := make(map[string]Hold, len(entry.Holders))
+			for owner, hold := range entry.Holders {
+				holders[owner] = hold
+			}
+			snap.Locks[id] = LockMapEntry{
+				Mode:    entry.Mode,
+				Holders: holders,
+			}
+		}
+		for id, token := range m.Tokens {
+			snap.Tokens[id] = token
+		}
+		for id, entry := range m.Semaphores {
+			holders := make(map[string]PermitHold, len(entry.Holders))
+			for owner, hold := range entry.Holders {
+				holders[owner] = hold
+			}
+			snap.Semaphores[id] = SemaphoreEntry{
+				Limit:   entry.Limit,
+				Holders: holders,
+			}
+		}
+	}
+	return snap
+}
+
+// restore replaces the lock table and the sessions with the ones of the
+// snapshot. The holds aren't bound to the sessions until bindSessions is
+// called. The mutexes of all the shards must be held.
+func (ls *SimpleLockService) restore(snap Snapshot) {
+	for _, m := range ls.shards {
+		m.LockMap = make(map[string]LockMapEntry)
+		m.Semaphores = make(map[string]SemaphoreEntry)
+	}
+	ls.restoreSessions(snap.Sessions, time.Now())
+	for id, entry := range snap.Locks {
+		if entry.Holders == nil {
+			continue
+		}
+		ls.shard(id).LockMap[id] = entry
+	}
+	for id, token := range snap.Tokens {
+		if m := ls.shard(id); token > m.Tokens[id] {
+			m.Tokens[id] = token
+		}
+	}
+	for id, entry := range snap.Semaphores {
+		if entry.Holders == nil {
+			continue
+		}
+		ls.shard(id).Semaphores[id] = entry
+	}
+}
@@ -0,0 +1,156 @@
+package lockservice
+
+import (
+	"context"
+	"time"
+)
+
+// waiter is a process queued on a lock that is held by another
+// process. The fencing token of the acquisition is sent on grant
+// once the lock is handed over to the waiter, or the reason why it
+// couldn't be.
+//
+// A process that pounced on the lock isn't blocked and has no grant
+// channel, it learns of the handover through the watchers of the lock.
+// Its pounce runs out at the deadline, unless the deadline is zero.
+type waiter struct {
+	sd       Descriptors
+	grant    chan handover
+	pounce   bool
+	deadline time.Time
+}
+
+// handover is the outcome of handing a lock over to a waiter.
+type handover struct {
+	token FencingToken
+	err   error
+}
+
+// lapsed returns true if the waiter is a pounce that has run out at the
+// given time.
+func (w *waiter) lapsed(now time.Time) bool {
+	return w.pounce && !w.deadline.IsZero() && !now.Before(w.deadline)
+}
+
+// AcquireWait lets a client acquire a lock on an object, blocking
+// while the lock is held by another process. Blocked processes are
+// queued in FIFO order and the lock is handed over to the first of
+// them when it is released or its lease runs out. Consecutive shared
+// waiters are granted the lock together.
+//
+// ErrAcquireTimeout is returned if the deadline of the context passes
+// before the lock is granted and the error of the context is returned
+// if it's cancelled otherwise.
+func (ls *SimpleLockService) AcquireWait(ctx context.Context, sd Descriptors) (FencingToken, error) {
+	id := sd.ID()
+	m := ls.shard(id)
+	m.Mutex.Lock()
+	now := time.Now()
+	ls.expire(id, now)
+	if ls.acquirable(sd) {
+		if err := ls.attach(sd); err != nil {
+			m.Mutex.Unlock()
+			return 0, err
+		}
+		token, err := ls.acquire(sd, now)
+		m.Mutex.Unlock()
+		return token, err
+	}
+	w := &waiter{
+		sd:    sd,
+		grant: make(chan handover, 1),
+	}
+	m.Waiters[id] = append(m.Waiters[id], w)
+	m.Mutex.Unlock()
+	ls.
+		log.
+		Debug().
+		Str("descriptor", id).
+		Str("owner", sd.Owner()).
+		Msg("queued, waiting for the lock")
+
+	// Nobody else might touch the lock when its lease runs out,
+	// so the waiter wakes up to expire it on its own. A stale tick
+	// only results in a spurious check of the lease.
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+	for {
+		m.Mutex.Lock()
+		wait, ok := ls.untilExpiry(id, time.Now())
+		m.Mutex.Unlock()
+		var expiry <-chan time.Time
+		if ok {
+			timer.Reset(wait)
+			expiry = timer.C
+		}
+
+		select {
+		case h := <-w.grant:
+			return h.token, h.err
+		case <-expiry:
+			m.Mutex.Lock()
+			ls.expire(id, time.Now())
+			m.Mutex.Unlock()
+		case <-ctx.Done():
+			m.Mutex.Lock()
+			if !ls.dequeue(id, w) {
+				// The lock was handed over while the context ended,
+				// pass it on to the next waiter.
+				if h := <-w.grant; h.err == nil {
+					ls.drop(id, sd.Owner(), EventReleased, time.Now())
+				}
+			}
+			m.Mutex.Unlock()
+			ls.
+				log.
+				Debug().
+				Str("descriptor", id).
+				Str("owner", sd.Owner()).
+				Msg("gave up waiting for the lock")
+			if ctx.Err() == context.DeadlineExceeded {
+				return 0, ErrAcquireTimeout
+			}
+			return 0, ctx.Err()
+		}
+	}
+}
+
+// dequeue removes the waiter from the queue of the descriptor and
+// returns false if it wasn't queued anymore. The mutex of the shard
+// of the lock must be held.
+func (ls *SimpleLockService) dequeue(id string, w *waiter) bool {
+	m := ls.shard(id)
+	queue := m.Waiters[id]
+	for i := range queue {
+		if queue[i] == w {
+			queue = append(queue[:i:i], queue[i+1:]...)
+			if len(queue) == 0 {
+				delete(m.Waiters, id)
+			} else {
+				m.Waiters[id] = queue
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// untilExpiry returns the time left until the first lease on the lock
+// of the descriptor runs out. False is returned if the lock isn't held
+// or never expires. The mutex of the shard of the lock must be held.
+func (ls *SimpleLockService) untilExpiry(id string, now time.Time) (time.Duration, bool) {
+	var (
+		first time.Duration
+		found bool
+	)
+	for _, hold := range ls.shard(id).LockMap[id].Holders {
+		if hold.Lease <= 0 {
+			continue
+		}
+		left := hold.Timestamp.Add(hold.Lease).Sub(now)
+		if !found || left < first {
+			first, found = left, true
+		}
+	}
+	return first, found
+}
@@ -0,0 +1,196 @@
+package lockservice
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SyncPolicy decides when the write-ahead log is synced to disk.
+// A positive policy is the interval at which the log is synced.
+type SyncPolicy time.Duration
+
+const (
+	// SyncAlways syncs the log on every append, so that no
+	// acknowledged change is ever lost.
+	SyncAlways SyncPolicy = 0
+	// SyncNever leaves syncing the log to the operating system.
+	SyncNever SyncPolicy = -1
+)
+
+// SyncEvery returns a policy which syncs the log at the given interval.
+// Changes appended since the last sync can be lost on a crash of the
+// machine, but not on a crash of the process.
+func SyncEvery(interval time.Duration) SyncPolicy {
+	return SyncPolicy(interval)
+}
+
+// ParseSyncPolicy parses a policy from "always", "never" or the
+// interval at which the log is synced, such as "100ms".
+func ParseSyncPolicy(s string) (SyncPolicy, error) {
+	switch strings.ToLower(s) {
+	case "always":
+		return SyncAlways, nil
+	case "never":
+		return SyncNever, nil
+	}
+	interval, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	if interval <= 0 {
+		return SyncAlways, nil
+	}
+	return SyncEvery(interval), nil
+}
+
+var _ Log = (*WAL)(nil)
+
+// WAL is a write-ahead log on disk that implements Log. Records are
+// stored as lines of JSON, and a torn record at the end of the log,
+// left behind by a crash in the middle of an append, is discarded
+// when the log is replayed.
+type WAL struct {
+	mu     sync.Mutex
+	file   *os.File
+	policy SyncPolicy
+	// dirty is true if records were appended since the
+	// last sync.
+	dirty bool
+	done  chan struct{}
+	wg    sync.WaitGroup
+}
+
+// OpenWAL opens the write-ahead log at the given path, creating it
+// if it doesn't exist. The log is synced according to the policy.
+func OpenWAL(path string, policy SyncPolicy) (*WAL, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := file.Seek(0, io.SeekEnd); err != nil {
+		file.Close()
+		return nil, err
+	}
+	wal := &WAL{
+		file:   file,
+		policy: policy,
+		done:   make(chan struct{}),
+	}
+	if policy > 0 {
+		wal.wg.Add(1)
+		go wal.syncEvery(time.Duration(policy))
+	}
+	return wal, nil
+}
+
+// Append writes the record to the end of the log.
+func (wal *WAL) Append(rec Record) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	wal.mu.Lock()
+	defer wal.mu.Unlock()
+	if _, err := wal.file.Write(data); err != nil {
+		return err
+	}
+	if wal.policy == SyncAlways {
+		return wal.file.Sync()
+	}
+	wal.dirty = true
+	return nil
+}
+
+// Replay calls apply with every record of the log. A torn record at
+// the end of the log is cut off, so that later records are appended
+// after the last complete one.
+func (wal *WAL) Replay(apply func(Record) error) error {
+	wal.mu.Lock()
+	defer wal.mu.Unlock()
+	if _, err := wal.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	var (
+		reader = bufio.NewReader(wal.file)
+		offset int64
+	)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		var rec Record
+		if err := json.Unmarshal(bytes.TrimSpace(line), &rec); err != nil {
+			break
+		}
+		if err := apply(rec); err != nil {
+			return err
+		}
+		offset += int64(len(line))
+	}
+	if err := wal.file.Truncate(offset); err != nil {
+		return err
+	}
+	_, err := wal.file.Seek(offset, io.SeekStart)
+	return err
+}
+
+// Reset truncates the log to an empty file.
+func (wal *WAL) Reset() error {
+	wal.mu.Lock()
+	defer wal.mu.Unlock()
+	if err := wal.file.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := wal.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	wal.dirty = false
+	return wal.file.Sync()
+}
+
+// Close syncs the log and closes its file.
+func (wal *WAL) Close() error {
+	close(wal.done)
+	wal.wg.Wait()
+
+	wal.mu.Lock()
+	defer wal.mu.Unlock()
+	if err := wal.file.Sync(); err != nil {
+		wal.file.Close()
+		return err
+	}
+	return wal.file.Close()
+}
+
+// syncEvery syncs the log at every interval, as long as records
+// were appended since the last sync, until the log is closed.
+func (wal *WAL) syncEvery(interval time.Duration) {
+	defer wal.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-wal.done:
+			return
+		case <-ticker.C:
+			wal.mu.Lock()
+			if wal.dirty {
+				wal.file.Sync()
+				wal.dirty = false
+			}
+			wal.mu.Unlock()
+		}
+	}
+}
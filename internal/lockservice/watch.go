@@ -0,0 +1,109 @@
+package lockservice
+
+import (
+	"sync"
+	"time"
+)
+
+// watchBuffer is the number of events buffered for a watcher. A watcher
+// that falls further behind is dropped and its channel is closed.
+const watchBuffer = 64
+
+// EventType describes a change in the state of a lock.
+type EventType string
+
+const (
+	// EventAcquired is sent when a lock is granted to a holder.
+	EventAcquired EventType = "acquired"
+	// EventReleased is sent when a holder releases a lock.
+	EventReleased EventType = "released"
+	// EventExpired is sent when the lease of a holder runs out.
+	EventExpired EventType = "expired"
+)
+
+// Event is a change in the state of a lock, as seen by its watchers.
+// Free is true if the lock has no holders left after the event and
+// Pounced is true if the lock was handed over to a process that had
+// pounced on it.
+type Event struct {
+	Type    EventType    `json:"type"`
+	ID      string       `json:"id"`
+	Owner   string       `json:"owner"`
+	Mode    LockMode     `json:"mode,omitempty"`
+	Token   FencingToken `json:"token,omitempty"`
+	Free    bool         `json:"free"`
+	Pounced bool         `json:"pounced,omitempty"`
+	Time    time.Time    `json:"time"`
+}
+
+// watcher receives the events of a lock it watches.
+type watcher struct {
+	events chan Event
+}
+
+// Watch lets a client follow the changes in the state of the lock on
+// the object. The events are delivered on the returned channel in the
+// order they happen, until the returned function is called.
+//
+// A watcher that doesn't keep up with the events is dropped, in which
+// case its channel is closed early.
+func (ls *SimpleLockService) Watch(o Object) (<-chan Event, func()) {
+	id := o.ID()
+	w := &watcher{
+		events: make(chan Event, watchBuffer),
+	}
+	m := ls.shard(id)
+	m.Mutex.Lock()
+	m.Watchers[id] = append(m.Watchers[id], w)
+	m.Mutex.Unlock()
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			m.Mutex.Lock()
+			if ls.unwatch(id, w) {
+				close(w.events)
+			}
+			m.Mutex.Unlock()
+		})
+	}
+	return w.events, cancel
+}
+
+// notify sends the event to all the watchers of its lock. The mutex of
+// the shard of the lock must be held.
+func (ls *SimpleLockService) notify(ev Event) {
+	for _, w := range ls.shard(ev.ID).Watchers[ev.ID] {
+		select {
+		case w.events <- ev:
+		default:
+			ls.
+				log.
+				Debug().
+				Str("descriptor", ev.ID).
+				Msg("watcher fell behind, dropped")
+			ls.unwatch(ev.ID, w)
+			close(w.events)
+		}
+	}
+}
+
+// unwatch removes the watcher of the lock and returns false if it
+// wasn't watching anymore. The mutex of the shard of the lock must be
+// held.
+func (ls *SimpleLockService) unwatch(id string, w *watcher) bool {
+	m := ls.shard(id)
+	watchers := m.Watchers[id]
+	for i := range watchers {
+		if watchers[i] == w {
+			watchers = append(watchers[:i:i], watchers[i+1:]...)
+			if len(watchers) == 0 {
+				delete(m.Watchers, id)
+			} else {
+				m.Watchers[id] = watchers
+			}
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,4 @@
+// Package raft implements the Raft consensus algorithm, which replicates
+// a log of commands across the nodes of a cluster and applies them to a
+// state machine on every node in the same order.
+package raft
@@ -0,0 +1,15 @@
+package raft
+
+// Error provides constant error strings to the driver functions.
+type Error string
+
+func (e Error) Error() string { return string(e) }
+
+// Constant errors.
+// Rule of thumb, all errors start with a small letter and end with no full stop.
+const (
+	ErrNotLeader      = Error("node isn't the leader of the cluster")
+	ErrLeadershipLost = Error("leadership was lost before the command was committed")
+	ErrStopped        = Error("node is stopped")
+	ErrUnknownNode    = Error("node isn't one of the peers of the cluster")
+)
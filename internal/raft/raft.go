@@ -0,0 +1,907 @@
+package raft
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+const (
+	// DefaultElectionTimeout is the shortest time for which a follower
+	// waits to hear from a leader before it starts an election.
+	DefaultElectionTimeout = 300 * time.Millisecond
+	// DefaultHeartbeatInterval is the interval at which the leader
+	// sends heartbeats to its followers.
+	DefaultHeartbeatInterval = 50 * time.Millisecond
+	// maxAppendEntries is the largest number of entries sent to a
+	// follower in a single AppendEntries call.
+	maxAppendEntries = 256
+	// tickInterval is the interval at which election timeouts are
+	// checked.
+	tickInterval = 10 * time.Millisecond
+	// DefaultSnapshotThreshold is the number of entries applied since
+	// the last snapshot after which the log is compacted into a new
+	// snapshot.
+	DefaultSnapshotThreshold = 1024
+)
+
+// Role is the role that a node plays in the cluster.
+type Role string
+
+const (
+	// Follower nodes replicate the log of the leader.
+	Follower Role = "follower"
+	// Candidate nodes are running for leader.
+	Candidate Role = "candidate"
+	// Leader nodes accept proposals and replicate them.
+	Leader Role = "leader"
+)
+
+// Entry is a command in the replicated log. Entries with no command
+// are appended by new leaders to commit the entries of earlier terms.
+type Entry struct {
+	Term    uint64 `json:"term"`
+	Index   uint64 `json:"index"`
+	Command []byte `json:"command,omitempty"`
+}
+
+// StateMachine is the state replicated by the cluster. Every node
+// applies the committed commands to its state machine in the order
+// of the log, so Apply must be deterministic.
+//
+// The log is compacted into snapshots of the state machine, which
+// Restore must bring back exactly as it was when Snapshot was called.
+type StateMachine interface {
+	Apply(command []byte) []byte
+	Snapshot() ([]byte, error)
+	Restore(snapshot []byte) error
+}
+
+// Config configures a node of the cluster.
+type Config struct {
+	// ID is the ID of the node, which must be a key of Peers.
+	ID string
+	// Peers maps the IDs of all the nodes of the cluster, including
+	// this one, to the base URLs at which they serve their Handler.
+	Peers map[string]string
+	// Storage keeps the state of the node across crashes. A node
+	// without storage forgets its state.
+	Storage           Storage
+	ElectionTimeout   time.Duration
+	HeartbeatInterval time.Duration
+	// SnapshotThreshold is the number of entries applied since the
+	// last snapshot after which the log is compacted. It defaults to
+	// DefaultSnapshotThreshold.
+	SnapshotThreshold uint64
+	Log               zerolog.Logger
+}
+
+// Raft is a node of a cluster which replicates a log of commands with
+// the Raft consensus algorithm and applies them to a state machine.
+//
+// Nodes talk to each other over HTTP, so every node must serve the
+// Handler of its Raft at the URL given in the peers of the cluster.
+type Raft struct {
+	mu     sync.Mutex
+	id     string
+	peers  map[string]string
+	sm     StateMachine
+	store  Storage
+	log    zerolog.Logger
+	client *http.Client
+
+	electionTimeout   time.Duration
+	heartbeatInterval time.Duration
+	snapshotThreshold uint64
+
+	// term, votedFor, entries and snapshot are kept in the storage.
+	// entries[0] is a sentinel entry with the index and term of the
+	// last entry covered by the snapshot, which are 0 without one.
+	term     uint64
+	votedFor string
+	entries  []Entry
+	snapshot Snapshot
+	// restore is a snapshot installed by the leader that the state
+	// machine has yet to be restored from.
+	restore *Snapshot
+
+	role        Role
+	leader      string
+	commitIndex uint64
+	lastApplied uint64
+	// deadline is the time at which a follower or candidate
+	// starts a new election.
+	deadline time.Time
+
+	// termStart is the index of the entry that the leader appended
+	// when it was elected.
+	termStart uint64
+	// nextIndex and matchIndex are kept by the leader for every
+	// follower, as the index of the next entry to send and of the
+	// last entry known to be replicated.
+	nextIndex  map[string]uint64
+	matchIndex map[string]uint64
+	// replicate wakes up the replicators of the leader.
+	replicate map[string]chan struct{}
+
+	// proposals are the proposals waiting for their entry to be
+	// applied, by the index of the entry.
+	proposals map[uint64]*proposal
+	// reads are the reads waiting for the entries up to their read
+	// index to be applied.
+	reads   []read
+	applied *sync.Cond
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// proposal is a command proposed to the leader which waits for its
+// entry to be applied.
+type proposal struct {
+	term   uint64
+	result chan []byte
+	lost   chan struct{}
+}
+
+// read is a read of the state machine that waits for the entries up to
+// its index to be applied.
+type read struct {
+	index uint64
+	done  chan struct{}
+}
+
+// New creates a node of the cluster that applies the committed commands
+// to the state machine, recovering the state kept in its storage. The
+// state machine is restored from the stored snapshot, if any. The node
+// starts as a follower and takes part in the cluster once Start is
+// called.
+func New(cfg Config, sm StateMachine) (*Raft, error) {
+	if cfg.ElectionTimeout == 0 {
+		cfg.ElectionTimeout = DefaultElectionTimeout
+	}
+	if cfg.HeartbeatInterval == 0 {
+		cfg.HeartbeatInterval = DefaultHeartbeatInterval
+	}
+	if cfg.SnapshotThreshold == 0 {
+		cfg.SnapshotThreshold = DefaultSnapshotThreshold
+	}
+	if cfg.Storage == nil {
+		cfg.Storage = NewMemoryStorage()
+	}
+	if _, ok := cfg.Peers[cfg.ID]; !ok {
+		return nil, ErrUnknownNode
+	}
+
+	snap, err := cfg.Storage.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+	if snap.Index > 0 {
+		if err := sm.Restore(snap.Data); err != nil {
+			return nil, err
+		}
+	}
+	term, votedFor, entries, err := cfg.Storage.Load()
+	if err != nil {
+		return nil, err
+	}
+	rf := &Raft{
+		id:                cfg.ID,
+		peers:             cfg.Peers,
+		sm:                sm,
+		store:             cfg.Storage,
+		log:               cfg.Log,
+		client:            &http.Client{Timeout: cfg.ElectionTimeout / 2},
+		electionTimeout:   cfg.ElectionTimeout,
+		heartbeatInterval: cfg.HeartbeatInterval,
+		snapshotThreshold: cfg.SnapshotThreshold,
+		term:              term,
+		votedFor:          votedFor,
+		entries:           append([]Entry{{Index: snap.Index, Term: snap.Term}}, entries...),
+		snapshot:          snap,
+		commitIndex:       snap.Index,
+		lastApplied:       snap.Index,
+		role:              Follower,
+		proposals:         make(map[uint64]*proposal),
+		done:              make(chan struct{}),
+	}
+	rf.applied = sync.NewCond(&rf.mu)
+	return rf, nil
+}
+
+// Start starts the timers of the node and the application of committed
+// commands to the state machine.
+func (rf *Raft) Start() {
+	rf.mu.Lock()
+	rf.resetDeadline()
+	rf.mu.Unlock()
+
+	rf.wg.Add(2)
+	go rf.ticker()
+	go rf.applier()
+}
+
+// Stop stops the node. Proposals waiting on the node fail with ErrStopped.
+func (rf *Raft) Stop() {
+	rf.mu.Lock()
+	select {
+	case <-rf.done:
+		rf.mu.Unlock()
+		return
+	default:
+	}
+	close(rf.done)
+	rf.applied.Broadcast()
+	rf.mu.Unlock()
+	rf.wg.Wait()
+}
+
+// Propose appends the command to the replicated log and returns the
+// result of applying it, once it is committed. Only the leader accepts
+// proposals, other nodes fail with ErrNotLeader.
+//
+// ErrLeadershipLost is returned if the node loses its leadership before
+// the command is committed and the command was dropped from the log. A
+// proposal whose context runs out might still be committed later on.
+func (rf *Raft) Propose(ctx context.Context, command []byte) ([]byte, error) {
+	rf.mu.Lock()
+	if rf.role != Leader {
+		rf.mu.Unlock()
+		return nil, ErrNotLeader
+	}
+	entry, err := rf.appendEntry(command)
+	if err != nil {
+		rf.mu.Unlock()
+		return nil, err
+	}
+	p := &proposal{
+		term:   rf.term,
+		result: make(chan []byte, 1),
+		lost:   make(chan struct{}),
+	}
+	rf.proposals[entry.Index] = p
+	rf.wakeReplicators()
+	rf.advanceCommit()
+	rf.mu.Unlock()
+
+	select {
+	case result := <-p.result:
+		return result, nil
+	case <-p.lost:
+		return nil, ErrLeadershipLost
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-rf.done:
+		return nil, ErrStopped
+	}
+}
+
+// ReadIndex lets the leader serve a read of its state machine without
+// appending it to the log. It returns once the node is confirmed to still
+// be the leader by a majority of the cluster and its state machine has
+// applied every entry committed before the call, after which the state
+// machine reflects every write that completed before the read began.
+//
+// ErrNotLeader is returned by other nodes and ErrLeadershipLost if a
+// majority doesn't confirm the leadership of the node.
+func (rf *Raft) ReadIndex(ctx context.Context) error {
+	rf.mu.Lock()
+	if rf.role != Leader {
+		rf.mu.Unlock()
+		return ErrNotLeader
+	}
+	// Until the first entry of its term is committed, the leader may
+	// not know of all the committed entries, so it waits for that one.
+	index := rf.commitIndex
+	if start := rf.termStart; start > index {
+		index = start
+	}
+	term := rf.term
+	rf.mu.Unlock()
+
+	if !rf.confirm(term) {
+		return ErrLeadershipLost
+	}
+
+	rf.mu.Lock()
+	if rf.lastApplied >= index {
+		rf.mu.Unlock()
+		return nil
+	}
+	r := read{index: index, done: make(chan struct{})}
+	rf.reads = append(rf.reads, r)
+	rf.mu.Unlock()
+
+	select {
+	case <-r.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-rf.done:
+		return ErrStopped
+	}
+}
+
+// Leader returns the ID and the URL of the leader of the cluster as far
+// as this node knows. The ID is empty if the node knows of no leader.
+func (rf *Raft) Leader() (string, string) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	return rf.leader, rf.peers[rf.leader]
+}
+
+// State returns the current term of the node and its role.
+func (rf *Raft) State() (uint64, Role) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	return rf.term, rf.role
+}
+
+// ticker starts an election whenever the deadline of the node passes
+// without hearing from a leader.
+func (rf *Raft) ticker() {
+	defer rf.wg.Done()
+	ticker := time.NewTicker(tickInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-rf.done:
+			return
+		case <-ticker.C:
+			rf.mu.Lock()
+			if rf.role != Leader && time.Now().After(rf.deadline) {
+				rf.startElection()
+			}
+			rf.mu.Unlock()
+		}
+	}
+}
+
+// startElection makes the node a candidate in a new term and asks every
+// other node for its vote. The mutex must be held.
+func (rf *Raft) startElection() {
+	rf.term++
+	rf.role = Candidate
+	rf.votedFor = rf.id
+	rf.leader = ""
+	rf.resetDeadline()
+	if err := rf.persistState(); err != nil {
+		rf.role = Follower
+		return
+	}
+	rf.
+		log.
+		Debug().
+		Str("node", rf.id).
+		Uint64("term", rf.term).
+		Msg("starting election")
+
+	last := rf.entries[len(rf.entries)-1]
+	args := RequestVoteArgs{
+		Term:         rf.term,
+		CandidateID:  rf.id,
+		LastLogIndex: last.Index,
+		LastLogTerm:  last.Term,
+	}
+	votes := 1
+	if votes > len(rf.peers)/2 {
+		rf.becomeLeader()
+		return
+	}
+	for id := range rf.peers {
+		if id == rf.id {
+			continue
+		}
+		go func(id string) {
+			var reply RequestVoteReply
+			if err := rf.call(id, requestVotePath, args, &reply); err != nil {
+				return
+			}
+			rf.mu.Lock()
+			defer rf.mu.Unlock()
+			if reply.Term > rf.term {
+				rf.becomeFollower(reply.Term)
+				return
+			}
+			if rf.role != Candidate || rf.term != args.Term || !reply.VoteGranted {
+				return
+			}
+			votes++
+			if votes > len(rf.peers)/2 {
+				rf.becomeLeader()
+			}
+		}(id)
+	}
+}
+
+// becomeFollower makes the node a follower in the given term. The
+// mutex must be held.
+func (rf *Raft) becomeFollower(term uint64) {
+	if term > rf.term {
+		rf.term = term
+		rf.votedFor = ""
+		rf.persistState()
+	}
+	if rf.role == Leader {
+		rf.
+			log.
+			Debug().
+			Str("node", rf.id).
+			Uint64("term", rf.term).
+			Msg("stepping down")
+	}
+	rf.role = Follower
+	rf.resetDeadline()
+}
+
+// becomeLeader makes the candidate the leader of its term. The leader
+// appends an empty entry, so that the entries of earlier terms get
+// committed along with it, and starts replicating its log. The mutex
+// must be held.
+func (rf *Raft) becomeLeader() {
+	rf.role = Leader
+	rf.leader = rf.id
+	rf.nextIndex = make(map[string]uint64)
+	rf.matchIndex = make(map[string]uint64)
+	rf.replicate = make(map[string]chan struct{})
+	rf.
+		log.
+		Debug().
+		Str("node", rf.id).
+		Uint64("term", rf.term).
+		Msg("elected leader")
+
+	last, err := rf.appendEntry(nil)
+	if err != nil {
+		rf.becomeFollower(rf.term)
+		return
+	}
+	rf.termStart = last.Index
+	for id := range rf.peers {
+		if id == rf.id {
+			continue
+		}
+		rf.nextIndex[id] = last.Index
+		rf.matchIndex[id] = 0
+		wake := make(chan struct{}, 1)
+		rf.replicate[id] = wake
+		rf.wg.Add(1)
+		go rf.replicator(id, rf.term, wake)
+	}
+	rf.advanceCommit()
+}
+
+// appendEntry stores the command and appends it to the log of the
+// leader. The mutex must be held.
+func (rf *Raft) appendEntry(command []byte) (Entry, error) {
+	entry := Entry{
+		Term:    rf.term,
+		Index:   rf.lastIndex() + 1,
+		Command: command,
+	}
+	if err := rf.persistEntries([]Entry{entry}); err != nil {
+		return Entry{}, err
+	}
+	rf.entries = append(rf.entries, entry)
+	return entry, nil
+}
+
+// wakeReplicators makes the replicators of the leader send the new
+// entries right away. The mutex must be held.
+func (rf *Raft) wakeReplicators() {
+	for _, wake := range rf.replicate {
+		select {
+		case wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// replicator sends the log of the leader to a follower for as long as
+// the node is the leader of the given term, at least every heartbeat.
+func (rf *Raft) replicator(peer string, term uint64, wake chan struct{}) {
+	defer rf.wg.Done()
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-rf.done:
+			return
+		case <-timer.C:
+		case <-wake:
+		}
+		more, ok := rf.sendEntries(peer, term)
+		if !ok {
+			return
+		}
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		if more {
+			timer.Reset(0)
+		} else {
+			timer.Reset(rf.heartbeatInterval)
+		}
+	}
+}
+
+// sendEntries sends the follower the entries it is missing, or a
+// heartbeat if there are none. A follower that is missing entries that
+// were compacted is sent the snapshot instead. It returns true if more
+// entries remain to be sent and false once the node is no longer the
+// leader of the given term.
+func (rf *Raft) sendEntries(peer string, term uint64) (bool, bool) {
+	rf.mu.Lock()
+	if rf.role != Leader || rf.term != term {
+		rf.mu.Unlock()
+		return false, false
+	}
+	next := rf.nextIndex[peer]
+	if next <= rf.snapshot.Index {
+		rf.mu.Unlock()
+		return rf.sendSnapshot(peer, term)
+	}
+	prev := rf.entry(next - 1)
+	end := rf.lastIndex() + 1
+	if end-next > maxAppendEntries {
+		end = next + maxAppendEntries
+	}
+	args := AppendEntriesArgs{
+		Term:         term,
+		LeaderID:     rf.id,
+		PrevLogIndex: prev.Index,
+		PrevLogTerm:  prev.Term,
+		Entries:      append([]Entry(nil), rf.entries[next-rf.firstIndex():end-rf.firstIndex()]...),
+		LeaderCommit: rf.commitIndex,
+	}
+	rf.mu.Unlock()
+
+	var reply AppendEntriesReply
+	if err := rf.call(peer, appendEntriesPath, args, &reply); err != nil {
+		return false, true
+	}
+
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if reply.Term > rf.term {
+		rf.becomeFollower(reply.Term)
+		return false, false
+	}
+	if rf.role != Leader || rf.term != term {
+		return false, false
+	}
+	if reply.Success {
+		match := args.PrevLogIndex + uint64(len(args.Entries))
+		if match > rf.matchIndex[peer] {
+			rf.matchIndex[peer] = match
+		}
+		rf.nextIndex[peer] = rf.matchIndex[peer] + 1
+		rf.advanceCommit()
+	} else if reply.ConflictIndex > 0 && reply.ConflictIndex < rf.nextIndex[peer] {
+		rf.nextIndex[peer] = reply.ConflictIndex
+	} else if rf.nextIndex[peer] > 1 {
+		rf.nextIndex[peer]--
+	}
+	return rf.nextIndex[peer] <= rf.lastIndex(), true
+}
+
+// sendSnapshot sends the snapshot of the leader to a follower that is
+// missing the entries it covers. It returns like sendEntries does.
+func (rf *Raft) sendSnapshot(peer string, term uint64) (bool, bool) {
+	rf.mu.Lock()
+	if rf.role != Leader || rf.term != term {
+		rf.mu.Unlock()
+		return false, false
+	}
+	args := InstallSnapshotArgs{
+		Term:     term,
+		LeaderID: rf.id,
+		Snapshot: rf.snapshot,
+	}
+	rf.mu.Unlock()
+
+	var reply InstallSnapshotReply
+	if err := rf.call(peer, installSnapshotPath, args, &reply); err != nil {
+		return false, true
+	}
+
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if reply.Term > rf.term {
+		rf.becomeFollower(reply.Term)
+		return false, false
+	}
+	if rf.role != Leader || rf.term != term {
+		return false, false
+	}
+	if reply.Success {
+		if args.Snapshot.Index > rf.matchIndex[peer] {
+			rf.matchIndex[peer] = args.Snapshot.Index
+		}
+		rf.nextIndex[peer] = rf.matchIndex[peer] + 1
+		rf.advanceCommit()
+	}
+	return rf.nextIndex[peer] <= rf.lastIndex(), true
+}
+
+// confirm returns true if a majority of the cluster, counting this node,
+// acknowledges it as the leader of the given term, which it learns from
+// a round of heartbeats.
+func (rf *Raft) confirm(term uint64) bool {
+	rf.mu.Lock()
+	if rf.role != Leader || rf.term != term {
+		rf.mu.Unlock()
+		return false
+	}
+	args := AppendEntriesArgs{
+		Term:     term,
+		LeaderID: rf.id,
+		// The heartbeat claims no entries, so that followers
+		// don't change their logs or commit anything on it.
+		PrevLogIndex: rf.lastIndex() + 1,
+		LeaderCommit: rf.commitIndex,
+	}
+	peers := len(rf.peers)
+	rf.mu.Unlock()
+
+	acks := make(chan bool, peers)
+	for id := range rf.peers {
+		if id == rf.id {
+			continue
+		}
+		go func(id string) {
+			var reply AppendEntriesReply
+			if err := rf.call(id, appendEntriesPath, args, &reply); err != nil {
+				acks <- false
+				return
+			}
+			if reply.Term > term {
+				rf.mu.Lock()
+				if reply.Term > rf.term {
+					rf.becomeFollower(reply.Term)
+				}
+				rf.mu.Unlock()
+			}
+			acks <- reply.Term == term
+		}(id)
+	}
+	confirmed := 1
+	for i := 1; i < peers && confirmed <= peers/2; i++ {
+		if <-acks {
+			confirmed++
+		}
+	}
+	return confirmed > peers/2
+}
+
+// advanceCommit commits the entries of the current term that are
+// replicated on a majority of the nodes, along with all the entries
+// before them. The mutex must be held.
+func (rf *Raft) advanceCommit() {
+	matches := []uint64{rf.lastIndex()}
+	for _, match := range rf.matchIndex {
+		matches = append(matches, match)
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		return matches[i] > matches[j]
+	})
+	// The entry at the index replicated on a majority.
+	majority := matches[len(rf.peers)/2]
+	if majority > rf.commitIndex && rf.entry(majority).Term == rf.term {
+		rf.commitIndex = majority
+		rf.applied.Broadcast()
+	}
+}
+
+// applier applies the committed entries to the state machine in the
+// order of the log and hands the results to their proposals. It restores
+// the state machine from the snapshots installed by the leader, and
+// compacts the log once enough entries have been applied.
+func (rf *Raft) applier() {
+	defer rf.wg.Done()
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	for {
+		for rf.lastApplied >= rf.commitIndex && rf.restore == nil {
+			select {
+			case <-rf.done:
+				return
+			default:
+			}
+			rf.applied.Wait()
+		}
+		if snap := rf.restore; snap != nil {
+			rf.restore = nil
+			rf.mu.Unlock()
+			err := rf.sm.Restore(snap.Data)
+			rf.mu.Lock()
+			if err != nil {
+				rf.
+					log.
+					Error().
+					Err(err).
+					Str("node", rf.id).
+					Msg("can't restore the snapshot")
+				continue
+			}
+			if snap.Index > rf.lastApplied {
+				rf.lastApplied = snap.Index
+			}
+			rf.wakeReads()
+			continue
+		}
+		entries := append([]Entry(nil), rf.entries[rf.lastApplied+1-rf.firstIndex():rf.commitIndex+1-rf.firstIndex()]...)
+		rf.mu.Unlock()
+
+		results := make([][]byte, len(entries))
+		for i, entry := range entries {
+			if entry.Command != nil {
+				results[i] = rf.sm.Apply(entry.Command)
+			}
+		}
+
+		rf.mu.Lock()
+		for i, entry := range entries {
+			rf.lastApplied = entry.Index
+			p, ok := rf.proposals[entry.Index]
+			if !ok {
+				continue
+			}
+			delete(rf.proposals, entry.Index)
+			if p.term == entry.Term {
+				p.result <- results[i]
+			} else {
+				close(p.lost)
+			}
+		}
+		rf.wakeReads()
+		// A snapshot installed meanwhile is restored first.
+		if rf.restore == nil && rf.lastApplied >= rf.snapshot.Index+rf.snapshotThreshold {
+			rf.compact()
+		}
+	}
+}
+
+// wakeReads lets the reads whose index has been applied go ahead. The
+// mutex must be held.
+func (rf *Raft) wakeReads() {
+	waiting := rf.reads[:0]
+	for _, r := range rf.reads {
+		if r.index <= rf.lastApplied {
+			close(r.done)
+		} else {
+			waiting = append(waiting, r)
+		}
+	}
+	rf.reads = waiting
+}
+
+// compact takes a snapshot of the state machine, which has applied the
+// entries up to lastApplied, stores it and drops the entries it covers
+// from the log. It is only called by the applier, so that nothing is
+// applied while the snapshot is taken. The mutex must be held.
+func (rf *Raft) compact() {
+	last := rf.entry(rf.lastApplied)
+	rf.mu.Unlock()
+	data, err := rf.sm.Snapshot()
+	rf.mu.Lock()
+	if err != nil {
+		rf.
+			log.
+			Error().
+			Err(err).
+			Str("node", rf.id).
+			Msg("can't take a snapshot")
+		return
+	}
+	// A snapshot installed meanwhile may cover more entries.
+	if last.Index <= rf.snapshot.Index {
+		return
+	}
+	snap := Snapshot{
+		Index: last.Index,
+		Term:  last.Term,
+		Data:  data,
+	}
+	if err := rf.store.SaveSnapshot(snap); err != nil {
+		rf.
+			log.
+			Error().
+			Err(err).
+			Str("node", rf.id).
+			Msg("can't store the snapshot")
+		return
+	}
+	rf.entries = append([]Entry{{Index: snap.Index, Term: snap.Term}}, rf.entries[snap.Index+1-rf.firstIndex():]...)
+	rf.snapshot = snap
+	rf.
+		log.
+		Debug().
+		Str("node", rf.id).
+		Uint64("index", snap.Index).
+		Msg("compacted the log")
+}
+
+// truncate removes the entries from the given index on, failing the
+// proposals waiting on them. The mutex must be held.
+func (rf *Raft) truncate(index uint64) {
+	rf.entries = rf.entries[:index-rf.firstIndex()]
+	if err := rf.store.Truncate(index); err != nil {
+		rf.
+			log.
+			Error().
+			Err(err).
+			Str("node", rf.id).
+			Msg("can't truncate the stored log")
+	}
+	for i, p := range rf.proposals {
+		if i >= index {
+			delete(rf.proposals, i)
+			close(p.lost)
+		}
+	}
+}
+
+// firstIndex returns the index of the sentinel entry of the log, which
+// is the last index covered by the snapshot. The mutex must be held.
+func (rf *Raft) firstIndex() uint64 {
+	return rf.entries[0].Index
+}
+
+// lastIndex returns the index of the last entry of the log. The mutex
+// must be held.
+func (rf *Raft) lastIndex() uint64 {
+	return rf.entries[len(rf.entries)-1].Index
+}
+
+// entry returns the entry of the log at the index, which must not be
+// below the first index. The mutex must be held.
+func (rf *Raft) entry(index uint64) Entry {
+	return rf.entries[index-rf.firstIndex()]
+}
+
+// resetDeadline pushes the election deadline to a random point between
+// one and two election timeouts from now. The mutex must be held.
+func (rf *Raft) resetDeadline() {
+	timeout := rf.electionTimeout + time.Duration(rand.Int63n(int64(rf.electionTimeout)))
+	rf.deadline = time.Now().Add(timeout)
+}
+
+// persistState stores the term and vote of the node. The mutex must be
+// held.
+func (rf *Raft) persistState() error {
+	err := rf.store.SetState(rf.term, rf.votedFor)
+	if err != nil {
+		rf.
+			log.
+			Error().
+			Err(err).
+			Str("node", rf.id).
+			Msg("can't store the term")
+	}
+	return err
+}
+
+// persistEntries stores entries appended to the log of the node. The
+// mutex must be held.
+func (rf *Raft) persistEntries(entries []Entry) error {
+	err := rf.store.Append(entries)
+	if err != nil {
+		rf.
+			log.
+			Error().
+			Err(err).
+			Str("node", rf.id).
+			Msg("can't store the log")
+	}
+	return err
+}
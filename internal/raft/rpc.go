@@ -0,0 +1,294 @@
+package raft
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+)
+
+const (
+	requestVotePath     = "/raft/requestVote"
+	appendEntriesPath   = "/raft/appendEntries"
+	installSnapshotPath = "/raft/installSnapshot"
+)
+
+// RequestVoteArgs is the request of a candidate for the vote of a node.
+type RequestVoteArgs struct {
+	Term         uint64 `json:"term"`
+	CandidateID  string `json:"candidateID"`
+	LastLogIndex uint64 `json:"lastLogIndex"`
+	LastLogTerm  uint64 `json:"lastLogTerm"`
+}
+
+// RequestVoteReply is the reply of a node to a RequestVoteArgs.
+type RequestVoteReply struct {
+	Term        uint64 `json:"term"`
+	VoteGranted bool   `json:"voteGranted"`
+}
+
+// AppendEntriesArgs is the request of a leader to append entries to
+// the log of a follower. It carries no entries when it is a heartbeat.
+type AppendEntriesArgs struct {
+	Term         uint64  `json:"term"`
+	LeaderID     string  `json:"leaderID"`
+	PrevLogIndex uint64  `json:"prevLogIndex"`
+	PrevLogTerm  uint64  `json:"prevLogTerm"`
+	Entries      []Entry `json:"entries,omitempty"`
+	LeaderCommit uint64  `json:"leaderCommit"`
+}
+
+// AppendEntriesReply is the reply of a follower to an AppendEntriesArgs.
+// A follower whose log doesn't match the leader's at the previous entry
+// reports the index from which the leader should send entries again in
+// ConflictIndex.
+type AppendEntriesReply struct {
+	Term          uint64 `json:"term"`
+	Success       bool   `json:"success"`
+	ConflictIndex uint64 `json:"conflictIndex,omitempty"`
+}
+
+// InstallSnapshotArgs is the request of a leader to replace the log of a
+// follower that is missing entries which the leader has compacted with
+// the snapshot of the leader.
+type InstallSnapshotArgs struct {
+	Term     uint64   `json:"term"`
+	LeaderID string   `json:"leaderID"`
+	Snapshot Snapshot `json:"snapshot"`
+}
+
+// InstallSnapshotReply is the reply of a follower to an
+// InstallSnapshotArgs. Success is true once the follower has stored
+// the snapshot.
+type InstallSnapshotReply struct {
+	Term    uint64 `json:"term"`
+	Success bool   `json:"success"`
+}
+
+// Handler returns the HTTP handler through which the node receives the
+// calls of the other nodes of the cluster. The paths of the handler all
+// start with "/raft/".
+func (rf *Raft) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(requestVotePath, func(w http.ResponseWriter, r *http.Request) {
+		var args RequestVoteArgs
+		if !rf.decode(w, r, &args) {
+			return
+		}
+		encode(w, rf.RequestVote(args))
+	})
+	mux.HandleFunc(appendEntriesPath, func(w http.ResponseWriter, r *http.Request) {
+		var args AppendEntriesArgs
+		if !rf.decode(w, r, &args) {
+			return
+		}
+		encode(w, rf.AppendEntries(args))
+	})
+	mux.HandleFunc(installSnapshotPath, func(w http.ResponseWriter, r *http.Request) {
+		var args InstallSnapshotArgs
+		if !rf.decode(w, r, &args) {
+			return
+		}
+		encode(w, rf.InstallSnapshot(args))
+	})
+	return mux
+}
+
+// RequestVote handles the request of a candidate for the vote of the
+// node. The vote is granted to the first candidate of the term whose
+// log is at least as up to date as the log of the node.
+func (rf *Raft) RequestVote(args RequestVoteArgs) RequestVoteReply {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if args.Term > rf.term {
+		rf.becomeFollower(args.Term)
+	}
+	reply := RequestVoteReply{Term: rf.term}
+	if args.Term < rf.term {
+		return reply
+	}
+	last := rf.entries[len(rf.entries)-1]
+	upToDate := args.LastLogTerm > last.Term ||
+		(args.LastLogTerm == last.Term && args.LastLogIndex >= last.Index)
+	if (rf.votedFor == "" || rf.votedFor == args.CandidateID) && upToDate {
+		rf.votedFor = args.CandidateID
+		if rf.persistState() != nil {
+			rf.votedFor = ""
+			return reply
+		}
+		rf.resetDeadline()
+		reply.VoteGranted = true
+	}
+	return reply
+}
+
+// AppendEntries handles the request of the leader to append entries to
+// the log of the node. Entries that conflict with the ones of the leader
+// are dropped along with all the entries after them.
+func (rf *Raft) AppendEntries(args AppendEntriesArgs) AppendEntriesReply {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	reply := AppendEntriesReply{Term: rf.term}
+	if args.Term < rf.term {
+		return reply
+	}
+	rf.becomeFollower(args.Term)
+	rf.leader = args.LeaderID
+	reply.Term = rf.term
+
+	last := rf.lastIndex()
+	if args.PrevLogIndex > last {
+		reply.ConflictIndex = last + 1
+		return reply
+	}
+	if first := rf.firstIndex(); args.PrevLogIndex < first {
+		// The entries covered by the snapshot of the node are
+		// committed, so they match the ones of the leader.
+		skip := first - args.PrevLogIndex
+		if skip > uint64(len(args.Entries)) {
+			skip = uint64(len(args.Entries))
+		}
+		args.Entries = args.Entries[skip:]
+		args.PrevLogIndex, args.PrevLogTerm = first, rf.entries[0].Term
+	}
+	if term := rf.entry(args.PrevLogIndex).Term; term != args.PrevLogTerm {
+		// The whole conflicting term is skipped at once.
+		index := args.PrevLogIndex
+		for index > rf.firstIndex()+1 && rf.entry(index-1).Term == term {
+			index--
+		}
+		reply.ConflictIndex = index
+		return reply
+	}
+
+	for i, entry := range args.Entries {
+		if entry.Index <= rf.lastIndex() {
+			if rf.entry(entry.Index).Term == entry.Term {
+				continue
+			}
+			rf.truncate(entry.Index)
+		}
+		if err := rf.persistEntries(args.Entries[i:]); err != nil {
+			return reply
+		}
+		rf.entries = append(rf.entries, args.Entries[i:]...)
+		break
+	}
+
+	if args.LeaderCommit > rf.commitIndex {
+		// Only the entries known to match the leader's are committed.
+		commit := args.PrevLogIndex + uint64(len(args.Entries))
+		if args.LeaderCommit < commit {
+			commit = args.LeaderCommit
+		}
+		if commit > rf.commitIndex {
+			rf.commitIndex = commit
+			rf.applied.Broadcast()
+		}
+	}
+	reply.Success = true
+	return reply
+}
+
+// InstallSnapshot handles the request of the leader to install its
+// snapshot on the node. The entries after the snapshot are kept if the
+// log of the node matches the snapshot, and the whole log is replaced
+// otherwise. The state machine is restored from the snapshot by the
+// applier of the node.
+func (rf *Raft) InstallSnapshot(args InstallSnapshotArgs) InstallSnapshotReply {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	reply := InstallSnapshotReply{Term: rf.term}
+	if args.Term < rf.term {
+		return reply
+	}
+	rf.becomeFollower(args.Term)
+	rf.leader = args.LeaderID
+	reply.Term = rf.term
+
+	snap := args.Snapshot
+	if snap.Index <= rf.commitIndex {
+		// The node already has every entry of the snapshot.
+		reply.Success = true
+		return reply
+	}
+	if err := rf.store.SaveSnapshot(snap); err != nil {
+		rf.
+			log.
+			Error().
+			Err(err).
+			Str("node", rf.id).
+			Msg("can't store the snapshot")
+		return reply
+	}
+	if snap.Index <= rf.lastIndex() && rf.entry(snap.Index).Term == snap.Term {
+		rf.entries = append([]Entry{{Index: snap.Index, Term: snap.Term}}, rf.entries[snap.Index+1-rf.firstIndex():]...)
+	} else {
+		rf.truncate(rf.firstIndex() + 1)
+		rf.entries = []Entry{{Index: snap.Index, Term: snap.Term}}
+	}
+	rf.snapshot = snap
+	rf.commitIndex = snap.Index
+	rf.restore = &snap
+	rf.applied.Broadcast()
+	rf.
+		log.
+		Debug().
+		Str("node", rf.id).
+		Uint64("index", snap.Index).
+		Msg("installed snapshot")
+	reply.Success = true
+	return reply
+}
+
+// call sends the arguments to the given path of a node and decodes its
+// reply.
+func (rf *Raft) call(peer, path string, args, reply interface{}) error {
+	data, err := json.Marshal(args)
+	if err != nil {
+		return err
+	}
+	resp, err := rf.client.Post(rf.peers[peer]+path, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s%s: %s", peer, path, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(reply)
+}
+
+// decode decodes the arguments of a call from the request and reports
+// whether the call should be handled. Calls are refused once the node
+// is stopped.
+func (rf *Raft) decode(w http.ResponseWriter, r *http.Request, args interface{}) bool {
+	select {
+	case <-rf.done:
+		http.Error(w, ErrStopped.Error(), http.StatusServiceUnavailable)
+		return false
+	default:
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	if err := json.Unmarshal(body, args); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// encode writes the reply of a call as JSON.
+func encode(w http.ResponseWriter, reply interface{}) {
+	data, err := json.Marshal(reply)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
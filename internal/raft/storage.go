@@ -0,0 +1,363 @@
+package raft
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Storage keeps the state that a node must not forget across a crash:
+// its current term, the candidate it voted for in that term and its log,
+// whose oldest entries may be compacted into a snapshot.
+type Storage interface {
+	// Load returns the stored term, vote and the log entries after
+	// the stored snapshot.
+	Load() (term uint64, votedFor string, entries []Entry, err error)
+	// SetState stores the current term and vote.
+	SetState(term uint64, votedFor string) error
+	// Append stores the entries after the ones already stored.
+	Append(entries []Entry) error
+	// Truncate removes the stored entries from the given index on.
+	Truncate(index uint64) error
+	// Snapshot returns the stored snapshot, which is empty if none
+	// was stored.
+	Snapshot() (Snapshot, error)
+	// SaveSnapshot stores the snapshot and removes the stored entries
+	// that it covers.
+	SaveSnapshot(Snapshot) error
+}
+
+// Snapshot is the state of the state machine once it has applied all
+// the entries up to Index, which are no longer needed in the log.
+type Snapshot struct {
+	Index uint64 `json:"index"`
+	Term  uint64 `json:"term"`
+	Data  []byte `json:"data,omitempty"`
+}
+
+// hardState is the term and vote of a node as it is stored.
+type hardState struct {
+	Term     uint64 `json:"term"`
+	VotedFor string `json:"votedFor"`
+}
+
+var _ Storage = (*MemoryStorage)(nil)
+
+// MemoryStorage is a Storage that keeps the state in memory. A node
+// restarted with the same MemoryStorage recovers its state, which is
+// enough to test crashes of nodes that run in the same process.
+type MemoryStorage struct {
+	mu       sync.Mutex
+	term     uint64
+	votedFor string
+	entries  []Entry
+	snapshot Snapshot
+}
+
+// NewMemoryStorage returns an empty MemoryStorage.
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{}
+}
+
+// Load returns the stored term, vote and log entries.
+func (s *MemoryStorage) Load() (uint64, string, []Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.term, s.votedFor, append([]Entry(nil), s.entries...), nil
+}
+
+// SetState stores the current term and vote.
+func (s *MemoryStorage) SetState(term uint64, votedFor string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.term, s.votedFor = term, votedFor
+	return nil
+}
+
+// Append stores the entries after the ones already stored.
+func (s *MemoryStorage) Append(entries []Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = append(s.entries, entries...)
+	return nil
+}
+
+// Truncate removes the stored entries from the given index on.
+func (s *MemoryStorage) Truncate(index uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = before(s.entries, index)
+	return nil
+}
+
+// Snapshot returns the stored snapshot.
+func (s *MemoryStorage) Snapshot() (Snapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.snapshot, nil
+}
+
+// SaveSnapshot stores the snapshot and removes the stored entries that
+// it covers.
+func (s *MemoryStorage) SaveSnapshot(snap Snapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.snapshot = snap
+	s.entries = after(s.entries, snap.Index)
+	return nil
+}
+
+var _ Storage = (*FileStorage)(nil)
+
+// FileStorage is a Storage that keeps the state in a directory. The term
+// and vote are replaced atomically on every change, as is the snapshot,
+// while log entries are appended to a file of JSON lines which is synced
+// on every append.
+type FileStorage struct {
+	mu  sync.Mutex
+	dir string
+	log *os.File
+	// last is the index of the last stored entry.
+	last uint64
+}
+
+// OpenFileStorage opens the storage in the given directory, creating
+// the directory if it doesn't exist.
+func OpenFileStorage(dir string) (*FileStorage, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	log, err := os.OpenFile(filepath.Join(dir, "log"), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileStorage{
+		dir: dir,
+		log: log,
+	}, nil
+}
+
+// Load returns the stored term, vote and the log entries after the
+// stored snapshot. An entry torn by a crash in the middle of an append
+// ends the log.
+func (s *FileStorage) Load() (uint64, string, []Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var state hardState
+	data, err := ioutil.ReadFile(filepath.Join(s.dir, "state"))
+	if err != nil && !os.IsNotExist(err) {
+		return 0, "", nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &state); err != nil {
+			return 0, "", nil, err
+		}
+	}
+
+	snap, err := s.readSnapshot()
+	if err != nil {
+		return 0, "", nil, err
+	}
+	entries, err := s.read()
+	if err != nil {
+		return 0, "", nil, err
+	}
+	// Entries after a torn one are rewritten without it, along with
+	// the entries of a snapshot that was stored right before a crash.
+	entries = after(entries, snap.Index)
+	if err := s.rewrite(entries); err != nil {
+		return 0, "", nil, err
+	}
+	return state.Term, state.VotedFor, entries, nil
+}
+
+// SetState stores the current term and vote.
+func (s *FileStorage) SetState(term uint64, votedFor string) error {
+	data, err := json.Marshal(hardState{Term: term, VotedFor: votedFor})
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return writeFileSync(filepath.Join(s.dir, "state"), data)
+}
+
+// Append stores the entries after the ones already stored.
+func (s *FileStorage) Append(entries []Entry) error {
+	var data []byte
+	for _, entry := range entries {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		data = append(append(data, line...), '\n')
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.log.Write(data); err != nil {
+		return err
+	}
+	if len(entries) > 0 {
+		s.last = entries[len(entries)-1].Index
+	}
+	return s.log.Sync()
+}
+
+// Truncate removes the stored entries from the given index on. Since
+// conflicting entries are rare, the log is simply rewritten.
+func (s *FileStorage) Truncate(index uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if index > s.last {
+		return nil
+	}
+	entries, err := s.read()
+	if err != nil {
+		return err
+	}
+	return s.rewrite(before(entries, index))
+}
+
+// Snapshot returns the stored snapshot.
+func (s *FileStorage) Snapshot() (Snapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.readSnapshot()
+}
+
+// SaveSnapshot stores the snapshot and rewrites the log without the
+// entries that it covers. A crash in between leaves the entries in the
+// log, and they are dropped once the storage is loaded.
+func (s *FileStorage) SaveSnapshot(snap Snapshot) error {
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := writeFileSync(filepath.Join(s.dir, "snapshot"), data); err != nil {
+		return err
+	}
+	entries, err := s.read()
+	if err != nil {
+		return err
+	}
+	return s.rewrite(after(entries, snap.Index))
+}
+
+// Close closes the log file of the storage.
+func (s *FileStorage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.log.Close()
+}
+
+// read returns the entries of the log file, up to the first one that
+// is torn. The mutex of the storage must be held.
+func (s *FileStorage) read() ([]Entry, error) {
+	file, err := os.Open(s.log.Name())
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var entries []Entry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			break
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// readSnapshot returns the snapshot file of the storage, which is empty
+// if there is none. The mutex of the storage must be held.
+func (s *FileStorage) readSnapshot() (Snapshot, error) {
+	var snap Snapshot
+	data, err := ioutil.ReadFile(filepath.Join(s.dir, "snapshot"))
+	if os.IsNotExist(err) {
+		return snap, nil
+	}
+	if err != nil {
+		return snap, err
+	}
+	err = json.Unmarshal(data, &snap)
+	return snap, err
+}
+
+// rewrite replaces the log file with one holding the given entries.
+// The mutex of the storage must be held.
+func (s *FileStorage) rewrite(entries []Entry) error {
+	var data []byte
+	for _, entry := range entries {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		data = append(append(data, line...), '\n')
+	}
+	name := s.log.Name()
+	if err := writeFileSync(name, data); err != nil {
+		return err
+	}
+	log, err := os.OpenFile(name, os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	s.log.Close()
+	s.log = log
+	s.last = 0
+	if len(entries) > 0 {
+		s.last = entries[len(entries)-1].Index
+	}
+	return nil
+}
+
+// before returns the entries whose index is below the given one.
+func before(entries []Entry, index uint64) []Entry {
+	for i, entry := range entries {
+		if entry.Index >= index {
+			return entries[:i]
+		}
+	}
+	return entries
+}
+
+// after returns the entries whose index is above the given one.
+func after(entries []Entry, index uint64) []Entry {
+	for i, entry := range entries {
+		if entry.Index > index {
+			return entries[i:]
+		}
+	}
+	return nil
+}
+
+// writeFileSync replaces the file with the given data through a synced
+// temporary file, so that a crash leaves either the old or the new file.
+func writeFileSync(name string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(name), filepath.Base(name)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
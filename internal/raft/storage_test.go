@@ -0,0 +1,91 @@
+package raft
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileStorage(t *testing.T) {
+	t.Run("state and log survive a reopen", func(t *testing.T) {
+		dir := t.TempDir()
+		s, err := OpenFileStorage(dir)
+		if err != nil {
+			t.Fatalf("openFileStorage: %v", err)
+		}
+		if err := s.SetState(3, "node1"); err != nil {
+			t.Fatalf("setState: %v", err)
+		}
+		entries := []Entry{{Term: 1, Index: 1}, {Term: 2, Index: 2, Command: []byte("a")}, {Term: 2, Index: 3}}
+		if err := s.Append(entries); err != nil {
+			t.Fatalf("append: %v", err)
+		}
+		if err := s.Truncate(3); err != nil {
+			t.Fatalf("truncate: %v", err)
+		}
+		if err := s.Append([]Entry{{Term: 3, Index: 3, Command: []byte("b")}}); err != nil {
+			t.Fatalf("append: %v", err)
+		}
+		s.Close()
+
+		// An entry torn by a crash ends the log.
+		file, err := os.OpenFile(filepath.Join(dir, "log"), os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			t.Fatalf("open: %v", err)
+		}
+		file.WriteString(`{"term":3,"ind`)
+		file.Close()
+
+		s, err = OpenFileStorage(dir)
+		if err != nil {
+			t.Fatalf("openFileStorage: %v", err)
+		}
+		defer s.Close()
+		term, votedFor, got, err := s.Load()
+		if err != nil {
+			t.Fatalf("load: %v", err)
+		}
+		if term != 3 || votedFor != "node1" {
+			t.Errorf("load: got %d, %q want 3, %q", term, votedFor, "node1")
+		}
+		if len(got) != 3 || got[1].Term != 2 || string(got[2].Command) != "b" {
+			t.Errorf("load: got %+v", got)
+		}
+	})
+	t.Run("snapshot replaces the entries it covers", func(t *testing.T) {
+		dir := t.TempDir()
+		s, err := OpenFileStorage(dir)
+		if err != nil {
+			t.Fatalf("openFileStorage: %v", err)
+		}
+		entries := []Entry{{Term: 1, Index: 1}, {Term: 1, Index: 2}, {Term: 2, Index: 3}}
+		if err := s.Append(entries); err != nil {
+			t.Fatalf("append: %v", err)
+		}
+		snap := Snapshot{Index: 2, Term: 1, Data: []byte("state")}
+		if err := s.SaveSnapshot(snap); err != nil {
+			t.Fatalf("saveSnapshot: %v", err)
+		}
+		if err := s.Truncate(4); err != nil {
+			t.Fatalf("truncate: %v", err)
+		}
+		s.Close()
+
+		s, err = OpenFileStorage(dir)
+		if err != nil {
+			t.Fatalf("openFileStorage: %v", err)
+		}
+		defer s.Close()
+		got, err := s.Snapshot()
+		if err != nil || got.Index != 2 || string(got.Data) != "state" {
+			t.Errorf("snapshot: got %+v, %v want %+v", got, err, snap)
+		}
+		_, _, left, err := s.Load()
+		if err != nil {
+			t.Fatalf("load: %v", err)
+		}
+		if len(left) != 1 || left[0].Index != 3 {
+			t.Errorf("load: got %+v want the entry at index 3", left)
+		}
+	})
+}